@@ -11,10 +11,10 @@ import (
 	"encoding/json"
 	"encoding/pem"
 	"errors"
+	"fmt"
 	"io"
 	"io/fs"
 	"math/big"
-	"os"
 	"path"
 	"slices"
 	"strings"
@@ -43,12 +43,20 @@ var (
 func mauDir(d string) string      { return path.Join(d, mauDirName) }
 func accountFile(d string) string { return path.Join(mauDir(d), accountKeyFilename) }
 
+func publicKeyFile(d string) string { return path.Join(mauDir(d), publicKeyFilename) }
+
 func NewAccount(root, name, email, passphrase string) (*Account, error) {
+	return NewAccountWithStorage(DiskStorage{}, root, name, email, passphrase)
+}
+
+// NewAccountWithStorage creates an account keeping its files in storage
+// under the root path
+func NewAccountWithStorage(storage Storage, root, name, email, passphrase string) (*Account, error) {
 	if len(passphrase) == 0 {
 		return nil, ErrPassphraseRequired
 	}
 
-	acc, err := createAccountFile(root)
+	acc, err := createAccountFile(storage, root)
 	if err != nil {
 		return nil, err
 	}
@@ -58,28 +66,37 @@ func NewAccount(root, name, email, passphrase string) (*Account, error) {
 		return nil, err
 	}
 
-	if err := saveEncryptedEntity(acc, entity, passphrase); err != nil {
+	if err := saveEncryptedEntity(storage, acc, entity, passphrase); err != nil {
+		return nil, err
+	}
+
+	account := buildAccount(storage, entity, root)
+	if err := account.savePublicKey(); err != nil {
 		return nil, err
 	}
 
-	return buildAccount(entity, root), nil
+	return account, nil
 }
 
-func buildAccount(entity *openpgp.Entity, path string) *Account {
+func buildAccount(storage Storage, entity *openpgp.Entity, path string) *Account {
 	return &Account{
-		entity: entity,
-		path:   path,
+		entity:  entity,
+		path:    path,
+		storage: storage,
+		index:   newFileIndex(storage, path),
+		keyring: &keyringCache{},
+		search:  &searchIndex{},
 	}
 }
 
-func createAccountFile(root string) (string, error) {
+func createAccountFile(storage Storage, root string) (string, error) {
 	dir := mauDir(root)
-	if err := os.MkdirAll(dir, DirPerm); err != nil {
+	if err := storage.MkdirAll(dir); err != nil {
 		return "", err
 	}
 
 	acc := accountFile(root)
-	if _, err := os.Stat(acc); err == nil {
+	if exists(storage, acc) {
 		return "", ErrAccountAlreadyExists
 	}
 	return acc, nil
@@ -94,11 +111,16 @@ func createAccountEntity(name, email string) (*openpgp.Entity, error) {
 	})
 }
 
-func saveEncryptedEntity(acc string, entity *openpgp.Entity, passphrase string) (err error) {
-	plainFile, err := os.Create(acc)
+func saveEncryptedEntity(storage Storage, acc string, entity *openpgp.Entity, passphrase string) (err error) {
+	plainFile, err := storage.Create(acc)
 	if err != nil {
 		return err
 	}
+	defer func() {
+		if cerr := plainFile.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
 
 	encryptedFile, err := openpgp.SymmetricallyEncrypt(plainFile, []byte(passphrase), nil, nil)
 	if err != nil {
@@ -115,11 +137,17 @@ func saveEncryptedEntity(acc string, entity *openpgp.Entity, passphrase string)
 }
 
 func OpenAccount(rootPath, passphrase string) (*Account, error) {
-	if err := ensureAccountDir(rootPath); err != nil {
+	return OpenAccountWithStorage(DiskStorage{}, rootPath, passphrase)
+}
+
+// OpenAccountWithStorage opens the account kept in storage under the root
+// path
+func OpenAccountWithStorage(storage Storage, rootPath, passphrase string) (*Account, error) {
+	if err := storage.MkdirAll(mauDir(rootPath)); err != nil {
 		return nil, err
 	}
 
-	encryptedFile, err := openAccountFile(rootPath)
+	encryptedFile, err := storage.Open(accountFile(rootPath))
 	if err != nil {
 		return nil, err
 	}
@@ -130,23 +158,46 @@ func OpenAccount(rootPath, passphrase string) (*Account, error) {
 		return nil, err
 	}
 
-	return &Account{
-		entity: entity,
-		path:   rootPath,
-	}, nil
+	account := buildAccount(storage, entity, rootPath)
+	if !exists(storage, publicKeyFile(rootPath)) {
+		if err := account.savePublicKey(); err != nil {
+			return nil, err
+		}
+	}
+
+	return account, nil
 }
 
-func ensureAccountDir(rootPath string) error {
-	dir := mauDir(rootPath)
-	return os.MkdirAll(dir, DirPerm)
+// savePublicKey writes the public key of the account next to the encrypted
+// one, so the account can be told apart without the passphrase
+func (a *Account) savePublicKey() error {
+	var key bytes.Buffer
+	if err := a.Export(&key); err != nil {
+		return err
+	}
+	return a.storage.WriteFile(publicKeyFile(a.path), key.Bytes())
 }
 
-func openAccountFile(rootPath string) (*os.File, error) {
-	acc := accountFile(rootPath)
-	return os.Open(acc)
+// readPublicKey reads the fingerprint of the account under the root path
+// from its public key
+func readPublicKey(storage Storage, rootPath string) (Fingerprint, error) {
+	data, err := storage.ReadFile(publicKeyFile(rootPath))
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) != 1 || keys[0].PrimaryKey == nil {
+		return nil, ErrCannotConvertPublicKey
+	}
+
+	return keys[0].PrimaryKey.Fingerprint, nil
 }
 
-func decryptAndReadEntity(encryptedFile *os.File, passphrase string) (*openpgp.Entity, error) {
+func decryptAndReadEntity(encryptedFile io.Reader, passphrase string) (*openpgp.Entity, error) {
 	prompted := false
 	prompt := func(keys []openpgp.Key, symmetric bool) ([]byte, error) {
 		if prompted {
@@ -168,8 +219,22 @@ func decryptAndReadEntity(encryptedFile *os.File, passphrase string) (*openpgp.E
 }
 
 type Account struct {
-	path   string
-	entity *openpgp.Entity
+	path    string
+	entity  *openpgp.Entity
+	storage Storage
+	index   *fileIndex
+	keyring *keyringCache
+	search  *searchIndex
+}
+
+// Storage returns the storage keeping the account files
+func (a *Account) Storage() Storage {
+	return a.storage
+}
+
+// file returns a file of the account storage
+func (a *Account) file(p string) *File {
+	return &File{Path: p, storage: a.storage}
 }
 
 func (a *Account) Identity() (string, error) {
@@ -399,18 +464,22 @@ func encodeCertificateAndKeyGeneric(privKey crypto.PrivateKey, derBytes []byte)
 }
 
 func (a *Account) handleFileVersioning(filePath string) error {
-	file := File{Path: filePath}
-	hash, err := file.Hash()
+	hash, err := a.file(filePath).Hash()
 	if err != nil {
 		return err
 	}
 
 	versionsDir := filePath + ".versions"
-	if err = os.MkdirAll(versionsDir, DirPerm); err != nil {
+	if err = a.storage.MkdirAll(versionsDir); err != nil {
 		return err
 	}
 
-	return os.Rename(filePath, path.Join(versionsDir, hash))
+	if err := a.storage.Rename(filePath, path.Join(versionsDir, hash)); err != nil {
+		return err
+	}
+
+	a.index.move(filePath, path.Join(versionsDir, hash))
+	return nil
 }
 
 func (a *Account) prepareEncryptionEntities(recipients []*Friend) []*openpgp.Entity {
@@ -436,7 +505,7 @@ func (a *Account) AddFile(r io.Reader, name string, recipients []*Friend) (*File
 		return nil, err
 	}
 
-	return &File{Path: filePath}, nil
+	return a.file(filePath), nil
 }
 
 func validateFlatFileName(name string) error {
@@ -457,7 +526,7 @@ func (a *Account) prepareFilePath(name string) (string, error) {
 	fpr := a.Fingerprint().String()
 	fprDir := path.Join(a.path, fpr)
 
-	if err := os.MkdirAll(fprDir, DirPerm); err != nil {
+	if err := a.storage.MkdirAll(fprDir); err != nil {
 		return "", err
 	}
 
@@ -466,18 +535,23 @@ func (a *Account) prepareFilePath(name string) (string, error) {
 		return "", err
 	}
 
+	// the file is shared again, followers shouldn't delete it anymore
+	if err := a.removeTombstone(filePath); err != nil {
+		return "", err
+	}
+
 	return filePath, nil
 }
 
 func (a *Account) handleExistingFile(p string) error {
-	if _, err := os.Stat(p); err == nil {
+	if exists(a.storage, p) {
 		return a.handleFileVersioning(p)
 	}
 	return nil
 }
 
-func (a *Account) createFileWithCloseCheck(p string) (file *os.File, cleanup func(error) error, err error) {
-	file, err = os.Create(p)
+func (a *Account) createFileWithCloseCheck(p string) (file io.WriteCloser, cleanup func(error) error, err error) {
+	file, err = a.storage.Create(p)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -496,7 +570,11 @@ func (a *Account) writeEncryptedFile(p string, r io.Reader, recipients []*Friend
 	if err != nil {
 		return err
 	}
-	defer func() { err = cleanup(err) }()
+	defer func() {
+		if err = cleanup(err); err == nil {
+			a.reindex(p)
+		}
+	}()
 
 	w, err := openpgp.Encrypt(file, entities, a.entity, nil, nil)
 	if err != nil {
@@ -511,14 +589,51 @@ func (a *Account) writeEncryptedFile(p string, r io.Reader, recipients []*Friend
 	return w.Close()
 }
 
+// encryptForAccount encrypts the private state of the account, like its
+// search index, so only the account can read it
+func (a *Account) encryptForAccount(data []byte) ([]byte, error) {
+	var encrypted bytes.Buffer
+	w, err := openpgp.Encrypt(&encrypted, []*openpgp.Entity{a.entity}, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return encrypted.Bytes(), nil
+}
+
+// decryptForAccount decrypts state encrypted by encryptForAccount
+func (a *Account) decryptForAccount(encrypted []byte) ([]byte, error) {
+	md, err := openpgp.ReadMessage(bytes.NewReader(encrypted), openpgp.EntityList{a.entity}, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return io.ReadAll(md.UnverifiedBody)
+}
+
 func (a *Account) RemoveFile(file *File) error {
 	if file == nil {
 		return nil
 	}
 
-	if err := os.Remove(file.Path); err != nil {
+	if a.isSharedFile(file) {
+		if err := a.writeTombstone(file); err != nil {
+			return fmt.Errorf("failed to write tombstone of %s: %w", file.Name(), err)
+		}
+	}
+
+	if err := a.storage.Remove(file.Path); err != nil {
 		return err
 	}
+	a.index.forget(file.Path)
+	a.index.save()
+	a.unsearchFile(file.Path)
 
 	if file.version {
 		return nil
@@ -537,19 +652,19 @@ func (a *Account) removeFileVersions(file *File) error {
 }
 
 // resolveFriendPath resolves a path for a friend's content, checking both followed and unfollowed directories.
-// Returns the resolved path or os.ErrNotExist if neither exists.
+// Returns the resolved path or fs.ErrNotExist if neither exists.
 func (a *Account) resolveFriendPath(fpr Fingerprint, subpath string) (string, error) {
 	followedPath := path.Join(a.path, fpr.String(), subpath)
 	unfollowedPath := path.Join(a.path, "."+fpr.String(), subpath)
 
-	if _, err := os.Stat(followedPath); err == nil {
+	if exists(a.storage, followedPath) {
 		return followedPath, nil
 	}
-	if _, err := os.Stat(unfollowedPath); err == nil {
+	if exists(a.storage, unfollowedPath) {
 		return unfollowedPath, nil
 	}
 
-	return "", os.ErrNotExist
+	return "", fs.ErrNotExist
 }
 
 type dirEntry struct {
@@ -568,7 +683,7 @@ func filterRecentFiles(files []fs.DirEntry, after time.Time) []dirEntry {
 }
 
 func createRecentEntry(f fs.DirEntry, after time.Time) *dirEntry {
-	if !f.Type().IsRegular() {
+	if !f.Type().IsRegular() || isHiddenFileName(f.Name()) {
 		return nil
 	}
 
@@ -613,24 +728,52 @@ func (a *Account) ListFiles(fingerprint Fingerprint, after time.Time, limit uint
 		return []*File{}
 	}
 
-	files, err := os.ReadDir(dirpath)
+	files, err := a.storage.ReadDir(dirpath)
 	if err != nil {
 		return []*File{}
 	}
+	a.forgetRemovedFiles(dirpath, files)
+	a.removeStaleTempFiles(dirpath, files)
 
 	recent := filterRecentFiles(files, after)
 	sortByModificationTime(recent)
 	page := applyLimit(recent, limit)
 
-	return buildFileList(dirpath, page)
+	return a.buildFileList(dirpath, page)
+}
+
+// forgetRemovedFiles drops the index entries of files removed from dirpath
+// without the account, by sync or by hand
+func (a *Account) forgetRemovedFiles(dirpath string, files []fs.DirEntry) {
+	names := make(map[string]bool, len(files))
+	for _, f := range files {
+		names[f.Name()] = true
+	}
+
+	a.index.retain(dirpath, names)
+}
+
+// removeStaleTempFiles removes the temporary files of writes that never
+// finished, a crash or a full disk leaves them in dirpath
+func (a *Account) removeStaleTempFiles(dirpath string, files []fs.DirEntry) {
+	for _, f := range files {
+		if !isTempFileName(f.Name()) {
+			continue
+		}
+
+		info, err := f.Info()
+		if err != nil || time.Since(info.ModTime()) < staleTempAge {
+			continue
+		}
+
+		_ = a.storage.Remove(path.Join(dirpath, f.Name()))
+	}
 }
 
-func buildFileList(dirpath string, items []dirEntry) []*File {
+func (a *Account) buildFileList(dirpath string, items []dirEntry) []*File {
 	list := make([]*File, 0, len(items))
 	for _, item := range items {
-		list = append(list, &File{
-			Path: path.Join(dirpath, item.entry.Name()),
-		})
+		list = append(list, a.file(path.Join(dirpath, item.entry.Name())))
 	}
 	return list
 }
@@ -675,7 +818,7 @@ func (a *Account) UpdateLastSyncTime(fpr Fingerprint, syncTime time.Time) error
 func (a *Account) loadSyncState() (*syncState, error) {
 	filePath := syncStateFile(a.path)
 
-	data, err := os.ReadFile(filePath)
+	data, err := a.storage.ReadFile(filePath)
 	if err != nil {
 		return nil, err
 	}
@@ -696,5 +839,5 @@ func (a *Account) saveSyncState(state *syncState) error {
 		return err
 	}
 
-	return os.WriteFile(filePath, data, FilePerm)
+	return a.storage.WriteFile(filePath, data)
 }
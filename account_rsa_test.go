@@ -28,7 +28,7 @@ func createRSAAccount(t *testing.T, name, email, passphrase string) *Account {
 	dir := t.TempDir()
 
 	// Create account directory structure
-	acc, err := createAccountFile(dir)
+	acc, err := createAccountFile(DiskStorage{}, dir)
 	require.NoError(t, err)
 
 	// Create RSA entity
@@ -36,10 +36,10 @@ func createRSAAccount(t *testing.T, name, email, passphrase string) *Account {
 	require.NoError(t, err)
 
 	// Save encrypted
-	err = saveEncryptedEntity(acc, entity, passphrase)
+	err = saveEncryptedEntity(DiskStorage{}, acc, entity, passphrase)
 	require.NoError(t, err)
 
-	return buildAccount(entity, dir)
+	return buildAccount(DiskStorage{}, entity, dir)
 }
 
 // TestRSAAccountCreation tests that RSA accounts can be created
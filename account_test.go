@@ -3,6 +3,7 @@ package mau
 import (
 	"bytes"
 	"io"
+	"os"
 	"path"
 	"strings"
 	"testing"
@@ -167,6 +168,21 @@ func TestListFiles(t *testing.T) {
 		assert.Equal(t, 0, len(files))
 	})
 
+	t.Run("Skips temporary files and removes stale ones", func(t T) {
+		dir := path.Join(account_dir, account.Fingerprint().String())
+		fresh := path.Join(dir, ".hello.txt.pgp.123.tmp")
+		stale := path.Join(dir, ".hello.txt.pgp.456.tmp")
+		assert.NoError(t, os.WriteFile(fresh, []byte("partial"), FilePerm))
+		assert.NoError(t, os.WriteFile(stale, []byte("partial"), FilePerm))
+		old := time.Now().Add(-2 * staleTempAge)
+		assert.NoError(t, os.Chtimes(stale, old, old))
+
+		files := account.ListFiles(account.Fingerprint(), time.Time{}, 10)
+		assert.Equal(t, 1, len(files))
+		assert.FileExists(t, fresh)
+		assert.NoFileExists(t, stale)
+	})
+
 	t.Run("Asking for a fingerprint other than the account", func(t T) {
 		unknownFpr, _ := FingerprintFromString("01234567891234567890")
 		files := account.ListFiles(unknownFpr, time.Now().Add(-time.Second), 10)
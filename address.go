@@ -0,0 +1,94 @@
+package mau
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Peer addresses are host:port strings optionally prefixed by a transport
+// hint so resolvers can advertise every way a peer is reachable:
+//
+//	host:port                            TCP (TLS)
+//	tcp://host:port                      TCP (TLS)
+//	quic://host:port                     QUIC (HTTP/3)
+//	relay://<relay fpr>@host:port        TCP circuit through the relay at host:port
+//	rendezvous://<friend fpr>@host:port  QUIC hole punched through a mutual friend
+//	webrtc://<fpr>                       WebRTC data channel negotiated over signaling
+const (
+	TransportTCP        = "tcp"
+	TransportQUIC       = "quic"
+	TransportRelay      = "relay"
+	TransportRendezvous = "rendezvous"
+	TransportWebRTC     = "webrtc"
+)
+
+// QUICAddress formats host:port as an address reached over QUIC
+func QUICAddress(hostport string) string {
+	return TransportQUIC + "://" + hostport
+}
+
+// webrtcAddress formats the address of a peer reached over WebRTC
+func webrtcAddress(fingerprint Fingerprint) string {
+	return TransportWebRTC + "://" + fingerprint.String()
+}
+
+// relayAddress formats the address of a peer reachable through relay
+func relayAddress(relay *Peer) string {
+	return viaAddress(TransportRelay, relay)
+}
+
+// rendezvousAddress formats the address of a peer reachable by hole punching
+// coordinated by the rendezvous peer
+func rendezvousAddress(rendezvous *Peer) string {
+	return viaAddress(TransportRendezvous, rendezvous)
+}
+
+func viaAddress(scheme string, via *Peer) string {
+	return (&url.URL{
+		Scheme: scheme,
+		User:   url.User(via.Fingerprint.String()),
+		Host:   via.Address,
+	}).String()
+}
+
+type peerAddress struct {
+	transport string
+	host      string // host:port to connect to
+	via       *Peer  // relay or rendezvous peer the peer is reached through
+}
+
+func parsePeerAddress(address string) (*peerAddress, error) {
+	if !strings.Contains(address, "://") {
+		return &peerAddress{transport: TransportTCP, host: address}, nil
+	}
+
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid peer address %s: %w", address, err)
+	}
+
+	switch u.Scheme {
+	case TransportTCP, TransportQUIC, TransportWebRTC:
+		return &peerAddress{transport: u.Scheme, host: u.Host}, nil
+	case TransportRelay:
+		return parseViaAddress(u, TransportTCP)
+	case TransportRendezvous:
+		return parseViaAddress(u, TransportQUIC)
+	default:
+		return nil, fmt.Errorf("unsupported transport %s in address %s", u.Scheme, address)
+	}
+}
+
+func parseViaAddress(u *url.URL, transport string) (*peerAddress, error) {
+	fpr, err := FingerprintFromString(u.User.Username())
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s fingerprint: %w", u.Scheme, err)
+	}
+
+	return &peerAddress{
+		transport: transport,
+		host:      u.Host,
+		via:       &Peer{Fingerprint: fpr, Address: u.Host},
+	}, nil
+}
@@ -0,0 +1,69 @@
+package mau
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePeerAddress(t *testing.T) {
+	fpr, err := FingerprintFromString("abaf11c65a2970b130abe3c479be3e4300411886")
+	require.NoError(t, err)
+
+	t.Run("Defaults to TCP without a transport hint", func(t T) {
+		peer, err := parsePeerAddress("192.0.2.1:8080")
+		require.NoError(t, err)
+		assert.Equal(t, &peerAddress{transport: TransportTCP, host: "192.0.2.1:8080"}, peer)
+	})
+
+	t.Run("Parses TCP addresses", func(t T) {
+		peer, err := parsePeerAddress("tcp://192.0.2.1:8080")
+		require.NoError(t, err)
+		assert.Equal(t, &peerAddress{transport: TransportTCP, host: "192.0.2.1:8080"}, peer)
+	})
+
+	t.Run("Parses QUIC addresses", func(t T) {
+		address := QUICAddress("192.0.2.1:8080")
+		assert.Equal(t, "quic://192.0.2.1:8080", address)
+
+		peer, err := parsePeerAddress(address)
+		require.NoError(t, err)
+		assert.Equal(t, &peerAddress{transport: TransportQUIC, host: "192.0.2.1:8080"}, peer)
+	})
+
+	t.Run("Parses relay addresses", func(t T) {
+		address := relayAddress(&Peer{Fingerprint: fpr, Address: "192.0.2.1:8080"})
+		assert.Equal(t, "relay://abaf11c65a2970b130abe3c479be3e4300411886@192.0.2.1:8080", address)
+
+		peer, err := parsePeerAddress(address)
+		require.NoError(t, err)
+		assert.Equal(t, TransportTCP, peer.transport)
+		assert.Equal(t, "192.0.2.1:8080", peer.host)
+		require.NotNil(t, peer.via)
+		assert.Equal(t, fpr, peer.via.Fingerprint)
+		assert.Equal(t, "192.0.2.1:8080", peer.via.Address)
+	})
+
+	t.Run("Parses rendezvous addresses", func(t T) {
+		address := rendezvousAddress(&Peer{Fingerprint: fpr, Address: "192.0.2.1:8080"})
+		assert.Equal(t, "rendezvous://abaf11c65a2970b130abe3c479be3e4300411886@192.0.2.1:8080", address)
+
+		peer, err := parsePeerAddress(address)
+		require.NoError(t, err)
+		assert.Equal(t, TransportQUIC, peer.transport)
+		assert.Equal(t, "192.0.2.1:8080", peer.host)
+		require.NotNil(t, peer.via)
+		assert.Equal(t, fpr, peer.via.Fingerprint)
+	})
+
+	t.Run("Fails for invalid relay fingerprints", func(t T) {
+		_, err := parsePeerAddress("relay://xyz@192.0.2.1:8080")
+		assert.Error(t, err)
+	})
+
+	t.Run("Fails for unsupported transports", func(t T) {
+		_, err := parsePeerAddress("sctp://192.0.2.1:8080")
+		assert.ErrorContains(t, err, "unsupported transport")
+	})
+}
@@ -0,0 +1,391 @@
+package mau
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+// Agent: keeps an account unlocked in memory for the processes of its user,
+// so every command doesn't ask for the passphrase, like gpg-agent. it
+// listens on a Unix socket in .mau that only the user can open, and answers
+// only processes of its own user. the agent starts locked, a client unlocks
+// it with the passphrase, which the agent checks by opening the account then
+// forgets. clients get the decrypted account key, never the passphrase: the
+// OpenPGP library signs and decrypts with Curve25519 keys only in the process
+// holding them. the agent forgets the key when locked or after its timeout,
+// nothing is written to disk.
+
+var (
+	ErrAgentLocked   = errors.New("Agent is locked")
+	ErrAgentRunning  = errors.New("Agent is already running")
+	ErrAgentResponse = errors.New("Agent response is invalid")
+	ErrAgentPeer     = errors.New("Agent client runs as another user")
+	ErrAgentAccount  = errors.New("Agent keeps another account")
+)
+
+// agentErrors are the errors a client gets back as themselves
+var agentErrors = []error{ErrAgentLocked, ErrIncorrectPassphrase, ErrPassphraseRequired}
+
+type agentRequest struct {
+	Command    string `json:"command"` // unlock, key, status, lock or stop
+	Passphrase string `json:"passphrase,omitempty"`
+}
+
+type agentResponse struct {
+	Key         []byte    `json:"key,omitempty"` // the decrypted account key
+	Fingerprint string    `json:"fingerprint,omitempty"`
+	Expires     time.Time `json:"expires,omitzero"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// AgentStatus describes the account an agent keeps unlocked
+type AgentStatus struct {
+	Unlocked    bool
+	Fingerprint Fingerprint
+	Expires     time.Time // zero when the agent doesn't lock by itself
+}
+
+// Agent keeps an account unlocked for the clients of its socket
+type Agent struct {
+	storage Storage
+	root    string
+	timeout time.Duration // zero to keep the account unlocked until locked
+
+	mutex    sync.Mutex
+	account  *Account
+	expires  time.Time
+	timer    *time.Timer
+	listener net.Listener
+}
+
+// AgentSocket is the path of the socket of the agent of the account
+func AgentSocket(rootPath string) string {
+	return path.Join(mauDir(rootPath), agentSocketName)
+}
+
+// NewAgent creates a locked agent of the account under the root path
+func NewAgent(rootPath string, timeout time.Duration) *Agent {
+	return NewAgentWithStorage(DiskStorage{}, rootPath, timeout)
+}
+
+// NewAgentWithStorage creates a locked agent of the account kept in storage
+func NewAgentWithStorage(storage Storage, rootPath string, timeout time.Duration) *Agent {
+	return &Agent{storage: storage, root: rootPath, timeout: timeout}
+}
+
+// ListenAgent listens on the agent socket, ErrAgentRunning when an agent
+// already answers on it. the socket of an agent that didn't stop cleanly is
+// replaced. the socket is created in a private directory and moved in place
+// once only the user can open it.
+func ListenAgent(socket string) (net.Listener, error) {
+	if conn, err := net.DialTimeout("unix", socket, agentDialTimeout); err == nil {
+		_ = conn.Close()
+		return nil, ErrAgentRunning
+	}
+	if err := os.Remove(socket); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	dir, err := os.MkdirTemp(path.Dir(socket), ".agent-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	private := path.Join(dir, agentSocketName)
+	listener, err := net.Listen("unix", private)
+	if err != nil {
+		return nil, err
+	}
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+
+	if err := os.Chmod(private, FilePerm); err != nil {
+		_ = listener.Close()
+		return nil, err
+	}
+	if err := os.Rename(private, socket); err != nil {
+		_ = listener.Close()
+		return nil, err
+	}
+
+	return &agentListener{Listener: listener, socket: socket}, nil
+}
+
+// agentListener removes the socket it was moved to when closed
+type agentListener struct {
+	net.Listener
+	socket string
+}
+
+func (l *agentListener) Close() error {
+	err := l.Listener.Close()
+	if rerr := os.Remove(l.socket); rerr != nil && !errors.Is(rerr, os.ErrNotExist) && err == nil {
+		err = rerr
+	}
+
+	return err
+}
+
+// Serve answers the clients of listener until the agent is stopped or
+// closed, the account is locked when it returns
+func (a *Agent) Serve(listener net.Listener) error {
+	a.mutex.Lock()
+	a.listener = listener
+	a.mutex.Unlock()
+	defer a.Lock()
+
+	for {
+		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		go a.handle(conn)
+	}
+}
+
+// Close locks the account and stops serving
+func (a *Agent) Close() error {
+	a.Lock()
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.listener == nil {
+		return nil
+	}
+
+	return a.listener.Close()
+}
+
+// Unlock keeps the account unlocked with the passphrase until the timeout,
+// an incorrect passphrase leaves the agent as it was. the passphrase isn't
+// kept.
+func (a *Agent) Unlock(passphrase string) error {
+	account, err := OpenAccountWithStorage(a.storage, a.root, passphrase)
+	if err != nil {
+		return err
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.forget()
+	a.account = account
+	if a.timeout > 0 {
+		a.expires = time.Now().Add(a.timeout)
+		a.timer = time.AfterFunc(a.timeout, a.Lock)
+	}
+
+	return nil
+}
+
+// Lock forgets the account
+func (a *Agent) Lock() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.forget()
+}
+
+// Status describes the account the agent keeps unlocked
+func (a *Agent) Status() *AgentStatus {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.account == nil {
+		return &AgentStatus{}
+	}
+
+	return &AgentStatus{Unlocked: true, Fingerprint: a.account.Fingerprint(), Expires: a.expires}
+}
+
+// forget drops the account, the mutex must be held
+func (a *Agent) forget() {
+	if a.timer != nil {
+		a.timer.Stop()
+		a.timer = nil
+	}
+
+	a.account = nil
+	a.expires = time.Time{}
+}
+
+func (a *Agent) handle(conn net.Conn) {
+	defer conn.Close()
+	if err := checkAgentPeer(conn); err != nil {
+		slog.Warn("refused agent client", "error", err)
+		return
+	}
+	_ = conn.SetDeadline(time.Now().Add(agentRequestTimeout))
+
+	var req agentRequest
+	if err := json.NewDecoder(bufio.NewReader(conn)).Decode(&req); err != nil {
+		return
+	}
+
+	resp := a.respond(&req)
+	_ = json.NewEncoder(conn).Encode(resp)
+
+	if req.Command == "stop" {
+		_ = a.Close()
+	}
+}
+
+func (a *Agent) respond(req *agentRequest) *agentResponse {
+	switch req.Command {
+	case "unlock":
+		if err := a.Unlock(req.Passphrase); err != nil {
+			return &agentResponse{Error: err.Error()}
+		}
+		return a.statusResponse()
+
+	case "key":
+		a.mutex.Lock()
+		defer a.mutex.Unlock()
+		if a.account == nil {
+			return &agentResponse{Error: ErrAgentLocked.Error()}
+		}
+		var key bytes.Buffer
+		if err := a.account.entity.SerializePrivateWithoutSigning(&key, nil); err != nil {
+			return &agentResponse{Error: err.Error()}
+		}
+		return &agentResponse{Key: key.Bytes()}
+
+	case "status":
+		return a.statusResponse()
+
+	case "lock", "stop":
+		a.Lock()
+		return &agentResponse{}
+	}
+
+	return &agentResponse{Error: "Unknown agent command " + req.Command}
+}
+
+func (a *Agent) statusResponse() *agentResponse {
+	status := a.Status()
+	if !status.Unlocked {
+		return &agentResponse{}
+	}
+
+	return &agentResponse{Fingerprint: status.Fingerprint.String(), Expires: status.Expires}
+}
+
+// AgentClient talks to the agent listening on a socket
+type AgentClient struct {
+	socket string
+}
+
+// NewAgentClient returns a client of the agent of the socket
+func NewAgentClient(socket string) *AgentClient {
+	return &AgentClient{socket: socket}
+}
+
+// OpenAccount opens the account under the root path with the key the agent
+// keeps unlocked, ErrAgentLocked when the agent is locked
+func (c *AgentClient) OpenAccount(rootPath string) (*Account, error) {
+	return c.OpenAccountWithStorage(DiskStorage{}, rootPath)
+}
+
+// OpenAccountWithStorage opens the account kept in storage under the root
+// path with the key the agent keeps unlocked, ErrAgentAccount when the key
+// isn't the one of that account
+func (c *AgentClient) OpenAccountWithStorage(storage Storage, rootPath string) (*Account, error) {
+	resp, err := c.request(&agentRequest{Command: "key"})
+	if err != nil {
+		return nil, err
+	}
+
+	entity, err := openpgp.ReadEntity(packet.NewReader(bytes.NewReader(resp.Key)))
+	if err != nil || entity.PrivateKey == nil || entity.PrivateKey.Encrypted {
+		return nil, ErrAgentResponse
+	}
+
+	fpr, err := readPublicKey(storage, rootPath)
+	if err != nil {
+		return nil, err
+	}
+	if !fpr.Equal(entity.PrimaryKey.Fingerprint) {
+		return nil, ErrAgentAccount
+	}
+
+	return buildAccount(storage, entity, rootPath), nil
+}
+
+// Unlock unlocks the account of the agent with the passphrase
+func (c *AgentClient) Unlock(passphrase string) error {
+	_, err := c.request(&agentRequest{Command: "unlock", Passphrase: passphrase})
+	return err
+}
+
+// Lock makes the agent forget the account
+func (c *AgentClient) Lock() error {
+	_, err := c.request(&agentRequest{Command: "lock"})
+	return err
+}
+
+// Stop locks the agent and stops it
+func (c *AgentClient) Stop() error {
+	_, err := c.request(&agentRequest{Command: "stop"})
+	return err
+}
+
+// Status describes the account the agent keeps unlocked
+func (c *AgentClient) Status() (*AgentStatus, error) {
+	resp, err := c.request(&agentRequest{Command: "status"})
+	if err != nil {
+		return nil, err
+	}
+
+	status := &AgentStatus{Unlocked: resp.Fingerprint != "", Expires: resp.Expires}
+	if status.Unlocked {
+		if status.Fingerprint, err = FingerprintFromString(resp.Fingerprint); err != nil {
+			return nil, ErrAgentResponse
+		}
+	}
+
+	return status, nil
+}
+
+func (c *AgentClient) request(req *agentRequest) (*agentResponse, error) {
+	conn, err := net.DialTimeout("unix", c.socket, agentDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(agentRequestTimeout))
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, err
+	}
+
+	var resp agentResponse
+	if err := json.NewDecoder(bufio.NewReader(conn)).Decode(&resp); err != nil {
+		return nil, ErrAgentResponse
+	}
+
+	if resp.Error != "" {
+		for _, known := range agentErrors {
+			if resp.Error == known.Error() {
+				return nil, known
+			}
+		}
+		return nil, errors.New(resp.Error)
+	}
+
+	return &resp, nil
+}
@@ -0,0 +1,41 @@
+package mau
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// checkAgentPeer refuses clients running as another user than the agent,
+// from the credentials the kernel gives for the socket (SO_PEERCRED)
+func checkAgentPeer(conn net.Conn) error {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return ErrAgentPeer
+	}
+
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var (
+		cred    *syscall.Ucred
+		credErr error
+	)
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); err != nil {
+		return err
+	}
+	if credErr != nil {
+		return credErr
+	}
+
+	if int(cred.Uid) != os.Getuid() {
+		return fmt.Errorf("%w: uid %d", ErrAgentPeer, cred.Uid)
+	}
+
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package mau
+
+import "net"
+
+// checkAgentPeer relies on the permissions of the socket where the peer
+// credentials aren't read
+func checkAgentPeer(conn net.Conn) error {
+	return nil
+}
@@ -0,0 +1,145 @@
+package mau
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"path"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAgent(t *testing.T) {
+	storage := NewMemoryStorage()
+	account, err := NewAccountWithStorage(storage, "/ahmed", "Ahmed", "ahmed@example.com", "secret")
+	require.NoError(t, err)
+
+	socket := path.Join(t.TempDir(), agentSocketName)
+	listener, err := ListenAgent(socket)
+	require.NoError(t, err)
+
+	agent := NewAgentWithStorage(storage, "/ahmed", time.Hour)
+	served := make(chan error)
+	go func() { served <- agent.Serve(listener) }()
+
+	client := NewAgentClient(socket)
+
+	t.Run("Starts locked", func(t T) {
+		_, err := client.OpenAccountWithStorage(storage, "/ahmed")
+		assert.ErrorIs(t, err, ErrAgentLocked)
+
+		status, err := client.Status()
+		require.NoError(t, err)
+		assert.False(t, status.Unlocked)
+	})
+
+	t.Run("Refuses a second agent", func(t T) {
+		_, err := ListenAgent(socket)
+		assert.ErrorIs(t, err, ErrAgentRunning)
+	})
+
+	t.Run("Opens the socket to the user only", func(t T) {
+		info, err := os.Stat(socket)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(FilePerm), info.Mode().Perm())
+
+		entries, err := os.ReadDir(path.Dir(socket))
+		require.NoError(t, err)
+		assert.Len(t, entries, 1) // the private directory is removed
+	})
+
+	t.Run("Checks the user of its clients", func(t T) {
+		if runtime.GOOS != "linux" {
+			t.Skip("peer credentials are read on Linux only")
+		}
+
+		conn, err := net.Dial("unix", socket)
+		require.NoError(t, err)
+		defer conn.Close()
+		assert.NoError(t, checkAgentPeer(conn))
+
+		pipe, other := net.Pipe()
+		defer pipe.Close()
+		defer other.Close()
+		assert.ErrorIs(t, checkAgentPeer(pipe), ErrAgentPeer)
+	})
+
+	t.Run("Unlocks with the passphrase of the account", func(t T) {
+		assert.ErrorIs(t, client.Unlock("wrong"), ErrIncorrectPassphrase)
+		require.NoError(t, client.Unlock("secret"))
+
+		opened, err := client.OpenAccountWithStorage(storage, "/ahmed")
+		require.NoError(t, err)
+		assert.Equal(t, account.Fingerprint(), opened.Fingerprint())
+
+		file, err := account.AddFile(bytes.NewBufferString("hello"), "hello.txt", nil)
+		require.NoError(t, err)
+		r, err := file.Reader(opened)
+		require.NoError(t, err)
+		content, err := io.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, "hello", string(content))
+
+		status, err := client.Status()
+		require.NoError(t, err)
+		assert.True(t, status.Unlocked)
+		assert.Equal(t, account.Fingerprint(), status.Fingerprint)
+		assert.WithinDuration(t, time.Now().Add(time.Hour), status.Expires, time.Minute)
+	})
+
+	t.Run("Opens only the account of its key", func(t T) {
+		_, err := NewAccountWithStorage(storage, "/sara", "Sara", "sara@example.com", "other")
+		require.NoError(t, err)
+
+		_, err = client.OpenAccountWithStorage(storage, "/sara")
+		assert.ErrorIs(t, err, ErrAgentAccount)
+	})
+
+	t.Run("Never returns the passphrase", func(t T) {
+		for _, command := range []string{"key", "status", "passphrase"} {
+			resp, err := client.request(&agentRequest{Command: command})
+			if err != nil {
+				continue
+			}
+			data, err := json.Marshal(resp)
+			require.NoError(t, err)
+			assert.NotContains(t, string(data), "secret")
+		}
+	})
+
+	t.Run("Locks", func(t T) {
+		require.NoError(t, client.Lock())
+		_, err := client.OpenAccountWithStorage(storage, "/ahmed")
+		assert.ErrorIs(t, err, ErrAgentLocked)
+	})
+
+	t.Run("Locks after the timeout", func(t T) {
+		agent.timeout = 50 * time.Millisecond
+		require.NoError(t, agent.Unlock("secret"))
+		assert.True(t, agent.Status().Unlocked)
+
+		assert.Eventually(t, func() bool { return !agent.Status().Unlocked }, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("Stops", func(t T) {
+		require.NoError(t, client.Unlock("secret"))
+		require.NoError(t, client.Stop())
+		require.NoError(t, <-served)
+
+		assert.False(t, agent.Status().Unlocked)
+		_, err := client.OpenAccountWithStorage(storage, "/ahmed")
+		assert.Error(t, err)
+		assert.NoFileExists(t, socket)
+
+		// the socket is free for the next agent
+		listener, err := ListenAgent(socket)
+		require.NoError(t, err)
+		require.NoError(t, listener.Close())
+	})
+}
@@ -0,0 +1,398 @@
+package mau
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Attachments: the media of a document, like the photo of a post, is
+// encrypted to its own file, a blob, named after the SHA-256 of its content
+// like <hash>.blob.pgp. the document refers to it with a MediaObject holding
+// its address, size, hash and MIME type. the same content attached twice is
+// stored once, encrypted to the recipients of both. blobs no document refers
+// to anymore are removed by PruneAttachments, so a blob should be referenced
+// by a document before the next prune.
+
+var (
+	ErrNotAnAttachment    = errors.New("Address isn't an attachment")
+	ErrAttachmentMismatch = errors.New("Attachment doesn't match its size or hash")
+)
+
+var blobNameReg = regexp.MustCompile(`^[0-9a-f]{64}` + regexp.QuoteMeta(blobSuffix) + `\.pgp$`)
+
+func isBlobName(name string) bool { return blobNameReg.MatchString(name) }
+
+func blobName(sum string) string { return sum + blobSuffix + ".pgp" }
+
+// mediaType returns the Schema.org type of a MIME type
+func mediaType(mimeType string) string {
+	switch strings.SplitN(mimeType, "/", 2)[0] {
+	case "image":
+		return "ImageObject"
+	case "video":
+		return "VideoObject"
+	case "audio":
+		return "AudioObject"
+	default:
+		return "MediaObject"
+	}
+}
+
+// AddAttachment encrypts the content of r as a blob for the recipients and
+// returns the MediaObject to add to a document shared with them
+func (a *Account) AddAttachment(r io.Reader, mimeType string, recipients []*Friend) (*MediaObject, error) {
+	tmpPath := path.Join(mauDir(a.path), "attachment-"+rand.Text()+".tmp")
+	defer a.forgetTemp(tmpPath)
+
+	counter := &countingHash{Hash: sha256.New()}
+	if err := a.writeEncryptedFile(tmpPath, io.TeeReader(r, counter), recipients); err != nil {
+		return nil, err
+	}
+
+	sum := fmt.Sprintf("%x", counter.Sum(nil))
+	fprDir := path.Join(a.path, a.Fingerprint().String())
+	if err := a.storage.MkdirAll(fprDir); err != nil {
+		return nil, err
+	}
+
+	blobPath := path.Join(fprDir, blobName(sum))
+	if err := a.saveBlob(tmpPath, blobPath, recipients); err != nil {
+		return nil, err
+	}
+
+	return &MediaObject{
+		Type:           mediaType(mimeType),
+		ContentURL:     fileListPath(a.Fingerprint(), blobName(sum)),
+		ContentSize:    counter.size,
+		SHA256:         sum,
+		EncodingFormat: mimeType,
+	}, nil
+}
+
+type countingHash struct {
+	hash.Hash
+	size int64
+}
+
+func (c *countingHash) Write(p []byte) (int, error) {
+	c.size += int64(len(p))
+	return c.Hash.Write(p)
+}
+
+func (a *Account) forgetTemp(p string) {
+	_ = removeIfExists(a.storage, p)
+	a.index.forget(p)
+	a.index.save()
+}
+
+// saveBlob moves the blob encrypted at tmpPath to blobPath. a blob already
+// there is encrypted again for its recipients and the new ones, to another
+// temporary file replacing it so readers never see a partial blob.
+func (a *Account) saveBlob(tmpPath, blobPath string, recipients []*Friend) error {
+	if !exists(a.storage, blobPath) {
+		return a.replaceBlob(tmpPath, blobPath)
+	}
+
+	existing, err := a.file(blobPath).Recipients(a)
+	if err != nil {
+		return err
+	}
+
+	all := slices.Clone(existing)
+	for _, r := range recipients {
+		if !slices.ContainsFunc(all, func(f *Friend) bool { return f.Fingerprint().Equal(r.Fingerprint()) }) {
+			all = append(all, r)
+		}
+	}
+	if len(all) == len(existing) {
+		return nil
+	}
+
+	content, err := a.file(tmpPath).Reader(a)
+	if err != nil {
+		return err
+	}
+
+	reencrypted := path.Join(mauDir(a.path), "attachment-"+rand.Text()+".tmp")
+	defer a.forgetTemp(reencrypted)
+	if err := a.writeEncryptedFile(reencrypted, content, all); err != nil {
+		return err
+	}
+
+	return a.replaceBlob(reencrypted, blobPath)
+}
+
+// replaceBlob renames the encrypted file at tmpPath over blobPath
+func (a *Account) replaceBlob(tmpPath, blobPath string) error {
+	if err := a.storage.Rename(tmpPath, blobPath); err != nil {
+		return err
+	}
+	a.index.move(tmpPath, blobPath)
+	a.index.save()
+
+	return nil
+}
+
+// OpenAttachment returns the content of an attachment the account has,
+// checked against the signature of its author and its size and hash
+func (a *Account) OpenAttachment(media *MediaObject) (io.Reader, error) {
+	addr, err := attachmentAddress(media)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := a.referenceFile(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return a.readAttachment(file, addr, media)
+}
+
+// FetchAttachment opens the attachment like OpenAttachment, downloading it
+// first from its author or peers when the account doesn't have it, as when
+// the download policy defers attachments
+func (a *Account) FetchAttachment(ctx context.Context, media *MediaObject, peers []Fingerprint, resolvers []FingerprintResolver) (io.Reader, error) {
+	addr, err := attachmentAddress(media)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := a.fetchFile(ctx, addr, peers, resolvers)
+	if err != nil {
+		return nil, err
+	}
+
+	return a.readAttachment(file, addr, media)
+}
+
+func attachmentAddress(media *MediaObject) (*Address, error) {
+	addr, err := ParseAddress(media.ContentURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isBlobName(addr.Name) || addr.Version != "" {
+		return nil, fmt.Errorf("%w: %s", ErrNotAnAttachment, media.ContentURL)
+	}
+
+	return addr, nil
+}
+
+func (a *Account) readAttachment(file *File, addr *Address, media *MediaObject) (io.Reader, error) {
+	author, err := a.author(addr.Fingerprint)
+	if err != nil {
+		return nil, err
+	}
+
+	data, _, err := a.readSigned(file, author)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrReferenceNotSigned, err)
+	}
+
+	sum := fmt.Sprintf("%x", sha256.Sum256(data))
+	if int64(len(data)) != media.ContentSize || sum != media.SHA256 || blobName(sum) != addr.Name {
+		return nil, fmt.Errorf("%w: %s", ErrAttachmentMismatch, media.ContentURL)
+	}
+
+	return bytes.NewReader(data), nil
+}
+
+// PrunedAttachment is a blob removed by PruneAttachments
+type PrunedAttachment struct {
+	Fingerprint Fingerprint `json:"fingerprint"`
+	Name        string      `json:"name"`
+	Size        int64       `json:"size"`
+}
+
+// AttachmentPruneReport lists the blobs pruned, or that would be pruned by a
+// dry run
+type AttachmentPruneReport struct {
+	DryRun bool                `json:"dry_run"`
+	Pruned []*PrunedAttachment `json:"pruned"`
+	Size   int64               `json:"size"` // total size of the pruned blobs
+}
+
+// PruneAttachments removes the blobs of the account and its friends that no
+// document or version of a document the account can read refers to. a dry
+// run only reports them. nothing is removed when a file can't be read, as it
+// may refer to blobs.
+func (a *Account) PruneAttachments(dryRun bool) (*AttachmentPruneReport, error) {
+	report := &AttachmentPruneReport{DryRun: dryRun, Pruned: []*PrunedAttachment{}}
+	referenced, err := a.referencedBlobs()
+	if err != nil {
+		return report, err
+	}
+	defer a.index.save()
+
+	for _, dir := range a.contentDirPaths() {
+		if err := a.pruneDirBlobs(dir, referenced, report); err != nil {
+			return report, err
+		}
+	}
+
+	return report, nil
+}
+
+func (a *Account) pruneDirBlobs(dir string, referenced map[string]bool, report *AttachmentPruneReport) error {
+	fpr, _ := contentDirFingerprint(path.Base(dir))
+	entries, err := a.readContentDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || !isBlobName(entry.Name()) || referenced[fileListPath(fpr, entry.Name())] {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		if err := a.pruneBlob(path.Join(dir, entry.Name()), report.DryRun); err != nil {
+			return err
+		}
+		report.Pruned = append(report.Pruned, &PrunedAttachment{Fingerprint: fpr, Name: entry.Name(), Size: info.Size()})
+		report.Size += info.Size()
+	}
+
+	return nil
+}
+
+// readContentDir lists dir, a content directory that doesn't exist yet is
+// empty
+func (a *Account) readContentDir(dir string) ([]fs.DirEntry, error) {
+	entries, err := a.storage.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+
+	return entries, err
+}
+
+func (a *Account) pruneBlob(p string, dryRun bool) error {
+	if dryRun {
+		return nil
+	}
+
+	if err := a.storage.Remove(p); err != nil {
+		return err
+	}
+	a.index.forget(p)
+
+	return nil
+}
+
+// referencedBlobs returns the canonical addresses of the blobs documents and
+// their versions refer to
+func (a *Account) referencedBlobs() (map[string]bool, error) {
+	referenced := map[string]bool{}
+
+	for _, dir := range a.contentDirPaths() {
+		entries, err := a.readContentDir(dir)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			var err error
+			switch {
+			case entry.IsDir() && strings.HasSuffix(entry.Name(), ".versions"):
+				err = a.collectDirBlobReferences(path.Join(dir, entry.Name()), referenced)
+			case entry.Type().IsRegular() && path.Ext(entry.Name()) == ".pgp" && !isBlobName(entry.Name()):
+				err = a.collectBlobReferences(a.file(path.Join(dir, entry.Name())), referenced)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return referenced, nil
+}
+
+func (a *Account) collectDirBlobReferences(versionsDir string, referenced map[string]bool) error {
+	entries, err := a.storage.ReadDir(versionsDir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+
+		version := &File{Path: path.Join(versionsDir, entry.Name()), version: true, storage: a.storage}
+		if err := a.collectBlobReferences(version, referenced); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (a *Account) collectBlobReferences(file *File, referenced map[string]bool) error {
+	r, err := file.Reader(a)
+	if err != nil {
+		return fmt.Errorf("%s: %w", file.Path, err)
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("%s: %w", file.Path, err)
+	}
+
+	// files that aren't JSON documents can't hold media objects
+	var doc any
+	if json.Unmarshal(data, &doc) != nil {
+		return nil
+	}
+
+	for _, media := range mediaObjects(doc) {
+		if _, err := attachmentAddress(media); err == nil {
+			referenced[canonicalAddress(media.ContentURL)] = true
+		}
+	}
+
+	return nil
+}
+
+// mediaObjects returns the objects with a contentUrl anywhere in the decoded
+// JSON value v
+func mediaObjects(v any) []*MediaObject {
+	var found []*MediaObject
+
+	switch v := v.(type) {
+	case map[string]any:
+		if _, ok := v["contentUrl"].(string); ok {
+			data, _ := json.Marshal(v)
+			media := &MediaObject{}
+			if json.Unmarshal(data, media) == nil {
+				found = append(found, media)
+			}
+		}
+		for _, field := range v {
+			found = append(found, mediaObjects(field)...)
+		}
+	case []any:
+		for _, item := range v {
+			found = append(found, mediaObjects(item)...)
+		}
+	}
+
+	return found
+}
@@ -0,0 +1,161 @@
+package mau
+
+import (
+	"bytes"
+	"io"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAttachments(t *testing.T) {
+	newAccount := func(name string) *Account {
+		account, err := NewAccountWithStorage(NewMemoryStorage(), "/"+name, name, name+"@example.com", "password")
+		require.NoError(t, err)
+		return account
+	}
+	alice, bob, carol := newAccount("alice"), newAccount("bob"), newAccount("carol")
+	bobFriend, carolFriend := befriend(t, alice, bob), befriend(t, alice, carol)
+	aliceFriend := befriend(t, bob, alice)
+	require.NoError(t, bob.Follow(aliceFriend))
+	atAlice := serve(t, alice)
+
+	photo := []byte("not really a jpeg")
+	media, err := alice.AddAttachment(bytes.NewReader(photo), "image/jpeg", []*Friend{bobFriend})
+	require.NoError(t, err)
+
+	blobPath := path.Join(alice.path, alice.Fingerprint().String(), path.Base(media.ContentURL))
+	post := &SocialMediaPosting{ArticleBody: "Sunset", AssociatedMedia: []*MediaObject{media}}
+	_, err = alice.AddContent(post, "sunset.json", []*Friend{bobFriend})
+	require.NoError(t, err)
+
+	t.Run("Stores attachments named by their hash", func(t T) {
+		assert.Equal(t, "ImageObject", media.Type)
+		assert.Equal(t, int64(len(photo)), media.ContentSize)
+		assert.Equal(t, "image/jpeg", media.EncodingFormat)
+		assert.Equal(t, fileListPath(alice.Fingerprint(), media.SHA256+".blob.pgp"), media.ContentURL)
+
+		r, err := alice.OpenAttachment(media)
+		require.NoError(t, err)
+		content, err := io.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, photo, content)
+	})
+
+	t.Run("Stores the same content once for all recipients", func(t T) {
+		again, err := alice.AddAttachment(bytes.NewReader(photo), "image/jpeg", []*Friend{carolFriend})
+		require.NoError(t, err)
+		assert.Equal(t, media.ContentURL, again.ContentURL)
+
+		recipients, err := alice.file(blobPath).Recipients(alice)
+		require.NoError(t, err)
+		assert.Len(t, recipients, 2)
+		assert.Empty(t, alice.file(blobPath).Versions())
+
+		entries, err := alice.storage.ReadDir(mauDir(alice.path))
+		require.NoError(t, err)
+		for _, entry := range entries {
+			assert.NotContains(t, entry.Name(), ".tmp")
+		}
+	})
+
+	t.Run("Checks attachments against their hash", func(t T) {
+		forged := *media
+		forged.SHA256 = "00" + media.SHA256[2:]
+		_, err := alice.OpenAttachment(&forged)
+		assert.ErrorIs(t, err, ErrAttachmentMismatch)
+
+		forged = *media
+		forged.ContentURL = fileListPath(alice.Fingerprint(), "sunset.json.pgp")
+		_, err = alice.OpenAttachment(&forged)
+		assert.ErrorIs(t, err, ErrNotAnAttachment)
+	})
+
+	t.Run("Defers attachments with a lazy policy", func(t T) {
+		client, err := bob.Client(alice.Fingerprint(), nil)
+		require.NoError(t, err)
+		client.SetDownloadPolicy(DownloadPolicy{LazyAttachments: true})
+
+		report, err := client.SyncFriend(Timeout(5*time.Second), alice.Fingerprint(), time.Time{}, []FingerprintResolver{atAlice})
+		require.NoError(t, err)
+		require.Len(t, report.Skipped, 1)
+		assert.ErrorIs(t, report.Skipped[0], ErrAttachmentDeferred)
+		assert.False(t, report.Incomplete())
+
+		synced, err := bob.Resolve(fileListPath(alice.Fingerprint(), "sunset.json.pgp"))
+		require.NoError(t, err)
+		attachment := synced.Content.(*SocialMediaPosting).AssociatedMedia[0]
+
+		_, err = bob.OpenAttachment(attachment)
+		assert.ErrorIs(t, err, ErrReferenceNotFound)
+
+		r, err := bob.FetchAttachment(Timeout(5*time.Second), attachment, nil, []FingerprintResolver{atAlice})
+		require.NoError(t, err)
+		content, err := io.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, photo, content)
+	})
+
+	t.Run("Prunes attachments no document refers to", func(t T) {
+		orphan, err := alice.AddAttachment(bytes.NewBufferString("draft"), "text/plain", nil)
+		require.NoError(t, err)
+		assert.Equal(t, "MediaObject", orphan.Type)
+		orphanPath := path.Join(alice.path, alice.Fingerprint().String(), path.Base(orphan.ContentURL))
+
+		report, err := alice.PruneAttachments(true)
+		require.NoError(t, err)
+		require.Len(t, report.Pruned, 1)
+		assert.Equal(t, path.Base(orphan.ContentURL), report.Pruned[0].Name)
+		assert.True(t, exists(alice.storage, orphanPath))
+
+		report, err = alice.PruneAttachments(false)
+		require.NoError(t, err)
+		assert.Len(t, report.Pruned, 1)
+		assert.False(t, exists(alice.storage, orphanPath))
+		assert.True(t, exists(alice.storage, blobPath))
+
+		// an old version of the post keeps its attachment
+		_, err = alice.AddContent(&SocialMediaPosting{ArticleBody: "No photo"}, "sunset.json", []*Friend{bobFriend})
+		require.NoError(t, err)
+		report, err = alice.PruneAttachments(false)
+		require.NoError(t, err)
+		assert.Empty(t, report.Pruned)
+	})
+
+	t.Run("Reads references in any address form", func(t T) {
+		other, err := alice.AddAttachment(bytes.NewBufferString("other photo"), "image/png", nil)
+		require.NoError(t, err)
+		otherPath := path.Join(alice.path, alice.Fingerprint().String(), path.Base(other.ContentURL))
+
+		other.ContentURL = "/p2p/" + strings.ToUpper(alice.Fingerprint().String()) + "/" + other.SHA256 + ".blob"
+		_, err = alice.AddContent(&Comment{Text: "Look", AssociatedMedia: []*MediaObject{other}}, "look.json", nil)
+		require.NoError(t, err)
+
+		report, err := alice.PruneAttachments(false)
+		require.NoError(t, err)
+		assert.Empty(t, report.Pruned)
+		assert.True(t, exists(alice.storage, otherPath))
+	})
+
+	t.Run("Prunes nothing when a file can't be read", func(t T) {
+		orphan, err := alice.AddAttachment(bytes.NewBufferString("orphan"), "text/plain", nil)
+		require.NoError(t, err)
+		orphanPath := path.Join(alice.path, alice.Fingerprint().String(), path.Base(orphan.ContentURL))
+
+		broken := path.Join(alice.path, alice.Fingerprint().String(), "broken.pgp")
+		require.NoError(t, alice.storage.WriteFile(broken, []byte("not encrypted")))
+
+		_, err = alice.PruneAttachments(false)
+		assert.Error(t, err)
+		assert.True(t, exists(alice.storage, orphanPath))
+
+		require.NoError(t, alice.storage.Remove(broken))
+		report, err := alice.PruneAttachments(false)
+		require.NoError(t, err)
+		assert.Len(t, report.Pruned, 1)
+	})
+}
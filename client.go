@@ -7,11 +7,13 @@ import (
 	"crypto/x509"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"net"
 	"net/http"
 	"net/url"
-	"os"
 	"path"
+	"sync"
 	"time"
 
 	"github.com/go-resty/resty/v2"
@@ -27,6 +29,15 @@ type Client struct {
 	client  *resty.Client
 	account *Account
 	peer    Fingerprint
+
+	viaMutex   sync.RWMutex
+	relays     map[string]*Peer // key: relay address, relays the peer is reached through
+	rendezvous map[string]*Peer // key: rendezvous address, friends punching holes to the peer
+
+	webrtc *webrtcTransport
+
+	archiveDeleted bool
+	policy         DownloadPolicy
 }
 
 // TODO(maybe) Cache clients map[Fingerprint]*Client
@@ -37,8 +48,11 @@ func (a *Account) Client(peer Fingerprint, DNSNames []string) (*Client, error) {
 	}
 
 	c := &Client{
-		account: a,
-		peer:    peer,
+		account:    a,
+		peer:       peer,
+		relays:     map[string]*Peer{},
+		rendezvous: map[string]*Peer{},
+		webrtc:     &webrtcTransport{account: a, peer: peer},
 	}
 
 	c.client = c.createRestyClient(cert)
@@ -46,10 +60,72 @@ func (a *Account) Client(peer Fingerprint, DNSNames []string) (*Client, error) {
 }
 
 func (c *Client) createRestyClient(cert tls.Certificate) *resty.Client {
-	return resty.New().
+	client := resty.New().
 		SetRedirectPolicy(resty.NoRedirectPolicy()).
 		SetTimeout(httpClientTimeout).
 		SetTLSClientConfig(c.createTLSConfig(cert))
+
+	if transport, err := client.Transport(); err == nil {
+		transport.DialContext = c.dialContext(transport.DialContext)
+		client.SetTransport(c.createPeerTransport(transport, cert))
+	}
+
+	return client
+}
+
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// dialContext dials addresses of relays through which the peer is reached by
+// opening a circuit to the peer, other addresses are dialed directly
+func (c *Client) dialContext(direct dialFunc) dialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		c.viaMutex.RLock()
+		relay, ok := c.relays[addr]
+		c.viaMutex.RUnlock()
+
+		if ok {
+			return c.account.dialThroughRelay(ctx, relay, c.peer)
+		}
+
+		return direct(ctx, network, addr)
+	}
+}
+
+// peerURL returns the URL of urlPath on the peer at address. relay and
+// rendezvous addresses are replaced by the address of the peer they go
+// through, QUIC and WebRTC addresses use their transport as scheme to be
+// routed to their transport
+func (c *Client) peerURL(address, urlPath string) string {
+	u := url.URL{Scheme: uriProtocolName, Host: address, Path: urlPath}
+
+	peer, err := parsePeerAddress(address)
+	if err != nil {
+		return u.String()
+	}
+
+	u.Host = peer.host
+	if peer.transport != TransportTCP {
+		u.Scheme = peer.transport
+	}
+
+	if peer.via != nil {
+		c.addVia(peer)
+	}
+
+	return u.String()
+}
+
+// addVia remembers the relay or rendezvous peer the peer is reached through
+// so dialing its address reaches the peer instead
+func (c *Client) addVia(peer *peerAddress) {
+	c.viaMutex.Lock()
+	defer c.viaMutex.Unlock()
+
+	if peer.transport == TransportQUIC {
+		c.rendezvous[peer.host] = peer.via
+	} else {
+		c.relays[peer.host] = peer.via
+	}
 }
 
 func (c *Client) createTLSConfig(cert tls.Certificate) *tls.Config {
@@ -96,7 +172,7 @@ func (c *Client) fetchFileList(ctx context.Context, fingerprint Fingerprint, add
 		return nil, fmt.Errorf("peer %s responded with error status %s", fingerprint, resp.Status())
 	}
 
-	result, ok := resp.Result().(*[]FileListItem)
+	result, ok := resp.Result().(*fileList)
 	if !ok || result == nil {
 		return nil, fmt.Errorf("failed to parse response from peer %s", fingerprint)
 	}
@@ -105,7 +181,7 @@ func (c *Client) fetchFileList(ctx context.Context, fingerprint Fingerprint, add
 }
 
 func (c *Client) fetchFileListRequest(ctx context.Context, fingerprint Fingerprint, address string, after time.Time) (*resty.Response, error) {
-	var list []FileListItem
+	var list fileList
 	url := c.buildFileListURL(fingerprint, address)
 
 	return c.client.R().
@@ -117,11 +193,7 @@ func (c *Client) fetchFileListRequest(ctx context.Context, fingerprint Fingerpri
 }
 
 func (c *Client) buildFileListURL(fingerprint Fingerprint, address string) string {
-	return (&url.URL{
-		Scheme: uriProtocolName,
-		Host:   address,
-		Path:   fmt.Sprintf("/p2p/%s", fingerprint),
-	}).String()
+	return c.peerURL(address, fmt.Sprintf("/p2p/%s", fingerprint))
 }
 
 func (c *Client) downloadFiles(ctx context.Context, address string, fingerprint Fingerprint, list []FileListItem, resp *resty.Response) error {
@@ -130,11 +202,12 @@ func (c *Client) downloadFiles(ctx context.Context, address string, fingerprint
 		case <-ctx.Done():
 			return nil
 		default:
-			// Extract filename from path (format: /p2p/{FPR}/{filename})
-			filename := path.Base(list[i].Path)
-			err := c.DownloadFile(ctx, address, fingerprint, filename, &list[i])
-			if err != nil {
-				slog.Error("failed to download file", "url", resp.Request.URL, "file", filename, "error", err)
+			var skipped *SkippedFile
+			err := c.syncFile(ctx, address, fingerprint, &list[i])
+			if errors.As(err, &skipped) {
+				slog.Info("skipped file", "url", resp.Request.URL, "file", path.Base(list[i].Path), "reason", skipped.Reason)
+			} else if err != nil {
+				slog.Error("failed to download file", "url", resp.Request.URL, "file", path.Base(list[i].Path), "error", err)
 			}
 		}
 	}
@@ -142,20 +215,45 @@ func (c *Client) downloadFiles(ctx context.Context, address string, fingerprint
 }
 
 func (c *Client) DownloadFriend(ctx context.Context, fingerprint Fingerprint, after time.Time, fingerprintResolvers []FingerprintResolver) error {
+	_, err := c.SyncFriend(ctx, fingerprint, after, fingerprintResolvers)
+	return err
+}
+
+// SyncFriend downloads the files of a followed friend changed after the given
+// time the download policy allows, and reports the files downloaded and the
+// ones skipped
+func (c *Client) SyncFriend(ctx context.Context, fingerprint Fingerprint, after time.Time, fingerprintResolvers []FingerprintResolver) (*SyncReport, error) {
+	address, err := c.resolveFollowedAddress(ctx, fingerprint, fingerprintResolvers)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, session := withSyncSession(ctx)
+	err = c.downloadFriendFrom(ctx, fingerprint, address, after)
+
+	return session.report, err
+}
+
+// resolveFollowedAddress returns the address of a friend we follow
+func (c *Client) resolveFollowedAddress(ctx context.Context, fingerprint Fingerprint, fingerprintResolvers []FingerprintResolver) (string, error) {
 	if c == nil || c.client == nil {
-		return errors.New("client is not initialized")
+		return "", errors.New("client is not initialized")
 	}
 
 	followed := path.Join(c.account.path, fingerprint.String())
-	if _, err := os.Stat(followed); err != nil {
-		return ErrFriendNotFollowed
+	if !exists(c.account.storage, followed) {
+		return "", ErrFriendNotFollowed
 	}
 
 	address, err := c.resolveFingerprintAddress(ctx, fingerprint, fingerprintResolvers)
 	if err != nil {
-		return fmt.Errorf("failed to resolve address for %s: %w", fingerprint, err)
+		return "", fmt.Errorf("failed to resolve address for %s: %w", fingerprint, err)
 	}
 
+	return address, nil
+}
+
+func (c *Client) downloadFriendFrom(ctx context.Context, fingerprint Fingerprint, address string, after time.Time) error {
 	list, resp, err := c.fetchFileListWithResp(ctx, fingerprint, address, after)
 	if err != nil {
 		return fmt.Errorf("failed to fetch file list for %s: %w", fingerprint, err)
@@ -174,7 +272,7 @@ func (c *Client) fetchFileListWithResp(ctx context.Context, fingerprint Fingerpr
 		return nil, nil, fmt.Errorf("peer %s responded with error status %s", fingerprint, resp.Status())
 	}
 
-	result, ok := resp.Result().(*[]FileListItem)
+	result, ok := resp.Result().(*fileList)
 	if !ok || result == nil {
 		return nil, nil, fmt.Errorf("failed to parse response")
 	}
@@ -183,36 +281,48 @@ func (c *Client) fetchFileListWithResp(ctx context.Context, fingerprint Fingerpr
 }
 
 func (c *Client) fileAlreadyExists(f *File, expectedSize int64, expectedHash string) bool {
-	s, err := f.Size()
-	if err != nil || s != expectedSize {
-		return false
-	}
-
-	h, err := f.Hash()
-	return err == nil && h == expectedHash
+	meta, err := c.account.fileMeta(f)
+	return err == nil && meta.Size == expectedSize && meta.Sum == expectedHash
 }
 
-func (c *Client) downloadFileContent(ctx context.Context, address string, fingerprint Fingerprint, filename string) ([]byte, *resty.Response, error) {
+// downloadFileContent downloads the file, failing with ErrFileTooLarge as soon
+// as the peer sends more than limit bytes
+func (c *Client) downloadFileContent(ctx context.Context, address string, fingerprint Fingerprint, filename string, limit int64) ([]byte, *resty.Response, error) {
 	resp, err := c.client.
 		R().
 		SetContext(ctx).
-		Get(
-			(&url.URL{
-				Scheme: uriProtocolName,
-				Host:   address,
-				Path:   fmt.Sprintf("/p2p/%s/%s", fingerprint, filename),
-			}).String(),
-		)
+		SetDoNotParseResponse(true).
+		Get(c.peerURL(address, fmt.Sprintf("/p2p/%s/%s", fingerprint, filename)))
 
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to download file %s from peer %s: %w", filename, fingerprint, err)
 	}
+	defer resp.RawBody().Close()
 
 	if resp.StatusCode() != http.StatusOK {
 		return nil, nil, fmt.Errorf("server returned status %s while downloading file %s from %s", resp.Status(), filename, resp.Request.URL)
 	}
 
-	return resp.Body(), resp, nil
+	data, err := readLimited(resp.RawBody(), limit)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to download file %s from peer %s: %w", filename, fingerprint, err)
+	}
+
+	return data, resp, nil
+}
+
+// readLimited reads body up to limit bytes, ErrFileTooLarge if it's longer
+func readLimited(body io.Reader, limit int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("%w: received more than %d bytes", ErrFileTooLarge, limit)
+	}
+
+	return data, nil
 }
 
 func validateDownloadedContent(data []byte, file *FileListItem) error {
@@ -231,55 +341,58 @@ func validateDownloadedContent(data []byte, file *FileListItem) error {
 
 func (c *Client) writeAndVerifyTemp(f *File, data []byte, fingerprint Fingerprint) (string, error) {
 	tmpPath := f.Path + ".tmp"
-	err := os.WriteFile(tmpPath, data, FilePerm)
+	err := c.account.storage.WriteFile(tmpPath, data)
 	if err != nil {
 		return "", fmt.Errorf("failed to write temporary file: %w", err)
 	}
 
 	// Verify signature before accepting the file
-	tmpFile := &File{Path: tmpPath}
+	tmpFile := c.account.file(tmpPath)
 	err = tmpFile.VerifySignature(c.account, fingerprint)
 	if err != nil {
-		os.Remove(tmpPath) // Clean up temporary file
+		_ = c.account.storage.Remove(tmpPath) // Clean up temporary file
 		return "", fmt.Errorf("signature verification failed for %s: %w", path.Base(f.Path), err)
 	}
 
 	return tmpPath, nil
 }
 
-func createVersionBackup(f *File, tmpPath string) error {
+func (c *Client) createVersionBackup(f *File, tmpPath string) error {
+	storage := c.account.storage
+
 	// Get hash of existing file
 	existingHash, err := f.Hash()
 	if err != nil {
-		os.Remove(tmpPath)
+		_ = storage.Remove(tmpPath)
 		return fmt.Errorf("failed to hash existing file for versioning: %w", err)
 	}
 
 	// Create version directory (e.g., /path/to/file.txt.pgp.versions/)
 	versionsDir := f.Path + ".versions"
-	if err := os.MkdirAll(versionsDir, DirPerm); err != nil {
-		os.Remove(tmpPath)
+	if err := storage.MkdirAll(versionsDir); err != nil {
+		_ = storage.Remove(tmpPath)
 		return fmt.Errorf("failed to create versions directory: %w", err)
 	}
 
 	// Move existing file to version directory with hash as filename
 	versionPath := path.Join(versionsDir, existingHash)
-	if err := os.Rename(f.Path, versionPath); err != nil {
-		os.Remove(tmpPath)
+	if err := storage.Rename(f.Path, versionPath); err != nil {
+		_ = storage.Remove(tmpPath)
 		return fmt.Errorf("failed to save file version: %w", err)
 	}
+	c.account.index.move(f.Path, versionPath)
 
 	return nil
 }
 
 func (c *Client) DownloadFile(ctx context.Context, address string, fingerprint Fingerprint, filename string, file *FileListItem) error {
-	f := File{
-		Path:    path.Join(c.account.path, fingerprint.String(), filename),
-		version: false,
+	f := c.account.file(path.Join(c.account.path, fingerprint.String(), filename))
+	if c.fileAlreadyExists(f, file.Size, file.Sum) {
+		return nil
 	}
 
-	if c.fileAlreadyExists(&f, file.Size, file.Sum) {
-		return nil
+	if err := c.checkDownloadPolicy(ctx, fingerprint, file); err != nil {
+		return err
 	}
 
 	data, err := c.fetchAndValidateFile(ctx, address, fingerprint, filename, file)
@@ -287,11 +400,16 @@ func (c *Client) DownloadFile(ctx context.Context, address string, fingerprint F
 		return fmt.Errorf("failed to fetch and validate file %s: %w", filename, err)
 	}
 
-	return c.saveVerifiedFile(&f, data, fingerprint)
+	if err := c.saveVerifiedFile(f, data, fingerprint); err != nil {
+		return err
+	}
+
+	recordDownload(ctx, file)
+	return nil
 }
 
 func (c *Client) fetchAndValidateFile(ctx context.Context, address string, fingerprint Fingerprint, filename string, file *FileListItem) ([]byte, error) {
-	data, _, err := c.downloadFileContent(ctx, address, fingerprint, filename)
+	data, _, err := c.downloadFileContent(ctx, address, fingerprint, filename, c.bodyLimit(file))
 	if err != nil {
 		return nil, fmt.Errorf("failed to download file content: %w", err)
 	}
@@ -309,18 +427,18 @@ func (c *Client) saveVerifiedFile(f *File, data []byte, fingerprint Fingerprint)
 		return fmt.Errorf("failed to write and verify temporary file: %w", err)
 	}
 
-	if _, err := os.Stat(f.Path); err == nil {
-		if err := createVersionBackup(f, tmpPath); err != nil {
+	if exists(c.account.storage, f.Path) {
+		if err := c.createVersionBackup(f, tmpPath); err != nil {
 			return fmt.Errorf("failed to create version backup: %w", err)
 		}
 	}
 
-	if err := os.Rename(tmpPath, f.Path); err != nil {
-		os.Remove(tmpPath)
+	if err := c.account.storage.Rename(tmpPath, f.Path); err != nil {
+		_ = c.account.storage.Remove(tmpPath)
 		return fmt.Errorf("failed to save verified file: %w", err)
 	}
 
-	return nil
+	return c.indexDownload(f, data)
 }
 
 func (c *Client) verifyPeerCertificate(rawCerts [][]byte, _ [][]*x509.Certificate) error {
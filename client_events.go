@@ -0,0 +1,117 @@
+package mau
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var ErrEventStreamEnded = errors.New("Event stream ended")
+
+// FileEventHandler is called for every file event of a watched friend, after
+// the file is downloaded for created and changed files
+type FileEventHandler func(fingerprint Fingerprint, event *FileEvent)
+
+// WatchFriend subscribes to the file events of the friend and downloads
+// created and changed files as soon as they are announced. files changed
+// after after are synced once subscribed so no change is missed in between.
+// it blocks until ctx is cancelled or the stream ends. handler may be nil.
+func (c *Client) WatchFriend(ctx context.Context, fingerprint Fingerprint, after time.Time, fingerprintResolvers []FingerprintResolver, handler FileEventHandler) error {
+	address, err := c.resolveFollowedAddress(ctx, fingerprint, fingerprintResolvers)
+	if err != nil {
+		return err
+	}
+
+	stream, err := c.openEvents(ctx, fingerprint, address)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = stream.Close() }()
+
+	if err := c.downloadFriendFrom(ctx, fingerprint, address, after); err != nil {
+		return err
+	}
+
+	err = readServerSentEvents(stream, func(_, data string) {
+		c.handleFileEvent(ctx, address, fingerprint, data, handler)
+	})
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	return fmt.Errorf("%w: %s: %w", ErrEventStreamEnded, fingerprint, err)
+}
+
+// openEvents opens the event stream of the fingerprint, the stream isn't
+// subject to the client timeout
+func (c *Client) openEvents(ctx context.Context, fingerprint Fingerprint, address string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.peerURL(address, fmt.Sprintf("/p2p/%s/events", fingerprint)), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := c.client.GetClient().Transport.RoundTrip(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to subscribe to events of %s: %w", fingerprint, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("peer %s responded with error status %s", fingerprint, resp.Status)
+	}
+
+	return resp.Body, nil
+}
+
+func (c *Client) handleFileEvent(ctx context.Context, address string, fingerprint Fingerprint, data string, handler FileEventHandler) {
+	var event FileEvent
+	if err := json.Unmarshal([]byte(data), &event); err != nil {
+		slog.Error("invalid file event", "peer", fingerprint, "error", err)
+		return
+	}
+
+	if event.Type == FileCreated || event.Type == FileChanged {
+		if err := c.syncFile(ctx, address, fingerprint, &event.FileListItem); err != nil {
+			slog.Error("failed to download file", "peer", fingerprint, "file", event.Path, "error", err)
+			return
+		}
+	}
+
+	if handler != nil {
+		handler(fingerprint, &event)
+	}
+}
+
+// readServerSentEvents calls handle with the type and data of every event
+// until r ends
+func readServerSentEvents(r io.Reader, handle func(kind, data string)) error {
+	var kind string
+	var data []string
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+
+		switch {
+		case line == "" && len(data) > 0:
+			handle(kind, strings.Join(data, "\n"))
+			kind, data = "", nil
+		case field == "event":
+			kind = value
+		case field == "data":
+			data = append(data, value)
+		}
+	}
+
+	return scanner.Err()
+}
@@ -0,0 +1,116 @@
+package mau
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatchFriend(t *testing.T) {
+	friend, err := NewAccount(t.TempDir(), "Mohamed Mahmoud", "mohamed@example.com", "password")
+	require.NoError(t, err)
+	accountDir := t.TempDir()
+	account, err := NewAccount(accountDir, "Ahmed Mohamed", "ahmed@example.com", "password")
+	require.NoError(t, err)
+
+	aFriend := befriend(t, friend, account)
+	require.NoError(t, account.Follow(befriend(t, account, friend)))
+
+	_, err = friend.AddFile(strings.NewReader("Before watching"), "before.txt", []*Friend{aFriend})
+	require.NoError(t, err)
+
+	server, err := friend.Server(nil)
+	require.NoError(t, err)
+	listener, address := TempListener()
+	go func() { _ = server.Serve(*listener, "") }()
+	defer server.Close()
+
+	client, err := account.Client(friend.Fingerprint(), nil)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := make(chan *FileEvent, 10)
+	watching := make(chan error, 1)
+	go func() {
+		watching <- client.WatchFriend(ctx, friend.Fingerprint(), time.Time{}, []FingerprintResolver{StaticAddress(address)}, func(_ Fingerprint, event *FileEvent) {
+			events <- event
+		})
+	}()
+
+	t.Run("Syncs files changed before subscribing", func(t T) {
+		assert.Eventually(t, func() bool {
+			_, err := account.GetFile(friend.Fingerprint(), "before.txt.pgp")
+			return err == nil
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("Downloads files as soon as they are announced", func(t T) {
+		_, err := friend.AddFile(strings.NewReader("Hello in real time"), "hello.txt", []*Friend{aFriend})
+		require.NoError(t, err)
+
+		select {
+		case event := <-events:
+			assert.Equal(t, FileCreated, event.Type)
+			assert.Equal(t, "hello.txt.pgp", path.Base(event.Path))
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "no file event received")
+		}
+
+		assert.FileExists(t, path.Join(accountDir, friend.Fingerprint().String(), "hello.txt.pgp"))
+	})
+
+	t.Run("Doesn't announce files shared with others", func(t T) {
+		_, err := friend.AddFile(strings.NewReader("Private"), "private.txt", nil)
+		require.NoError(t, err)
+		_, err = friend.AddFile(strings.NewReader("Shared"), "shared.txt", []*Friend{aFriend})
+		require.NoError(t, err)
+
+		select {
+		case event := <-events:
+			assert.Equal(t, "shared.txt.pgp", path.Base(event.Path))
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "no file event received")
+		}
+
+		assert.NoFileExists(t, path.Join(accountDir, friend.Fingerprint().String(), "private.txt.pgp"))
+	})
+
+	t.Run("Returns when the context is cancelled", func(t T) {
+		cancel()
+		assert.NoError(t, <-watching)
+	})
+}
+
+func TestEventsOverBufferedTransports(t *testing.T) {
+	account, err := NewAccount(t.TempDir(), "Ahmed Mohamed", "ahmed@example.com", "password")
+	require.NoError(t, err)
+	server, err := account.Server(nil)
+	require.NoError(t, err)
+
+	w := newResponseBuffer()
+	server.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/p2p/"+account.Fingerprint().String()+"/events", nil))
+	assert.Equal(t, http.StatusNotImplemented, w.status)
+}
+
+func TestReadServerSentEvents(t *testing.T) {
+	stream := "event: created\ndata: {\"path\":\"a\"}\n\n\n: comment\nevent: deleted\ndata: line 1\ndata: line 2\n\n"
+
+	var kinds, data []string
+	err := readServerSentEvents(strings.NewReader(stream), func(kind, d string) {
+		kinds = append(kinds, kind)
+		data = append(data, d)
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{"created", "deleted"}, kinds)
+	assert.Equal(t, []string{`{"path":"a"}`, "line 1\nline 2"}, data)
+}
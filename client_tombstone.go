@@ -0,0 +1,58 @@
+package mau
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+)
+
+// SetArchiveDeleted makes the client keep the local copy of files the peer
+// deleted as a version of the file instead of removing it with its versions
+func (c *Client) SetArchiveDeleted(archive bool) {
+	c.archiveDeleted = archive
+}
+
+// syncFile downloads a listed file and applies it when it's a tombstone
+func (c *Client) syncFile(ctx context.Context, address string, fingerprint Fingerprint, item *FileListItem) error {
+	filename := path.Base(item.Path)
+	if err := c.DownloadFile(ctx, address, fingerprint, filename, item); err != nil {
+		return err
+	}
+
+	if isTombstoneName(filename) {
+		return c.applyTombstone(fingerprint, filename)
+	}
+
+	// a file shared again after its deletion
+	return c.account.removeTombstone(path.Join(c.account.path, fingerprint.String(), filename))
+}
+
+// applyTombstone removes or archives the local copy of the file the
+// downloaded tombstone is about. an invalid tombstone is removed.
+func (c *Client) applyTombstone(fingerprint Fingerprint, filename string) error {
+	dir := path.Join(c.account.path, fingerprint.String())
+
+	tombstonePath := path.Join(dir, filename)
+	tombstone, err := c.account.file(tombstonePath).Tombstone(c.account)
+	if err != nil {
+		if rerr := removeIfExists(c.account.storage, tombstonePath); rerr != nil {
+			return errors.Join(fmt.Errorf("invalid tombstone %s: %w", filename, err), rerr)
+		}
+		c.account.index.forget(tombstonePath)
+		c.account.index.save()
+
+		return fmt.Errorf("invalid tombstone %s: %w", filename, err)
+	}
+
+	file := c.account.file(path.Join(dir, tombstone.Name))
+	if !exists(c.account.storage, file.Path) {
+		return nil
+	}
+
+	if c.archiveDeleted {
+		return c.account.handleFileVersioning(file.Path)
+	}
+
+	return c.account.RemoveFile(file)
+}
@@ -0,0 +1,135 @@
+package mau
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path"
+)
+
+var (
+	ErrVersionNameMismatch = errors.New("Version isn't named after its hash")
+)
+
+// DownloadVersions downloads the old versions of a file of a followed friend
+// we don't have yet. only the versions we are permitted to read are listed by
+// the friend, each one is checked against its hash and signature.
+func (c *Client) DownloadVersions(ctx context.Context, fingerprint Fingerprint, filename string, fingerprintResolvers []FingerprintResolver) error {
+	if err := validateFileName(filename); err != nil {
+		return err
+	}
+
+	address, err := c.resolveFollowedAddress(ctx, fingerprint, fingerprintResolvers)
+	if err != nil {
+		return err
+	}
+
+	list, err := c.fetchVersionList(ctx, fingerprint, address, filename)
+	if err != nil {
+		return err
+	}
+
+	versionsDir := path.Join(c.account.path, fingerprint.String(), filename+".versions")
+	if err := c.account.storage.MkdirAll(versionsDir); err != nil {
+		return err
+	}
+
+	ctx, _ = withSyncSession(ctx)
+	for i := range list {
+		var skipped *SkippedFile
+		err := c.downloadVersion(ctx, address, fingerprint, versionsDir, &list[i])
+		if errors.As(err, &skipped) {
+			slog.Info("skipped version", "peer", fingerprint, "version", list[i].Path, "reason", skipped.Reason)
+		} else if err != nil {
+			slog.Error("failed to download version", "peer", fingerprint, "version", list[i].Path, "error", err)
+		}
+	}
+
+	return nil
+}
+
+func (c *Client) fetchVersionList(ctx context.Context, fingerprint Fingerprint, address, filename string) ([]FileListItem, error) {
+	var list fileList
+	resp, err := c.client.R().
+		SetContext(ctx).
+		SetResult(&list).
+		ForceContentType("application/json").
+		Get(c.peerURL(address, fmt.Sprintf("/p2p/%s/%s.versions", fingerprint, filename)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch versions of %s from %s: %w", filename, fingerprint, err)
+	}
+
+	if resp.StatusCode() != http.StatusOK {
+		return nil, fmt.Errorf("peer %s responded with error status %s", fingerprint, resp.Status())
+	}
+
+	return list, nil
+}
+
+// downloadVersion saves a listed version in versionsDir unless it's there
+// already or the download policy refuses it. versions are named after their
+// hash.
+func (c *Client) downloadVersion(ctx context.Context, address string, fingerprint Fingerprint, versionsDir string, item *FileListItem) error {
+	hash := path.Base(item.Path)
+	if hash != item.Sum || validateFileName(hash) != nil {
+		return ErrVersionNameMismatch
+	}
+
+	f := &File{Path: path.Join(versionsDir, hash), version: true, storage: c.account.storage}
+	if c.fileAlreadyExists(f, item.Size, item.Sum) {
+		return nil
+	}
+
+	if err := c.checkDownloadPolicy(ctx, fingerprint, item); err != nil {
+		return err
+	}
+
+	data, err := c.downloadPath(ctx, address, item.Path, c.bodyLimit(item))
+	if err != nil {
+		return err
+	}
+
+	if err := validateDownloadedContent(data, item); err != nil {
+		return err
+	}
+
+	tmpPath, err := c.writeAndVerifyTemp(f, data, fingerprint)
+	if err != nil {
+		return err
+	}
+
+	if err := c.account.storage.Rename(tmpPath, f.Path); err != nil {
+		return err
+	}
+
+	if err := c.indexDownload(f, data); err != nil {
+		return err
+	}
+
+	recordDownload(ctx, item)
+	return nil
+}
+
+func (c *Client) downloadPath(ctx context.Context, address, urlPath string, limit int64) ([]byte, error) {
+	resp, err := c.client.R().
+		SetContext(ctx).
+		SetDoNotParseResponse(true).
+		Get(c.peerURL(address, urlPath))
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", urlPath, err)
+	}
+	defer resp.RawBody().Close()
+
+	if resp.StatusCode() != http.StatusOK {
+		return nil, fmt.Errorf("server returned status %s while downloading %s", resp.Status(), urlPath)
+	}
+
+	data, err := readLimited(resp.RawBody(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", urlPath, err)
+	}
+
+	return data, nil
+}
@@ -0,0 +1,868 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"path"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	. "github.com/mau-network/mau"
+)
+
+// allCommands returns the commands of the CLI in the order help lists them.
+// setup defines the flags of the command and returns its action, called with
+// the arguments left after the flags.
+func allCommands() []*command {
+	return []*command{
+		{
+			name:    "init",
+			summary: "Initialize new account in the account directory",
+			setup: func(fs *flag.FlagSet) func(args []string) {
+				name := fs.String("name", "", "name")
+				email := fs.String("email", "", "email")
+				passphrase := fs.String("passphrase", "", "passphrase (if empty, prompt interactively)")
+
+				return func(args []string) {
+					pass := *passphrase
+					if pass == "" {
+						pass = getPasswordFunc()
+					}
+
+					fmt.Println("Initializing account...")
+					_, err := NewAccount(root, *name, *email, pass)
+					raise(err)
+					fmt.Println("Done")
+				}
+			},
+		},
+		{
+			name:    "show",
+			summary: "Show current account information",
+			setup: func(fs *flag.FlagSet) func(args []string) {
+				passphrase := fs.String("passphrase", "", "passphrase (if empty, prompt interactively)")
+
+				return func(args []string) {
+					account := getAccountWithPassphrase(*passphrase)
+					if opts.json {
+						printJSON(friendJSON{Name: account.Name(), Email: account.Email(), Fingerprint: account.Fingerprint().String()})
+						return
+					}
+
+					fmt.Println("Name: ", account.Name())
+					fmt.Println("Email: ", account.Email())
+					fmt.Println("Fingerprint: ", account.Fingerprint())
+				}
+			},
+		},
+		{
+			name:    "export",
+			summary: "Export account public key to file",
+			setup: func(fs *flag.FlagSet) func(args []string) {
+				output := fs.String("output", "/dev/stdout", "output")
+
+				return func(args []string) {
+					account := getAccount()
+
+					out, err := os.OpenFile(*output, os.O_CREATE|os.O_RDWR|os.O_TRUNC, FilePerm)
+					raise(err)
+					defer func() {
+						if err := out.Close(); err != nil {
+							raise(err)
+						}
+					}()
+
+					err = account.Export(out)
+					raise(err)
+				}
+			},
+		},
+		{
+			name:    "friend",
+			summary: "Add a friend using this public key file",
+			setup: func(fs *flag.FlagSet) func(args []string) {
+				key := fs.String("key", "", "path to key file")
+
+				return func(args []string) {
+					account := getAccount()
+
+					keyFile, err := os.OpenFile(*key, os.O_RDONLY, 0600)
+					raise(err)
+					defer func() { _ = keyFile.Close() }()
+
+					friend, err := account.AddFriend(keyFile)
+					raise(err)
+
+					fmt.Println("Friend added: ", friend.Name(), friend.Email(), friend.Fingerprint())
+				}
+			},
+		},
+		{
+			name:    "friends",
+			summary: "List all friends",
+			setup: func(_ *flag.FlagSet) func(args []string) {
+				return func(args []string) {
+					account := getAccount()
+
+					friends, err := account.ListFriends()
+					raise(err)
+
+					if opts.json {
+						printJSON(keyringToJSON(friends))
+						return
+					}
+					printKeyring("", friends)
+				}
+			},
+		},
+		{
+			name:    "unfriend",
+			summary: "Remove a friend",
+			setup: func(fs *flag.FlagSet) func(args []string) {
+				fingerprint := fs.String("fingerprint", "", "fingerprint of the friend account")
+
+				return func(args []string) {
+					account := getAccount()
+
+					friends, err := account.ListFriends()
+					raise(err)
+
+					fpr, err := FingerprintFromString(*fingerprint)
+					raise(err)
+
+					friend := friends.FindByFingerprint(fpr)
+					if friend == nil {
+						raise(fmt.Errorf("%w: %s", ErrCantFindFriend, *fingerprint))
+					}
+
+					fmt.Println("Removing friend: ", friend.Name(), friend.Email(), "...")
+					raise(account.RemoveFriend(friend))
+					fmt.Println("Done")
+				}
+			},
+		},
+		{
+			name:    "follow",
+			summary: "Follow friend posts",
+			setup: func(fs *flag.FlagSet) func(args []string) {
+				fingerprint := fs.String("fingerprint", "", "fingerprint of the friend account")
+
+				return func(args []string) {
+					account := getAccount()
+
+					friends, err := account.ListFriends()
+					raise(err)
+
+					fpr, err := FingerprintFromString(*fingerprint)
+					raise(err)
+
+					friend := friends.FindByFingerprint(fpr)
+					if friend == nil {
+						raise(fmt.Errorf("%w: %s", ErrCantFindFriend, *fingerprint))
+					}
+
+					fmt.Println("Following friend: ", friend.Name(), friend.Email(), "...")
+					raise(account.Follow(friend))
+					fmt.Println("Done")
+				}
+			},
+		},
+		{
+			name:    "unfollow",
+			summary: "Unfollow friend posts",
+			setup: func(fs *flag.FlagSet) func(args []string) {
+				fingerprint := fs.String("fingerprint", "", "fingerprint of the friend account")
+
+				return func(args []string) {
+					account := getAccount()
+
+					friends, err := account.ListFriends()
+					raise(err)
+
+					fpr, err := FingerprintFromString(*fingerprint)
+					raise(err)
+
+					friend := friends.FindByFingerprint(fpr)
+					if friend == nil {
+						raise(fmt.Errorf("%w: %s", ErrCantFindFriend, *fingerprint))
+					}
+
+					fmt.Println("Unfollowing friend: ", friend.Name(), friend.Email(), "...")
+					raise(account.Unfollow(friend))
+					fmt.Println("Done")
+				}
+			},
+		},
+		{
+			name:    "follows",
+			summary: "List friends you follow",
+			setup: func(_ *flag.FlagSet) func(args []string) {
+				return func(args []string) {
+					account := getAccount()
+
+					friends, err := account.ListFollows()
+					raise(err)
+
+					if opts.json {
+						printJSON(friendsToJSON(friends))
+						return
+					}
+					for _, f := range friends {
+						fmt.Println(f.Name(), f.Email(), f.Fingerprint())
+					}
+				}
+			},
+		},
+		{
+			name:    "group",
+			summary: "Create, rename or delete a group of friends and manage its members",
+			setup: func(fs *flag.FlagSet) func(args []string) {
+				name := fs.String("name", "", "group name")
+				create := fs.Bool("create", false, "create the group")
+				del := fs.Bool("delete", false, "delete the group, members in no other group stay friends")
+				rename := fs.String("rename", "", "new name of the group")
+				add := fs.String("add", "", "fingerprint of a friend to add to the group")
+				remove := fs.String("remove", "", "fingerprint of a friend to remove from the group")
+
+				return func(args []string) {
+					account := getAccount()
+
+					switch {
+					case *create:
+						raise(account.CreateGroup(*name))
+					case *del:
+						raise(account.RemoveGroup(*name))
+					case *rename != "":
+						raise(account.RenameGroup(*name, *rename))
+					case *add != "":
+						raise(account.AddToGroup(findFriend(account, *add), *name))
+					case *remove != "":
+						raise(account.RemoveFromGroup(findFriend(account, *remove), *name))
+					default:
+						group, err := account.Group(*name)
+						raise(err)
+						printKeyring("", group)
+						return
+					}
+					fmt.Println("Done")
+				}
+			},
+		},
+		{
+			name:    "groups",
+			summary: "List groups and their members",
+			setup: func(_ *flag.FlagSet) func(args []string) {
+				return func(args []string) {
+					account := getAccount()
+
+					groups, err := account.Groups()
+					raise(err)
+
+					for _, g := range groups {
+						printKeyring("", g)
+					}
+				}
+			},
+		},
+		{
+			name:    "share",
+			summary: "Share a file with friends following you",
+			setup: func(fs *flag.FlagSet) func(args []string) {
+				file := fs.String("file", "", "file path to share")
+				fingerprints := fs.String("fingerprints", "", "comma separated list of fingerprints to share the file with")
+				groups := fs.String("group", "", "comma separated list of groups to share the file with")
+
+				return func(args []string) {
+					account := getAccount()
+
+					f, err := os.Open(*file)
+					raise(err)
+
+					allFrields, err := account.ListFriends()
+					raise(err)
+
+					friends := []*Friend{}
+					if *groups != "" {
+						friends, err = account.GroupRecipients(strings.Split(*groups, ",")...)
+						raise(err)
+					}
+
+					fprs := strings.Split(*fingerprints, ",")
+					for _, fprStr := range fprs {
+						if fprStr == "" && *groups != "" {
+							continue
+						}
+
+						fpr, err := FingerprintFromString(fprStr)
+						if err != nil {
+							raise(fmt.Errorf("Can't parse %s as fingerprint", fprStr))
+						}
+
+						f := allFrields.FindByFingerprint(fpr)
+						if f == nil {
+							raise(fmt.Errorf("%w: %s", ErrCantFindFriend, fprStr))
+						}
+
+						if !slices.ContainsFunc(friends, func(g *Friend) bool { return g.Fingerprint().Equal(fpr) }) {
+							friends = append(friends, f)
+						}
+					}
+
+					name := path.Base(*file)
+					_, err = account.AddFile(f, name, friends)
+					raise(err)
+				}
+			},
+		},
+		{
+			name:    "files",
+			summary: "List files you share with your followers",
+			setup: func(fs *flag.FlagSet) func(args []string) {
+				fingerprint := fs.String("fingerprint", "", "fingerprint of the friend account")
+
+				return func(args []string) {
+					account := getAccount()
+					var fpr Fingerprint
+
+					if *fingerprint == "" {
+						fpr = account.Fingerprint()
+					} else {
+						var err error
+						fpr, err = FingerprintFromString(*fingerprint)
+						raise(err)
+					}
+
+					after := time.Date(0, 0, 0, 0, 0, 0, 0, time.UTC)
+					files := account.ListFiles(fpr, after, 0)
+					list := []fileJSON{}
+					for _, f := range files {
+						if f.Deleted() {
+							continue
+						}
+
+						rs, err := f.Recipients(account)
+						if opts.json {
+							item := fileJSON{Name: f.Name(), Recipients: friendsToJSON(rs)}
+							item.Size, _ = f.Size()
+							if err != nil {
+								item.Error = err.Error()
+							}
+							list = append(list, item)
+							continue
+						}
+
+						fmt.Println(f.Name())
+						if err != nil {
+							fmt.Println("Error: ", err)
+							continue
+						}
+
+						for _, r := range rs {
+							fmt.Println("\t", r.Name(), r.Email(), r.Fingerprint())
+						}
+					}
+					if opts.json {
+						printJSON(list)
+					}
+				}
+			},
+		},
+		{
+			name:    "timeline",
+			summary: "Show your posts and the posts of friends you follow, newest first",
+			setup: func(fs *flag.FlagSet) func(args []string) {
+				limit := fs.Uint("limit", 20, "number of items to show, 0 for all")
+				before := fs.String("before", "", "show items signed before this RFC 3339 time")
+				types := fs.String("type", "", "comma separated list of Schema.org types to show")
+				fingerprints := fs.String("fingerprints", "", "comma separated list of authors to show")
+
+				return func(args []string) {
+					account := getAccount()
+					opts := FeedOptions{Limit: *limit}
+
+					if *before != "" {
+						var err error
+						opts.Before, err = time.Parse(time.RFC3339, *before)
+						raise(err)
+					}
+					if *types != "" {
+						opts.Types = strings.Split(*types, ",")
+					}
+					if *fingerprints != "" {
+						for _, fprStr := range strings.Split(*fingerprints, ",") {
+							fpr, err := FingerprintFromString(fprStr)
+							raise(err)
+							opts.Authors = append(opts.Authors, fpr)
+						}
+					}
+
+					items, err := account.Feed(opts)
+					raise(err)
+
+					for _, item := range items {
+						printFeedItem(item)
+					}
+					if *limit > 0 && uint(len(items)) >= *limit {
+						fmt.Println("More: mau timeline -before", items[len(items)-1].Signed.Format(time.RFC3339))
+					}
+				}
+			},
+		},
+		{
+			name:    "search",
+			summary: "Search your posts and the posts of friends you follow",
+			args:    "[WORDS...]",
+			setup: func(fs *flag.FlagSet) func(args []string) {
+				limit := fs.Uint("limit", 20, "number of items to show, 0 for all")
+				after := fs.String("after", "", "show items signed after this RFC 3339 time")
+				before := fs.String("before", "", "show items signed before this RFC 3339 time")
+				types := fs.String("type", "", "comma separated list of Schema.org types to show")
+				fingerprints := fs.String("fingerprints", "", "comma separated list of authors to show")
+				rebuild := fs.Bool("rebuild", false, "build the search index again from your files")
+				disable := fs.Bool("disable", false, "remove the search index")
+
+				return func(args []string) {
+					account := getAccount()
+
+					if *disable {
+						raise(account.DisableSearch())
+						fmt.Println("Search index removed")
+						return
+					}
+
+					// the index is built on first search, then updated with synced files
+					if *rebuild || !account.SearchEnabled() {
+						fmt.Fprintln(os.Stderr, "Building search index...")
+						raise(account.RebuildSearchIndex())
+					} else {
+						raise(account.UpdateSearchIndex())
+					}
+
+					query := SearchQuery{Text: strings.Join(args, " "), Limit: *limit}
+					if *after != "" {
+						var err error
+						query.After, err = time.Parse(time.RFC3339, *after)
+						raise(err)
+					}
+					if *before != "" {
+						var err error
+						query.Before, err = time.Parse(time.RFC3339, *before)
+						raise(err)
+					}
+					if *types != "" {
+						query.Types = strings.Split(*types, ",")
+					}
+					if *fingerprints != "" {
+						for _, fprStr := range strings.Split(*fingerprints, ",") {
+							fpr, err := FingerprintFromString(fprStr)
+							raise(err)
+							query.Authors = append(query.Authors, fpr)
+						}
+					}
+
+					items, err := account.Search(query)
+					raise(err)
+
+					for _, item := range items {
+						printFeedItem(item)
+					}
+					if len(items) == 0 {
+						fmt.Println("No results")
+					}
+				}
+			},
+		},
+		{
+			name:    "chat",
+			summary: "List your conversations, read and send messages",
+			setup: func(fs *flag.FlagSet) func(args []string) {
+				fingerprints := fs.String("with", "", "comma separated list of friends in the conversation (empty to list conversations)")
+				send := fs.String("send", "", "message to send to the conversation")
+				limit := fs.Int("limit", 20, "number of messages to show, 0 for all")
+				sync := fs.Bool("sync", false, "sync the friends of the conversation first")
+				address := fs.String("address", "", "address to sync the friends from")
+
+				return func(args []string) {
+					account := getAccount()
+
+					if *fingerprints == "" {
+						conversations, err := account.Conversations()
+						raise(err)
+						for _, c := range conversations {
+							printConversation(c)
+						}
+						return
+					}
+
+					keyring, err := account.ListFriends()
+					raise(err)
+
+					friends := []*Friend{}
+					participants := []Fingerprint{account.Fingerprint()}
+					for _, fprStr := range strings.Split(*fingerprints, ",") {
+						fpr, err := FingerprintFromString(fprStr)
+						if err != nil {
+							raise(fmt.Errorf("Can't parse %s as fingerprint", fprStr))
+						}
+
+						f := keyring.FindByFingerprint(fpr)
+						if f == nil {
+							raise(fmt.Errorf("%w: %s", ErrCantFindFriend, fprStr))
+						}
+						friends = append(friends, f)
+						participants = append(participants, fpr)
+					}
+
+					if *sync {
+						ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+						defer cancel()
+						if err := account.SyncConversation(ctx, participants, conf.resolvers(*address)); err != nil {
+							log.Printf("Failed to sync the conversation: %v", err)
+						}
+					}
+
+					if *send != "" {
+						_, err := account.SendMessage(*send, friends)
+						raise(err)
+					}
+
+					id := ConversationID(participants)
+					messages, err := account.Messages(id)
+					raise(err)
+
+					if *limit > 0 && len(messages) > *limit {
+						messages = messages[len(messages)-*limit:]
+					}
+					for _, m := range messages {
+						fmt.Printf("%s  %s: %s\n", m.Signed.Local().Format("2006-01-02 15:04"), m.Sender.Name(), m.Message.Text)
+					}
+					if len(messages) > 0 {
+						raise(account.MarkRead(id, messages[len(messages)-1].Signed))
+					}
+				}
+			},
+		},
+		{
+			name:    "open",
+			summary: "Open a file shared with you",
+			setup: func(fs *flag.FlagSet) func(args []string) {
+				file := fs.String("file", "", "file path to open")
+				output := fs.String("output", "/dev/stdout", "output")
+				fingerprint := fs.String("fingerprint", "", "fingerprint of the friend account")
+
+				return func(args []string) {
+					account := getAccount()
+					var fpr Fingerprint
+
+					if *fingerprint == "" {
+						fpr = account.Fingerprint()
+					} else {
+						var err error
+						fpr, err = FingerprintFromString(*fingerprint)
+						raise(err)
+					}
+
+					f, err := account.GetFile(fpr, *file)
+					raise(err)
+
+					r, err := f.Reader(account)
+					raise(err)
+
+					out, err := os.OpenFile(*output, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, FilePerm)
+					raise(err)
+					defer func() {
+						if err := out.Close(); err != nil {
+							raise(err)
+						}
+					}()
+
+					_, err = io.Copy(out, r)
+					raise(err)
+				}
+			},
+		},
+		{
+			name:    "delete",
+			summary: "Delete a file you shared previously",
+			setup: func(fs *flag.FlagSet) func(args []string) {
+				file := fs.String("file", "", "file name to delete")
+
+				return func(args []string) {
+					account := getAccount()
+					f, err := account.GetFile(account.Fingerprint(), *file)
+					raise(err)
+
+					raise(account.RemoveFile(f))
+					fmt.Println("Deleted", f.Name())
+				}
+			},
+		},
+		{
+			name:    "gc",
+			summary: "Prune old file versions the retention policy doesn't keep and unused attachments",
+			setup: func(fs *flag.FlagSet) func(args []string) {
+				dryRun := fs.Bool("dry-run", false, "report the versions and attachments to prune without removing them")
+				fprStr := fs.String("fingerprint", "", "directory to set the retention of (default: every directory)")
+				keepLast := fs.Int("keep-last", 0, "keep the last N versions of every file")
+				keepDays := fs.Int("keep-days", 0, "keep versions made in the last D days")
+				keepReferenced := fs.Bool("keep-referenced", false, "keep versions other files link to")
+
+				return func(args []string) {
+					account := getAccount()
+
+					// retention flags replace the retention of the directory
+					changed := false
+					fs.Visit(func(f *flag.Flag) { changed = changed || strings.HasPrefix(f.Name, "keep-") })
+					if changed {
+						var fpr Fingerprint
+						if *fprStr != "" {
+							var err error
+							fpr, err = FingerprintFromString(*fprStr)
+							raise(err)
+						}
+
+						raise(account.SetVersionRetention(fpr, &VersionRetention{
+							KeepLast:       *keepLast,
+							KeepFor:        time.Duration(*keepDays) * 24 * time.Hour,
+							KeepReferenced: *keepReferenced,
+						}))
+					}
+
+					report, err := account.PruneVersions(*dryRun)
+					raise(err)
+					printPruneReport(report)
+
+					attachments, err := account.PruneAttachments(*dryRun)
+					raise(err)
+					printAttachmentPruneReport(attachments)
+				}
+			},
+		},
+		{
+			name:    "serve",
+			summary: "Open a server to allow followers to sync your content",
+			setup: func(fs *flag.FlagSet) func(args []string) {
+				passphrase := fs.String("passphrase", "", "passphrase (if empty, prompt interactively)")
+				port := fs.Int("port", conf.Port, "port to listen on (0 for random)")
+				quic := fs.Bool("quic", false, "also serve over QUIC (HTTP/3) on the same UDP port")
+				retention := fs.Duration("tombstone-retention", TombstoneRetention, "how long tombstones of deleted files are kept")
+				syncInterval := fs.Duration("sync-interval", conf.SyncInterval, "sync the followed friends at this interval (0 not to sync)")
+
+				return func(args []string) {
+					account := getAccountWithPassphrase(*passphrase)
+					peers, err := conf.bootstrapPeers()
+					raise(err)
+					server, err := account.Server(peers)
+					raise(err)
+
+					listener, err := ListenTCP(fmt.Sprintf(":%d", *port))
+					raise(withCode(exitNetwork, err))
+
+					portNum := listener.Addr().(*net.TCPAddr).Port
+					fmt.Println("Account: ", account.Name(), account.Fingerprint())
+					fmt.Println("Using port:", portNum)
+
+					go expireTombstones(account, *retention)
+					if *syncInterval > 0 {
+						go syncFollows(account, *syncInterval, conf.resolvers(""))
+					}
+					if account.SearchEnabled() {
+						go account.WatchSearch(context.Background())
+					}
+
+					if *quic {
+						conn, err := ListenUDP(fmt.Sprintf(":%d", portNum))
+						raise(withCode(exitNetwork, err))
+						go func() {
+							if err := server.ServeQUIC(conn); err != nil {
+								log.Printf("QUIC server error: %v", err)
+							}
+						}()
+					}
+
+					raise(withCode(exitNetwork, server.Serve(listener, "")))
+				}
+			},
+		},
+		{
+			name:    "sync",
+			summary: "Sync content from a friend",
+			setup: func(fs *flag.FlagSet) func(args []string) {
+				fprStr := fs.String("fingerprint", "", "user fingerprint to sync files")
+				address := fs.String("address", "", "source address to sync from")
+				full := fs.Bool("full", false, "perform full sync instead of incremental")
+				watch := fs.Bool("watch", false, "keep syncing files as the friend announces them")
+				archive := fs.Bool("archive-deleted", false, "keep files the friend deleted as versions instead of removing them")
+				maxFileSize := fs.Int64("max-file-size", 0, "skip files larger than this many bytes (0 for no limit)")
+				maxFriendBytes := fs.Int64("max-friend-bytes", 0, "skip files that would grow the friend directory beyond this many bytes (0 for no limit)")
+				maxFiles := fs.Int("max-files", 0, "download at most this many files (0 for no limit)")
+				lazyAttachments := fs.Bool("lazy-attachments", false, "download attachments when they're opened instead of during sync")
+				interval := fs.Duration("interval", 0, "keep syncing the friend at this interval (0 to sync once)")
+
+				return func(args []string) {
+					account := getAccount()
+					var fpr Fingerprint
+					fpr, err := FingerprintFromString(*fprStr)
+					raise(err)
+
+					client, err := account.Client(fpr, nil)
+					raise(err)
+					client.SetArchiveDeleted(*archive)
+					client.SetDownloadPolicy(DownloadPolicy{
+						MaxFileSize:     *maxFileSize,
+						MaxFriendBytes:  *maxFriendBytes,
+						MaxSyncFiles:    *maxFiles,
+						LazyAttachments: *lazyAttachments,
+					})
+
+					// Get the latest synced file date for incremental sync
+					// Use zero time for full sync or first-time sync
+					var t time.Time
+					if *full {
+						t = time.Time{}
+						status("Performing full sync...")
+					} else {
+						t = account.GetLastSyncTime(fpr)
+						if t.IsZero() {
+							status("No previous sync found, performing full sync...")
+						} else {
+							status("Performing incremental sync (since %s)...", t.Format(time.RFC3339))
+						}
+					}
+
+					resolvers := conf.resolvers(*address)
+					raise(syncFriend(account, client, fpr, t, resolvers))
+
+					switch {
+					case *watch:
+						if account.SearchEnabled() {
+							go account.WatchSearch(context.Background())
+						}
+						watchFriend(client, fpr, account.GetLastSyncTime(fpr), resolvers)
+					case *interval > 0:
+						syncEvery(account, client, fpr, *interval, resolvers)
+					}
+				}
+			},
+		},
+		{
+			name:    "peers",
+			summary: "List friends reachable on the local network",
+			setup: func(fs *flag.FlagSet) func(args []string) {
+				timeout := fs.Duration("timeout", 5*time.Second, "how long to look for friends on the local network")
+
+				return func(args []string) {
+					account := getAccount()
+
+					friends, err := account.ListFriends()
+					raise(err)
+
+					ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+					defer cancel()
+					peers := findPeers(ctx, friends.FriendsSet())
+
+					if opts.json {
+						printJSON(peers)
+						return
+					}
+					for _, p := range peers {
+						fmt.Println(p.Name, p.Email, p.Fingerprint, p.Address)
+					}
+					if len(peers) == 0 {
+						fmt.Println("No friends found on the local network")
+					}
+				}
+			},
+		},
+		{
+			name:    "agent",
+			summary: "Keep your account unlocked so commands don't ask for the passphrase",
+			setup: func(fs *flag.FlagSet) func(args []string) {
+				timeout := fs.Duration("timeout", AgentTimeout, "lock the account this long after it's unlocked (0 to keep it unlocked)")
+				unlock := fs.Bool("unlock", false, "unlock the running agent with the passphrase")
+				lock := fs.Bool("lock", false, "lock the running agent")
+				stop := fs.Bool("stop", false, "stop the running agent")
+				showStatus := fs.Bool("status", false, "show whether the running agent is unlocked")
+
+				return func(args []string) {
+					socket := agentSocket()
+					client := NewAgentClient(socket)
+
+					switch {
+					case *unlock:
+						raise(client.Unlock(getPasswordFunc()))
+						fmt.Println("Agent unlocked")
+					case *lock:
+						raise(client.Lock())
+						fmt.Println("Agent locked")
+					case *stop:
+						raise(client.Stop())
+						fmt.Println("Agent stopped")
+					case *showStatus:
+						s, err := client.Status()
+						raise(err)
+						printAgentStatus(s)
+					default:
+						listener, err := ListenAgent(socket)
+						raise(err)
+
+						agent := NewAgent(root, *timeout)
+						ctx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+						defer stopSignals()
+						go func() {
+							<-ctx.Done()
+							_ = agent.Close()
+						}()
+
+						status("Agent listening on %s, unlock it with mau agent -unlock", socket)
+						raise(agent.Serve(listener))
+					}
+				}
+			},
+		},
+		{
+			name:    "help",
+			summary: "Show the commands or the flags of a command",
+			args:    "[COMMAND]",
+			setup: func(_ *flag.FlagSet) func(args []string) {
+				return func(args []string) {
+					if len(args) == 0 {
+						printUsage(os.Stdout)
+						return
+					}
+
+					cmd := findCommand(args[0])
+					if cmd == nil {
+						fail(exitUsage, fmt.Errorf("Command %s is not recognized", args[0]))
+						return
+					}
+					fs := cmd.flagSet()
+					fs.SetOutput(os.Stdout)
+					fs.Usage()
+				}
+			},
+		},
+		{
+			name:    "completion",
+			summary: "Print the shell completion script for bash, zsh or fish",
+			args:    "SHELL",
+			setup: func(_ *flag.FlagSet) func(args []string) {
+				return func(args []string) {
+					if len(args) != 1 {
+						fail(exitUsage, fmt.Errorf("%w: bash, zsh or fish", errMissingShell))
+						return
+					}
+					raise(printCompletion(os.Stdout, args[0]))
+				}
+			},
+		},
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Completion: the completion scripts are generated from the commands and
+// their flags so they don't go out of date. source the output of
+// "mau completion SHELL" from the shell startup file.
+
+var (
+	errMissingShell     = errors.New("Shell is required")
+	errUnsupportedShell = errors.New("Shell isn't supported")
+)
+
+func printCompletion(w io.Writer, shell string) error {
+	switch shell {
+	case "bash":
+		printBashCompletion(w)
+	case "zsh":
+		fmt.Fprintln(w, "autoload -U +X bashcompinit && bashcompinit")
+		printBashCompletion(w)
+	case "fish":
+		printFishCompletion(w)
+	default:
+		return fmt.Errorf("%w: %s", errUnsupportedShell, shell)
+	}
+
+	return nil
+}
+
+// flagNames returns the flags of fs prefixed with a dash, and the ones taking
+// a value
+func flagNames(fs *flag.FlagSet) (all, valued []string) {
+	fs.VisitAll(func(f *flag.Flag) {
+		all = append(all, "-"+f.Name)
+		if b, ok := f.Value.(interface{ IsBoolFlag() bool }); !ok || !b.IsBoolFlag() {
+			valued = append(valued, "-"+f.Name, "--"+f.Name)
+		}
+	})
+
+	return all, valued
+}
+
+func printBashCompletion(w io.Writer) {
+	globals, valued := flagNames(globalFlags(&globalOptions{}))
+	names := []string{}
+	for _, c := range allCommands() {
+		names = append(names, c.name)
+	}
+
+	fmt.Fprintf(w, `_mau() {
+	local cur=${COMP_WORDS[COMP_CWORD]} cmd="" i
+	for ((i = 1; i < COMP_CWORD; i++)); do
+		case ${COMP_WORDS[i]} in
+		%s) ((i++)) ;;
+		-*) ;;
+		*)
+			cmd=${COMP_WORDS[i]}
+			break
+			;;
+		esac
+	done
+
+	case $cmd in
+	"") COMPREPLY=($(compgen -W "%s" -- "$cur")) ;;
+	help) COMPREPLY=($(compgen -W "%s" -- "$cur")) ;;
+	completion) COMPREPLY=($(compgen -W "bash zsh fish" -- "$cur")) ;;
+`, strings.Join(valued, "|"), strings.Join(append(names, globals...), " "), strings.Join(names, " "))
+
+	for _, c := range allCommands() {
+		if flags, _ := flagNames(c.flagSet()); len(flags) > 0 {
+			fmt.Fprintf(w, "\t%s) COMPREPLY=($(compgen -W \"%s\" -- \"$cur\")) ;;\n", c.name, strings.Join(flags, " "))
+		}
+	}
+
+	fmt.Fprintln(w, `	esac
+}
+complete -o default -F _mau mau`)
+}
+
+func printFishCompletion(w io.Writer) {
+	names := []string{}
+	for _, c := range allCommands() {
+		names = append(names, c.name)
+	}
+
+	fmt.Fprintf(w, "complete -c mau -f -n '__fish_seen_subcommand_from help' -a '%s'\n", strings.Join(names, " "))
+	fmt.Fprintln(w, "complete -c mau -f -n '__fish_seen_subcommand_from completion' -a 'bash zsh fish'")
+	globalFlags(&globalOptions{}).VisitAll(func(f *flag.Flag) {
+		fmt.Fprintf(w, "complete -c mau -n __fish_use_subcommand -o %s -d %s\n", f.Name, fishQuote(f.Usage))
+	})
+
+	for _, c := range allCommands() {
+		fmt.Fprintf(w, "complete -c mau -f -n __fish_use_subcommand -a %s -d %s\n", c.name, fishQuote(c.summary))
+		c.flagSet().VisitAll(func(f *flag.Flag) {
+			fmt.Fprintf(w, "complete -c mau -n '__fish_seen_subcommand_from %s' -o %s -d %s\n", c.name, f.Name, fishQuote(f.Usage))
+		})
+	}
+}
+
+func fishQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `\'`) + "'"
+}
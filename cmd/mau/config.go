@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/mau-network/mau"
+	"go.yaml.in/yaml/v3"
+)
+
+// Config: defaults of the command flags, read from a YAML file. flags win
+// over environment variables, which win over the config file:
+//
+//	root: ~/mau             # account directory, like -root or MAU_HOME
+//	port: 8080              # serve -port
+//	sync_interval: 15m      # serve -sync-interval
+//	resolvers:              # where sync and chat look for friends
+//	  - local               # the local network
+//	  - mau.example.org:443 # a fixed address
+//	bootstrap:              # peers serve joins the network with
+//	  - fingerprint: ABC123...
+//	    address: peer.example.org:443
+
+const (
+	configEnv  = "MAU_CONFIG"
+	homeEnv    = "MAU_HOME"
+	configFile = "config.yaml"
+)
+
+type config struct {
+	Root         string          `yaml:"root"`
+	Port         int             `yaml:"port"`
+	SyncInterval time.Duration   `yaml:"sync_interval"`
+	Resolvers    []string        `yaml:"resolvers"`
+	Bootstrap    []bootstrapPeer `yaml:"bootstrap"`
+}
+
+type bootstrapPeer struct {
+	Fingerprint string `yaml:"fingerprint"`
+	Address     string `yaml:"address"`
+}
+
+// defaultConfigPath is config.yaml in the mau directory of the user
+// configuration directory, ~/.config/mau on Linux
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(dir, "mau", configFile)
+}
+
+// loadConfig reads the config file at p, MAU_CONFIG or the default path. a
+// missing default file is an empty config, a missing file asked for is an
+// error.
+func loadConfig(p string) (*config, error) {
+	if p == "" {
+		p = os.Getenv(configEnv)
+	}
+
+	optional := p == ""
+	if optional {
+		p = defaultConfigPath()
+	}
+
+	conf := &config{}
+	if p == "" {
+		return conf, nil
+	}
+
+	data, err := os.ReadFile(p)
+	if optional && errors.Is(err, fs.ErrNotExist) {
+		return conf, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := yaml.Unmarshal(data, conf); err != nil {
+		return nil, fmt.Errorf("%s: %w", p, err)
+	}
+
+	return conf, nil
+}
+
+// accountRoot returns the account directory from -root, MAU_HOME, the config
+// file or the working directory, in that order
+func accountRoot(flagRoot string, conf *config) (string, error) {
+	root := flagRoot
+	if root == "" {
+		root = os.Getenv(homeEnv)
+	}
+	if root == "" {
+		root = conf.Root
+	}
+	if root == "" {
+		return os.Getwd()
+	}
+
+	if rest, ok := strings.CutPrefix(root, "~"); ok && (rest == "" || rest[0] == '/') {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		root = home + rest
+	}
+
+	return filepath.Abs(root)
+}
+
+// resolvers returns the resolvers of the config, the local network when
+// there's none, followed by the address given to the command
+func (c *config) resolvers(address string) []FingerprintResolver {
+	names := c.Resolvers
+	if len(names) == 0 {
+		names = []string{"local"}
+	}
+
+	resolvers := []FingerprintResolver{}
+	for _, name := range names {
+		if name == "local" {
+			resolvers = append(resolvers, LocalFriendAddress)
+		} else {
+			resolvers = append(resolvers, StaticAddress(name))
+		}
+	}
+
+	if address != "" {
+		resolvers = append(resolvers, StaticAddress(address))
+	}
+
+	return resolvers
+}
+
+// bootstrapPeers returns the peers of the config serve joins the network with
+func (c *config) bootstrapPeers() ([]*Peer, error) {
+	peers := []*Peer{}
+	for _, b := range c.Bootstrap {
+		fpr, err := FingerprintFromString(b.Fingerprint)
+		if err != nil {
+			return nil, fmt.Errorf("bootstrap peer %s: %w", b.Address, err)
+		}
+		peers = append(peers, &Peer{Fingerprint: fpr, Address: b.Address})
+	}
+
+	return peers, nil
+}
@@ -2,481 +2,478 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"log"
-	"net"
 	"os"
+	"os/signal"
 	"path"
+	"slices"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	. "github.com/mau-network/mau"
-	"golang.org/x/term"
 )
 
-func main() {
-	if len(os.Args) < 2 {
-		fmt.Println(`Available commands:
-	init:     Initialize new account in current directory
-	show:     Show current account information
-	export:   Export account public key to file
-	friend:   Add a friend using this public key file
-	friends:  List all friends
-	unfriend: Remove a friend
-	follow:   Follow friend posts
-	unfollow: Unfollow friend posts
-	follows:  List friends you follow
-	share:    Share a file with friends following you
-	files:    List files you share with your followers
-	open:     Open a file shared with you
-	delete:   Delete a file you shared previously
-	serve:    Open a server to allow followers to sync your content
-	sync:     Sync content from a friend`)
-		exitFunc(1)
-		return
-	}
+// globalOptions are the flags given before the command
+type globalOptions struct {
+	json           bool
+	passphraseFile string
+	passphraseFD   int
+	root           string
+	config         string
+}
 
-	wd, _ := os.Getwd()
+// Settings of the command, set by main before running it
+var (
+	opts globalOptions
+	conf = &config{} // the config file, defaults of the command flags
+	root string      // the account directory
+)
 
-	switch os.Args[1] {
-	case "init":
-		initCmd := flag.NewFlagSet("init", flag.ExitOnError)
-		name := initCmd.String("name", "", "name")
-		email := initCmd.String("email", "", "email")
-		passphrase := initCmd.String("passphrase", "", "passphrase (if empty, prompt interactively)")
-		if err := initCmd.Parse(os.Args[2:]); err != nil {
-			log.Fatalf("Failed to parse init flags: %v", err)
-		}
+// command is a subcommand of the CLI
+type command struct {
+	name    string
+	summary string
+	args    string // arguments after the flags, for the usage
+	setup   func(fs *flag.FlagSet) func(args []string)
+}
 
-		pass := *passphrase
-		if pass == "" {
-			pass = getPasswordFunc()
+// flagSet returns the flags of the command, the usage of the command is its
+// help
+func (c *command) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet(c.name, flag.ExitOnError)
+	c.setup(fs)
+	fs.Usage = func() {
+		usage := strings.TrimSpace("mau " + c.name + " [FLAGS] " + c.args)
+		fmt.Fprintf(fs.Output(), "Usage: %s\n\n%s\n", usage, c.summary)
+		if hasFlags(fs) {
+			fmt.Fprintln(fs.Output(), "\nFlags:")
+			fs.PrintDefaults()
 		}
+	}
 
-		fmt.Println("Initializing account...")
-		_, err := NewAccount(wd, *name, *email, pass)
-		raise(err)
-		fmt.Println("Done")
-
-	case "show":
-		showCmd := flag.NewFlagSet("show", flag.ExitOnError)
-		passphrase := showCmd.String("passphrase", "", "passphrase (if empty, prompt interactively)")
-		if err := showCmd.Parse(os.Args[2:]); err != nil {
-			log.Fatalf("Failed to parse show flags: %v", err)
-		}
+	return fs
+}
 
-		account := getAccountWithPassphrase(*passphrase)
+// run parses the flags of the command and runs it
+func (c *command) run(args []string) {
+	fs := flag.NewFlagSet(c.name, flag.ExitOnError)
+	action := c.setup(fs)
+	fs.Usage = c.flagSet().Usage
+	if err := fs.Parse(args); err != nil {
+		fail(exitUsage, err)
+		return
+	}
 
-		fmt.Println("Name: ", account.Name())
-		fmt.Println("Email: ", account.Email())
-		fmt.Println("Fingerprint: ", account.Fingerprint())
+	action(fs.Args())
+}
 
-	case "export":
-		exportCmd := flag.NewFlagSet("export", flag.ExitOnError)
-		output := exportCmd.String("output", "/dev/stdout", "output")
-		if err := exportCmd.Parse(os.Args[2:]); err != nil {
-			log.Fatalf("Failed to parse export flags: %v", err)
+func findCommand(name string) *command {
+	for _, c := range allCommands() {
+		if c.name == name {
+			return c
 		}
+	}
 
-		account := getAccount()
+	return nil
+}
 
-		out, err := os.OpenFile(*output, os.O_CREATE|os.O_RDWR|os.O_TRUNC, FilePerm)
-		raise(err)
-		defer func() {
-			if err := out.Close(); err != nil {
-				raise(err)
-			}
-		}()
+func hasFlags(fs *flag.FlagSet) bool {
+	found := false
+	fs.VisitAll(func(*flag.Flag) { found = true })
+	return found
+}
 
-		err = account.Export(out)
-		raise(err)
+// globalFlags defines the flags given before the command on o
+func globalFlags(o *globalOptions) *flag.FlagSet {
+	global := flag.NewFlagSet("mau", flag.ExitOnError)
+	global.BoolVar(&o.json, "json", false, "print JSON on stdout for show, friends, follows, files, sync and peers")
+	global.StringVar(&o.passphraseFile, "passphrase-file", "", "read the passphrase from the first line of this file")
+	global.IntVar(&o.passphraseFD, "passphrase-fd", -1, "read the passphrase from the first line of this file descriptor")
+	global.StringVar(&o.root, "root", "", "account directory (default: $MAU_HOME, the config root or the working directory)")
+	global.StringVar(&o.config, "config", "", "config file (default: $MAU_CONFIG or "+defaultConfigPath()+")")
+	global.Usage = func() { printUsage(global.Output()) }
+
+	return global
+}
 
-	case "friend":
-		friendCmd := flag.NewFlagSet("friend", flag.ExitOnError)
-		key := friendCmd.String("key", "", "path to key file")
-		if err := friendCmd.Parse(os.Args[2:]); err != nil {
-			log.Fatalf("Failed to parse friend flags: %v", err)
-		}
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: mau [GLOBAL FLAGS] COMMAND [FLAGS]\n\nAvailable commands:")
+	for _, c := range allCommands() {
+		fmt.Fprintf(w, "\t%-11s %s\n", c.name+":", c.summary)
+	}
 
-		account := getAccount()
+	fmt.Fprintln(w, "\nGlobal flags:")
+	global := globalFlags(&globalOptions{})
+	global.SetOutput(w)
+	global.PrintDefaults()
+
+	fmt.Fprintln(w, `
+The passphrase is read from -passphrase-file, -passphrase-fd or the
+MAU_PASSPHRASE environment variable, else the account is opened by an
+unlocked "mau agent", and the passphrase is asked for otherwise.
+
+Exit codes:
+	0  success
+	1  error, or no command given
+	2  unknown command or invalid flags
+	3  no account in the directory, wrong or missing passphrase
+	4  friend, group or file not found
+	5  peer unreachable or sync failed
+
+Run "mau help COMMAND" for the flags of a command.`)
+}
 
-		keyFile, err := os.OpenFile(*key, os.O_RDONLY, 0600)
-		raise(err)
-		defer func() { _ = keyFile.Close() }()
+func main() {
+	global := globalFlags(&opts)
+	if err := global.Parse(os.Args[1:]); err != nil {
+		fail(exitUsage, err)
+		return
+	}
 
-		friend, err := account.AddFriend(keyFile)
-		raise(err)
-		if friend == nil {
-			log.Fatal("Failed to add friend")
-		}
+	var err error
+	conf, err = loadConfig(opts.config)
+	raise(err)
+	root, err = accountRoot(opts.root, conf)
+	raise(err)
 
-		fmt.Println("Friend added: ", friend.Name(), friend.Email(), friend.Fingerprint())
+	if global.NArg() < 1 {
+		printUsage(os.Stdout)
+		exitFunc(exitError)
+		return
+	}
 
-	case "friends":
-		account := getAccount()
+	cmd := findCommand(global.Arg(0))
+	if cmd == nil {
+		fail(exitUsage, fmt.Errorf("Command %s is not recognized", global.Arg(0)))
+		return
+	}
 
-		friends, err := account.ListFriends()
-		raise(err)
-		if friends == nil {
-			log.Fatal("Failed to list friends")
-		}
+	cmd.run(global.Args()[1:])
+}
 
-		printKeyring("", friends)
+func getAccount() *Account {
+	return getAccountWithPassphrase("")
+}
 
-	case "unfriend":
-		unfriendCmd := flag.NewFlagSet("unfriend", flag.ExitOnError)
-		fingerprint := unfriendCmd.String("fingerprint", "", "fingerprint of the friend account")
-		if err := unfriendCmd.Parse(os.Args[2:]); err != nil {
-			log.Fatalf("Failed to parse unfriend flags: %v", err)
+// getAccountWithPassphrase opens the account with the passphrase given to
+// the command, the key the agent keeps or the passphrase asked for
+func getAccountWithPassphrase(passphrase string) *Account {
+	if passphrase == "" && !passphraseGiven() {
+		if account, err := NewAgentClient(agentSocket()).OpenAccount(root); err == nil {
+			return account
 		}
+	}
+	if passphrase == "" {
+		passphrase = getPasswordFunc()
+	}
 
-		account := getAccount()
-
-		friends, err := account.ListFriends()
-		raise(err)
-		if friends == nil {
-			log.Fatal("Failed to list friends")
-		}
+	account, err := OpenAccount(root, passphrase)
+	raise(withCode(exitAccount, err))
 
-		fpr, err := FingerprintFromString(*fingerprint)
-		raise(err)
+	return account
+}
 
-		friend := friends.FindByFingerprint(fpr)
-		if friend == nil {
-			log.Fatal("Can't find friend with fingerprint ", *fingerprint)
-		}
+// agentSocket is MAU_AGENT_SOCK or the socket in the account directory
+func agentSocket() string {
+	if socket := os.Getenv(agentSocketEnv); socket != "" {
+		return socket
+	}
 
-		fmt.Println("Removing friend: ", friend.Name(), friend.Email(), "...")
-		raise(account.RemoveFriend(friend))
-		fmt.Println("Done")
+	return AgentSocket(root)
+}
 
-	case "follow":
-		followCmd := flag.NewFlagSet("follow", flag.ExitOnError)
-		fingerprint := followCmd.String("fingerprint", "", "fingerprint of the friend account")
-		if err := followCmd.Parse(os.Args[2:]); err != nil {
-			log.Fatalf("Failed to parse follow flags: %v", err)
-		}
+func printAgentStatus(s *AgentStatus) {
+	if opts.json {
+		printJSON(agentJSON{Unlocked: s.Unlocked, Fingerprint: s.Fingerprint.String(), Expires: s.Expires})
+		return
+	}
 
-		account := getAccount()
+	switch {
+	case !s.Unlocked:
+		fmt.Println("Agent is locked")
+	case s.Expires.IsZero():
+		fmt.Println("Agent is unlocked:", s.Fingerprint)
+	default:
+		fmt.Println("Agent is unlocked:", s.Fingerprint, "until", s.Expires.Local().Format("15:04:05"))
+	}
+}
 
-		friends, err := account.ListFriends()
-		raise(err)
-		if friends == nil {
-			log.Fatal("Failed to list friends")
-		}
+// Variables to allow mocking in tests
+var (
+	exitFunc        = os.Exit
+	getPasswordFunc = getPassword
+)
 
-		fpr, err := FingerprintFromString(*fingerprint)
-		raise(err)
+func getPassword() string {
+	pass, err := readPassphrase()
+	raise(withCode(exitAccount, err))
 
-		friend := friends.FindByFingerprint(fpr)
-		if friend == nil {
-			log.Fatal("Can't find friend with fingerprint ", *fingerprint)
-		}
+	return pass
+}
 
-		fmt.Println("Following friend: ", friend.Name(), friend.Email(), "...")
-		raise(account.Follow(friend))
-		fmt.Println("Done")
+// findFriend returns the friend with the fingerprint or exits
+func findFriend(account *Account, fingerprint string) *Friend {
+	friends, err := account.ListFriends()
+	raise(err)
 
-	case "unfollow":
-		unfollowCmd := flag.NewFlagSet("unfollow", flag.ExitOnError)
-		fingerprint := unfollowCmd.String("fingerprint", "", "fingerprint of the friend account")
-		if err := unfollowCmd.Parse(os.Args[2:]); err != nil {
-			log.Fatalf("Failed to parse unfollow flags: %v", err)
-		}
+	fpr, err := FingerprintFromString(fingerprint)
+	raise(err)
 
-		account := getAccount()
+	friend := friends.FindByFingerprint(fpr)
+	if friend == nil {
+		raise(fmt.Errorf("%w: %s", ErrCantFindFriend, fingerprint))
+	}
 
-		friends, err := account.ListFriends()
-		raise(err)
-		if friends == nil {
-			log.Fatal("Failed to list friends")
-		}
+	return friend
+}
 
-		fpr, err := FingerprintFromString(*fingerprint)
-		raise(err)
+func printKeyring(p string, r *Keyring) {
+	if r == nil {
+		return
+	}
 
-		friend := friends.FindByFingerprint(fpr)
-		if friend == nil {
-			log.Fatal("Can't find friend with fingerprint ", *fingerprint)
-		}
+	fmt.Println(r.Name(), ":")
+	for _, f := range r.Friends {
+		fmt.Println(p+" ", f.Name(), f.Email(), f.Fingerprint())
+	}
+	for _, k := range r.SubKeyrings {
+		printKeyring(p+" ", k)
+	}
+}
 
-		fmt.Println("Unfollowing friend: ", friend.Name(), friend.Email(), "...")
-		raise(account.Unfollow(friend))
-		fmt.Println("Done")
+func printConversation(c *Conversation) {
+	names, fprs := []string{}, []string{}
+	for _, p := range c.Participants {
+		names = append(names, p.Name)
+		fprs = append(fprs, p.Identifier)
+	}
 
-	case "follows":
-		account := getAccount()
+	fmt.Printf("%s  %s", c.Last.Signed.Local().Format("2006-01-02 15:04"), strings.Join(names, ", "))
+	if c.Unread > 0 {
+		fmt.Printf("  (%d unread)", c.Unread)
+	}
+	fmt.Printf("\n\t%s: %s\n\tmau chat -with %s\n\n", c.Last.Sender.Name(), c.Last.Message.Text, strings.Join(fprs, ","))
+}
 
-		friends, err := account.ListFollows()
-		raise(err)
+func printFeedItem(item *FeedItem) {
+	fmt.Printf("%s  %s  %s\n", item.Signed.Local().Format("2006-01-02 15:04"), item.Author.Name(), item.File.Name())
+	for _, line := range feedItemLines(item.Content) {
+		fmt.Println("\t" + line)
+	}
+	fmt.Println()
+}
 
-		for _, f := range friends {
-			fmt.Println(f.Name(), f.Email(), f.Fingerprint())
+func feedItemLines(content Content) []string {
+	switch c := content.(type) {
+	case *SocialMediaPosting:
+		return slices.DeleteFunc([]string{c.Headline, c.ArticleBody}, func(l string) bool { return l == "" })
+	case *Comment:
+		return []string{c.Text}
+	case *LikeAction:
+		if c.Object != nil {
+			return []string{"Liked " + c.Object.ID}
 		}
-
-	case "share":
-		shareCmd := flag.NewFlagSet("share", flag.ExitOnError)
-		file := shareCmd.String("file", "", "file path to share")
-		fingerprints := shareCmd.String("fingerprints", "", "comma separated list of fingerprints to share the file with")
-		if err := shareCmd.Parse(os.Args[2:]); err != nil {
-			log.Fatalf("Failed to parse share flags: %v", err)
+	case *ShareAction:
+		if c.Object != nil {
+			return []string{"Shared " + c.Object.ID}
 		}
-
-		account := getAccount()
-
-		f, err := os.Open(*file)
-		raise(err)
-
-		allFrields, err := account.ListFriends()
-		raise(err)
-		if allFrields == nil {
-			log.Fatal("Failed to list friends")
+	case *FollowAction:
+		if c.Object != nil {
+			return []string{"Followed " + c.Object.Name + " " + c.Object.Identifier}
 		}
+	}
 
-		fprs := strings.Split(*fingerprints, ",")
-		friends := []*Friend{}
-		for _, fprStr := range fprs {
-			fpr, err := FingerprintFromString(fprStr)
-			if err != nil {
-				raise(fmt.Errorf("Can't parse %s as fingerprint", fprStr))
-			}
+	return []string{content.SchemaType()}
+}
 
-			f := allFrields.FindByFingerprint(fpr)
-			if f == nil {
-				raise(fmt.Errorf("Can't find friend %s", fprStr))
-			}
+// syncFriend downloads the friend files changed after since, the sync time
+// is recorded unless files were left for the next sync
+func syncFriend(account *Account, client *Client, fpr Fingerprint, since time.Time, resolvers []FingerprintResolver) error {
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
 
-			friends = append(friends, f)
-		}
+	report, err := client.SyncFriend(ctx, fpr, since, resolvers)
+	if err != nil {
+		return withCode(exitNetwork, err)
+	}
 
-		name := path.Base(*file)
-		_, err = account.AddFile(f, name, friends)
-		raise(err)
+	if opts.json {
+		printJSON(syncToJSON(fpr, report))
+	} else {
+		printSyncReport(report)
+	}
 
-	case "files":
-		filesCmd := flag.NewFlagSet("files", flag.ExitOnError)
-		fingerprint := filesCmd.String("fingerprint", "", "fingerprint of the friend account")
-		if err := filesCmd.Parse(os.Args[2:]); err != nil {
-			log.Fatalf("Failed to parse files flags: %v", err)
+	// files left by an incomplete sync are downloaded by the next one
+	if !report.Incomplete() {
+		if err := account.UpdateLastSyncTime(fpr, start); err != nil {
+			return err
 		}
+	}
 
-		account := getAccount()
-		var fpr Fingerprint
-
-		if *fingerprint == "" {
-			fpr = account.Fingerprint()
-		} else {
-			var err error
-			fpr, err = FingerprintFromString(*fingerprint)
-			raise(err)
+	if account.SearchEnabled() {
+		if err := account.UpdateSearchIndex(); err != nil {
+			log.Printf("Failed to update search index: %v", err)
 		}
+	}
 
-		after := time.Date(0, 0, 0, 0, 0, 0, 0, time.UTC)
-		files := account.ListFiles(fpr, after, 0)
-		for _, f := range files {
-			if f.Deleted() {
-				continue
-			}
-
-			fmt.Println(f.Name())
-			rs, err := f.Recipients(account)
-			if err != nil {
-				fmt.Println("Error: ", err)
-				continue
-			}
+	return nil
+}
 
-			for _, r := range rs {
-				fmt.Println("\t", r.Name(), r.Email(), r.Fingerprint())
-			}
-		}
+// syncEvery syncs the friend again every interval until interrupted
+func syncEvery(account *Account, client *Client, fpr Fingerprint, interval time.Duration, resolvers []FingerprintResolver) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	case "open":
-		openCmd := flag.NewFlagSet("open", flag.ExitOnError)
-		file := openCmd.String("file", "", "file path to open")
-		output := openCmd.String("output", "/dev/stdout", "output")
-		fingerprint := openCmd.String("fingerprint", "", "fingerprint of the friend account")
-		if err := openCmd.Parse(os.Args[2:]); err != nil {
-			log.Fatalf("Failed to parse open flags: %v", err)
+	status("Syncing every %s, press Ctrl+C to stop...", interval)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
 		}
 
-		account := getAccount()
-		var fpr Fingerprint
-
-		if *fingerprint == "" {
-			fpr = account.Fingerprint()
-		} else {
-			var err error
-			fpr, err = FingerprintFromString(*fingerprint)
-			raise(err)
+		if err := syncFriend(account, client, fpr, account.GetLastSyncTime(fpr), resolvers); err != nil {
+			log.Printf("Sync error: %v, retrying in %s...", err, interval)
 		}
+	}
+}
 
-		f, err := account.GetFile(fpr, *file)
-		raise(err)
-
-		r, err := f.Reader(account)
-		raise(err)
-
-		out, err := os.OpenFile(*output, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, FilePerm)
-		raise(err)
-		defer func() {
-			if err := out.Close(); err != nil {
-				raise(err)
+// watchFriend downloads the friend files as they are announced until
+// interrupted, subscribing again when the stream ends
+func watchFriend(client *Client, fpr Fingerprint, after time.Time, resolvers []FingerprintResolver) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	status("Watching for changes, press Ctrl+C to stop...")
+	for ctx.Err() == nil {
+		subscribed := time.Now()
+		err := client.WatchFriend(ctx, fpr, after, resolvers, func(_ Fingerprint, event *FileEvent) {
+			// a JSON document per line, scripts read them as they come
+			if opts.json {
+				out, _ := json.Marshal(event)
+				fmt.Println(string(out))
+				return
+			}
+			fmt.Printf("%s %s\n", event.Type, path.Base(event.Path))
+		})
+		if err != nil {
+			log.Printf("Watch error: %v, retrying...", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(5 * time.Second):
 			}
-		}()
-
-		_, err = io.Copy(out, r)
-		raise(err)
-
-	case "delete":
-		deleteCmd := flag.NewFlagSet("delete", flag.ExitOnError)
-		file := deleteCmd.String("file", "", "file name to delete")
-		if err := deleteCmd.Parse(os.Args[2:]); err != nil {
-			log.Fatalf("Failed to parse delete flags: %v", err)
-		}
-
-		account := getAccount()
-		f, err := account.GetFile(account.Fingerprint(), *file)
-		raise(err)
-
-		raise(account.RemoveFile(f))
-		fmt.Println("Deleted", f.Name())
-
-	case "serve":
-		serveCmd := flag.NewFlagSet("serve", flag.ExitOnError)
-		passphrase := serveCmd.String("passphrase", "", "passphrase (if empty, prompt interactively)")
-		port := serveCmd.String("port", "0", "port to listen on (0 for random)")
-		if err := serveCmd.Parse(os.Args[2:]); err != nil {
-			log.Fatalf("Failed to parse serve flags: %v", err)
 		}
+		after = subscribed
+	}
+}
 
-		account := getAccountWithPassphrase(*passphrase)
-		server, err := account.Server(nil)
-		raise(err)
-		if server == nil {
-			log.Fatal("Failed to create server")
-		}
+func printSyncReport(report *SyncReport) {
+	for _, s := range report.Skipped {
+		fmt.Printf("Skipped %s (%d bytes): %s\n", path.Base(s.Path), s.Size, s.Reason)
+	}
+	fmt.Printf("Downloaded %d files, skipped %d\n", len(report.Downloaded), len(report.Skipped))
+}
 
-		listener, err := ListenTCP(":" + *port)
-		raise(err)
-		if listener == nil {
-			log.Fatal("Failed to create TCP listener")
-		}
+func printPruneReport(report *PruneReport) {
+	verb := "Pruned"
+	if report.DryRun {
+		verb = "Would prune"
+	}
 
-		portNum := listener.Addr().(*net.TCPAddr).Port
-		fmt.Println("Account: ", account.Name(), account.Fingerprint())
-		fmt.Println("Using port:", portNum)
+	for _, v := range report.Pruned {
+		fmt.Printf("%s %s/%s version %s (%d bytes)\n", verb, v.Fingerprint, v.Name, v.Version, v.Size)
+	}
+	fmt.Printf("%s %d versions (%d bytes), kept %d\n", verb, len(report.Pruned), report.Size, report.Kept)
+}
 
-		if err := server.Serve(listener, ""); err != nil {
-			log.Fatalf("Server error: %v", err)
-		}
+func printAttachmentPruneReport(report *AttachmentPruneReport) {
+	verb := "Pruned"
+	if report.DryRun {
+		verb = "Would prune"
+	}
 
-	case "sync":
-		syncCmd := flag.NewFlagSet("sync", flag.ExitOnError)
-		fprStr := syncCmd.String("fingerprint", "", "user fingerprint to sync files")
-		address := syncCmd.String("address", "", "source address to sync from")
-		full := syncCmd.Bool("full", false, "perform full sync instead of incremental")
-		if err := syncCmd.Parse(os.Args[2:]); err != nil {
-			log.Fatalf("Failed to parse sync flags: %v", err)
-		}
+	for _, b := range report.Pruned {
+		fmt.Printf("%s %s/%s (%d bytes)\n", verb, b.Fingerprint, b.Name, b.Size)
+	}
+	fmt.Printf("%s %d attachments (%d bytes)\n", verb, len(report.Pruned), report.Size)
+}
 
-		account := getAccount()
-		var fpr Fingerprint
-		fpr, err := FingerprintFromString(*fprStr)
-		raise(err)
+// syncFollows syncs the friends the account follows every interval
+func syncFollows(account *Account, interval time.Duration, resolvers []FingerprintResolver) {
+	for {
+		time.Sleep(interval)
 
-		client, err := account.Client(fpr, nil)
-		raise(err)
-		if client == nil {
-			log.Fatal("Failed to create client")
+		follows, err := account.ListFollows()
+		if err != nil {
+			log.Printf("Failed to list follows: %v", err)
+			continue
 		}
 
-		// Get the latest synced file date for incremental sync
-		// Use zero time for full sync or first-time sync
-		var t time.Time
-		if *full {
-			t = time.Time{}
-			fmt.Println("Performing full sync...")
-		} else {
-			t = account.GetLastSyncTime(fpr)
-			if t.IsZero() {
-				fmt.Println("No previous sync found, performing full sync...")
-			} else {
-				fmt.Printf("Performing incremental sync (since %s)...\n", t.Format(time.RFC3339))
+		for _, f := range follows {
+			fpr := f.Fingerprint()
+			client, err := account.Client(fpr, nil)
+			if err != nil {
+				log.Printf("Failed to create client for %s: %v", fpr, err)
+				continue
 			}
-		}
 
-		syncStartTime := time.Now()
-		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
-		defer cancel()
-		resolvers := []FingerprintResolver{LocalFriendAddress}
-		if len(*address) > 0 {
-			resolvers = append(resolvers, StaticAddress(*address))
+			if err := syncFriend(account, client, fpr, account.GetLastSyncTime(fpr), resolvers); err != nil {
+				log.Printf("Sync error for %s: %v, retrying in %s...", fpr, err, interval)
+			}
 		}
-		err = client.DownloadFriend(ctx, fpr, t, resolvers)
-		raise(err)
-
-		// Update last sync time on successful sync
-		err = account.UpdateLastSyncTime(fpr, syncStartTime)
-		raise(err)
-
-	default:
-		fmt.Printf("Command %s is not recognized", os.Args[1])
 	}
 }
 
-func getAccount() *Account {
-	return getAccountWithPassphrase("")
-}
+// expireTombstones removes the tombstones older than retention daily
+func expireTombstones(account *Account, retention time.Duration) {
+	for {
+		if n, err := account.ExpireTombstones(retention); err != nil {
+			log.Printf("Failed to expire tombstones: %v", err)
+		} else if n > 0 {
+			log.Printf("Expired %d tombstones", n)
+		}
 
-func getAccountWithPassphrase(passphrase string) *Account {
-	wd, _ := os.Getwd()
-	pass := passphrase
-	if pass == "" {
-		pass = getPasswordFunc()
-	}
-	account, err := OpenAccount(wd, pass)
-	raise(err)
-	if account == nil {
-		log.Fatal("Failed to open account")
+		time.Sleep(24 * time.Hour)
 	}
-
-	return account
 }
 
-// Variables to allow mocking in tests
-var (
-	exitFunc        = os.Exit
-	getPasswordFunc = getPassword
-)
-
-func getPassword() string {
-	fmt.Print("Passphrase: ")
-	bytepw, err := term.ReadPassword(int(syscall.Stdin))
-	raise(err)
-	fmt.Println("")
-
-	return string(bytepw)
-}
-
-func printKeyring(p string, r *Keyring) {
-	if r == nil {
-		return
-	}
+// findPeers resolves the addresses of the friends on the local network until
+// ctx is done, returning the ones found
+func findPeers(ctx context.Context, friends []*Friend) []peerJSON {
+	var (
+		mutex sync.Mutex
+		wg    sync.WaitGroup
+	)
+
+	peers := []peerJSON{}
+	for _, f := range friends {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			addresses := make(chan string, 1)
+			if err := LocalFriendAddress(ctx, f.Fingerprint(), addresses); err != nil {
+				return
+			}
 
-	fmt.Println(r.Name(), ":")
-	for _, f := range r.Friends {
-		fmt.Println(p+" ", f.Name(), f.Email(), f.Fingerprint())
-	}
-	for _, k := range r.SubKeyrings {
-		printKeyring(p+" ", k)
+			select {
+			case address := <-addresses:
+				mutex.Lock()
+				peers = append(peers, peerJSON{friendJSON: friendToJSON(f), Address: address})
+				mutex.Unlock()
+			default:
+			}
+		}()
 	}
-}
+	wg.Wait()
 
-func raise(err error) {
-	if err != nil {
-		log.Fatal(err)
-	}
+	slices.SortFunc(peers, func(a, b peerJSON) int { return strings.Compare(a.Fingerprint, b.Fingerprint) })
+	return peers
 }
@@ -2,11 +2,15 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"io"
+	"net"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	. "github.com/mau-network/mau"
 )
@@ -58,12 +62,20 @@ func TestCLIHelp(t *testing.T) {
 		"follow",
 		"unfollow",
 		"follows",
+		"group",
+		"groups",
 		"share",
 		"files",
+		"timeline",
+		"search",
+		"chat",
 		"open",
 		"delete",
+		"gc",
 		"serve",
 		"sync",
+		"peers",
+		"agent",
 	}
 
 	for _, cmd := range expectedCommands {
@@ -570,3 +582,546 @@ func createTestAccount(t *testing.T) (string, *Account) {
 
 	return tmpDir, account
 }
+
+// TestCLITimeline tests showing the account posts in the timeline
+func TestCLITimeline(t *testing.T) {
+	tmpDir, account := createTestAccount(t)
+	defer os.RemoveAll(tmpDir)
+
+	if _, err := account.AddContent(&SocialMediaPosting{ArticleBody: "Hello timeline"}, "", nil); err != nil {
+		t.Fatalf("Failed to add post: %v", err)
+	}
+
+	originalWd, _ := os.Getwd()
+	defer os.Chdir(originalWd)
+	if err := os.Chdir(tmpDir); err != nil {
+		t.Fatalf("Failed to change to temp dir: %v", err)
+	}
+
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+
+	oldPasswordFunc := getPasswordFunc
+	getPasswordFunc = func() string {
+		return "test-passphrase"
+	}
+	defer func() { getPasswordFunc = oldPasswordFunc }()
+
+	oldStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+	defer func() { os.Stdout = oldStdout }()
+
+	os.Args = []string{"mau", "timeline", "-type", "SocialMediaPosting"}
+	main()
+
+	w.Close()
+	var buf bytes.Buffer
+	io.Copy(&buf, r)
+	output := buf.String()
+
+	if !strings.Contains(output, "Hello timeline") {
+		t.Errorf("Expected the post in the timeline, got: %s", output)
+	}
+	if !strings.Contains(output, "Test User") {
+		t.Errorf("Expected the author in the timeline, got: %s", output)
+	}
+}
+
+// TestCLISearch tests searching the account posts, building the index first
+func TestCLISearch(t *testing.T) {
+	tmpDir, account := createTestAccount(t)
+	defer os.RemoveAll(tmpDir)
+
+	for _, body := range []string{"Tomato soup", "Lemon cake"} {
+		if _, err := account.AddContent(&SocialMediaPosting{ArticleBody: body}, "", nil); err != nil {
+			t.Fatalf("Failed to add post: %v", err)
+		}
+	}
+
+	originalWd, _ := os.Getwd()
+	defer os.Chdir(originalWd)
+	if err := os.Chdir(tmpDir); err != nil {
+		t.Fatalf("Failed to change to temp dir: %v", err)
+	}
+
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+
+	oldPasswordFunc := getPasswordFunc
+	getPasswordFunc = func() string {
+		return "test-passphrase"
+	}
+	defer func() { getPasswordFunc = oldPasswordFunc }()
+
+	oldStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+	defer func() { os.Stdout = oldStdout }()
+
+	os.Args = []string{"mau", "search", "tomato"}
+	main()
+
+	w.Close()
+	var buf bytes.Buffer
+	io.Copy(&buf, r)
+	output := buf.String()
+
+	if !strings.Contains(output, "Tomato soup") {
+		t.Errorf("Expected the matching post, got: %s", output)
+	}
+	if strings.Contains(output, "Lemon cake") {
+		t.Errorf("Expected only matching posts, got: %s", output)
+	}
+	if !account.SearchEnabled() {
+		t.Error("Expected the search index to be built")
+	}
+}
+
+// TestCLIChat tests sending a message and listing conversations
+func TestCLIChat(t *testing.T) {
+	tmpDir, account := createTestAccount(t)
+	defer os.RemoveAll(tmpDir)
+
+	friendDir, friendAccount := createTestAccount(t)
+	defer os.RemoveAll(friendDir)
+
+	var key bytes.Buffer
+	if err := friendAccount.Export(&key); err != nil {
+		t.Fatalf("Failed to export key: %v", err)
+	}
+	if _, err := account.AddFriend(&key); err != nil {
+		t.Fatalf("Failed to add friend: %v", err)
+	}
+	friendFPR := friendAccount.Fingerprint().String()
+
+	originalWd, _ := os.Getwd()
+	defer os.Chdir(originalWd)
+	if err := os.Chdir(tmpDir); err != nil {
+		t.Fatalf("Failed to change to temp dir: %v", err)
+	}
+
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+
+	oldPasswordFunc := getPasswordFunc
+	getPasswordFunc = func() string {
+		return "test-passphrase"
+	}
+	defer func() { getPasswordFunc = oldPasswordFunc }()
+
+	oldStdout := os.Stdout
+	defer func() { os.Stdout = oldStdout }()
+
+	run := func(args ...string) string {
+		r, w, _ := os.Pipe()
+		os.Stdout = w
+		os.Args = append([]string{"mau"}, args...)
+		main()
+		w.Close()
+
+		var buf bytes.Buffer
+		io.Copy(&buf, r)
+		return buf.String()
+	}
+
+	output := run("chat", "-with", friendFPR, "-send", "Hello friend")
+	if !strings.Contains(output, "Test User: Hello friend") {
+		t.Errorf("Expected the sent message, got: %s", output)
+	}
+
+	output = run("chat")
+	if !strings.Contains(output, "mau chat -with "+friendFPR) {
+		t.Errorf("Expected the conversation in the list, got: %s", output)
+	}
+}
+
+// TestCLIJSON tests the JSON output of the commands scripts read, with the
+// passphrase read from a file and from the environment
+func TestCLIJSON(t *testing.T) {
+	tmpDir, account := createTestAccount(t)
+	defer os.RemoveAll(tmpDir)
+
+	friendDir, friendAccount := createTestAccount(t)
+	defer os.RemoveAll(friendDir)
+
+	var key bytes.Buffer
+	if err := friendAccount.Export(&key); err != nil {
+		t.Fatalf("Failed to export key: %v", err)
+	}
+	friend, err := account.AddFriend(&key)
+	if err != nil {
+		t.Fatalf("Failed to add friend: %v", err)
+	}
+	if err := account.Follow(friend); err != nil {
+		t.Fatalf("Failed to follow friend: %v", err)
+	}
+	if _, err := account.AddFile(strings.NewReader("hello"), "hello.txt", []*Friend{friend}); err != nil {
+		t.Fatalf("Failed to add file: %v", err)
+	}
+
+	passFile := filepath.Join(t.TempDir(), "passphrase")
+	if err := os.WriteFile(passFile, []byte("test-passphrase\n"), 0600); err != nil {
+		t.Fatalf("Failed to write passphrase file: %v", err)
+	}
+
+	originalWd, _ := os.Getwd()
+	defer os.Chdir(originalWd)
+	if err := os.Chdir(tmpDir); err != nil {
+		t.Fatalf("Failed to change to temp dir: %v", err)
+	}
+
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+
+	oldStdout := os.Stdout
+	defer func() { os.Stdout = oldStdout }()
+
+	run := func(v any, args ...string) {
+		r, w, _ := os.Pipe()
+		os.Stdout = w
+		os.Args = append([]string{"mau"}, args...)
+		main()
+		w.Close()
+
+		var buf bytes.Buffer
+		io.Copy(&buf, r)
+		if err := json.Unmarshal(buf.Bytes(), v); err != nil {
+			t.Fatalf("Expected JSON output of %v, got: %s", args, buf.String())
+		}
+	}
+
+	var show friendJSON
+	run(&show, "-json", "-passphrase-file", passFile, "show")
+	if show.Name != "Test User" || show.Fingerprint != account.Fingerprint().String() {
+		t.Errorf("Unexpected show output: %+v", show)
+	}
+
+	t.Setenv(passphraseEnv, "test-passphrase")
+
+	var friends keyringJSON
+	run(&friends, "-json", "friends")
+	if len(friends.Friends) != 1 || friends.Friends[0].Fingerprint != friend.Fingerprint().String() {
+		t.Errorf("Unexpected friends output: %+v", friends)
+	}
+
+	var follows []friendJSON
+	run(&follows, "-json", "follows")
+	if len(follows) != 1 || follows[0].Email != "test@example.com" {
+		t.Errorf("Unexpected follows output: %+v", follows)
+	}
+
+	var files []fileJSON
+	run(&files, "-json", "files")
+	if len(files) != 1 || files[0].Name != "hello.txt.pgp" || files[0].Size == 0 || len(files[0].Recipients) != 1 {
+		t.Errorf("Unexpected files output: %+v", files)
+	}
+}
+
+// TestCLIExitCodes tests the exit code of each class of error
+func TestCLIExitCodes(t *testing.T) {
+	tmpDir, _ := createTestAccount(t)
+	defer os.RemoveAll(tmpDir)
+
+	originalWd, _ := os.Getwd()
+	defer os.Chdir(originalWd)
+	if err := os.Chdir(tmpDir); err != nil {
+		t.Fatalf("Failed to change to temp dir: %v", err)
+	}
+
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+
+	oldStderr := os.Stderr
+	defer func() { os.Stderr = oldStderr }()
+
+	// exiting must stop the command, so the exit code is panicked and
+	// recovered
+	type exit struct{ code int }
+	oldExit := exitFunc
+	exitFunc = func(code int) { panic(exit{code}) }
+	defer func() { exitFunc = oldExit }()
+
+	run := func(args ...string) (code int, stderr string) {
+		r, w, _ := os.Pipe()
+		os.Stderr = w
+		defer func() {
+			w.Close()
+			var buf bytes.Buffer
+			io.Copy(&buf, r)
+			stderr = buf.String()
+
+			if e, ok := recover().(exit); ok {
+				code = e.code
+			}
+		}()
+
+		os.Args = append([]string{"mau"}, args...)
+		main()
+		return 0, ""
+	}
+
+	t.Setenv(passphraseEnv, "wrong-passphrase")
+	if code, _ := run("show"); code != exitAccount {
+		t.Errorf("Expected exit code %d for a wrong passphrase, got %d", exitAccount, code)
+	}
+
+	t.Setenv(passphraseEnv, "test-passphrase")
+	code, stderr := run("-json", "follow", "-fingerprint", "0123456789abcdef0123456789abcdef01234567")
+	if code != exitNotFound {
+		t.Errorf("Expected exit code %d for an unknown friend, got %d", exitNotFound, code)
+	}
+	var failure struct {
+		Error string `json:"error"`
+		Code  int    `json:"code"`
+	}
+	if err := json.Unmarshal([]byte(stderr), &failure); err != nil || failure.Code != exitNotFound || failure.Error == "" {
+		t.Errorf("Expected the error as JSON, got: %s", stderr)
+	}
+
+	if code, _ := run("unknown"); code != exitUsage {
+		t.Errorf("Expected exit code %d for an unknown command, got %d", exitUsage, code)
+	}
+
+	busy, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer busy.Close()
+	port := strconv.Itoa(busy.Addr().(*net.TCPAddr).Port)
+	if code, _ := run("serve", "-port", port); code != exitNetwork {
+		t.Errorf("Expected exit code %d for a port in use, got %d", exitNetwork, code)
+	}
+}
+
+// TestCLICommandHelp tests the help of a command lists its flags
+func TestCLICommandHelp(t *testing.T) {
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+
+	oldStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+	defer func() { os.Stdout = oldStdout }()
+
+	os.Args = []string{"mau", "help", "sync"}
+	main()
+
+	w.Close()
+	var buf bytes.Buffer
+	io.Copy(&buf, r)
+	output := buf.String()
+
+	for _, expected := range []string{"Usage: mau sync", "-fingerprint", "-interval", "-watch"} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Help output missing %s: %s", expected, output)
+		}
+	}
+}
+
+// TestCLIRoot tests opening the account of another directory with -root and
+// MAU_HOME
+func TestCLIRoot(t *testing.T) {
+	tmpDir, account := createTestAccount(t)
+	defer os.RemoveAll(tmpDir)
+
+	t.Setenv(passphraseEnv, "test-passphrase")
+
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+
+	oldStdout := os.Stdout
+	defer func() { os.Stdout = oldStdout }()
+
+	oldExit := exitFunc
+	exitFunc = func(code int) { t.Fatalf("Unexpected exit with code %d", code) }
+	defer func() { exitFunc = oldExit }()
+
+	show := func(args ...string) friendJSON {
+		r, w, _ := os.Pipe()
+		os.Stdout = w
+		os.Args = append([]string{"mau", "-json"}, append(args, "show")...)
+		main()
+		w.Close()
+
+		var buf bytes.Buffer
+		io.Copy(&buf, r)
+		var out friendJSON
+		if err := json.Unmarshal(buf.Bytes(), &out); err != nil {
+			t.Fatalf("Expected JSON output, got: %s", buf.String())
+		}
+		return out
+	}
+
+	if out := show("-root", tmpDir); out.Fingerprint != account.Fingerprint().String() {
+		t.Errorf("Expected the account of -root, got: %+v", out)
+	}
+
+	t.Setenv(homeEnv, tmpDir)
+	if out := show(); out.Fingerprint != account.Fingerprint().String() {
+		t.Errorf("Expected the account of MAU_HOME, got: %+v", out)
+	}
+}
+
+// TestCLIConfig tests reading the defaults of the flags from the config file
+func TestCLIConfig(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(p, []byte(`
+root: /srv/mau
+port: 8080
+sync_interval: 15m
+resolvers:
+  - local
+  - mau.example.org:443
+bootstrap:
+  - fingerprint: 0123456789abcdef0123456789abcdef01234567
+    address: peer.example.org:443
+`), 0600)
+	if err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	conf, err := loadConfig(p)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if conf.Port != 8080 || conf.SyncInterval != 15*time.Minute {
+		t.Errorf("Unexpected config: %+v", conf)
+	}
+	if n := len(conf.resolvers("bob.example.org:443")); n != 3 {
+		t.Errorf("Expected 3 resolvers, got %d", n)
+	}
+
+	peers, err := conf.bootstrapPeers()
+	if err != nil || len(peers) != 1 || peers[0].Address != "peer.example.org:443" {
+		t.Errorf("Unexpected bootstrap peers: %v %v", peers, err)
+	}
+
+	t.Setenv(homeEnv, "")
+	if root, _ := accountRoot("", conf); root != "/srv/mau" {
+		t.Errorf("Expected the root of the config, got %s", root)
+	}
+	if root, _ := accountRoot("/tmp/other", conf); root != "/tmp/other" {
+		t.Errorf("Expected -root to win over the config, got %s", root)
+	}
+
+	if _, err := loadConfig(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("Expected an error for a missing config file")
+	}
+}
+
+// TestCLICompletion tests the completion scripts list the commands and flags
+func TestCLICompletion(t *testing.T) {
+	var bash bytes.Buffer
+	if err := printCompletion(&bash, "bash"); err != nil {
+		t.Fatalf("Failed to print completion: %v", err)
+	}
+	for _, expected := range []string{"complete -o default -F _mau mau", "timeline", "-lazy-attachments", "-root|--root"} {
+		if !strings.Contains(bash.String(), expected) {
+			t.Errorf("Bash completion missing %s", expected)
+		}
+	}
+
+	var fish bytes.Buffer
+	if err := printCompletion(&fish, "fish"); err != nil {
+		t.Fatalf("Failed to print completion: %v", err)
+	}
+	if !strings.Contains(fish.String(), "__fish_seen_subcommand_from sync' -o fingerprint") {
+		t.Errorf("Fish completion missing sync flags: %s", fish.String())
+	}
+
+	if err := printCompletion(io.Discard, "powershell"); err == nil {
+		t.Error("Expected an error for an unsupported shell")
+	}
+}
+
+// TestCLIAgent tests commands open the account with the key of the agent
+// once it's unlocked
+func TestCLIAgent(t *testing.T) {
+	tmpDir, account := createTestAccount(t)
+	defer os.RemoveAll(tmpDir)
+
+	listener, err := ListenAgent(AgentSocket(tmpDir))
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	agent := NewAgent(tmpDir, time.Hour)
+	go agent.Serve(listener)
+	defer agent.Close()
+
+	originalWd, _ := os.Getwd()
+	defer os.Chdir(originalWd)
+	if err := os.Chdir(tmpDir); err != nil {
+		t.Fatalf("Failed to change to temp dir: %v", err)
+	}
+
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+
+	prompts := 0
+	oldPasswordFunc := getPasswordFunc
+	getPasswordFunc = func() string {
+		prompts++
+		return "test-passphrase"
+	}
+	defer func() { getPasswordFunc = oldPasswordFunc }()
+
+	oldStdout := os.Stdout
+	defer func() { os.Stdout = oldStdout }()
+
+	run := func(args ...string) string {
+		r, w, _ := os.Pipe()
+		os.Stdout = w
+		os.Args = append([]string{"mau"}, args...)
+		main()
+		w.Close()
+
+		var buf bytes.Buffer
+		io.Copy(&buf, r)
+		return buf.String()
+	}
+
+	run("show")
+	if agent.Status().Unlocked {
+		t.Fatal("Expected commands not to unlock the agent")
+	}
+
+	run("agent", "-unlock")
+	if !agent.Status().Unlocked {
+		t.Fatal("Expected the prompted passphrase to unlock the agent")
+	}
+
+	output := run("show")
+	if prompts != 2 {
+		t.Errorf("Expected the agent key to be used, prompted %d times", prompts)
+	}
+	if !strings.Contains(output, account.Fingerprint().String()) {
+		t.Errorf("Expected the account to be opened, got: %s", output)
+	}
+
+	var status agentJSON
+	if err := json.Unmarshal([]byte(run("-json", "agent", "-status")), &status); err != nil || !status.Unlocked {
+		t.Errorf("Expected the agent to be unlocked: %+v %v", status, err)
+	}
+
+	run("agent", "-lock")
+	if agent.Status().Unlocked {
+		t.Error("Expected the agent to be locked")
+	}
+}
+
+// TestCLISyncInterval tests the config interval applies to serve, sync runs
+// once unless -interval is given
+func TestCLISyncInterval(t *testing.T) {
+	oldConf := conf
+	conf = &config{SyncInterval: 15 * time.Minute}
+	defer func() { conf = oldConf }()
+
+	if d := findCommand("serve").flagSet().Lookup("sync-interval").DefValue; d != "15m0s" {
+		t.Errorf("Expected serve to sync at the config interval, got %s", d)
+	}
+	if d := findCommand("sync").flagSet().Lookup("interval").DefValue; d != "0s" {
+		t.Errorf("Expected sync to run once unless -interval is given, got %s", d)
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	. "github.com/mau-network/mau"
+	"golang.org/x/term"
+)
+
+// Scripting: with --json the commands scripts read print one JSON document on
+// stdout with the schemas below, other messages go to stderr. errors exit
+// with a code per class of error, printed as {"error", "code"} on stderr in
+// JSON mode. the passphrase can be read from a file, a file descriptor or
+// the environment instead of the terminal.
+
+// Exit codes
+const (
+	exitError    = 1 // any other error, or no command given
+	exitUsage    = 2 // unknown command or invalid flags, like the flag package
+	exitAccount  = 3 // no account in the directory, wrong or missing passphrase
+	exitNotFound = 4 // friend, group or file not found
+	exitNetwork  = 5 // peer unreachable or sync failed
+)
+
+const (
+	passphraseEnv  = "MAU_PASSPHRASE"
+	agentSocketEnv = "MAU_AGENT_SOCK"
+)
+
+// codeError is an error exiting with a code its class doesn't imply
+type codeError struct {
+	code int
+	err  error
+}
+
+func (e *codeError) Error() string { return e.err.Error() }
+func (e *codeError) Unwrap() error { return e.err }
+
+// withCode sets the exit code of a non nil err
+func withCode(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &codeError{code: code, err: err}
+}
+
+// exitCode returns the exit code of the class of err
+func exitCode(err error) int {
+	var ce *codeError
+	switch {
+	case errors.As(err, &ce):
+		return ce.code
+	case errors.Is(err, ErrPassphraseRequired),
+		errors.Is(err, ErrIncorrectPassphrase),
+		errors.Is(err, ErrNoIdentity):
+		return exitAccount
+	case errors.Is(err, ErrCantFindFriend),
+		errors.Is(err, ErrGroupNotFound),
+		errors.Is(err, ErrFriendNotInGroup),
+		errors.Is(err, ErrReferenceNotFound),
+		errors.Is(err, fs.ErrNotExist):
+		return exitNotFound
+	case errors.Is(err, ErrIncorrectPeerCertificate):
+		return exitNetwork
+	}
+
+	return exitError
+}
+
+// raise exits with the code of err unless it's nil
+func raise(err error) {
+	if err != nil {
+		fail(exitCode(err), err)
+	}
+}
+
+// fail prints err and exits with code
+func fail(code int, err error) {
+	if opts.json {
+		out, _ := json.Marshal(map[string]any{"error": err.Error(), "code": code})
+		fmt.Fprintln(os.Stderr, string(out))
+	} else {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+	}
+
+	exitFunc(code)
+}
+
+// printJSON prints v as the JSON output of the command
+func printJSON(v any) {
+	out, err := json.MarshalIndent(v, "", "  ")
+	raise(err)
+	fmt.Println(string(out))
+}
+
+// status prints progress messages, to stderr in JSON mode to keep stdout
+// parseable
+func status(format string, a ...any) {
+	if opts.json {
+		fmt.Fprintf(os.Stderr, format+"\n", a...)
+		return
+	}
+
+	fmt.Printf(format+"\n", a...)
+}
+
+// readPassphrase reads the passphrase from --passphrase-file, --passphrase-fd,
+// MAU_PASSPHRASE or the terminal, in that order
+func readPassphrase() (string, error) {
+	switch {
+	case opts.passphraseFile != "":
+		f, err := os.Open(opts.passphraseFile)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		return firstLine(f)
+
+	case opts.passphraseFD >= 0:
+		return firstLine(os.NewFile(uintptr(opts.passphraseFD), "passphrase-fd"))
+	}
+
+	if pass, ok := os.LookupEnv(passphraseEnv); ok {
+		return pass, nil
+	}
+
+	if !term.IsTerminal(int(syscall.Stdin)) {
+		return "", fmt.Errorf("%w: use --passphrase-file, --passphrase-fd or %s", ErrPassphraseRequired, passphraseEnv)
+	}
+
+	// prompt on stderr so it isn't mixed with the output
+	fmt.Fprint(os.Stderr, "Passphrase: ")
+	pass, err := term.ReadPassword(int(syscall.Stdin))
+	fmt.Fprintln(os.Stderr)
+
+	return string(pass), err
+}
+
+// passphraseGiven is true when the passphrase comes from the flags or the
+// environment, they win over the agent
+func passphraseGiven() bool {
+	_, env := os.LookupEnv(passphraseEnv)
+	return opts.passphraseFile != "" || opts.passphraseFD >= 0 || env
+}
+
+func firstLine(f *os.File) (string, error) {
+	line, err := bufio.NewReader(f).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("%w: can't read %s: %v", ErrPassphraseRequired, f.Name(), err)
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// JSON schemas of the commands output. fields are only added, never renamed
+// or removed, so scripts keep working.
+
+type friendJSON struct {
+	Name        string `json:"name"`
+	Email       string `json:"email"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+type keyringJSON struct {
+	Name    string         `json:"name"`
+	Friends []friendJSON   `json:"friends"`
+	Groups  []*keyringJSON `json:"groups"`
+}
+
+type fileJSON struct {
+	Name       string       `json:"name"`
+	Size       int64        `json:"size"`
+	Recipients []friendJSON `json:"recipients"`
+	Error      string       `json:"error,omitempty"` // recipients couldn't be read
+}
+
+type skippedJSON struct {
+	FileListItem
+	Reason string `json:"reason"`
+}
+
+type syncJSON struct {
+	Fingerprint string         `json:"fingerprint"`
+	Downloaded  []FileListItem `json:"downloaded"`
+	Skipped     []skippedJSON  `json:"skipped"`
+	Incomplete  bool           `json:"incomplete"` // files were left for the next sync
+}
+
+type agentJSON struct {
+	Unlocked    bool      `json:"unlocked"`
+	Fingerprint string    `json:"fingerprint,omitempty"`
+	Expires     time.Time `json:"expires,omitzero"`
+}
+
+type peerJSON struct {
+	friendJSON
+	Address string `json:"address"`
+}
+
+func friendToJSON(f *Friend) friendJSON {
+	return friendJSON{Name: f.Name(), Email: f.Email(), Fingerprint: f.Fingerprint().String()}
+}
+
+func friendsToJSON(friends []*Friend) []friendJSON {
+	list := []friendJSON{}
+	for _, f := range friends {
+		list = append(list, friendToJSON(f))
+	}
+
+	return list
+}
+
+func keyringToJSON(r *Keyring) *keyringJSON {
+	k := &keyringJSON{Name: r.Name(), Friends: friendsToJSON(r.Friends), Groups: []*keyringJSON{}}
+	for _, sub := range r.SubKeyrings {
+		k.Groups = append(k.Groups, keyringToJSON(sub))
+	}
+
+	return k
+}
+
+func syncToJSON(fpr Fingerprint, report *SyncReport) *syncJSON {
+	s := &syncJSON{
+		Fingerprint: fpr.String(),
+		Downloaded:  append([]FileListItem{}, report.Downloaded...),
+		Skipped:     []skippedJSON{},
+		Incomplete:  report.Incomplete(),
+	}
+	for _, skipped := range report.Skipped {
+		s.Skipped = append(s.Skipped, skippedJSON{FileListItem: skipped.FileListItem, Reason: skipped.Reason.Error()})
+	}
+
+	return s
+}
@@ -11,10 +11,62 @@ const (
 	mDNSServiceName    = "_mau._tcp"
 	mauDirName         = ".mau"
 	accountKeyFilename = "account.pgp"
+	publicKeyFilename  = "account.pub" // not .pgp, the keyring reads those as friends
 	syncStateFilename  = "sync_state.json"
+	retentionFilename  = "retention.json"
+	indexFilename      = "index.json"
+	searchFilename     = "search.gpg"     // not .pgp, the keyring reads those as friends
+	chatStateFilename  = "chat_state.gpg" // encrypted to the account, like search.gpg
+	agentSocketName    = "agent.sock"
+	tombstoneSuffix    = ".tombstone"
+	blobSuffix         = ".blob"
+	SchemaContext      = "https://schema.org"
 	DirPerm            = 0700
 	FilePerm           = 0600
 	uriProtocolName    = "https"
 	mDNSDomain         = "local"
+	mDNSQUICField      = "quic" // TXT record field of the QUIC port
 	httpClientTimeout  = 3 * time.Second
+	staleTempAge       = time.Hour
+	referenceMaxSize   = 64 << 20 // bytes of a file fetched to resolve an address
+	tombstoneMaxSize   = 64 << 10 // bytes of a tombstone, a small signed record
+
+	portMapAttempts       = 4
+	portMapInitialTimeout = 250 * time.Millisecond
+
+	relayProtocol          = "mau-relay"
+	relayPendingCircuits   = 16
+	relayAcceptTimeout     = 10 * time.Second
+	relayHeartbeatInterval = 25 * time.Second
+
+	rendezvousPendingPeers  = 16
+	rendezvousPunchAttempts = 5
+	rendezvousPunchInterval = 100 * time.Millisecond
+
+	fileEventsBufferSize = 64
+
+	accountWatchBufferSize   = 64
+	accountWatchPollInterval = time.Second
+	accountWatchSettleDelay  = 50 * time.Millisecond
+	accountWatchMaxDelay     = 500 * time.Millisecond
+
+	signalingMailboxSize    = 64
+	signalingMessageTTL     = 30 * time.Second
+	signalingMaxMessageSize = 64 << 10
+	signalingMaxPollWait    = 25 * time.Second // below the server write timeout
+	signalingNonceSize      = 16
+	signalingChallengeTTL   = time.Minute
+	signalingTokenSize      = 32
+	signalingSessionTTL     = time.Hour
+	signalingRemotePollWait = 2 * time.Second // below the client timeout
+
+	TombstoneRetention = 90 * 24 * time.Hour // default period tombstones are kept for
+
+	webrtcChannelLabel     = "mau"
+	webrtcChallengeSize    = 32
+	webrtcHandshakeTimeout = 10 * time.Second
+
+	AgentTimeout        = 15 * time.Minute // default time an agent keeps the account unlocked
+	agentDialTimeout    = time.Second
+	agentRequestTimeout = 10 * time.Second
 )
@@ -0,0 +1,247 @@
+package mau
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var (
+	ErrInvalidContext = errors.New("Content @context isn't Schema.org")
+	ErrMissingType    = errors.New("Content has no @type")
+	ErrTypeMismatch   = errors.New("Content @type isn't the expected type")
+	ErrNotOwnContent  = errors.New("Only the account own files can be updated")
+)
+
+// schemaContexts are the @context values accepted for Schema.org
+var schemaContexts = map[string]bool{
+	SchemaContext:        true,
+	SchemaContext + "/":  true,
+	"http://schema.org":  true,
+	"http://schema.org/": true,
+}
+
+// Content is a Schema.org JSON-LD document: one of the types of this
+// package or a Document for any other type
+type Content interface {
+	SchemaType() string
+}
+
+// keyedContent is content named after what it's about instead of its whole
+// document, like a like named after the liked document
+type keyedContent interface {
+	contentKey() string
+}
+
+// contentTypes builds the Go type of the Schema.org types this package knows
+var contentTypes = map[string]func() Content{
+	"SocialMediaPosting": func() Content { return &SocialMediaPosting{} },
+	"Comment":            func() Content { return &Comment{} },
+	"LikeAction":         func() Content { return &LikeAction{} },
+	"ShareAction":        func() Content { return &ShareAction{} },
+	"FollowAction":       func() Content { return &FollowAction{} },
+	"Message":            func() Content { return &Message{} },
+}
+
+// AddContent shares the content as a JSON-LD file. the @context and @type are
+// filled when empty and @id is set to the file address. an empty name is
+// replaced by ContentName so the same content always gets the same file.
+func (a *Account) AddContent(content Content, name string, recipients []*Friend) (*File, error) {
+	doc, err := contentDocument(content)
+	if err != nil {
+		return nil, err
+	}
+
+	if name == "" {
+		name = documentName(content, doc)
+	}
+
+	doc["@id"] = fileListPath(a.Fingerprint(), ensurePGPExtension(name))
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := a.AddFile(bytes.NewReader(data), name, recipients)
+	if err != nil {
+		return nil, err
+	}
+
+	a.searchFile(file)
+	return file, nil
+}
+
+// UpdateContent replaces the content of one of the account files, keeping
+// the old content as a version. nil recipients keep the file recipients.
+func (a *Account) UpdateContent(file *File, content Content, recipients []*Friend) (*File, error) {
+	if !a.isSharedFile(file) {
+		return nil, ErrNotOwnContent
+	}
+
+	if recipients == nil {
+		var err error
+		if recipients, err = file.Recipients(a); err != nil {
+			return nil, err
+		}
+	}
+
+	return a.AddContent(content, file.Name(), recipients)
+}
+
+// ContentName returns the file name of content: its type and a hash of what
+// it's about for actions or of the whole document otherwise
+func ContentName(content Content) (string, error) {
+	doc, err := contentDocument(content)
+	if err != nil {
+		return "", err
+	}
+
+	return documentName(content, doc), nil
+}
+
+func documentName(content Content, doc Document) string {
+	key := doc.SchemaType() + ":"
+	if keyed, ok := content.(keyedContent); ok && keyed.contentKey() != "" {
+		key += keyed.contentKey()
+	} else {
+		withoutID := Document{}
+		for k, v := range doc {
+			if k != "@id" {
+				withoutID[k] = v
+			}
+		}
+		data, _ := json.Marshal(withoutID) // map keys are sorted
+		key += string(data)
+	}
+
+	sum := sha256.Sum256([]byte(key))
+	return fmt.Sprintf("%s-%x.json", strings.ToLower(doc.SchemaType()), sum[:8])
+}
+
+// contentDocument converts content to a generic document with @context and
+// @type filled and validated
+func contentDocument(content Content) (Document, error) {
+	data, err := json.Marshal(content)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := Document{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	if _, ok := doc["@context"]; !ok {
+		doc["@context"] = SchemaContext
+	}
+	if doc.SchemaType() == "" && content.SchemaType() != "" {
+		doc["@type"] = content.SchemaType()
+	}
+
+	return doc, validateDocument(doc)
+}
+
+func validateDocument(doc Document) error {
+	if !isSchemaContext(doc["@context"]) {
+		return ErrInvalidContext
+	}
+
+	if doc.SchemaType() == "" {
+		return ErrMissingType
+	}
+
+	return nil
+}
+
+// isSchemaContext is true for the Schema.org context or a list of contexts
+// including it
+func isSchemaContext(context any) bool {
+	switch c := context.(type) {
+	case string:
+		return schemaContexts[c]
+	case []any:
+		for _, v := range c {
+			if isSchemaContext(v) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// ReadContent decodes the file into content after checking its @context and
+// @type. any type can be read into a *Document.
+func (f *File) ReadContent(account *Account, content Content) error {
+	data, doc, err := f.readDocument(account)
+	if err != nil {
+		return err
+	}
+
+	if _, generic := content.(*Document); !generic && doc.SchemaType() != content.SchemaType() {
+		return fmt.Errorf("%w: %s isn't %s", ErrTypeMismatch, doc.SchemaType(), content.SchemaType())
+	}
+
+	return json.Unmarshal(data, content)
+}
+
+// Content decodes the file into the Go type of its @type, a *Document for
+// types without one
+func (f *File) Content(account *Account) (Content, error) {
+	r, err := f.Reader(account)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeContent(f.Name(), data)
+}
+
+func (f *File) readDocument(account *Account) ([]byte, Document, error) {
+	r, err := f.Reader(account)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	doc, err := parseDocument(f.Name(), data)
+	return data, doc, err
+}
+
+// decodeContent decodes the decrypted content of the file name into the Go
+// type of its @type
+func decodeContent(name string, data []byte) (Content, error) {
+	doc, err := parseDocument(name, data)
+	if err != nil {
+		return nil, err
+	}
+
+	build, ok := contentTypes[doc.SchemaType()]
+	if !ok {
+		return &doc, nil
+	}
+
+	content := build()
+	return content, json.Unmarshal(data, content)
+}
+
+func parseDocument(name string, data []byte) (Document, error) {
+	doc := Document{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to decode %s as JSON-LD: %w", name, err)
+	}
+
+	return doc, validateDocument(doc)
+}
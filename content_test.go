@@ -0,0 +1,142 @@
+package mau
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContent(t *testing.T) {
+	account, err := NewAccountWithStorage(NewMemoryStorage(), "/ahmed", "Ahmed Mohamed", "ahmed@example.com", "password")
+	require.NoError(t, err)
+	friend, err := NewAccountWithStorage(NewMemoryStorage(), "/mohamed", "Mohamed", "mohamed@example.com", "password")
+	require.NoError(t, err)
+	mohamed := befriend(t, account, friend)
+	author := NewPerson(account.Name(), account.Fingerprint())
+
+	t.Run("Round trips a post", func(t T) {
+		post := &SocialMediaPosting{
+			Headline:      "Hello",
+			ArticleBody:   "Hello world",
+			Author:        author,
+			DatePublished: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			Keywords:      []string{"greeting"},
+		}
+		file, err := account.AddContent(post, "hello.json", []*Friend{mohamed})
+		require.NoError(t, err)
+		assert.Equal(t, "hello.json.pgp", file.Name())
+
+		read := &SocialMediaPosting{}
+		require.NoError(t, file.ReadContent(account, read))
+		assert.Equal(t, LinkedDataContext{SchemaContext}, read.Context)
+		assert.Equal(t, "SocialMediaPosting", read.Type)
+		assert.Equal(t, "/p2p/"+account.Fingerprint().String()+"/hello.json.pgp", read.ID)
+		assert.Equal(t, post.ArticleBody, read.ArticleBody)
+		assert.Equal(t, post.Keywords, read.Keywords)
+		assert.True(t, post.DatePublished.Equal(read.DatePublished))
+		assert.Equal(t, author.Identifier, read.Author.Identifier)
+
+		content, err := file.Content(account)
+		require.NoError(t, err)
+		assert.IsType(t, &SocialMediaPosting{}, content)
+	})
+
+	t.Run("Names actions after their object", func(t T) {
+		post := &Reference{Type: "SocialMediaPosting", ID: "/p2p/abc/hello.json.pgp"}
+		like := &LikeAction{Agent: author, Object: post, StartTime: time.Now()}
+		file, err := account.AddContent(like, "", nil)
+		require.NoError(t, err)
+
+		again := &LikeAction{Agent: author, Object: post, StartTime: time.Now().Add(time.Hour)}
+		name, err := ContentName(again)
+		require.NoError(t, err)
+		assert.Equal(t, file.Name(), name+".pgp")
+		assert.Regexp(t, `^likeaction-[0-9a-f]{16}\.json$`, name)
+
+		other, err := ContentName(&LikeAction{Object: &Reference{ID: "/p2p/abc/other.json.pgp"}})
+		require.NoError(t, err)
+		assert.NotEqual(t, name, other)
+
+		first, err := ContentName(&Comment{Text: "first"})
+		require.NoError(t, err)
+		second, err := ContentName(&Comment{Text: "second"})
+		require.NoError(t, err)
+		assert.NotEqual(t, first, second)
+	})
+
+	t.Run("Reads unknown types as documents", func(t T) {
+		event := &Document{"@type": "Event", "name": "Meetup", "location": "Cairo"}
+		file, err := account.AddContent(event, "", nil)
+		require.NoError(t, err)
+
+		content, err := file.Content(account)
+		require.NoError(t, err)
+		doc, ok := content.(*Document)
+		require.True(t, ok)
+		assert.Equal(t, "Event", doc.SchemaType())
+		assert.Equal(t, "Meetup", (*doc)["name"])
+
+		assert.ErrorIs(t, file.ReadContent(account, &Comment{}), ErrTypeMismatch)
+	})
+
+	t.Run("Validates context and type", func(t T) {
+		_, err := account.AddContent(&Document{"name": "untyped"}, "", nil)
+		assert.ErrorIs(t, err, ErrMissingType)
+
+		_, err = account.AddContent(&Document{"@context": "https://example.com", "@type": "Event"}, "", nil)
+		assert.ErrorIs(t, err, ErrInvalidContext)
+
+		_, err = account.AddContent(&Document{"@context": []any{"https://example.com", "http://schema.org/"}, "@type": "Event"}, "", nil)
+		assert.NoError(t, err)
+
+		file, err := account.AddFile(bytes.NewBufferString(`{"@type": "Comment"}`), "plain.json", nil)
+		require.NoError(t, err)
+		assert.ErrorIs(t, file.ReadContent(account, &Comment{}), ErrInvalidContext)
+
+		file, err = account.AddFile(bytes.NewBufferString("not json"), "plain.txt", nil)
+		require.NoError(t, err)
+		_, err = file.Content(account)
+		assert.Error(t, err)
+	})
+
+	t.Run("Reads a list of contexts into typed content", func(t T) {
+		data := `{"@context": ["https://schema.org", {"ex": "https://example.com/"}], "@type": "Comment", "text": "Hi"}`
+		file, err := account.AddFile(bytes.NewBufferString(data), "listed.json", nil)
+		require.NoError(t, err)
+
+		comment := &Comment{}
+		require.NoError(t, file.ReadContent(account, comment))
+		assert.Equal(t, "Hi", comment.Text)
+		assert.Len(t, comment.Context, 2)
+		assert.Equal(t, SchemaContext, comment.Context[0])
+
+		content, err := file.Content(account)
+		require.NoError(t, err)
+		assert.IsType(t, &Comment{}, content)
+	})
+
+	t.Run("Updates content keeping its recipients", func(t T) {
+		file, err := account.AddContent(&Comment{Text: "typo"}, "comment.json", []*Friend{mohamed})
+		require.NoError(t, err)
+
+		updated, err := account.UpdateContent(file, &Comment{Text: "fixed"}, nil)
+		require.NoError(t, err)
+		assert.Equal(t, file.Name(), updated.Name())
+		assert.Len(t, updated.Versions(), 1)
+
+		recipients, err := updated.Recipients(account)
+		require.NoError(t, err)
+		require.Len(t, recipients, 1)
+		assert.Equal(t, mohamed.Fingerprint(), recipients[0].Fingerprint())
+
+		comment := &Comment{}
+		require.NoError(t, updated.ReadContent(account, comment))
+		assert.Equal(t, "fixed", comment.Text)
+
+		_, err = account.UpdateContent(updated.Versions()[0], &Comment{Text: "old"}, nil)
+		assert.ErrorIs(t, err, ErrNotOwnContent)
+	})
+}
@@ -0,0 +1,231 @@
+package mau
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+)
+
+// LinkedData holds the JSON-LD keywords of a document. AddContent fills the
+// context, type and id when they're empty.
+type LinkedData struct {
+	Context LinkedDataContext `json:"@context,omitempty"`
+	Type    string            `json:"@type,omitempty"`
+	ID      string            `json:"@id,omitempty"`
+}
+
+// LinkedDataContext is the @context of a document, a single context or a
+// list of them. a single context string is written back as a string.
+type LinkedDataContext []any
+
+// UnmarshalJSON reads a context string or a list of contexts
+func (c *LinkedDataContext) UnmarshalJSON(data []byte) error {
+	var single string
+	if json.Unmarshal(data, &single) == nil {
+		*c = LinkedDataContext{single}
+		return nil
+	}
+
+	var list []any
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+
+	*c = list
+	return nil
+}
+
+func (c LinkedDataContext) MarshalJSON() ([]byte, error) {
+	if len(c) == 1 {
+		if single, ok := c[0].(string); ok {
+			return json.Marshal(single)
+		}
+	}
+
+	return json.Marshal([]any(c))
+}
+
+// Person identifies an account by its fingerprint
+type Person struct {
+	Type       string `json:"@type,omitempty"`
+	Name       string `json:"name,omitempty"`
+	Identifier string `json:"identifier,omitempty"` // fingerprint hex string
+}
+
+// NewPerson returns the Person of a friend or the account
+func NewPerson(name string, fpr Fingerprint) *Person {
+	return &Person{Type: "Person", Name: name, Identifier: fpr.String()}
+}
+
+// Reference points to another document by its content address
+type Reference struct {
+	Type string `json:"@type,omitempty"`
+	ID   string `json:"@id"`
+}
+
+// UnmarshalJSON reads bare addresses too, like "object": "/p2p/<FPR>/<file>"
+func (r *Reference) UnmarshalJSON(data []byte) error {
+	var id string
+	if json.Unmarshal(data, &id) == nil {
+		*r = Reference{ID: id}
+		return nil
+	}
+
+	type reference Reference // without this method
+	return json.Unmarshal(data, (*reference)(r))
+}
+
+// MediaObject is an attachment of a document, made by AddAttachment
+type MediaObject struct {
+	Type           string `json:"@type,omitempty"` // ImageObject, VideoObject, AudioObject or MediaObject
+	ContentURL     string `json:"contentUrl"`      // address of the blob
+	ContentSize    int64  `json:"contentSize"`     // bytes
+	SHA256         string `json:"sha256"`
+	EncodingFormat string `json:"encodingFormat,omitempty"` // MIME type
+	Name           string `json:"name,omitempty"`
+}
+
+// SocialMediaPosting is a status update or short post
+type SocialMediaPosting struct {
+	LinkedData
+	Headline        string         `json:"headline,omitempty"`
+	ArticleBody     string         `json:"articleBody,omitempty"`
+	Author          *Person        `json:"author,omitempty"`
+	DatePublished   time.Time      `json:"datePublished,omitzero"`
+	Keywords        []string       `json:"keywords,omitempty"`
+	Mentions        []*Person      `json:"mentions,omitempty"`
+	SharedContent   *Reference     `json:"sharedContent,omitempty"`
+	AssociatedMedia []*MediaObject `json:"associatedMedia,omitempty"`
+}
+
+func (*SocialMediaPosting) SchemaType() string { return "SocialMediaPosting" }
+
+// Comment is a reply to another document
+type Comment struct {
+	LinkedData
+	Text            string         `json:"text"`
+	Author          *Person        `json:"author,omitempty"`
+	DateCreated     time.Time      `json:"dateCreated,omitzero"`
+	ParentItem      *Reference     `json:"parentItem,omitempty"`
+	AssociatedMedia []*MediaObject `json:"associatedMedia,omitempty"`
+}
+
+func (*Comment) SchemaType() string { return "Comment" }
+
+// LikeAction likes a document. its file name is derived from the liked
+// document so liking it again replaces the like.
+type LikeAction struct {
+	LinkedData
+	Agent     *Person    `json:"agent,omitempty"`
+	Object    *Reference `json:"object"`
+	StartTime time.Time  `json:"startTime,omitzero"`
+	EndTime   time.Time  `json:"endTime,omitzero"` // set when the like is withdrawn
+}
+
+func (*LikeAction) SchemaType() string { return "LikeAction" }
+func (l *LikeAction) contentKey() string {
+	return referenceKey(l.Object)
+}
+
+// ShareAction shares a document with the account followers
+type ShareAction struct {
+	LinkedData
+	Agent       *Person    `json:"agent,omitempty"`
+	Object      *Reference `json:"object"`
+	StartTime   time.Time  `json:"startTime,omitzero"`
+	Description string     `json:"description,omitempty"`
+}
+
+func (*ShareAction) SchemaType() string { return "ShareAction" }
+func (s *ShareAction) contentKey() string {
+	return referenceKey(s.Object)
+}
+
+// FollowAction follows a person, its file name is derived from the followed
+// person
+type FollowAction struct {
+	LinkedData
+	Agent     *Person   `json:"agent,omitempty"`
+	Object    *Person   `json:"object"`
+	StartTime time.Time `json:"startTime,omitzero"`
+	EndTime   time.Time `json:"endTime,omitzero"` // set when unfollowing
+}
+
+func (*FollowAction) SchemaType() string { return "FollowAction" }
+func (f *FollowAction) contentKey() string {
+	if f.Object == nil {
+		return ""
+	}
+
+	return f.Object.Identifier
+}
+
+// Message is a chat message to the recipients. its conversation is the set
+// of its sender and recipients.
+type Message struct {
+	LinkedData
+	Text              string         `json:"text"`
+	Sender            *Person        `json:"sender,omitempty"`
+	Recipient         []*Person      `json:"recipient"`
+	DateSent          time.Time      `json:"dateSent,omitzero"`
+	MessageAttachment []*MediaObject `json:"messageAttachment,omitempty"`
+}
+
+func (*Message) SchemaType() string { return "Message" }
+
+// UnmarshalJSON reads a single recipient or attachment too, JSON-LD doesn't
+// tell one value from a list of one
+func (m *Message) UnmarshalJSON(data []byte) error {
+	type message Message // without this method
+	var doc struct {
+		*message
+		Recipient         json.RawMessage `json:"recipient"`
+		MessageAttachment json.RawMessage `json:"messageAttachment"`
+	}
+	doc.message = (*message)(m)
+
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	if err := unmarshalOneOrMany(doc.Recipient, &m.Recipient); err != nil {
+		return err
+	}
+
+	return unmarshalOneOrMany(doc.MessageAttachment, &m.MessageAttachment)
+}
+
+// Document is any JSON-LD document, for types without a Go type
+type Document map[string]any
+
+func (d Document) SchemaType() string {
+	t, _ := d["@type"].(string)
+	return t
+}
+
+// unmarshalOneOrMany reads a JSON object or list of objects into list
+func unmarshalOneOrMany[T any](data json.RawMessage, list *[]*T) error {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || string(data) == "null" {
+		return nil
+	}
+
+	if data[0] != '{' {
+		return json.Unmarshal(data, list)
+	}
+
+	one := new(T)
+	if err := json.Unmarshal(data, one); err != nil {
+		return err
+	}
+	*list = []*T{one}
+
+	return nil
+}
+
+func referenceKey(r *Reference) string {
+	if r == nil {
+		return ""
+	}
+
+	return r.ID
+}
@@ -0,0 +1,160 @@
+package mau
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sync"
+)
+
+var (
+	ErrFileTooLarge       = errors.New("File is larger than the maximum file size")
+	ErrFriendQuotaReached = errors.New("Friend directory would exceed its quota")
+	ErrSyncFileLimit      = errors.New("Sync reached the maximum number of files")
+	ErrAttachmentDeferred = errors.New("Attachment is downloaded when opened")
+)
+
+// DownloadPolicy limits what the client downloads from the peer so one
+// prolific friend can't fill the disk. zero values don't limit. tombstones
+// are capped at a small size instead of the maximum file size, and count
+// against the other limits like any file.
+type DownloadPolicy struct {
+	MaxFileSize    int64 // bytes of a single file
+	MaxFriendBytes int64 // bytes of the friend directory, versions included
+	MaxSyncFiles   int   // files downloaded by one SyncFriend call
+
+	// LazyAttachments leaves attachments for FetchAttachment to download
+	// when they're opened
+	LazyAttachments bool
+}
+
+// SkippedFile is a file the download policy refused to download
+type SkippedFile struct {
+	FileListItem
+	Reason error
+}
+
+func (s *SkippedFile) Error() string {
+	return fmt.Sprintf("skipped %s: %s", path.Base(s.Path), s.Reason)
+}
+
+func (s *SkippedFile) Unwrap() error { return s.Reason }
+
+// SyncReport lists the files downloaded and skipped by a sync
+type SyncReport struct {
+	Downloaded []FileListItem
+	Skipped    []*SkippedFile
+}
+
+// Incomplete is true when files were left for the next sync because of the
+// maximum number of files per sync. the time of an incomplete sync shouldn't
+// be used for the next incremental sync or those files would be missed.
+func (r *SyncReport) Incomplete() bool {
+	for _, s := range r.Skipped {
+		if errors.Is(s.Reason, ErrSyncFileLimit) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SetDownloadPolicy sets the limits of the files downloaded from the peer
+func (c *Client) SetDownloadPolicy(policy DownloadPolicy) {
+	c.policy = policy
+}
+
+type syncSessionKey struct{}
+
+// syncSession is the state of one sync, shared by the downloads of the sync
+// through their context
+type syncSession struct {
+	mutex       sync.Mutex
+	report      *SyncReport
+	friendBytes int64 // -1 until the friend directory is measured
+}
+
+func withSyncSession(ctx context.Context) (context.Context, *syncSession) {
+	s := &syncSession{report: &SyncReport{Downloaded: []FileListItem{}, Skipped: []*SkippedFile{}}, friendBytes: -1}
+	return context.WithValue(ctx, syncSessionKey{}, s), s
+}
+
+// checkDownloadPolicy returns a SkippedFile error when the policy refuses
+// the file, the session of ctx if any records it
+func (c *Client) checkDownloadPolicy(ctx context.Context, fingerprint Fingerprint, file *FileListItem) error {
+	session, _ := ctx.Value(syncSessionKey{}).(*syncSession)
+	if session == nil {
+		session = &syncSession{report: &SyncReport{}, friendBytes: -1}
+	}
+
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	reason := c.policyViolation(session, fingerprint, file)
+	if reason == nil {
+		return nil
+	}
+
+	skipped := &SkippedFile{FileListItem: *file, Reason: reason}
+	session.report.Skipped = append(session.report.Skipped, skipped)
+	return skipped
+}
+
+func (c *Client) policyViolation(session *syncSession, fingerprint Fingerprint, file *FileListItem) error {
+	tombstone := isTombstoneName(path.Base(file.Path))
+
+	switch {
+	case c.policy.LazyAttachments && isBlobName(path.Base(file.Path)):
+		return ErrAttachmentDeferred
+	case tombstone && file.Size > tombstoneMaxSize:
+		return ErrFileTooLarge
+	case !tombstone && c.policy.MaxFileSize > 0 && file.Size > c.policy.MaxFileSize:
+		return ErrFileTooLarge
+	case c.policy.MaxSyncFiles > 0 && len(session.report.Downloaded) >= c.policy.MaxSyncFiles:
+		return ErrSyncFileLimit
+	case c.policy.MaxFriendBytes > 0 && c.friendBytes(session, fingerprint)+file.Size > c.policy.MaxFriendBytes:
+		return ErrFriendQuotaReached
+	}
+
+	return nil
+}
+
+// bodyLimit is the most bytes read when downloading the file: its listed size
+// or the maximum size of a tombstone or file of the policy if smaller
+func (c *Client) bodyLimit(file *FileListItem) int64 {
+	switch {
+	case isTombstoneName(path.Base(file.Path)):
+		return min(file.Size, tombstoneMaxSize)
+	case c.policy.MaxFileSize > 0:
+		return min(file.Size, c.policy.MaxFileSize)
+	}
+
+	return file.Size
+}
+
+// friendBytes returns the size of the friend directory, measured once per
+// session and kept up to date by recordDownload
+func (c *Client) friendBytes(session *syncSession, fingerprint Fingerprint) int64 {
+	if session.friendBytes < 0 {
+		session.friendBytes = dirSize(c.account.storage, path.Join(c.account.path, fingerprint.String()))
+	}
+
+	return session.friendBytes
+}
+
+// recordDownload adds a downloaded file to the session of ctx if any
+func recordDownload(ctx context.Context, file *FileListItem) {
+	session, _ := ctx.Value(syncSessionKey{}).(*syncSession)
+	if session == nil {
+		return
+	}
+
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	session.report.Downloaded = append(session.report.Downloaded, *file)
+	if session.friendBytes >= 0 {
+		session.friendBytes += file.Size
+	}
+}
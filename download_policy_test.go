@@ -0,0 +1,158 @@
+package mau
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDownloadPolicy(t *testing.T) {
+	accountDir := t.TempDir()
+	account, err := NewAccount(accountDir, "Ahmed Mohamed", "ahmed@example.com", "password")
+	require.NoError(t, err)
+	friend, err := NewAccount(t.TempDir(), "Mohamed Mahmoud", "mohamed@example.com", "password")
+	require.NoError(t, err)
+
+	aFriend := befriend(t, friend, account)
+	require.NoError(t, account.Follow(befriend(t, account, friend)))
+
+	share := func(name string, size int) {
+		content := make([]byte, size)
+		_, err := rand.Read(content)
+		require.NoError(t, err)
+		_, err = friend.AddFile(bytes.NewReader(content), name, []*Friend{aFriend})
+		require.NoError(t, err)
+	}
+	share("small.txt", 10)
+	share("big.txt", 64<<10)
+
+	server, err := friend.Server(nil)
+	require.NoError(t, err)
+	listener, address := TempListener()
+	go func() { _ = server.Serve(*listener, "") }()
+	defer server.Close()
+
+	client, err := account.Client(friend.Fingerprint(), nil)
+	require.NoError(t, err)
+	resolvers := []FingerprintResolver{StaticAddress(address)}
+	localPath := func(name string) string {
+		return path.Join(accountDir, friend.Fingerprint().String(), name)
+	}
+	sync := func() *SyncReport {
+		report, err := client.SyncFriend(context.Background(), friend.Fingerprint(), time.Time{}, resolvers)
+		require.NoError(t, err)
+		return report
+	}
+
+	t.Run("Skips files larger than the maximum file size", func(t T) {
+		client.SetDownloadPolicy(DownloadPolicy{MaxFileSize: 16 << 10})
+		report := sync()
+
+		require.Len(t, report.Downloaded, 1)
+		assert.Equal(t, "small.txt.pgp", path.Base(report.Downloaded[0].Path))
+		require.Len(t, report.Skipped, 1)
+		assert.Equal(t, "big.txt.pgp", path.Base(report.Skipped[0].Path))
+		assert.ErrorIs(t, report.Skipped[0], ErrFileTooLarge)
+		assert.False(t, report.Incomplete())
+		assert.NoFileExists(t, localPath("big.txt.pgp"))
+	})
+
+	t.Run("Stops reading files larger than listed", func(t T) {
+		item := &FileListItem{Path: fileListPath(friend.Fingerprint(), "big.txt.pgp"), Size: 1 << 10, Sum: "unknown"}
+		err := client.DownloadFile(context.Background(), address, friend.Fingerprint(), "big.txt.pgp", item)
+		assert.ErrorIs(t, err, ErrFileTooLarge)
+		assert.NoFileExists(t, localPath("big.txt.pgp"))
+	})
+
+	t.Run("Skips files beyond the friend quota", func(t T) {
+		used := dirSize(account.storage, path.Join(accountDir, friend.Fingerprint().String()))
+		client.SetDownloadPolicy(DownloadPolicy{MaxFriendBytes: used + 16<<10})
+		report := sync()
+
+		assert.Empty(t, report.Downloaded)
+		require.Len(t, report.Skipped, 1)
+		assert.ErrorIs(t, report.Skipped[0], ErrFriendQuotaReached)
+		assert.NoFileExists(t, localPath("big.txt.pgp"))
+	})
+
+	t.Run("Downloads a limited number of files per sync", func(t T) {
+		share("a.txt", 10)
+		share("b.txt", 10)
+		client.SetDownloadPolicy(DownloadPolicy{MaxSyncFiles: 1})
+
+		report := sync()
+		assert.Len(t, report.Downloaded, 1)
+		assert.Len(t, report.Skipped, 2)
+		assert.True(t, report.Incomplete())
+
+		for range 2 {
+			report = sync()
+			assert.Len(t, report.Downloaded, 1)
+		}
+		assert.False(t, report.Incomplete())
+
+		for _, name := range []string{"big.txt.pgp", "a.txt.pgp", "b.txt.pgp"} {
+			assert.FileExists(t, localPath(name))
+		}
+	})
+
+	t.Run("Counts tombstones against the friend quota", func(t T) {
+		file, err := friend.GetFile(friend.Fingerprint(), "small.txt.pgp")
+		require.NoError(t, err)
+		require.NoError(t, friend.RemoveFile(file))
+
+		client.SetDownloadPolicy(DownloadPolicy{MaxFriendBytes: 1})
+		report := sync()
+		require.Len(t, report.Skipped, 1)
+		assert.ErrorIs(t, report.Skipped[0], ErrFriendQuotaReached)
+		assert.FileExists(t, localPath("small.txt.pgp"))
+	})
+
+	t.Run("Downloads tombstones larger than the maximum file size", func(t T) {
+		client.SetDownloadPolicy(DownloadPolicy{MaxFileSize: 1})
+		report := sync()
+		assert.Empty(t, report.Skipped)
+		assert.NoFileExists(t, localPath("small.txt.pgp"))
+	})
+
+	t.Run("Skips tombstones larger than a tombstone can be", func(t T) {
+		client.SetDownloadPolicy(DownloadPolicy{})
+		item := &FileListItem{Path: fileListPath(friend.Fingerprint(), "big.txt.pgp.tombstone"), Size: tombstoneMaxSize + 1}
+		err := client.DownloadFile(context.Background(), address, friend.Fingerprint(), "big.txt.pgp.tombstone", item)
+		assert.ErrorIs(t, err, ErrFileTooLarge)
+	})
+
+	t.Run("Removes invalid tombstones", func(t T) {
+		share("fake.txt", 10)
+		friendDir := path.Join(friend.path, friend.Fingerprint().String())
+		require.NoError(t, os.Rename(path.Join(friendDir, "fake.txt.pgp"), path.Join(friendDir, "other.txt.pgp.tombstone")))
+
+		client.SetDownloadPolicy(DownloadPolicy{})
+		sync()
+		assert.NoFileExists(t, localPath("other.txt.pgp.tombstone"))
+	})
+
+	t.Run("Downloading a single file checks the policy", func(t T) {
+		client.SetDownloadPolicy(DownloadPolicy{MaxFileSize: 1})
+		share("c.txt", 10)
+		list, err := client.fetchFileList(context.Background(), friend.Fingerprint(), address, time.Time{})
+		require.NoError(t, err)
+
+		for i := range list {
+			if strings.HasSuffix(list[i].Path, "/c.txt.pgp") {
+				err := client.DownloadFile(context.Background(), address, friend.Fingerprint(), "c.txt.pgp", &list[i])
+				var skipped *SkippedFile
+				require.ErrorAs(t, err, &skipped)
+				assert.ErrorIs(t, err, ErrFileTooLarge)
+			}
+		}
+	})
+}
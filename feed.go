@@ -0,0 +1,244 @@
+package mau
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+)
+
+// The feed is the timeline of the account: its own documents and the
+// documents of the friends it follows, newest first. files are ordered by the
+// creation time of their OpenPGP signature, which the author can't change
+// after signing, instead of their modification time which sync resets.
+// every file is verified once, its signature time and type are then kept in
+// the file index.
+
+var (
+	ErrUnknownAuthor = errors.New("Feed author isn't the account or a followed friend")
+)
+
+// FeedOptions selects a page of the feed
+type FeedOptions struct {
+	Before  time.Time     // documents signed before, zero for the newest
+	Limit   uint          // documents per page, zero for all
+	Types   []string      // Schema.org types to include, empty for all
+	Authors []Fingerprint // the account or followed friends, empty for all of them
+}
+
+// FeedItem is a verified document of the feed
+type FeedItem struct {
+	File    *File
+	Author  *Friend // the account itself for its own documents
+	Signed  time.Time
+	Content Content
+}
+
+type feedEntry struct {
+	file       *File
+	author     *Friend
+	signed     time.Time
+	schemaType string
+}
+
+// Feed returns a page of the feed newest first. the next page is the
+// documents signed before the last item. documents signed in the same second
+// as the last item are all in the page so paging doesn't skip any of them.
+// files that aren't JSON-LD documents or fail verification are left out.
+func (a *Account) Feed(opts FeedOptions) ([]*FeedItem, error) {
+	authors, err := a.feedAuthors(opts.Authors)
+	if err != nil {
+		return nil, err
+	}
+	defer a.index.save()
+
+	entries := []*feedEntry{}
+	for _, author := range authors {
+		entries = append(entries, a.feedEntries(author, opts)...)
+	}
+	sortFeed(entries)
+
+	return a.feedItems(feedPage(entries, opts.Limit)), nil
+}
+
+// feedAuthors returns the account and its follows, or the ones of
+// fingerprints
+func (a *Account) feedAuthors(fingerprints []Fingerprint) ([]*Friend, error) {
+	follows, err := a.ListFollows()
+	if err != nil {
+		return nil, err
+	}
+
+	all := append([]*Friend{{entity: a.entity}}, follows...)
+	if len(fingerprints) == 0 {
+		return all, nil
+	}
+
+	authors := []*Friend{}
+	for _, fpr := range fingerprints {
+		i := slices.IndexFunc(all, func(f *Friend) bool { return f.Fingerprint().Equal(fpr) })
+		if i < 0 {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownAuthor, fpr)
+		}
+		authors = append(authors, all[i])
+	}
+
+	return authors, nil
+}
+
+func (a *Account) feedEntries(author *Friend, opts FeedOptions) []*feedEntry {
+	dir := path.Join(a.path, author.Fingerprint().String())
+	files, err := a.storage.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	entries := []*feedEntry{}
+	for _, f := range files {
+		if !isDocumentFile(f) {
+			continue
+		}
+
+		entry := a.feedEntry(a.file(path.Join(dir, f.Name())), author)
+		if entry != nil && entry.matches(opts) {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries
+}
+
+// isDocumentFile is true for the files of a content directory that may be
+// documents, leaving out tombstones, attachments and hidden files
+func isDocumentFile(entry fs.DirEntry) bool {
+	return entry.Type().IsRegular() && path.Ext(entry.Name()) == ".pgp" && !strings.HasPrefix(entry.Name(), ".") && !isBlobName(entry.Name())
+}
+
+// feedEntry returns the signature time and type of the file from the index,
+// verifying the file when the index doesn't have them
+func (a *Account) feedEntry(file *File, author *Friend) *feedEntry {
+	meta, err := a.fileMeta(file)
+	if err != nil {
+		return nil
+	}
+
+	if meta.Signed.IsZero() {
+		data, signed, err := a.readSigned(file, author)
+		if err != nil {
+			slog.Warn("failed to verify feed file", "file", file.Path, "error", err)
+			return nil
+		}
+
+		meta = &fileMeta{Signed: signed, Type: documentType(data)}
+		a.index.sign(file.Path, meta.Signed, meta.Type)
+	}
+
+	return &feedEntry{file: file, author: author, signed: meta.Signed, schemaType: meta.Type}
+}
+
+func (e *feedEntry) matches(opts FeedOptions) bool {
+	if e.schemaType == "" {
+		return false
+	}
+	if !opts.Before.IsZero() && !e.signed.Before(opts.Before) {
+		return false
+	}
+
+	return len(opts.Types) == 0 || slices.Contains(opts.Types, e.schemaType)
+}
+
+// sortFeed orders entries newest first, by path for the same second
+func sortFeed(entries []*feedEntry) {
+	slices.SortFunc(entries, func(x, y *feedEntry) int {
+		if c := y.signed.Compare(x.signed); c != 0 {
+			return c
+		}
+		return strings.Compare(x.file.Path, y.file.Path)
+	})
+}
+
+// feedPage keeps the first limit entries and the ones signed in the same
+// second as the last of them
+func feedPage(entries []*feedEntry, limit uint) []*feedEntry {
+	if limit == 0 || uint(len(entries)) <= limit {
+		return entries
+	}
+
+	end := int(limit)
+	for end < len(entries) && entries[end].signed.Equal(entries[limit-1].signed) {
+		end++
+	}
+
+	return entries[:end]
+}
+
+func (a *Account) feedItems(entries []*feedEntry) []*FeedItem {
+	items := make([]*FeedItem, 0, len(entries))
+	for _, e := range entries {
+		data, signed, err := a.readSigned(e.file, e.author)
+		if err != nil {
+			slog.Warn("failed to verify feed file", "file", e.file.Path, "error", err)
+			continue
+		}
+
+		content, err := decodeContent(e.file.Name(), data)
+		if err != nil {
+			continue
+		}
+
+		items = append(items, &FeedItem{File: e.file, Author: e.author, Signed: signed, Content: content})
+	}
+
+	return items
+}
+
+// readSigned decrypts the file and checks it's signed by author, returning
+// its content and signature time
+func (a *Account) readSigned(file *File, author *Friend) ([]byte, time.Time, error) {
+	data, err := file.store().ReadFile(file.Path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	md, err := openpgp.ReadMessage(bytes.NewReader(data), openpgp.EntityList{a.entity, author.entity}, nil, nil)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to read OpenPGP message: %w", err)
+	}
+	if !md.IsSigned {
+		return nil, time.Time{}, errors.New("file is not signed")
+	}
+
+	body, err := io.ReadAll(md.UnverifiedBody)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to read message body: %w", err)
+	}
+	if md.SignatureError != nil {
+		return nil, time.Time{}, fmt.Errorf("invalid signature: %w", md.SignatureError)
+	}
+
+	if err := checkSignerIdentity(md, author.Fingerprint()); err != nil {
+		return nil, time.Time{}, err
+	}
+
+	return body, md.Signature.CreationTime, nil
+}
+
+// documentType returns the @type of a JSON-LD document, empty for other
+// content
+func documentType(data []byte) string {
+	doc := Document{}
+	if json.Unmarshal(data, &doc) != nil || validateDocument(doc) != nil {
+		return ""
+	}
+
+	return doc.SchemaType()
+}
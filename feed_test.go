@@ -0,0 +1,155 @@
+package mau
+
+import (
+	"bytes"
+	"encoding/json"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeSignedAt writes content signed by author at signed to the directory of
+// author in the account, with a modification time in the reverse order
+func writeSignedAt(t *testing.T, account, author *Account, name string, content Content, signed time.Time) {
+	writeSignedTo(t, account, author, nil, name, content, signed)
+}
+
+// writeSignedTo writes the file like writeSignedAt, encrypted to the others
+// too
+func writeSignedTo(t *testing.T, account, author *Account, others []*Account, name string, content Content, signed time.Time) {
+	storage := account.storage.(*MemoryStorage)
+	doc, err := contentDocument(content)
+	require.NoError(t, err)
+	data, err := json.Marshal(doc)
+	require.NoError(t, err)
+
+	p := path.Join(account.path, author.Fingerprint().String(), name+".pgp")
+	require.NoError(t, storage.MkdirAll(path.Dir(p)))
+	file, err := storage.Create(p)
+	require.NoError(t, err)
+
+	config := &packet.Config{Time: func() time.Time { return signed }}
+	to := openpgp.EntityList{account.entity}
+	for _, other := range others {
+		to = append(to, other.entity)
+	}
+	w, err := openpgp.Encrypt(file, to, author.entity, nil, config)
+	require.NoError(t, err)
+	_, err = w.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	require.NoError(t, file.Close())
+
+	// mirrored around now so newer files look older to the filesystem
+	require.NoError(t, storage.Chtimes(p, time.Now().Add(time.Since(signed))))
+}
+
+// accountSince creates an account with a key created at created, to sign
+// files in the past
+func accountSince(t *testing.T, name string, created time.Time) *Account {
+	entity, err := openpgp.NewEntity(name, "", name+"@example.com", &packet.Config{
+		Algorithm: packet.PubKeyAlgoEdDSA,
+		Time:      func() time.Time { return created },
+	})
+	require.NoError(t, err)
+
+	storage := NewMemoryStorage()
+	require.NoError(t, storage.MkdirAll(mauDir("/"+name)))
+	return buildAccount(storage, entity, "/"+name)
+}
+
+func TestFeed(t *testing.T) {
+	created := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	account := accountSince(t, "ahmed", created)
+	friendAccount := accountSince(t, "mohamed", created)
+	strangerAccount := accountSince(t, "omar", created)
+
+	friend := befriend(t, account, friendAccount)
+	require.NoError(t, account.Follow(friend))
+
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 12, 0, 0, 0, time.UTC) }
+	writeSignedAt(t, account, account, "first", &SocialMediaPosting{ArticleBody: "first"}, day(1))
+	writeSignedAt(t, account, friendAccount, "second", &SocialMediaPosting{ArticleBody: "second"}, day(2))
+	writeSignedAt(t, account, account, "third", &Comment{Text: "third"}, day(3))
+	writeSignedAt(t, account, friendAccount, "fourth", &LikeAction{Object: &Reference{ID: "first"}}, day(4))
+
+	// a file in the friend directory signed by someone else
+	forged := path.Join(account.path, friend.Fingerprint().String(), "forged.pgp")
+	writeSignedAt(t, account, strangerAccount, "forged", &SocialMediaPosting{ArticleBody: "forged"}, day(5))
+	require.NoError(t, account.storage.Rename(path.Join(account.path, strangerAccount.Fingerprint().String(), "forged.pgp"), forged))
+
+	_, err := account.AddFile(bytes.NewBufferString("not a document"), "notes.txt", nil)
+	require.NoError(t, err)
+
+	names := func(items []*FeedItem) []string {
+		list := []string{}
+		for _, item := range items {
+			list = append(list, item.File.Name())
+		}
+		return list
+	}
+
+	t.Run("Orders verified documents by signature time", func(t T) {
+		items, err := account.Feed(FeedOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"fourth.pgp", "third.pgp", "second.pgp", "first.pgp"}, names(items))
+
+		assert.Equal(t, day(4), items[0].Signed.UTC())
+		assert.Equal(t, friend.Fingerprint(), items[0].Author.Fingerprint())
+		assert.Equal(t, account.Fingerprint(), items[1].Author.Fingerprint())
+		assert.IsType(t, &LikeAction{}, items[0].Content)
+		assert.Equal(t, "third", items[1].Content.(*Comment).Text)
+	})
+
+	t.Run("Keeps signature times in the index", func(t T) {
+		meta, err := account.index.meta(path.Join(account.path, account.Fingerprint().String(), "third.pgp"))
+		require.NoError(t, err)
+		assert.Equal(t, day(3), meta.Signed.UTC())
+		assert.Equal(t, "Comment", meta.Type)
+	})
+
+	t.Run("Doesn't save decrypted metadata in the plaintext index", func(t T) {
+		account.index.save()
+		data, err := account.storage.ReadFile(indexFile(account.path))
+		require.NoError(t, err)
+		assert.NotContains(t, string(data), "Comment")
+		assert.NotContains(t, string(data), `"signed"`)
+		assert.NotContains(t, string(data), `"type"`)
+	})
+
+	t.Run("Paginates", func(t T) {
+		page, err := account.Feed(FeedOptions{Limit: 3})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"fourth.pgp", "third.pgp", "second.pgp"}, names(page))
+
+		next, err := account.Feed(FeedOptions{Limit: 3, Before: page[len(page)-1].Signed})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"first.pgp"}, names(next))
+	})
+
+	t.Run("Keeps documents signed in the same second in one page", func(t T) {
+		entries := []*feedEntry{{signed: day(2)}, {signed: day(1)}, {signed: day(1)}, {signed: day(0)}}
+		assert.Len(t, feedPage(entries, 2), 3)
+		assert.Len(t, feedPage(entries, 0), 4)
+	})
+
+	t.Run("Filters by type", func(t T) {
+		items, err := account.Feed(FeedOptions{Types: []string{"SocialMediaPosting", "Comment"}})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"third.pgp", "second.pgp", "first.pgp"}, names(items))
+	})
+
+	t.Run("Filters by author", func(t T) {
+		items, err := account.Feed(FeedOptions{Authors: []Fingerprint{friend.Fingerprint()}})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"fourth.pgp", "second.pgp"}, names(items))
+
+		_, err = account.Feed(FeedOptions{Authors: []Fingerprint{strangerAccount.Fingerprint()}})
+		assert.ErrorIs(t, err, ErrUnknownAuthor)
+	})
+}
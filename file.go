@@ -6,7 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"os"
+	"io/fs"
 	"path"
 	"path/filepath"
 	"strings"
@@ -52,9 +52,32 @@ func validateFileName(name string) error {
 	return nil
 }
 
+// isHiddenFileName reports whether the name is a dotfile or a temporary file,
+// never listed or served as content
+func isHiddenFileName(name string) bool {
+	return strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".tmp")
+}
+
+// isTempFileName reports whether the name is one of the temporary files
+// DiskStorage writes before renaming them over the file
+func isTempFileName(name string) bool {
+	return strings.HasPrefix(name, ".") && strings.HasSuffix(name, ".tmp")
+}
+
 type File struct {
 	Path    string
 	version bool
+	storage Storage
+}
+
+// store returns the storage of the file, files created without an account
+// are on disk
+func (f *File) store() Storage {
+	if f.storage == nil {
+		return DiskStorage{}
+	}
+
+	return f.storage
 }
 
 func (f *File) Name() string {
@@ -70,11 +93,7 @@ func (f *File) Versions() []*File {
 	}
 
 	vPath := f.Path + ".versions"
-	if _, err := os.Stat(vPath); err != nil {
-		return []*File{}
-	}
-
-	entries, err := os.ReadDir(vPath)
+	entries, err := f.store().ReadDir(vPath)
 	if err != nil {
 		return []*File{}
 	}
@@ -82,13 +101,14 @@ func (f *File) Versions() []*File {
 	return f.collectVersionFiles(vPath, entries)
 }
 
-func (f *File) collectVersionFiles(vPath string, entries []os.DirEntry) []*File {
+func (f *File) collectVersionFiles(vPath string, entries []fs.DirEntry) []*File {
 	versions := []*File{}
 	for _, entry := range entries {
 		if !entry.IsDir() {
 			versions = append(versions, &File{
 				Path:    path.Join(vPath, entry.Name()),
 				version: true,
+				storage: f.storage,
 			})
 		}
 	}
@@ -160,7 +180,7 @@ func checkSignerIdentity(md *openpgp.MessageDetails, expectedSigner Fingerprint)
 
 // VerifySignature verifies that a file was signed by the expected peer and is encrypted for the account
 func (f *File) VerifySignature(account *Account, expectedSigner Fingerprint) error {
-	data, err := os.ReadFile(f.Path)
+	data, err := f.store().ReadFile(f.Path)
 	if err != nil {
 		return fmt.Errorf("failed to read file for verification: %w", err)
 	}
@@ -223,33 +243,17 @@ func (f *File) Recipients(account *Account) ([]*Friend, error) {
 		return nil, errors.New("account cannot be nil")
 	}
 
-	r, keyring, err := f.openAndGetKeyring(account)
+	meta, err := account.fileMeta(f)
 	if err != nil {
 		return nil, err
 	}
-	defer func() { _ = r.Close() }()
 
-	keysIDs, err := extractEncryptedKeyIDs(r)
+	keyring, err := account.ListFriends()
 	if err != nil {
 		return nil, err
 	}
 
-	return matchKeyringToKeyIDs(keyring, keysIDs), nil
-}
-
-func (f *File) openAndGetKeyring(account *Account) (io.ReadCloser, *Keyring, error) {
-	r, err := os.Open(f.Path)
-	if err != nil {
-		return nil, nil, err
-	}
-
-	k, err := account.ListFriends()
-	if err != nil {
-		r.Close()
-		return nil, nil, err
-	}
-
-	return r, k, nil
+	return matchKeyringToKeyIDs(keyring, meta.KeyIDs), nil
 }
 
 func matchKeyringToKeyIDs(keyring *Keyring, keyIDs []uint64) []*Friend {
@@ -264,7 +268,7 @@ func (f *File) Reader(account *Account) (io.Reader, error) {
 		return nil, errors.New("account cannot be nil")
 	}
 
-	r, err := os.ReadFile(f.Path)
+	r, err := f.store().ReadFile(f.Path)
 	if err != nil {
 		return nil, err
 	}
@@ -286,13 +290,7 @@ func (f *File) decryptFileContent(data []byte, account *Account) (io.Reader, err
 }
 
 func (f *File) Hash() (string, error) {
-	r, err := os.Open(f.Path)
-	if err != nil {
-		return "", err
-	}
-
-	content, err := io.ReadAll(r)
-	r.Close()
+	content, err := f.store().ReadFile(f.Path)
 	if err != nil {
 		return "", err
 	}
@@ -303,7 +301,7 @@ func (f *File) Hash() (string, error) {
 }
 
 func (f *File) Size() (int64, error) {
-	info, err := os.Stat(f.Path)
+	info, err := f.store().Stat(f.Path)
 	if err != nil {
 		return 0, err
 	}
@@ -312,8 +310,8 @@ func (f *File) Size() (int64, error) {
 }
 
 func (f *File) Deleted() bool {
-	_, err := os.Stat(f.Path)
-	return os.IsNotExist(err)
+	_, err := f.store().Stat(f.Path)
+	return errors.Is(err, fs.ErrNotExist)
 }
 
 func (a *Account) GetFileVersion(fpr Fingerprint, name, version string) (*File, error) {
@@ -329,7 +327,7 @@ func (a *Account) GetFileVersion(fpr Fingerprint, name, version string) (*File,
 		return nil, err
 	}
 
-	return &File{Path: filepath, version: true}, nil
+	return &File{Path: filepath, version: true, storage: a.storage}, nil
 }
 
 func (a *Account) GetFile(fpr Fingerprint, name string) (*File, error) {
@@ -342,5 +340,5 @@ func (a *Account) GetFile(fpr Fingerprint, name string) (*File, error) {
 		return nil, err
 	}
 
-	return &File{Path: filepath}, nil
+	return a.file(filepath), nil
 }
@@ -0,0 +1,208 @@
+package mau
+
+import (
+	"context"
+	"io/fs"
+	"path"
+	"sync"
+	"time"
+)
+
+// File event types
+const (
+	FileCreated = "created"
+	FileChanged = "changed"
+	FileDeleted = "deleted"
+)
+
+// FileEvent is a change of a file in the directory of a fingerprint. deleted
+// files have no size or sum.
+type FileEvent struct {
+	Type string `json:"type"`
+	FileListItem
+}
+
+// fileChange is a file event with the recipients of the file, recipients of
+// deleted files are the ones they had before deletion
+type fileChange struct {
+	event      FileEvent
+	recipients []*Friend
+}
+
+// fileEvents sends subscribers the changes of the directories they watch, a
+// directory is watched while it has subscribers
+type fileEvents struct {
+	account *Account
+	mutex   sync.Mutex
+	watches map[string]*dirWatch // key: fingerprint hex string
+}
+
+type dirWatch struct {
+	subscribers map[chan *fileChange]struct{}
+	stop        context.CancelFunc
+}
+
+// fileState is what a scan knows about a file, recipients are read again
+// only when the file size or modification time changes
+type fileState struct {
+	file       *File
+	size       int64
+	modified   time.Time
+	recipients []*Friend
+}
+
+func newFileEvents(account *Account) *fileEvents {
+	return &fileEvents{account: account, watches: map[string]*dirWatch{}}
+}
+
+// subscribe returns the changes of the fingerprint directory until ctx is
+// done. changes are dropped for subscribers that don't keep up.
+func (e *fileEvents) subscribe(ctx context.Context, fpr Fingerprint) <-chan *fileChange {
+	changes := make(chan *fileChange, fileEventsBufferSize)
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	watch, ok := e.watches[fpr.String()]
+	if !ok {
+		var scanCtx context.Context
+		watch = &dirWatch{subscribers: map[chan *fileChange]struct{}{}}
+		scanCtx, watch.stop = context.WithCancel(context.Background())
+		e.watches[fpr.String()] = watch
+		go e.scan(scanCtx, fpr, watch)
+	}
+	watch.subscribers[changes] = struct{}{}
+
+	context.AfterFunc(ctx, func() { e.unsubscribe(fpr, watch, changes) })
+
+	return changes
+}
+
+func (e *fileEvents) unsubscribe(fpr Fingerprint, watch *dirWatch, changes chan *fileChange) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	delete(watch.subscribers, changes)
+	close(changes)
+
+	if len(watch.subscribers) == 0 {
+		watch.stop()
+		delete(e.watches, fpr.String())
+	}
+}
+
+func (e *fileEvents) broadcast(watch *dirWatch, change *fileChange) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	for s := range watch.subscribers {
+		select {
+		case s <- change:
+		default:
+		}
+	}
+}
+
+// scan compares the directory with its previous state when the account
+// watcher reports a change of its files, the first state is the one at
+// subscription time
+func (e *fileEvents) scan(ctx context.Context, fpr Fingerprint, watch *dirWatch) {
+	events := e.account.Watch(ctx)
+	files := e.dirState(fpr, nil)
+
+	for event := range events {
+		if event.Name == "" || !event.Fingerprint.Equal(fpr) {
+			continue
+		}
+
+		next := e.dirState(fpr, files)
+		for _, change := range diffDirState(fpr, files, next) {
+			e.broadcast(watch, change)
+		}
+		files = next
+	}
+}
+
+// dirState returns the state of the content files in the fingerprint
+// directory, reusing the previous state of unchanged files
+func (e *fileEvents) dirState(fpr Fingerprint, previous map[string]*fileState) map[string]*fileState {
+	state := map[string]*fileState{}
+
+	dir, err := e.account.resolveFriendPath(fpr, "")
+	if err != nil {
+		return state
+	}
+
+	entries, err := e.account.storage.ReadDir(dir)
+	if err != nil && previous != nil {
+		return previous
+	}
+
+	for _, entry := range entries {
+		if s := e.fileStateOf(dir, entry, previous[entry.Name()]); s != nil {
+			state[entry.Name()] = s
+		}
+	}
+
+	return state
+}
+
+func (e *fileEvents) fileStateOf(dir string, entry fs.DirEntry, previous *fileState) *fileState {
+	info, err := entry.Info()
+	if err != nil || !info.Mode().IsRegular() || !isContentFileName(entry.Name()) {
+		return nil
+	}
+
+	s := &fileState{file: e.account.file(path.Join(dir, entry.Name())), size: info.Size(), modified: info.ModTime()}
+	if previous.same(s) {
+		return previous
+	}
+
+	s.recipients, _ = s.file.Recipients(e.account)
+	return s
+}
+
+func (s *fileState) same(other *fileState) bool {
+	return s != nil && s.size == other.size && s.modified.Equal(other.modified)
+}
+
+func diffDirState(fpr Fingerprint, before, after map[string]*fileState) []*fileChange {
+	changes := []*fileChange{}
+
+	for name, s := range after {
+		if old, ok := before[name]; !ok {
+			changes = append(changes, newFileChange(fpr, name, FileCreated, s))
+		} else if old != s {
+			changes = append(changes, newFileChange(fpr, name, FileChanged, s))
+		}
+	}
+
+	for name, s := range before {
+		if _, ok := after[name]; !ok {
+			changes = append(changes, &fileChange{
+				event:      FileEvent{Type: FileDeleted, FileListItem: FileListItem{Path: fileListPath(fpr, name)}},
+				recipients: s.recipients,
+			})
+		}
+	}
+
+	return changes
+}
+
+func newFileChange(fpr Fingerprint, name, kind string, s *fileState) *fileChange {
+	sum, _ := s.file.Hash()
+
+	return &fileChange{
+		event: FileEvent{Type: kind, FileListItem: FileListItem{
+			Path:    fileListPath(fpr, name),
+			Size:    s.size,
+			Sum:     sum,
+			Deleted: isTombstoneName(name),
+		}},
+		recipients: s.recipients,
+	}
+}
+
+func fileListPath(fpr Fingerprint, name string) string {
+	return "/p2p/" + fpr.String() + "/" + name
+}
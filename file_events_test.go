@@ -0,0 +1,105 @@
+package mau
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func nextFileChange(t *testing.T, changes <-chan *fileChange) *fileChange {
+	select {
+	case change := <-changes:
+		return change
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "no file change received")
+		return nil
+	}
+}
+
+func TestFileEvents(t *testing.T) {
+	friend, err := NewAccount(t.TempDir(), "Mohamed Mahmoud", "mohamed@example.com", "password")
+	require.NoError(t, err)
+	account, err := NewAccount(t.TempDir(), "Ahmed Mohamed", "ahmed@example.com", "password")
+	require.NoError(t, err)
+	aFriend := befriend(t, account, friend)
+
+	_, err = account.AddFile(strings.NewReader("Before subscribing"), "before.txt", nil)
+	require.NoError(t, err)
+
+	events := newFileEvents(account)
+	ctx, cancel := context.WithCancel(context.Background())
+	changes := events.subscribe(ctx, account.Fingerprint())
+	time.Sleep(100 * time.Millisecond) // let the first scan record the existing files
+
+	t.Run("Sends created files with their recipients", func(t T) {
+		file, err := account.AddFile(strings.NewReader("Hello"), "hello.txt", []*Friend{aFriend})
+		require.NoError(t, err)
+
+		change := nextFileChange(t, changes)
+		assert.Equal(t, FileCreated, change.event.Type)
+		assert.Equal(t, fileListPath(account.Fingerprint(), "hello.txt.pgp"), change.event.Path)
+
+		size, err := file.Size()
+		require.NoError(t, err)
+		sum, err := file.Hash()
+		require.NoError(t, err)
+		assert.Equal(t, size, change.event.Size)
+		assert.Equal(t, sum, change.event.Sum)
+
+		require.Len(t, change.recipients, 1)
+		assert.Equal(t, friend.Fingerprint(), change.recipients[0].Fingerprint())
+	})
+
+	t.Run("Sends changed files", func(t T) {
+		time.Sleep(10 * time.Millisecond) // a different modification time
+		_, err := account.AddFile(strings.NewReader("Hello again"), "hello.txt", []*Friend{aFriend})
+		require.NoError(t, err)
+
+		change := nextFileChange(t, changes)
+		assert.Equal(t, FileChanged, change.event.Type)
+		assert.Equal(t, fileListPath(account.Fingerprint(), "hello.txt.pgp"), change.event.Path)
+	})
+
+	t.Run("Sends deleted files with their previous recipients and their tombstones", func(t T) {
+		file, err := account.GetFile(account.Fingerprint(), "hello.txt.pgp")
+		require.NoError(t, err)
+		require.NoError(t, account.RemoveFile(file))
+
+		received := map[string]*fileChange{}
+		for range 2 {
+			change := nextFileChange(t, changes)
+			received[change.event.Type] = change
+		}
+
+		deleted := received[FileDeleted]
+		require.NotNil(t, deleted)
+		assert.Equal(t, fileListPath(account.Fingerprint(), "hello.txt.pgp"), deleted.event.Path)
+		assert.Empty(t, deleted.event.Sum)
+		require.Len(t, deleted.recipients, 1)
+		assert.Equal(t, friend.Fingerprint(), deleted.recipients[0].Fingerprint())
+
+		tombstone := received[FileCreated]
+		require.NotNil(t, tombstone)
+		assert.Equal(t, fileListPath(account.Fingerprint(), "hello.txt.pgp.tombstone"), tombstone.event.Path)
+		assert.True(t, tombstone.event.Deleted)
+		require.Len(t, tombstone.recipients, 1)
+		assert.Equal(t, friend.Fingerprint(), tombstone.recipients[0].Fingerprint())
+	})
+
+	t.Run("Stops scanning when there are no subscribers", func(t T) {
+		cancel()
+
+		assert.Eventually(t, func() bool {
+			events.mutex.Lock()
+			defer events.mutex.Unlock()
+			return len(events.watches) == 0
+		}, time.Second, 10*time.Millisecond)
+
+		_, ok := <-changes
+		assert.False(t, ok)
+	})
+}
@@ -0,0 +1,234 @@
+package mau
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fileMeta is what listing a file needs without reading it: its size, hash
+// and the key IDs it's encrypted to. it's valid as long as the file size and
+// modification time didn't change.
+type fileMeta struct {
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+	Sum      string    `json:"sum"`
+	KeyIDs   []uint64  `json:"key_ids"`
+
+	// set once the feed verified the file. they come from the decrypted
+	// content so they're kept in memory only, index.json is plaintext
+	Signed time.Time `json:"-"` // signature time
+	Type   string    `json:"-"` // Schema.org @type, empty for other files
+}
+
+// fileIndex keeps the metadata of the account files in .mau/index.json so
+// listing doesn't read every file on every request
+type fileIndex struct {
+	mutex   sync.Mutex
+	storage Storage
+	root    string
+	entries map[string]*fileMeta // key: file path relative to the account root
+	loaded  bool
+	dirty   bool
+}
+
+func indexFile(d string) string { return path.Join(mauDir(d), indexFilename) }
+
+func newFileIndex(storage Storage, root string) *fileIndex {
+	return &fileIndex{storage: storage, root: root, entries: map[string]*fileMeta{}}
+}
+
+func (i *fileIndex) key(p string) string {
+	return strings.TrimPrefix(p, i.root+"/")
+}
+
+// load reads the index on first use, a missing or corrupted index starts
+// empty and is rebuilt as files are listed
+func (i *fileIndex) load() {
+	if i.loaded {
+		return
+	}
+	i.loaded = true
+
+	data, err := i.storage.ReadFile(indexFile(i.root))
+	if err != nil {
+		return
+	}
+
+	if err := json.Unmarshal(data, &i.entries); err != nil || i.entries == nil {
+		i.entries = map[string]*fileMeta{}
+	}
+}
+
+// meta returns the metadata of the file at p, read from the file when the
+// index doesn't have it or it changed since
+func (i *fileIndex) meta(p string) (*fileMeta, error) {
+	info, err := i.storage.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+
+	i.mutex.Lock()
+	entry := i.lookup(p, info)
+	i.mutex.Unlock()
+	if entry != nil {
+		return entry, nil
+	}
+
+	data, err := i.storage.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+
+	return i.put(p, info, data), nil
+}
+
+func (i *fileIndex) lookup(p string, info fs.FileInfo) *fileMeta {
+	i.load()
+
+	entry, ok := i.entries[i.key(p)]
+	if !ok || entry.Size != info.Size() || !entry.Modified.Equal(info.ModTime()) {
+		return nil
+	}
+
+	return entry
+}
+
+// update records the content just written to p
+func (i *fileIndex) update(p string, data []byte) error {
+	info, err := i.storage.Stat(p)
+	if err != nil {
+		return err
+	}
+
+	i.put(p, info, data)
+	return nil
+}
+
+func (i *fileIndex) put(p string, info fs.FileInfo, data []byte) *fileMeta {
+	keyIDs, _ := extractEncryptedKeyIDs(bytes.NewReader(data))
+	entry := &fileMeta{
+		Size:     info.Size(),
+		Modified: info.ModTime(),
+		Sum:      fmt.Sprintf("%x", sha256.Sum256(data)),
+		KeyIDs:   keyIDs,
+	}
+
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	i.load()
+	i.entries[i.key(p)] = entry
+	i.dirty = true
+
+	return entry
+}
+
+// sign records the signature time and type of the verified file at p, they
+// stay valid as long as its entry
+func (i *fileIndex) sign(p string, signed time.Time, schemaType string) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	i.load()
+	entry, ok := i.entries[i.key(p)]
+	if !ok {
+		return
+	}
+
+	verified := *entry
+	verified.Signed, verified.Type = signed, schemaType
+	i.entries[i.key(p)] = &verified
+	i.dirty = true
+}
+
+// move keeps the entry of a renamed file, renames keep the modification time
+func (i *fileIndex) move(oldPath, newPath string) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	i.load()
+	if entry, ok := i.entries[i.key(oldPath)]; ok {
+		i.entries[i.key(newPath)] = entry
+		delete(i.entries, i.key(oldPath))
+		i.dirty = true
+	}
+}
+
+func (i *fileIndex) forget(p string) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	i.load()
+	if _, ok := i.entries[i.key(p)]; ok {
+		delete(i.entries, i.key(p))
+		i.dirty = true
+	}
+}
+
+// retain forgets the entries of files of dir that aren't in names anymore
+func (i *fileIndex) retain(dir string, names map[string]bool) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	i.load()
+	for k := range i.entries {
+		if path.Dir(k) == i.key(dir) && !names[path.Base(k)] {
+			delete(i.entries, k)
+			i.dirty = true
+		}
+	}
+}
+
+// save writes the index if it changed since it was read
+func (i *fileIndex) save() {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	if !i.dirty {
+		return
+	}
+
+	data, err := json.Marshal(i.entries)
+	if err == nil {
+		err = i.storage.WriteFile(indexFile(i.root), data)
+	}
+	if err != nil {
+		slog.Warn("failed to save file index", "error", err)
+		return
+	}
+
+	i.dirty = false
+}
+
+// fileMeta returns the size, hash and key IDs of a file of the account
+func (a *Account) fileMeta(f *File) (*fileMeta, error) {
+	return a.index.meta(f.Path)
+}
+
+// reindex replaces the index entry of a file the account just wrote
+func (a *Account) reindex(p string) {
+	a.index.forget(p)
+	if _, err := a.index.meta(p); err != nil {
+		slog.Warn("failed to index file", "file", p, "error", err)
+	}
+
+	a.index.save()
+}
+
+// indexDownload records a file the client just saved from data
+func (c *Client) indexDownload(f *File, data []byte) error {
+	if err := c.account.index.update(f.Path, data); err != nil {
+		return fmt.Errorf("failed to index %s: %w", f.Name(), err)
+	}
+
+	c.account.index.save()
+	return nil
+}
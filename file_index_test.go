@@ -0,0 +1,94 @@
+package mau
+
+import (
+	"bytes"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileIndex(t *testing.T) {
+	storage := NewMemoryStorage()
+	account, err := NewAccountWithStorage(storage, "/ahmed", "Ahmed Mohamed", "ahmed@example.com", "password")
+	require.NoError(t, err)
+	friend, err := NewAccountWithStorage(NewMemoryStorage(), "/mohamed", "Mohamed Mahmoud", "mohamed@example.com", "password")
+	require.NoError(t, err)
+	aFriend := befriend(t, account, friend)
+
+	file, err := account.AddFile(bytes.NewBufferString("hello"), "hello.txt", []*Friend{aFriend})
+	require.NoError(t, err)
+	key := account.index.key(file.Path)
+
+	t.Run("Indexes files on write", func(t T) {
+		assert.True(t, exists(storage, indexFile("/ahmed")))
+
+		meta, err := account.fileMeta(file)
+		require.NoError(t, err)
+		hash, err := file.Hash()
+		require.NoError(t, err)
+		size, err := file.Size()
+		require.NoError(t, err)
+
+		assert.Equal(t, hash, meta.Sum)
+		assert.Equal(t, size, meta.Size)
+		assert.Len(t, meta.KeyIDs, 2)
+
+		recipients, err := file.Recipients(account)
+		require.NoError(t, err)
+		require.Len(t, recipients, 1)
+		assert.Equal(t, friend.Fingerprint(), recipients[0].Fingerprint())
+	})
+
+	t.Run("Uses the index while the file is unchanged", func(t T) {
+		account.index.entries[key].Sum = "cached"
+		meta, err := account.fileMeta(file)
+		require.NoError(t, err)
+		assert.Equal(t, "cached", meta.Sum)
+
+		require.NoError(t, storage.Chtimes(file.Path, time.Now().Add(time.Minute)))
+		meta, err = account.fileMeta(file)
+		require.NoError(t, err)
+		assert.NotEqual(t, "cached", meta.Sum)
+	})
+
+	t.Run("Reloads the saved index", func(t T) {
+		account.index.save()
+		opened, err := OpenAccountWithStorage(storage, "/ahmed", "password")
+		require.NoError(t, err)
+
+		opened.index.mutex.Lock()
+		opened.index.load()
+		entry := opened.index.entries[key]
+		opened.index.mutex.Unlock()
+
+		require.NotNil(t, entry)
+		assert.Equal(t, account.index.entries[key].Sum, entry.Sum)
+	})
+
+	t.Run("Moves the entry of replaced files to their version", func(t T) {
+		hash, err := file.Hash()
+		require.NoError(t, err)
+
+		_, err = account.AddFile(bytes.NewBufferString("hello again"), "hello.txt", nil)
+		require.NoError(t, err)
+
+		version := account.index.entries[account.index.key(path.Join(file.Path+".versions", hash))]
+		require.NotNil(t, version)
+		assert.Equal(t, hash, version.Sum)
+	})
+
+	t.Run("Forgets removed files", func(t T) {
+		other, err := account.AddFile(bytes.NewBufferString("other"), "other.txt", nil)
+		require.NoError(t, err)
+
+		require.NoError(t, account.RemoveFile(file))
+		assert.NotContains(t, account.index.entries, key)
+
+		require.NoError(t, storage.Remove(other.Path))
+		account.ListFiles(account.Fingerprint(), time.Time{}, 0)
+		assert.NotContains(t, account.index.entries, account.index.key(other.Path))
+	})
+}
@@ -7,6 +7,7 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/ProtonMail/go-crypto/openpgp/packet"
 )
@@ -121,3 +122,18 @@ func certToAddress(certs []*x509.Certificate) (string, error) {
 
 	return "", ErrCantFindAddress
 }
+
+// certHintedAddresses returns the other addresses in the certificate names,
+// the ones with a transport hint
+func certHintedAddresses(certs []*x509.Certificate) []string {
+	var addresses []string
+	for _, cert := range certs {
+		for _, name := range cert.DNSNames[min(1, len(cert.DNSNames)):] {
+			if strings.Contains(name, "://") {
+				addresses = append(addresses, name)
+			}
+		}
+	}
+
+	return addresses
+}
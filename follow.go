@@ -2,12 +2,12 @@ package mau
 
 import (
 	"fmt"
-	"os"
+	"io/fs"
 	"path"
 )
 
 func (a *Account) ListFollows() ([]*Friend, error) {
-	files, err := os.ReadDir(a.path)
+	files, err := a.storage.ReadDir(a.path)
 	if err != nil {
 		return nil, err
 	}
@@ -20,7 +20,7 @@ func (a *Account) ListFollows() ([]*Friend, error) {
 	return a.collectFollowedFriends(files, keyring), nil
 }
 
-func (a *Account) collectFollowedFriends(files []os.DirEntry, keyring *Keyring) []*Friend {
+func (a *Account) collectFollowedFriends(files []fs.DirEntry, keyring *Keyring) []*Friend {
 	follows := []*Friend{}
 	for _, file := range files {
 		if file.IsDir() && file.Name()[0] != '.' {
@@ -45,15 +45,15 @@ func (a *Account) Follow(friend *Friend) error {
 	unfollowed := path.Join(a.path, "."+fpr)
 	followed := path.Join(a.path, fpr)
 
-	if _, err := os.Stat(followed); err == nil {
+	if exists(a.storage, followed) {
 		return nil
 	}
 
-	if _, err := os.Stat(unfollowed); err == nil {
-		return os.Rename(unfollowed, followed)
+	if exists(a.storage, unfollowed) {
+		return a.storage.Rename(unfollowed, followed)
 	}
 
-	return os.Mkdir(followed, DirPerm)
+	return a.storage.MkdirAll(followed)
 }
 
 func (a *Account) Unfollow(friend *Friend) error {
@@ -61,8 +61,8 @@ func (a *Account) Unfollow(friend *Friend) error {
 	unfollowed := path.Join(a.path, "."+fpr)
 	followed := path.Join(a.path, fpr)
 
-	if _, err := os.Stat(followed); err == nil {
-		return os.Rename(followed, unfollowed)
+	if exists(a.storage, followed) {
+		return a.storage.Rename(followed, unfollowed)
 	}
 
 	return nil
@@ -3,9 +3,7 @@ package mau
 import (
 	"fmt"
 	"io"
-	"os"
 	"path"
-	"path/filepath"
 
 	"github.com/ProtonMail/go-crypto/openpgp"
 
@@ -84,9 +82,10 @@ func (a *Account) AddFriend(reader io.Reader) (*Friend, error) {
 	}
 
 	fpr := Fingerprint(entity.PrimaryKey.Fingerprint).String()
-	if err := a.saveFriendEntity(fpr, entity); err != nil {
+	if err := a.saveFriendEntity(mauDir(a.path), fpr, entity); err != nil {
 		return nil, err
 	}
+	a.keyring.invalidate()
 
 	return &Friend{entity: entity}, nil
 }
@@ -109,9 +108,9 @@ func readAndValidateEntity(reader io.Reader) (*openpgp.Entity, error) {
 	return entity, nil
 }
 
-func (a *Account) saveFriendEntity(fpr string, entity *openpgp.Entity) (err error) {
-	filePath := path.Join(mauDir(a.path), fpr+".pgp")
-	file, err := os.Create(filePath)
+func (a *Account) saveFriendEntity(dir, fpr string, entity *openpgp.Entity) (err error) {
+	filePath := path.Join(dir, fpr+".pgp")
+	file, err := a.storage.Create(filePath)
 	if err != nil {
 		return err
 	}
@@ -124,7 +123,7 @@ func (a *Account) saveFriendEntity(fpr string, entity *openpgp.Entity) (err erro
 	return a.encryptAndSerializeEntity(file, entity)
 }
 
-func (a *Account) encryptAndSerializeEntity(file *os.File, entity *openpgp.Entity) error {
+func (a *Account) encryptAndSerializeEntity(file io.Writer, entity *openpgp.Entity) error {
 	entities := []*openpgp.Entity{a.entity}
 	w, err := openpgp.Encrypt(file, entities, a.entity, nil, nil)
 	if err != nil {
@@ -151,6 +150,7 @@ func (a *Account) RemoveFriend(friend *Friend) error {
 	if err := a.removeFriendFiles(matches); err != nil {
 		return err
 	}
+	a.keyring.invalidate()
 
 	return a.Unfollow(friend)
 }
@@ -158,14 +158,21 @@ func (a *Account) RemoveFriend(friend *Friend) error {
 func (a *Account) findFriendFiles(friend *Friend) ([]string, error) {
 	file := fmt.Sprintf("%s.pgp", friend.Fingerprint())
 	uncategorized := path.Join(mauDir(a.path), file)
-	pattern := path.Join(mauDir(a.path), "**", file)
 
-	matches, err := filepath.Glob(pattern)
+	entries, err := a.storage.ReadDir(mauDir(a.path))
 	if err != nil {
 		return nil, err
 	}
 
-	if _, err := os.Stat(uncategorized); err == nil {
+	matches := []string{}
+	for _, entry := range entries {
+		categorized := path.Join(mauDir(a.path), entry.Name(), file)
+		if entry.IsDir() && exists(a.storage, categorized) {
+			matches = append(matches, categorized)
+		}
+	}
+
+	if exists(a.storage, uncategorized) {
 		matches = append(matches, uncategorized)
 	}
 
@@ -174,20 +181,26 @@ func (a *Account) findFriendFiles(friend *Friend) ([]string, error) {
 
 func (a *Account) removeFriendFiles(matches []string) error {
 	for _, match := range matches {
-		if err := os.Remove(match); err != nil {
+		if err := a.storage.Remove(match); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// ListFriends returns the keyring of the account, decrypted once and cached
+// until it changes. the keyring is shared by callers and shouldn't be
+// modified.
 func (a *Account) ListFriends() (*Keyring, error) {
-	friends := Keyring{Path: mauDir(a.path)}
+	return a.keyring.get(a)
+}
 
-	err := friends.read(a)
-	if err != nil {
-		return nil, err
+// entityKeyIDs returns the IDs of the primary key and subkeys of e
+func entityKeyIDs(e *openpgp.Entity) []uint64 {
+	ids := []uint64{e.PrimaryKey.KeyId}
+	for _, sk := range e.Subkeys {
+		ids = append(ids, sk.PublicKey.KeyId)
 	}
 
-	return &friends, nil
+	return ids
 }
@@ -0,0 +1,239 @@
+package mau
+
+import (
+	"errors"
+	"path"
+	"slices"
+	"strings"
+)
+
+// Groups are the sub keyrings of the account: directories under .mau holding
+// the keys of their members, like .mau/coworkers/<FPR>.pgp. a friend in no
+// group has its key in .mau itself, a friend in several groups has a copy of
+// its key in each of them.
+
+var (
+	ErrInvalidGroupName = errors.New("Invalid group name")
+	ErrGroupExists      = errors.New("Group already exists")
+	ErrGroupNotFound    = errors.New("Group not found")
+	ErrFriendNotInGroup = errors.New("Friend isn't a member of the group")
+)
+
+// reservedGroupNames are the files the account keeps in .mau next to groups
+var reservedGroupNames = []string{
+	publicKeyFilename,
+	indexFilename,
+	retentionFilename,
+	syncStateFilename,
+	chatStateFilename,
+	searchFilename,
+	agentSocketName,
+}
+
+func validateGroupName(name string) error {
+	if validateFileName(name) != nil || strings.HasPrefix(name, ".") || slices.Contains([]string{".pgp", ".tmp"}, path.Ext(name)) {
+		return ErrInvalidGroupName
+	}
+
+	if slices.Contains(reservedGroupNames, name) {
+		return ErrInvalidGroupName
+	}
+
+	return nil
+}
+
+func (a *Account) groupDir(name string) string { return path.Join(mauDir(a.path), name) }
+
+func friendKeyFile(dir string, friend *Friend) string {
+	return path.Join(dir, friend.Fingerprint().String()+".pgp")
+}
+
+// Groups returns the keyrings of the account groups
+func (a *Account) Groups() ([]*Keyring, error) {
+	keyring, err := a.ListFriends()
+	if err != nil {
+		return nil, err
+	}
+
+	return keyring.SubKeyrings, nil
+}
+
+// Group returns the keyring of the group, with the keyrings nested in it
+func (a *Account) Group(name string) (*Keyring, error) {
+	if err := validateGroupName(name); err != nil {
+		return nil, err
+	}
+
+	groups, err := a.Groups()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, g := range groups {
+		if g.Name() == name {
+			return g, nil
+		}
+	}
+
+	return nil, ErrGroupNotFound
+}
+
+func (a *Account) CreateGroup(name string) error {
+	if err := validateGroupName(name); err != nil {
+		return err
+	}
+
+	if exists(a.storage, a.groupDir(name)) {
+		return ErrGroupExists
+	}
+
+	defer a.keyring.invalidate()
+	return a.storage.MkdirAll(a.groupDir(name))
+}
+
+func (a *Account) RenameGroup(name, newName string) error {
+	if err := a.checkGroup(name); err != nil {
+		return err
+	}
+	if err := validateGroupName(newName); err != nil {
+		return err
+	}
+
+	if exists(a.storage, a.groupDir(newName)) {
+		return ErrGroupExists
+	}
+
+	defer a.keyring.invalidate()
+	return a.storage.Rename(a.groupDir(name), a.groupDir(newName))
+}
+
+// RemoveGroup removes the group. members in no other group are kept as
+// friends without a group.
+func (a *Account) RemoveGroup(name string) error {
+	group, err := a.Group(name)
+	if err != nil {
+		return err
+	}
+
+	for _, friend := range group.FriendsSet() {
+		if err := a.keepUngrouped(friend, name); err != nil {
+			return err
+		}
+	}
+
+	defer a.keyring.invalidate()
+	return a.storage.RemoveAll(a.groupDir(name))
+}
+
+// AddToGroup adds the friend to the group, keeping its other groups. the
+// friend isn't without a group anymore.
+func (a *Account) AddToGroup(friend *Friend, name string) error {
+	if err := a.checkGroup(name); err != nil {
+		return err
+	}
+
+	if err := a.saveFriendEntity(a.groupDir(name), friend.Fingerprint().String(), friend.entity); err != nil {
+		return err
+	}
+
+	defer a.keyring.invalidate()
+	return removeIfExists(a.storage, friendKeyFile(mauDir(a.path), friend))
+}
+
+// RemoveFromGroup removes the friend from the group, the friend stays a
+// friend without a group if it's in no other group
+func (a *Account) RemoveFromGroup(friend *Friend, name string) error {
+	if err := a.checkGroup(name); err != nil {
+		return err
+	}
+
+	key := friendKeyFile(a.groupDir(name), friend)
+	if !exists(a.storage, key) {
+		return ErrFriendNotInGroup
+	}
+
+	if err := a.keepUngrouped(friend, name); err != nil {
+		return err
+	}
+
+	defer a.keyring.invalidate()
+	return a.storage.Remove(key)
+}
+
+// MoveToGroup moves the friend from one group to another
+func (a *Account) MoveToGroup(friend *Friend, from, to string) error {
+	if err := a.AddToGroup(friend, to); err != nil {
+		return err
+	}
+
+	return a.RemoveFromGroup(friend, from)
+}
+
+// FriendGroups returns the names of the groups the friend is a member of
+func (a *Account) FriendGroups(friend *Friend) ([]string, error) {
+	entries, err := a.storage.ReadDir(mauDir(a.path))
+	if err != nil {
+		return nil, err
+	}
+
+	groups := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() && exists(a.storage, friendKeyFile(a.groupDir(entry.Name()), friend)) {
+			groups = append(groups, entry.Name())
+		}
+	}
+
+	return groups, nil
+}
+
+// GroupRecipients returns the members of the groups, each friend once, to
+// share files with whole groups
+func (a *Account) GroupRecipients(names ...string) ([]*Friend, error) {
+	recipients := []*Friend{}
+	seen := map[string]bool{} // key: fingerprint hex string
+
+	for _, name := range names {
+		group, err := a.Group(name)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, friend := range group.FriendsSet() {
+			if fpr := friend.Fingerprint().String(); !seen[fpr] {
+				seen[fpr] = true
+				recipients = append(recipients, friend)
+			}
+		}
+	}
+
+	return recipients, nil
+}
+
+func (a *Account) checkGroup(name string) error {
+	if err := validateGroupName(name); err != nil {
+		return err
+	}
+
+	if info, err := a.storage.Stat(a.groupDir(name)); err != nil || !info.IsDir() {
+		return ErrGroupNotFound
+	}
+
+	return nil
+}
+
+// keepUngrouped saves the friend key without a group when leaving the group
+// would leave it in none
+func (a *Account) keepUngrouped(friend *Friend, leaving string) error {
+	groups, err := a.FriendGroups(friend)
+	if err != nil {
+		return err
+	}
+
+	for _, g := range groups {
+		if g != leaving {
+			return nil
+		}
+	}
+
+	return a.saveFriendEntity(mauDir(a.path), friend.Fingerprint().String(), friend.entity)
+}
@@ -0,0 +1,125 @@
+package mau
+
+import (
+	"bytes"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGroups(t *testing.T) {
+	storage := NewMemoryStorage()
+	account, err := NewAccountWithStorage(storage, "/ahmed", "Ahmed Mohamed", "ahmed@example.com", "password")
+	require.NoError(t, err)
+
+	newFriend := func(name string) *Friend {
+		friend, err := NewAccountWithStorage(NewMemoryStorage(), "/"+name, name, name+"@example.com", "password")
+		require.NoError(t, err)
+		return befriend(t, account, friend)
+	}
+	mohamed, omar := newFriend("mohamed"), newFriend("omar")
+	rootKey := func(f *Friend) string { return friendKeyFile(mauDir("/ahmed"), f) }
+
+	t.Run("Creates groups", func(t T) {
+		require.NoError(t, account.CreateGroup("coworkers"))
+		require.NoError(t, account.CreateGroup("family"))
+		assert.ErrorIs(t, account.CreateGroup("coworkers"), ErrGroupExists)
+
+		for _, name := range []string{"", ".hidden", "a/b", "..", "x.pgp", "x.tmp", "index.json", "chat_state.gpg", "search.gpg", "agent.sock"} {
+			assert.ErrorIs(t, account.CreateGroup(name), ErrInvalidGroupName, name)
+		}
+
+		groups, err := account.Groups()
+		require.NoError(t, err)
+		require.Len(t, groups, 2)
+		assert.Equal(t, "coworkers", groups[0].Name())
+		assert.Empty(t, groups[0].FriendsSet())
+	})
+
+	t.Run("Adds friends to several groups", func(t T) {
+		require.NoError(t, account.AddToGroup(mohamed, "coworkers"))
+		require.NoError(t, account.AddToGroup(mohamed, "family"))
+		require.NoError(t, account.AddToGroup(omar, "coworkers"))
+		assert.ErrorIs(t, account.AddToGroup(omar, "missing"), ErrGroupNotFound)
+
+		groups, err := account.FriendGroups(mohamed)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"coworkers", "family"}, groups)
+		assert.False(t, exists(storage, rootKey(mohamed)))
+
+		keyring, err := account.ListFriends()
+		require.NoError(t, err)
+		assert.Empty(t, keyring.Friends)
+		assert.Len(t, keyring.FriendsSet(), 2)
+	})
+
+	t.Run("Shares files with groups", func(t T) {
+		recipients, err := account.GroupRecipients("coworkers", "family")
+		require.NoError(t, err)
+		assert.Len(t, recipients, 2)
+
+		file, err := account.AddFile(bytes.NewBufferString("hello"), "hello.txt", recipients)
+		require.NoError(t, err)
+		shared, err := file.Recipients(account)
+		require.NoError(t, err)
+		assert.Len(t, shared, 2)
+
+		_, err = account.GroupRecipients("missing")
+		assert.ErrorIs(t, err, ErrGroupNotFound)
+	})
+
+	t.Run("Removes friends from groups", func(t T) {
+		require.NoError(t, account.RemoveFromGroup(mohamed, "family"))
+		assert.False(t, exists(storage, rootKey(mohamed)))
+		assert.ErrorIs(t, account.RemoveFromGroup(mohamed, "family"), ErrFriendNotInGroup)
+
+		require.NoError(t, account.RemoveFromGroup(mohamed, "coworkers"))
+		assert.True(t, exists(storage, rootKey(mohamed)))
+
+		keyring, err := account.ListFriends()
+		require.NoError(t, err)
+		assert.NotNil(t, keyring.FindByFingerprint(mohamed.Fingerprint()))
+	})
+
+	t.Run("Moves friends between groups", func(t T) {
+		require.NoError(t, account.MoveToGroup(omar, "coworkers", "family"))
+
+		groups, err := account.FriendGroups(omar)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"family"}, groups)
+	})
+
+	t.Run("Renames groups", func(t T) {
+		require.NoError(t, account.RenameGroup("family", "relatives"))
+		assert.ErrorIs(t, account.RenameGroup("family", "others"), ErrGroupNotFound)
+		assert.ErrorIs(t, account.RenameGroup("relatives", "coworkers"), ErrGroupExists)
+
+		group, err := account.Group("relatives")
+		require.NoError(t, err)
+		require.Len(t, group.Friends, 1)
+		assert.Equal(t, omar.Fingerprint(), group.Friends[0].Fingerprint())
+	})
+
+	t.Run("Deleting a group keeps its members as friends", func(t T) {
+		require.NoError(t, account.RemoveGroup("relatives"))
+		_, err := account.Group("relatives")
+		assert.ErrorIs(t, err, ErrGroupNotFound)
+
+		keyring, err := account.ListFriends()
+		require.NoError(t, err)
+		assert.NotNil(t, keyring.FindByFingerprint(omar.Fingerprint()))
+		assert.True(t, exists(storage, rootKey(omar)))
+	})
+
+	t.Run("Removing a friend removes it from its groups", func(t T) {
+		require.NoError(t, account.AddToGroup(omar, "coworkers"))
+		require.NoError(t, account.RemoveFriend(omar))
+
+		assert.False(t, exists(storage, path.Join(account.groupDir("coworkers"), omar.Fingerprint().String()+".pgp")))
+		keyring, err := account.ListFriends()
+		require.NoError(t, err)
+		assert.Nil(t, keyring.FindByFingerprint(omar.Fingerprint()))
+	})
+}
@@ -7,9 +7,10 @@ import (
 	"log/slog"
 	"math/bits"
 	"math/rand"
+	"net"
 	"net/http"
-	"net/url"
 	"slices"
+	"strconv"
 	"sync"
 	"time"
 )
@@ -29,13 +30,16 @@ const (
 // allowing the server to join a P2P network.
 type Peer struct {
 	Fingerprint Fingerprint `json:"fingerprint"`
-	Address     string      `json:"address"` // Hostname:Port or IP:Port without the protocol
+	Address     string      `json:"address"`             // Hostname:Port or IP:Port optionally prefixed with a transport hint
+	Addresses   []string    `json:"addresses,omitempty"` // other addresses of the peer with their transport hint, like its QUIC address
 }
 
 type dhtServer struct {
 	mux           *http.ServeMux
 	account       *Account
 	address       string
+	quicAddress   string // advertised with address once the server is served over QUIC
+	addressMutex  sync.RWMutex
 	buckets       [dht_B]bucket
 	cancelRefresh context.CancelFunc
 	lastPing      map[string]time.Time // key: fingerprint hex string
@@ -53,9 +57,38 @@ func newDHTServer(account *Account, address string) *dhtServer {
 	d.mux.HandleFunc("GET /kad/ping", d.receivePing)
 	d.mux.HandleFunc("GET /kad/find_peer/{fpr}", d.receiveFindPeer)
 
+	rendezvous := newRendezvousServer(account)
+	d.mux.HandleFunc("GET /kad/rendezvous/register", rendezvous.register)
+	d.mux.HandleFunc("GET /kad/rendezvous/connect/{fpr}", rendezvous.connect)
+
 	return d
 }
 
+// advertiseQUIC advertises the QUIC port on the host of the DHT address
+func (d *dhtServer) advertiseQUIC(port int) {
+	host, _, err := net.SplitHostPort(d.address)
+	if err != nil {
+		return
+	}
+
+	d.addressMutex.Lock()
+	d.quicAddress = QUICAddress(net.JoinHostPort(host, strconv.Itoa(port)))
+	d.addressMutex.Unlock()
+}
+
+// advertised returns the addresses peers learn from our certificate, the DHT
+// address first
+func (d *dhtServer) advertised() []string {
+	d.addressMutex.RLock()
+	defer d.addressMutex.RUnlock()
+
+	if d.quicAddress == "" {
+		return []string{d.address}
+	}
+
+	return []string{d.address, d.quicAddress}
+}
+
 func (d *dhtServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	d.mux.ServeHTTP(w, r)
 }
@@ -66,7 +99,7 @@ func (d *dhtServer) sendPing(ctx context.Context, peer *Peer) error {
 		return nil
 	}
 
-	client, err := d.account.Client(peer.Fingerprint, []string{d.address})
+	client, err := d.account.Client(peer.Fingerprint, d.advertised())
 	if err != nil {
 		return err
 	}
@@ -89,13 +122,7 @@ func (d *dhtServer) shouldSkipPing(fingerprint Fingerprint) bool {
 }
 
 func (d *dhtServer) executePing(ctx context.Context, client *Client, address string) error {
-	u := url.URL{
-		Scheme: uriProtocolName,
-		Host:   address,
-		Path:   "/kad/ping",
-	}
-
-	_, err := client.client.R().SetContext(ctx).Get(u.String())
+	_, err := client.client.R().SetContext(ctx).Get(client.peerURL(address, "/kad/ping"))
 	return err
 }
 
@@ -215,12 +242,12 @@ func limitPeers(peers []*Peer, max int) []*Peer {
 }
 
 func (d *dhtServer) queryPeerForFingerprint(ctx context.Context, peer *Peer, fingerprint Fingerprint) ([]*Peer, error) {
-	client, err := d.account.Client(peer.Fingerprint, []string{d.address})
+	client, err := d.account.Client(peer.Fingerprint, d.advertised())
 	if err != nil {
 		return nil, err
 	}
 
-	u := buildFindPeerURL(peer.Address, fingerprint)
+	u := client.peerURL(peer.Address, "/kad/find_peer/"+fingerprint.String())
 	foundPeers, err := d.executeFindPeerRequest(ctx, client, u)
 	if err != nil {
 		d.removePeer(peer)
@@ -230,15 +257,6 @@ func (d *dhtServer) queryPeerForFingerprint(ctx context.Context, peer *Peer, fin
 	return foundPeers, nil
 }
 
-func buildFindPeerURL(address string, fingerprint Fingerprint) string {
-	u := url.URL{
-		Scheme: uriProtocolName,
-		Host:   address,
-		Path:   "/kad/find_peer/" + fingerprint.String(),
-	}
-	return u.String()
-}
-
 func (d *dhtServer) executeFindPeerRequest(ctx context.Context, client *Client, url string) ([]*Peer, error) {
 	var foundPeers []*Peer
 	_, err := client.client.
@@ -338,6 +356,7 @@ func (d *dhtServer) addPeerFromRequest(r *http.Request) error {
 	d.addPeer(&Peer{
 		Fingerprint: fingerprint,
 		Address:     address,
+		Addresses:   certHintedAddresses(r.TLS.PeerCertificates),
 	})
 
 	return nil
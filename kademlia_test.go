@@ -26,7 +26,7 @@ func TestBucket(t *testing.T) {
 	fpr2, err := FingerprintFromString("AAAF11C65A2970B130ABE3C479BE3E4300411886")
 	assert.NoError(t, err)
 
-	b.addToTail(&Peer{fpr1, "address"})
+	b.addToTail(&Peer{Fingerprint: fpr1, Address: "address"})
 	peer1 := b.get(fpr1)
 	assert.NotNil(t, peer1)
 	assert.Equal(t, fpr1, peer1.Fingerprint)
@@ -35,7 +35,7 @@ func TestBucket(t *testing.T) {
 	assert.NotNil(t, lrs1)
 	assert.Equal(t, fpr1, lrs1.Fingerprint)
 
-	b.addToTail(&Peer{fpr2, "address2"})
+	b.addToTail(&Peer{Fingerprint: fpr2, Address: "address2"})
 	lrs2 := b.leastRecentlySeen()
 	assert.NotNil(t, lrs2)
 	assert.Equal(t, fpr1, lrs2.Fingerprint)
@@ -138,10 +138,10 @@ func TestDHTServerAddPeer(t *testing.T) {
 	distance := s.bucketFor(peerFpr)
 	assert.Equal(t, 0, len(s.buckets[distance].values))
 
-	s.addPeer(&Peer{peerFpr, "address"})
+	s.addPeer(&Peer{Fingerprint: peerFpr, Address: "address"})
 	assert.Equal(t, 1, len(s.buckets[distance].values))
 
-	s.addPeer(&Peer{peerFpr2, "address"})
+	s.addPeer(&Peer{Fingerprint: peerFpr2, Address: "address"})
 	assert.Equal(t, 2, len(s.buckets[distance].values))
 }
 
@@ -149,7 +149,7 @@ func TestDHTServer(t *testing.T) {
 	bootstrap, err := NewAccount(t.TempDir(), "Main peer", "main@example.com", "password")
 	assert.NoError(t, err)
 	listener, bootstrap_addr := TempListener()
-	bootstrap_peer := &Peer{bootstrap.Fingerprint(), bootstrap_addr}
+	bootstrap_peer := &Peer{Fingerprint: bootstrap.Fingerprint(), Address: bootstrap_addr}
 
 	server, err := bootstrap.Server(nil)
 	assert.NoError(t, err)
@@ -375,7 +375,7 @@ func TestRefreshAllStallBuckets(t *testing.T) {
 
 		// Add a peer to buckets so refreshBucket has something to work with
 		fpr1 := ParseFPRIgnoreErr("ABAF11C65A2970B130ABE3C479BE3E4300411886")
-		s.buckets[0].addToTail(&Peer{fpr1, "127.0.0.1:8081"})
+		s.buckets[0].addToTail(&Peer{Fingerprint: fpr1, Address: "127.0.0.1:8081"})
 
 		result := s.refreshAllStallBuckets(ctx)
 		assert.True(t, result, "Should return true when processing completes")
@@ -409,7 +409,7 @@ func TestRefreshAllStallBuckets(t *testing.T) {
 
 		// Add peers so refresh actually happens
 		fpr := ParseFPRIgnoreErr("BBAF11C65A2970B130ABE3C479BE3E4300411886")
-		s.buckets[staleBucket].addToTail(&Peer{fpr, "127.0.0.1:8082"})
+		s.buckets[staleBucket].addToTail(&Peer{Fingerprint: fpr, Address: "127.0.0.1:8082"})
 
 		result := s.refreshAllStallBuckets(ctx)
 		assert.True(t, result)
@@ -445,7 +445,7 @@ func TestRefreshAllStallBuckets(t *testing.T) {
 		for _, idx := range staleBuckets {
 			s.buckets[idx].lastLookup = time.Now().Add(-2 * dht_STALL_PERIOD)
 			fpr := ParseFPRIgnoreErr(fmt.Sprintf("ABAF11C65A2970B130ABE3C47%015dF", idx))
-			s.buckets[idx].addToTail(&Peer{fpr, fmt.Sprintf("127.0.0.1:808%d", idx)})
+			s.buckets[idx].addToTail(&Peer{Fingerprint: fpr, Address: fmt.Sprintf("127.0.0.1:808%d", idx)})
 		}
 
 		result := s.refreshAllStallBuckets(ctx)
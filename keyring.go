@@ -2,14 +2,19 @@ package mau
 
 import (
 	"fmt"
-	"os"
+	"io/fs"
 	"path"
+	"sync"
 )
 
 type Keyring struct {
 	Path        string
 	Friends     []*Friend
 	SubKeyrings []*Keyring
+
+	indexOnce     sync.Once
+	byFingerprint map[string]*Friend // key: fingerprint hex string, sub keyrings included
+	byKeyID       map[uint64]*Friend // key: ID of the primary key and every subkey
 }
 
 func (k *Keyring) Name() string {
@@ -20,19 +25,10 @@ func (k *Keyring) Name() string {
 }
 
 func (k *Keyring) FriendsSet() []*Friend {
-	friends := map[string]*Friend{} // key: fingerprint hex string
-	for _, f := range k.Friends {
-		friends[f.Fingerprint().String()] = f
-	}
-
-	for _, sk := range k.SubKeyrings {
-		for _, f := range sk.FriendsSet() {
-			friends[f.Fingerprint().String()] = f
-		}
-	}
+	k.index()
 
-	set := []*Friend{}
-	for _, f := range friends {
+	set := make([]*Friend, 0, len(k.byFingerprint))
+	for _, f := range k.byFingerprint {
 		set = append(set, f)
 	}
 
@@ -44,52 +40,63 @@ func (k *Keyring) FindByFingerprint(fingerprint Fingerprint) *Friend {
 		return nil
 	}
 
-	for _, friend := range k.Friends {
-		if friend.Fingerprint().Equal(fingerprint) {
-			return friend
-		}
+	k.index()
+	return k.byFingerprint[fingerprint.String()]
+}
+
+func (k *Keyring) FriendById(id uint64) *Friend {
+	if k == nil {
+		return nil
 	}
 
-	for _, keyring := range k.SubKeyrings {
-		friend := keyring.FindByFingerprint(fingerprint)
-		if friend != nil {
-			return friend
-		}
+	k.index()
+	return k.byKeyID[id]
+}
+
+// index builds the lookup maps on first use. the keyring and its sub
+// keyrings shouldn't change after that.
+func (k *Keyring) index() {
+	k.indexOnce.Do(func() {
+		k.byFingerprint = map[string]*Friend{}
+		k.byKeyID = map[uint64]*Friend{}
+		k.indexKeyring(k)
+	})
+}
+
+// indexKeyring adds the friends of keyring, friends found first win
+func (k *Keyring) indexKeyring(keyring *Keyring) {
+	for _, f := range keyring.Friends {
+		k.indexFriend(f)
 	}
 
-	return nil
+	for _, sk := range keyring.SubKeyrings {
+		k.indexKeyring(sk)
+	}
 }
 
-func (k *Keyring) FriendById(id uint64) *Friend {
-	for _, f := range k.Friends {
-		if f.entity.PrimaryKey.KeyId == id {
-			return f
-		}
-		for _, sk := range f.entity.Subkeys {
-			if sk.PublicKey.KeyId == id {
-				return f
-			}
-		}
+func (k *Keyring) indexFriend(f *Friend) {
+	fpr := f.Fingerprint().String()
+	if _, ok := k.byFingerprint[fpr]; ok {
+		return
 	}
+	k.byFingerprint[fpr] = f
 
-	for _, sk := range k.SubKeyrings {
-		f := sk.FriendById(id)
-		if f != nil {
-			return f
+	for _, id := range entityKeyIDs(f.entity) {
+		if _, ok := k.byKeyID[id]; !ok {
+			k.byKeyID[id] = f
 		}
 	}
-
-	return nil
 }
 
 func (k *Keyring) read(account *Account) error {
-	files, err := os.ReadDir(k.Path)
+	files, err := account.storage.ReadDir(k.Path)
 	if err != nil {
 		return fmt.Errorf("failed to read keyring directory %s: %w", k.Path, err)
 	}
 
 	for _, file := range files {
-		if file.Name() == accountKeyFilename {
+		// the account key and state files like sync_state.json aren't friends
+		if file.Name() == accountKeyFilename || (!file.IsDir() && path.Ext(file.Name()) != ".pgp") {
 			continue
 		}
 
@@ -101,7 +108,7 @@ func (k *Keyring) read(account *Account) error {
 	return nil
 }
 
-func (k *Keyring) processKeyringEntry(account *Account, file os.DirEntry) error {
+func (k *Keyring) processKeyringEntry(account *Account, file fs.DirEntry) error {
 	filePath := path.Join(k.Path, file.Name())
 
 	if file.IsDir() {
@@ -122,7 +129,7 @@ func (k *Keyring) readSubKeyring(account *Account, filePath string) error {
 }
 
 func (k *Keyring) readFriendKey(account *Account, filePath string) error {
-	reader, err := os.Open(filePath)
+	reader, err := account.storage.Open(filePath)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,65 @@
+package mau
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+)
+
+// keyringCache keeps the decrypted keyring of the account. it's read again
+// when friends are added or removed through the account or when the keyring
+// files change on storage.
+type keyringCache struct {
+	mutex   sync.Mutex
+	keyring *Keyring
+	stamp   string // names, sizes and modification times of the keyring files
+}
+
+func (c *keyringCache) get(account *Account) (*Keyring, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	stamp := account.keyringStamp()
+	if c.keyring != nil && c.stamp == stamp {
+		return c.keyring, nil
+	}
+
+	keyring := &Keyring{Path: mauDir(account.path)}
+	if err := keyring.read(account); err != nil {
+		return nil, err
+	}
+
+	c.keyring, c.stamp = keyring, stamp
+	return keyring, nil
+}
+
+func (c *keyringCache) invalidate() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.keyring = nil
+}
+
+// keyringStamp describes the keyring files without reading them, it changes
+// when a friend key or a sub keyring is added, removed, moved or replaced
+func (a *Account) keyringStamp() string {
+	var stamp strings.Builder
+
+	walkStorage(a.storage, mauDir(a.path), func(name string, entry fs.DirEntry) {
+		if entry.IsDir() {
+			stamp.WriteString(name + "/\n")
+			return
+		}
+		if path.Ext(name) != ".pgp" {
+			return
+		}
+
+		if info, err := entry.Info(); err == nil {
+			fmt.Fprintf(&stamp, "%s:%d:%d\n", name, info.Size(), info.ModTime().UnixNano())
+		}
+	})
+
+	return stamp.String()
+}
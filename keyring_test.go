@@ -5,6 +5,7 @@ import (
 	"os"
 	"path"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -272,3 +273,64 @@ func TestKeyring_FriendById_SubKeyrings(t *testing.T) {
 		assert.Equal(t, "Work Friend", found.Name())
 	})
 }
+
+func TestKeyring_SkipsStateFiles(t *testing.T) {
+	account, err := NewAccount(t.TempDir(), "Ahmed Mohamed", "ahmed@example.com", "password")
+	require.NoError(t, err)
+	friend, err := NewAccount(t.TempDir(), "Mohamed Mahmoud", "mohamed@example.com", "password")
+	require.NoError(t, err)
+	befriend(t, account, friend)
+
+	require.NoError(t, account.UpdateLastSyncTime(friend.Fingerprint(), time.Now()))
+	require.NoError(t, account.SetVersionRetention(nil, &VersionRetention{KeepLast: 1}))
+
+	keyring, err := account.ListFriends()
+	require.NoError(t, err)
+	assert.Len(t, keyring.Friends, 1)
+}
+
+func TestKeyring_Cache(t *testing.T) {
+	storage := NewMemoryStorage()
+	account, err := NewAccountWithStorage(storage, "/ahmed", "Ahmed Mohamed", "ahmed@example.com", "password")
+	require.NoError(t, err)
+	friend, err := NewAccount(t.TempDir(), "Mohamed Mahmoud", "mohamed@example.com", "password")
+	require.NoError(t, err)
+
+	first, err := account.ListFriends()
+	require.NoError(t, err)
+
+	t.Run("returns the cached keyring while it's unchanged", func(t *testing.T) {
+		keyring, err := account.ListFriends()
+		require.NoError(t, err)
+		assert.Same(t, first, keyring)
+	})
+
+	aFriend := befriend(t, account, friend)
+
+	t.Run("reads the keyring again after adding a friend", func(t *testing.T) {
+		keyring, err := account.ListFriends()
+		require.NoError(t, err)
+		assert.NotNil(t, keyring.FindByFingerprint(friend.Fingerprint()))
+	})
+
+	t.Run("reads the keyring again when key files change", func(t *testing.T) {
+		keyFile := path.Join(mauDir("/ahmed"), friend.Fingerprint().String()+".pgp")
+		require.NoError(t, storage.MkdirAll(path.Join(mauDir("/ahmed"), "work")))
+		require.NoError(t, storage.Rename(keyFile, path.Join(mauDir("/ahmed"), "work", path.Base(keyFile))))
+
+		keyring, err := account.ListFriends()
+		require.NoError(t, err)
+		assert.Empty(t, keyring.Friends)
+		require.Len(t, keyring.SubKeyrings, 1)
+		assert.NotNil(t, keyring.FindByFingerprint(friend.Fingerprint()))
+	})
+
+	t.Run("reads the keyring again after removing a friend", func(t *testing.T) {
+		require.NoError(t, account.RemoveFriend(aFriend))
+
+		keyring, err := account.ListFriends()
+		require.NoError(t, err)
+		assert.Nil(t, keyring.FindByFingerprint(friend.Fingerprint()))
+		assert.Nil(t, keyring.FriendById(friend.entity.PrimaryKey.KeyId))
+	})
+}
@@ -0,0 +1,357 @@
+package mau
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Messages: a conversation is the set of accounts taking part in it, the
+// sender and recipients of its messages, so a direct message and a group
+// chat work the same way. a message is a Message document encrypted to the
+// other participants and shared from the sender directory like any file,
+// friends get it when they sync. messages are ordered by the time of their
+// signature. the recipients a message claims must match the keys it's
+// encrypted to. the time each conversation was last read is kept in .mau,
+// encrypted to the account and never shared.
+
+var (
+	ErrNoRecipients      = errors.New("Message has no recipients")
+	ErrMessageRecipients = errors.New("Message recipients don't match the keys it's encrypted to")
+)
+
+// ConversationID identifies the conversation of the participants, whatever
+// their order
+func ConversationID(participants []Fingerprint) string {
+	keys := []string{}
+	for _, p := range participants {
+		keys = append(keys, p.String())
+	}
+	slices.Sort(keys)
+
+	sum := sha256.Sum256([]byte(strings.Join(slices.Compact(keys), ",")))
+	return fmt.Sprintf("%x", sum[:8])
+}
+
+// ChatMessage is a verified message of a conversation
+type ChatMessage struct {
+	File         *File
+	Sender       *Friend // the account itself for its own messages
+	Signed       time.Time
+	Message      *Message
+	Participants []Fingerprint // the sender and recipients
+}
+
+// Conversation returns the ID of the message conversation
+func (m *ChatMessage) Conversation() string {
+	return ConversationID(m.Participants)
+}
+
+// Conversation is a summary of the messages between the account and other
+// participants
+type Conversation struct {
+	ID           string
+	Participants []*Person // without the account
+	Last         *ChatMessage
+	Unread       int // messages of the others since the conversation was read
+}
+
+// chatState is the time each conversation was last read
+type chatState struct {
+	Read map[string]time.Time `json:"read"` // key: conversation ID
+}
+
+func chatStateFile(d string) string { return path.Join(mauDir(d), chatStateFilename) }
+
+// SendMessage shares a message with the recipients, in the conversation of
+// the account and them
+func (a *Account) SendMessage(text string, recipients []*Friend) (*ChatMessage, error) {
+	if len(recipients) == 0 {
+		return nil, ErrNoRecipients
+	}
+
+	msg := &Message{
+		Text:     text,
+		Sender:   NewPerson(a.Name(), a.Fingerprint()),
+		DateSent: time.Now().UTC(),
+	}
+	for _, r := range recipients {
+		msg.Recipient = append(msg.Recipient, NewPerson(r.Name(), r.Fingerprint()))
+	}
+
+	file, err := a.AddContent(msg, "", recipients)
+	if err != nil {
+		return nil, err
+	}
+
+	return a.readMessage(file, &Friend{entity: a.entity})
+}
+
+// Conversations returns the conversations of the account, the most recent
+// first
+func (a *Account) Conversations() ([]*Conversation, error) {
+	keyring, err := a.ListFriends()
+	if err != nil {
+		return nil, err
+	}
+	read := a.loadChatState().Read
+
+	byID := map[string]*Conversation{}
+	for _, m := range a.chatMessages() {
+		id := m.Conversation()
+		c, ok := byID[id]
+		if !ok {
+			c = &Conversation{ID: id, Participants: a.participants(m, keyring)}
+			byID[id] = c
+		}
+
+		c.Last = m
+		if !m.Sender.Fingerprint().Equal(a.Fingerprint()) && m.Signed.After(read[id]) {
+			c.Unread++
+		}
+	}
+
+	conversations := slices.Collect(maps.Values(byID))
+	slices.SortFunc(conversations, func(x, y *Conversation) int {
+		if c := y.Last.Signed.Compare(x.Last.Signed); c != 0 {
+			return c
+		}
+		return strings.Compare(x.ID, y.ID)
+	})
+
+	return conversations, nil
+}
+
+// Messages returns the messages of the conversation, oldest first
+func (a *Account) Messages(conversation string) ([]*ChatMessage, error) {
+	messages := []*ChatMessage{}
+	for _, m := range a.chatMessages() {
+		if m.Conversation() == conversation {
+			messages = append(messages, m)
+		}
+	}
+
+	return messages, nil
+}
+
+// MarkRead records the conversation was read up to the messages signed at
+// until, messages signed after it are unread
+func (a *Account) MarkRead(conversation string, until time.Time) error {
+	state := a.loadChatState()
+	if until.After(state.Read[conversation]) {
+		state.Read[conversation] = until
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	encrypted, err := a.encryptForAccount(data)
+	if err != nil {
+		return err
+	}
+
+	return a.storage.WriteFile(chatStateFile(a.path), encrypted)
+}
+
+// SyncConversation syncs the participants of a conversation the account
+// follows, before the rest of its friends, so their messages arrive first
+func (a *Account) SyncConversation(ctx context.Context, participants []Fingerprint, resolvers []FingerprintResolver) error {
+	errs := []error{}
+	for _, fpr := range participants {
+		if fpr.Equal(a.Fingerprint()) || !exists(a.storage, path.Join(a.path, fpr.String())) {
+			continue
+		}
+
+		if err := a.syncParticipant(ctx, fpr, resolvers); err != nil {
+			errs = append(errs, fmt.Errorf("failed to sync %s: %w", fpr, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (a *Account) syncParticipant(ctx context.Context, fpr Fingerprint, resolvers []FingerprintResolver) error {
+	client, err := a.Client(fpr, nil)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	report, err := client.SyncFriend(ctx, fpr, a.GetLastSyncTime(fpr), resolvers)
+	if err != nil {
+		return err
+	}
+
+	if report.Incomplete() {
+		return nil
+	}
+
+	return a.UpdateLastSyncTime(fpr, start)
+}
+
+// chatMessages returns the messages of every conversation of the account,
+// oldest first
+func (a *Account) chatMessages() []*ChatMessage {
+	defer a.index.save()
+
+	messages := []*ChatMessage{}
+	for _, dir := range a.contentDirPaths() {
+		fpr, _ := contentDirFingerprint(path.Base(dir))
+		sender, err := a.author(fpr)
+		if err != nil {
+			continue
+		}
+
+		entries, _ := a.storage.ReadDir(dir)
+		for _, entry := range entries {
+			if !isDocumentFile(entry) {
+				continue
+			}
+
+			file := a.file(path.Join(dir, entry.Name()))
+			if e := a.feedEntry(file, sender); e == nil || e.schemaType != "Message" {
+				continue
+			}
+
+			m, err := a.readMessage(file, sender)
+			if err != nil {
+				slog.Warn("failed to read message", "file", file.Path, "error", err)
+				continue
+			}
+			if slices.ContainsFunc(m.Participants, a.Fingerprint().Equal) {
+				messages = append(messages, m)
+			}
+		}
+	}
+
+	slices.SortFunc(messages, func(x, y *ChatMessage) int {
+		if c := x.Signed.Compare(y.Signed); c != 0 {
+			return c
+		}
+		return strings.Compare(x.File.Path, y.File.Path)
+	})
+
+	return messages
+}
+
+// readMessage verifies the message was signed by its sender. the sender is
+// the owner of the directory, whoever the message claims sent it.
+func (a *Account) readMessage(file *File, sender *Friend) (*ChatMessage, error) {
+	data, signed, err := a.readSigned(file, sender)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := &Message{}
+	if err := json.Unmarshal(data, msg); err != nil {
+		return nil, err
+	}
+
+	participants, err := a.messageParticipants(file, sender, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ChatMessage{File: file, Sender: sender, Signed: signed, Message: msg, Participants: participants}, nil
+}
+
+// messageParticipants returns the sender and the recipients the message
+// claims, checked against the keys the file is encrypted to. the account and
+// its friends must be among them. the keys of other people aren't known, so
+// there must be as many keys left as such recipients.
+func (a *Account) messageParticipants(file *File, sender *Friend, msg *Message) ([]Fingerprint, error) {
+	meta, err := a.fileMeta(file)
+	if err != nil {
+		return nil, err
+	}
+
+	keyring, err := a.ListFriends()
+	if err != nil {
+		return nil, err
+	}
+
+	encryptedTo := map[string]bool{}
+	unknownKeys := 0
+	for _, id := range meta.KeyIDs {
+		switch {
+		case slices.Contains(entityKeyIDs(a.entity), id):
+			encryptedTo[a.Fingerprint().String()] = true
+		case slices.Contains(entityKeyIDs(sender.entity), id):
+			encryptedTo[sender.Fingerprint().String()] = true
+		case keyring.FriendById(id) != nil:
+			encryptedTo[keyring.FriendById(id).Fingerprint().String()] = true
+		default:
+			unknownKeys++
+		}
+	}
+
+	participants := []Fingerprint{sender.Fingerprint()}
+	for _, r := range msg.Recipient {
+		if r == nil {
+			continue
+		}
+
+		fpr, err := FingerprintFromString(r.Identifier)
+		if err != nil || len(fpr) == 0 || slices.ContainsFunc(participants, fpr.Equal) {
+			continue
+		}
+
+		switch {
+		case encryptedTo[fpr.String()]:
+		case fpr.Equal(a.Fingerprint()) || keyring.FindByFingerprint(fpr) != nil || unknownKeys == 0:
+			return nil, fmt.Errorf("%w: %s", ErrMessageRecipients, fpr)
+		default:
+			unknownKeys--
+		}
+		participants = append(participants, fpr)
+	}
+
+	return participants, nil
+}
+
+// participants returns the people of the message conversation but the
+// account, named after the keyring for friends
+func (a *Account) participants(m *ChatMessage, keyring *Keyring) []*Person {
+	people := []*Person{}
+	for _, fpr := range m.Participants {
+		if fpr.Equal(a.Fingerprint()) {
+			continue
+		}
+
+		person := NewPerson("", fpr)
+		if friend := keyring.FindByFingerprint(fpr); friend != nil {
+			person.Name = friend.Name()
+		} else if i := slices.IndexFunc(m.Message.Recipient, func(r *Person) bool { return r != nil && r.Identifier == fpr.String() }); i >= 0 {
+			person.Name = m.Message.Recipient[i].Name
+		}
+		people = append(people, person)
+	}
+
+	return people
+}
+
+// loadChatState reads the read markers, from the plaintext file of older
+// versions until they're saved again
+func (a *Account) loadChatState() *chatState {
+	state := &chatState{}
+	if encrypted, err := a.storage.ReadFile(chatStateFile(a.path)); err == nil {
+		if data, err := a.decryptForAccount(encrypted); err == nil {
+			_ = json.Unmarshal(data, state)
+		}
+	}
+	if state.Read == nil {
+		state.Read = map[string]time.Time{}
+	}
+
+	return state
+}
@@ -0,0 +1,163 @@
+package mau
+
+import (
+	"encoding/json"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConversationID(t *testing.T) {
+	alice, bob := Fingerprint{1, 2}, Fingerprint{3, 4}
+
+	assert.Equal(t, ConversationID([]Fingerprint{alice, bob}), ConversationID([]Fingerprint{bob, alice, bob}))
+	assert.NotEqual(t, ConversationID([]Fingerprint{alice, bob}), ConversationID([]Fingerprint{alice}))
+}
+
+func TestMessageJSON(t *testing.T) {
+	msg := &Message{}
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"@type": "Message",
+		"text": "Hey Bob",
+		"recipient": {"@type": "Person", "identifier": "a1"},
+		"messageAttachment": {"@type": "ImageObject", "contentUrl": "/p2p/a1/photo.blob.pgp"}
+	}`), msg))
+	assert.Equal(t, "Message", msg.Type)
+	assert.Equal(t, "Hey Bob", msg.Text)
+	require.Len(t, msg.Recipient, 1)
+	assert.Equal(t, "a1", msg.Recipient[0].Identifier)
+	require.Len(t, msg.MessageAttachment, 1)
+	assert.Equal(t, "ImageObject", msg.MessageAttachment[0].Type)
+
+	require.NoError(t, json.Unmarshal([]byte(`{"text": "Hi all", "recipient": [{"identifier": "a1"}, {"identifier": "b2"}]}`), msg))
+	assert.Len(t, msg.Recipient, 2)
+}
+
+func TestMessages(t *testing.T) {
+	created := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	alice := accountSince(t, "alice", created)
+	bob := accountSince(t, "bob", created)
+	carol := accountSince(t, "carol", created)
+
+	bobFriend, carolFriend := befriend(t, alice, bob), befriend(t, alice, carol)
+	aliceFriend := befriend(t, bob, alice)
+	require.NoError(t, alice.Follow(bobFriend))
+
+	to := func(accounts ...*Account) []*Person {
+		people := []*Person{}
+		for _, a := range accounts {
+			people = append(people, NewPerson(a.Name(), a.Fingerprint()))
+		}
+		return people
+	}
+
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 12, 0, 0, 0, time.UTC) }
+	writeSignedAt(t, alice, bob, "hi", &Message{Text: "Hi Alice", Recipient: to(alice)}, day(1))
+	writeSignedTo(t, alice, alice, []*Account{bob}, "hello", &Message{Text: "Hello Bob", Recipient: to(bob)}, day(2))
+	writeSignedTo(t, alice, carol, []*Account{bob}, "group", &Message{Text: "Hi both", Recipient: to(alice, bob)}, day(3))
+	writeSignedAt(t, alice, bob, "other", &Message{Text: "Not for Alice", Recipient: to(carol)}, day(4))
+
+	// a message in Bob's directory signed by Carol
+	writeSignedAt(t, alice, carol, "forged", &Message{Text: "Forged", Recipient: to(alice)}, day(5))
+	require.NoError(t, alice.storage.Rename(
+		path.Join(alice.path, carol.Fingerprint().String(), "forged.pgp"),
+		path.Join(alice.path, bob.Fingerprint().String(), "forged.pgp"),
+	))
+
+	// a message claiming Bob as a recipient without being encrypted to him
+	writeSignedAt(t, alice, carol, "claimed", &Message{Text: "Claimed", Recipient: to(alice, bob)}, day(6))
+
+	direct := ConversationID([]Fingerprint{alice.Fingerprint(), bob.Fingerprint()})
+	group := ConversationID([]Fingerprint{alice.Fingerprint(), bob.Fingerprint(), carol.Fingerprint()})
+
+	t.Run("Groups messages by participants", func(t T) {
+		conversations, err := alice.Conversations()
+		require.NoError(t, err)
+		require.Len(t, conversations, 2)
+
+		assert.Equal(t, group, conversations[0].ID)
+		assert.Equal(t, "Hi both", conversations[0].Last.Message.Text)
+		assert.Equal(t, 1, conversations[0].Unread)
+		require.Len(t, conversations[0].Participants, 2)
+
+		assert.Equal(t, direct, conversations[1].ID)
+		assert.Equal(t, "Hello Bob", conversations[1].Last.Message.Text)
+		assert.Equal(t, 1, conversations[1].Unread)
+		require.Len(t, conversations[1].Participants, 1)
+		assert.Equal(t, bobFriend.Name(), conversations[1].Participants[0].Name)
+	})
+
+	t.Run("Orders messages by signature time", func(t T) {
+		messages, err := alice.Messages(direct)
+		require.NoError(t, err)
+		require.Len(t, messages, 2)
+		assert.Equal(t, "Hi Alice", messages[0].Message.Text)
+		assert.Equal(t, bob.Fingerprint(), messages[0].Sender.Fingerprint())
+		assert.Equal(t, "Hello Bob", messages[1].Message.Text)
+		assert.Equal(t, day(2), messages[1].Signed.UTC())
+	})
+
+	t.Run("Keeps read markers", func(t T) {
+		require.NoError(t, alice.MarkRead(direct, day(1)))
+		require.NoError(t, alice.MarkRead(direct, day(0))) // doesn't go back
+
+		conversations, err := alice.Conversations()
+		require.NoError(t, err)
+		assert.Equal(t, 0, conversations[1].Unread)
+		assert.Equal(t, 1, conversations[0].Unread)
+		assert.True(t, exists(alice.storage, chatStateFile(alice.path)))
+
+		data, err := alice.storage.ReadFile(chatStateFile(alice.path))
+		require.NoError(t, err)
+		assert.NotContains(t, string(data), direct)
+	})
+
+	t.Run("Checks recipients against the encryption keys", func(t T) {
+		file := alice.file(path.Join(alice.path, carol.Fingerprint().String(), "claimed.pgp"))
+		_, err := alice.readMessage(file, carolFriend)
+		assert.ErrorIs(t, err, ErrMessageRecipients)
+
+		messages, err := alice.Messages(group)
+		require.NoError(t, err)
+		for _, m := range messages {
+			assert.NotEqual(t, "Claimed", m.Message.Text)
+		}
+	})
+
+	t.Run("Sends messages", func(t T) {
+		_, err := alice.SendMessage("Nobody", nil)
+		assert.ErrorIs(t, err, ErrNoRecipients)
+
+		sent, err := alice.SendMessage("Dinner tonight?", []*Friend{bobFriend, carolFriend})
+		require.NoError(t, err)
+		assert.Equal(t, group, sent.Conversation())
+
+		conversations, err := alice.Conversations()
+		require.NoError(t, err)
+		assert.Equal(t, "Dinner tonight?", conversations[0].Last.Message.Text)
+		assert.Equal(t, 1, conversations[0].Unread)
+
+		recipients, err := sent.File.Recipients(alice)
+		require.NoError(t, err)
+		assert.Len(t, recipients, 2)
+	})
+
+	t.Run("Syncs conversation partners", func(t T) {
+		_, err := bob.SendMessage("Sure", []*Friend{aliceFriend})
+		require.NoError(t, err)
+
+		atBob := serve(t, bob)
+		dave := accountSince(t, "dave", created) // not followed, skipped
+		require.NoError(t, alice.SyncConversation(Timeout(5*time.Second), []Fingerprint{alice.Fingerprint(), bob.Fingerprint(), dave.Fingerprint()}, []FingerprintResolver{atBob}))
+		assert.False(t, alice.GetLastSyncTime(bob.Fingerprint()).IsZero())
+		assert.True(t, alice.GetLastSyncTime(dave.Fingerprint()).IsZero())
+
+		messages, err := alice.Messages(direct)
+		require.NoError(t, err)
+		require.Len(t, messages, 3)
+		assert.Equal(t, "Sure", messages[2].Message.Text)
+	})
+}
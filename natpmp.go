@@ -0,0 +1,101 @@
+package mau
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+)
+
+// NAT Port Mapping Protocol (NAT-PMP) RFC 6886
+
+const (
+	natpmpPort              = 5351
+	natpmpVersion           = 0
+	natpmpOpExternalAddress = 0
+	natpmpOpMapUDP          = 1
+	natpmpOpMapTCP          = 2
+	natpmpResponseBit       = 128
+)
+
+var natpmpResultCodes = map[uint16]string{
+	1: "unsupported version",
+	2: "not authorized/refused",
+	3: "network failure",
+	4: "out of resources",
+	5: "unsupported opcode",
+}
+
+type natpmpMapper struct {
+	gateway string // gateway IP:Port
+}
+
+func newNATPMPMapper(gateway string) *natpmpMapper {
+	return &natpmpMapper{gateway: gateway}
+}
+
+func (n *natpmpMapper) name() string { return "natpmp" }
+
+func (n *natpmpMapper) addPortMapping(ctx context.Context, protocol string, internalPort uint16, lifetime time.Duration) (*PortMapping, error) {
+	externalIP, err := n.externalIP(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	op := byte(natpmpOpMapTCP)
+	if protocol == "UDP" {
+		op = natpmpOpMapUDP
+	}
+
+	response, err := n.request(ctx, buildNATPMPMapRequest(op, internalPort, lifetime), 16)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PortMapping{
+		Protocol:     protocol,
+		ExternalIP:   externalIP,
+		InternalPort: binary.BigEndian.Uint16(response[8:]),
+		ExternalPort: binary.BigEndian.Uint16(response[10:]),
+		Lifetime:     time.Duration(binary.BigEndian.Uint32(response[12:])) * time.Second,
+	}, nil
+}
+
+func (n *natpmpMapper) externalIP(ctx context.Context) (net.IP, error) {
+	response, err := n.request(ctx, []byte{natpmpVersion, natpmpOpExternalAddress}, 12)
+	if err != nil {
+		return nil, err
+	}
+
+	return net.IPv4(response[8], response[9], response[10], response[11]), nil
+}
+
+// request sends a NAT-PMP request and returns the response after checking its result code
+func (n *natpmpMapper) request(ctx context.Context, request []byte, size int) ([]byte, error) {
+	op := request[1]
+	valid := func(b []byte) bool {
+		return len(b) >= size && b[0] == natpmpVersion && b[1] == op|natpmpResponseBit
+	}
+
+	response, err := udpRoundTrip(ctx, n.gateway, request, valid)
+	if err != nil {
+		return nil, err
+	}
+
+	if code := binary.BigEndian.Uint16(response[2:]); code != 0 {
+		return nil, fmt.Errorf("NAT-PMP gateway error %d: %s", code, natpmpResultCodes[code])
+	}
+
+	return response, nil
+}
+
+func buildNATPMPMapRequest(op byte, internalPort uint16, lifetime time.Duration) []byte {
+	request := make([]byte, 12)
+	request[0] = natpmpVersion
+	request[1] = op
+	binary.BigEndian.PutUint16(request[4:], internalPort)
+	binary.BigEndian.PutUint16(request[6:], internalPort) // suggested external port
+	binary.BigEndian.PutUint32(request[8:], uint32(lifetime/time.Second))
+	return request
+}
@@ -0,0 +1,119 @@
+package mau
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeGateway listens on a local UDP port and answers every request with the
+// handler response. a nil response simulates a dropped packet.
+func fakeGateway(t *testing.T, handler func(request []byte) []byte) string {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 1100)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if response := handler(buf[:n]); response != nil {
+				conn.WriteTo(response, addr)
+			}
+		}
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+func natpmpGatewayHandler(result uint16) func([]byte) []byte {
+	return func(request []byte) []byte {
+		switch request[1] {
+		case natpmpOpExternalAddress:
+			response := make([]byte, 12)
+			response[1] = natpmpOpExternalAddress | natpmpResponseBit
+			copy(response[8:], net.IPv4(203, 0, 113, 7).To4())
+			return response
+		default:
+			response := make([]byte, 16)
+			response[1] = request[1] | natpmpResponseBit
+			binary.BigEndian.PutUint16(response[2:], result)
+			copy(response[8:10], request[4:6])
+			binary.BigEndian.PutUint16(response[10:], 40000)
+			copy(response[12:16], request[8:12])
+			return response
+		}
+	}
+}
+
+func TestNATPMPMapper(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	t.Run("Maps a TCP port", func(t T) {
+		mapper := newNATPMPMapper(fakeGateway(t, natpmpGatewayHandler(0)))
+
+		mapping, err := mapper.addPortMapping(ctx, "TCP", 8080, time.Hour)
+		require.NoError(t, err)
+		assert.Equal(t, "TCP", mapping.Protocol)
+		assert.Equal(t, "203.0.113.7", mapping.ExternalIP.String())
+		assert.Equal(t, uint16(8080), mapping.InternalPort)
+		assert.Equal(t, uint16(40000), mapping.ExternalPort)
+		assert.Equal(t, time.Hour, mapping.Lifetime)
+		assert.Equal(t, "203.0.113.7:40000", mapping.ExternalAddress())
+	})
+
+	t.Run("Uses the UDP opcode for UDP mappings", func(t T) {
+		opcodes := make(chan byte, 1)
+		handler := natpmpGatewayHandler(0)
+		mapper := newNATPMPMapper(fakeGateway(t, func(request []byte) []byte {
+			if request[1] != natpmpOpExternalAddress {
+				opcodes <- request[1]
+			}
+			return handler(request)
+		}))
+
+		_, err := mapper.addPortMapping(ctx, "UDP", 8080, time.Hour)
+		require.NoError(t, err)
+		assert.Equal(t, byte(natpmpOpMapUDP), <-opcodes)
+	})
+
+	t.Run("Reports gateway result codes", func(t T) {
+		mapper := newNATPMPMapper(fakeGateway(t, natpmpGatewayHandler(2)))
+
+		_, err := mapper.addPortMapping(ctx, "TCP", 8080, time.Hour)
+		assert.ErrorContains(t, err, "not authorized")
+	})
+
+	t.Run("Retransmits dropped requests", func(t T) {
+		dropped := false
+		handler := natpmpGatewayHandler(0)
+		mapper := newNATPMPMapper(fakeGateway(t, func(request []byte) []byte {
+			if !dropped {
+				dropped = true
+				return nil
+			}
+			return handler(request)
+		}))
+
+		_, err := mapper.addPortMapping(ctx, "TCP", 8080, time.Hour)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Fails when the gateway doesn't respond", func(t T) {
+		mapper := newNATPMPMapper(fakeGateway(t, func([]byte) []byte { return nil }))
+
+		ctx, cancel := context.WithTimeout(ctx, 300*time.Millisecond)
+		defer cancel()
+		_, err := mapper.addPortMapping(ctx, "TCP", 8080, time.Hour)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,124 @@
+package mau
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Port Control Protocol (PCP) RFC 6887. PCP uses the same gateway port as
+// NAT-PMP and replaces it on newer routers.
+
+const (
+	pcpVersion        = 2
+	pcpOpMap          = 1
+	pcpResponseBit    = 0x80
+	pcpHeaderSize     = 24
+	pcpMapPayloadSize = 36
+	pcpNonceSize      = 12
+	pcpProtocolTCP    = 6
+	pcpProtocolUDP    = 17
+)
+
+var pcpResultCodes = map[byte]string{
+	1:  "unsupported version",
+	2:  "not authorized",
+	3:  "malformed request",
+	4:  "unsupported opcode",
+	5:  "unsupported option",
+	6:  "malformed option",
+	7:  "network failure",
+	8:  "no resources",
+	9:  "unsupported protocol",
+	10: "user exceeded quota",
+	11: "cannot provide external",
+	12: "address mismatch",
+	13: "excessive remote peers",
+}
+
+type pcpMapper struct {
+	gateway string // gateway IP:Port
+}
+
+func newPCPMapper(gateway string) *pcpMapper {
+	return &pcpMapper{gateway: gateway}
+}
+
+func (p *pcpMapper) name() string { return "pcp" }
+
+func (p *pcpMapper) addPortMapping(ctx context.Context, protocol string, internalPort uint16, lifetime time.Duration) (*PortMapping, error) {
+	clientIP, err := outboundIP(p.gateway)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, pcpNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	request := buildPCPMapRequest(clientIP, nonce, pcpProtocolNumber(protocol), internalPort, lifetime)
+	response, err := udpRoundTrip(ctx, p.gateway, request, isPCPMapResponse(nonce))
+	if err != nil {
+		return nil, err
+	}
+
+	return parsePCPMapResponse(response, protocol)
+}
+
+// isPCPMapResponse matches MAP responses to the request carrying the same nonce
+func isPCPMapResponse(nonce []byte) func([]byte) bool {
+	return func(b []byte) bool {
+		return len(b) >= pcpHeaderSize+pcpMapPayloadSize &&
+			b[0] == pcpVersion &&
+			b[1] == pcpOpMap|pcpResponseBit &&
+			bytes.Equal(b[pcpHeaderSize:pcpHeaderSize+pcpNonceSize], nonce)
+	}
+}
+
+func pcpProtocolNumber(protocol string) byte {
+	if protocol == "UDP" {
+		return pcpProtocolUDP
+	}
+	return pcpProtocolTCP
+}
+
+func buildPCPMapRequest(clientIP net.IP, nonce []byte, protocol byte, internalPort uint16, lifetime time.Duration) []byte {
+	request := make([]byte, pcpHeaderSize+pcpMapPayloadSize)
+	request[0] = pcpVersion
+	request[1] = pcpOpMap
+	binary.BigEndian.PutUint32(request[4:], uint32(lifetime/time.Second))
+	copy(request[8:24], clientIP.To16())
+
+	payload := request[pcpHeaderSize:]
+	copy(payload, nonce)
+	payload[12] = protocol
+	binary.BigEndian.PutUint16(payload[16:], internalPort)
+	binary.BigEndian.PutUint16(payload[18:], internalPort) // suggested external port
+	copy(payload[20:36], net.IPv6zero)                     // no suggested external address
+	return request
+}
+
+func parsePCPMapResponse(response []byte, protocol string) (*PortMapping, error) {
+	if code := response[3]; code != 0 {
+		return nil, fmt.Errorf("PCP gateway error %d: %s", code, pcpResultCodes[code])
+	}
+
+	payload := response[pcpHeaderSize:]
+	externalIP := net.IP(bytes.Clone(payload[20:36]))
+	if ip4 := externalIP.To4(); ip4 != nil {
+		externalIP = ip4
+	}
+
+	return &PortMapping{
+		Protocol:     protocol,
+		ExternalIP:   externalIP,
+		InternalPort: binary.BigEndian.Uint16(payload[16:]),
+		ExternalPort: binary.BigEndian.Uint16(payload[18:]),
+		Lifetime:     time.Duration(binary.BigEndian.Uint32(response[4:])) * time.Second,
+	}, nil
+}
@@ -0,0 +1,78 @@
+package mau
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func pcpGatewayHandler(result byte) func([]byte) []byte {
+	return func(request []byte) []byte {
+		if request[0] != pcpVersion || request[1] != pcpOpMap {
+			return nil
+		}
+
+		response := make([]byte, pcpHeaderSize+pcpMapPayloadSize)
+		response[0] = pcpVersion
+		response[1] = pcpOpMap | pcpResponseBit
+		response[3] = result
+		copy(response[4:8], request[4:8]) // grant requested lifetime
+
+		payload := response[pcpHeaderSize:]
+		copy(payload, request[pcpHeaderSize:pcpHeaderSize+20])
+		binary.BigEndian.PutUint16(payload[18:], 41000)
+		copy(payload[20:], net.IPv4(198, 51, 100, 3).To16())
+		return response
+	}
+}
+
+func TestPCPMapper(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	t.Run("Maps a TCP port", func(t T) {
+		requests := make(chan []byte, 1)
+		handler := pcpGatewayHandler(0)
+		mapper := newPCPMapper(fakeGateway(t, func(r []byte) []byte {
+			requests <- append([]byte{}, r...)
+			return handler(r)
+		}))
+
+		mapping, err := mapper.addPortMapping(ctx, "TCP", 8080, 2*time.Hour)
+		require.NoError(t, err)
+		assert.Equal(t, "198.51.100.3", mapping.ExternalIP.String())
+		assert.Equal(t, uint16(8080), mapping.InternalPort)
+		assert.Equal(t, uint16(41000), mapping.ExternalPort)
+		assert.Equal(t, 2*time.Hour, mapping.Lifetime)
+
+		request := <-requests
+		assert.Equal(t, byte(pcpProtocolTCP), request[pcpHeaderSize+12])
+		assert.Equal(t, "127.0.0.1", net.IP(request[8:24]).String())
+	})
+
+	t.Run("Ignores responses with a different nonce", func(t T) {
+		handler := pcpGatewayHandler(0)
+		mapper := newPCPMapper(fakeGateway(t, func(r []byte) []byte {
+			response := handler(r)
+			response[pcpHeaderSize] ^= 0xff
+			return response
+		}))
+
+		ctx, cancel := context.WithTimeout(ctx, 300*time.Millisecond)
+		defer cancel()
+		_, err := mapper.addPortMapping(ctx, "UDP", 8080, time.Hour)
+		assert.Error(t, err)
+	})
+
+	t.Run("Reports gateway result codes", func(t T) {
+		mapper := newPCPMapper(fakeGateway(t, pcpGatewayHandler(8)))
+
+		_, err := mapper.addPortMapping(ctx, "TCP", 8080, time.Hour)
+		assert.ErrorContains(t, err, "no resources")
+	})
+}
@@ -0,0 +1,168 @@
+package mau
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNoPortMapper           = errors.New("No port mapping mechanism succeeded")
+	ErrNoDefaultGateway       = errors.New("Can't find default gateway")
+	ErrUnsupportedMapProtocol = errors.New("Port mapping protocol must be TCP or UDP")
+)
+
+// PortMapping describes a port forwarded by the network gateway to this
+// machine and the mechanism (upnp, pcp or natpmp) that created it.
+type PortMapping struct {
+	Mechanism    string
+	Protocol     string
+	ExternalIP   net.IP
+	ExternalPort uint16
+	InternalPort uint16
+	Lifetime     time.Duration
+}
+
+// ExternalAddress returns the IP:Port other peers can use to reach the mapped port
+func (m *PortMapping) ExternalAddress() string {
+	return net.JoinHostPort(m.ExternalIP.String(), strconv.Itoa(int(m.ExternalPort)))
+}
+
+// portMapper is implemented by every mechanism that can ask the gateway to
+// forward an external port to a local one.
+type portMapper interface {
+	name() string
+	addPortMapping(ctx context.Context, protocol string, internalPort uint16, lifetime time.Duration) (*PortMapping, error)
+}
+
+// MapPort asks the network gateway to forward an external port to
+// internalPort. It tries UPnP, PCP then NAT-PMP in order and returns the first
+// mapping that succeeds. The mapping expires after the granted lifetime so
+// callers should call MapPort again before it elapses.
+func MapPort(ctx context.Context, protocol string, internalPort uint16, lifetime time.Duration) (*PortMapping, error) {
+	return mapPort(ctx, defaultPortMappers(), protocol, internalPort, lifetime)
+}
+
+// MapPortVia is MapPort sending the PCP and NAT-PMP requests to gateway, for
+// systems where the default gateway can't be found
+func MapPortVia(ctx context.Context, gateway net.IP, protocol string, internalPort uint16, lifetime time.Duration) (*PortMapping, error) {
+	return mapPort(ctx, gatewayPortMappers(gateway), protocol, internalPort, lifetime)
+}
+
+// defaultPortMappers returns the mechanisms of the default gateway. without a
+// gateway PCP and NAT-PMP fail with the reason so MapPort reports it.
+func defaultPortMappers() []portMapper {
+	gateway, err := defaultGateway()
+	if err != nil {
+		return []portMapper{
+			newUPNPMapper(),
+			&unavailablePortMapper{mechanism: "pcp", err: err},
+			&unavailablePortMapper{mechanism: "natpmp", err: err},
+		}
+	}
+
+	return gatewayPortMappers(gateway)
+}
+
+func gatewayPortMappers(gateway net.IP) []portMapper {
+	address := net.JoinHostPort(gateway.String(), strconv.Itoa(natpmpPort))
+	return []portMapper{newUPNPMapper(), newPCPMapper(address), newNATPMPMapper(address)}
+}
+
+// unavailablePortMapper is a mechanism that can't be used on this system
+type unavailablePortMapper struct {
+	mechanism string
+	err       error
+}
+
+func (u *unavailablePortMapper) name() string { return u.mechanism }
+
+func (u *unavailablePortMapper) addPortMapping(ctx context.Context, protocol string, internalPort uint16, lifetime time.Duration) (*PortMapping, error) {
+	return nil, u.err
+}
+
+func mapPort(ctx context.Context, mappers []portMapper, protocol string, internalPort uint16, lifetime time.Duration) (*PortMapping, error) {
+	protocol = strings.ToUpper(protocol)
+	if protocol != "TCP" && protocol != "UDP" {
+		return nil, ErrUnsupportedMapProtocol
+	}
+
+	errs := []error{ErrNoPortMapper}
+	for _, m := range mappers {
+		mapping, err := m.addPortMapping(ctx, protocol, internalPort, lifetime)
+		if err == nil {
+			mapping.Mechanism = m.name()
+			return mapping, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", m.name(), err))
+	}
+
+	return nil, errors.Join(errs...)
+}
+
+// udpRoundTrip sends a request to a NAT-PMP/PCP gateway and waits for a
+// response accepted by the valid function. Requests are retransmitted with a
+// doubling timeout as both protocols run over unreliable UDP.
+func udpRoundTrip(ctx context.Context, address string, request []byte, valid func([]byte) bool) ([]byte, error) {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = conn.Close() }()
+
+	timeout := portMapInitialTimeout
+	for range portMapAttempts {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		response, err := udpAttempt(ctx, conn, request, timeout, valid)
+		if err == nil {
+			return response, nil
+		}
+		timeout *= 2
+	}
+
+	return nil, fmt.Errorf("no response from gateway %s", address)
+}
+
+func udpAttempt(ctx context.Context, conn net.Conn, request []byte, timeout time.Duration, valid func([]byte) bool) ([]byte, error) {
+	if _, err := conn.Write(request); err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 1100) // maximum PCP message size
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		if valid(buf[:n]) {
+			return buf[:n], nil
+		}
+	}
+}
+
+// outboundIP returns the local IP address the system uses to reach address.
+// no packets are sent as UDP sockets are connectionless.
+func outboundIP(address string) (net.IP, error) {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = conn.Close() }()
+
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
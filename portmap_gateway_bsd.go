@@ -0,0 +1,42 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package mau
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+
+	"golang.org/x/net/route"
+)
+
+// defaultGateway returns the IPv4 default gateway from the routing socket
+func defaultGateway() (net.IP, error) {
+	rib, err := route.FetchRIB(syscall.AF_INET, route.RIBTypeRoute, 0)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNoDefaultGateway, err)
+	}
+
+	messages, err := route.ParseRIB(route.RIBTypeRoute, rib)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNoDefaultGateway, err)
+	}
+
+	for _, m := range messages {
+		r, ok := m.(*route.RouteMessage)
+		if !ok || r.Flags&syscall.RTF_GATEWAY == 0 || len(r.Addrs) <= syscall.RTAX_GATEWAY {
+			continue
+		}
+
+		destination, ok := r.Addrs[syscall.RTAX_DST].(*route.Inet4Addr)
+		if !ok || destination.IP != [net.IPv4len]byte{} {
+			continue
+		}
+
+		if gateway, ok := r.Addrs[syscall.RTAX_GATEWAY].(*route.Inet4Addr); ok {
+			return net.IP(gateway.IP[:]), nil
+		}
+	}
+
+	return nil, ErrNoDefaultGateway
+}
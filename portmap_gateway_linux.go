@@ -0,0 +1,47 @@
+package mau
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+)
+
+var routeFile = "/proc/net/route"
+
+// defaultGateway returns the IPv4 default gateway from the kernel routing table
+func defaultGateway() (net.IP, error) {
+	f, err := os.Open(routeFile)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNoDefaultGateway, err)
+	}
+	defer func() { _ = f.Close() }()
+
+	return parseDefaultGateway(f)
+}
+
+func parseDefaultGateway(r io.Reader) (net.IP, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || fields[1] != "00000000" {
+			continue
+		}
+
+		gateway, err := hex.DecodeString(fields[2])
+		if err != nil || len(gateway) != net.IPv4len {
+			continue
+		}
+
+		// the routing table stores addresses in host (little endian) byte order
+		ip := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(ip, binary.LittleEndian.Uint32(gateway))
+		return ip, nil
+	}
+
+	return nil, ErrNoDefaultGateway
+}
@@ -0,0 +1,26 @@
+package mau
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDefaultGateway(t *testing.T) {
+	t.Run("Finds the default route", func(t T) {
+		table := "Iface\tDestination\tGateway \tFlags\tRefCnt\tUse\tMetric\tMask\n" +
+			"eth0\t0000A8C0\t00000000\t0001\t0\t0\t100\t00FFFFFF\n" +
+			"eth0\t00000000\t0100A8C0\t0003\t0\t0\t100\t00000000\n"
+
+		gateway, err := parseDefaultGateway(strings.NewReader(table))
+		require.NoError(t, err)
+		assert.Equal(t, "192.168.0.1", gateway.String())
+	})
+
+	t.Run("Fails without a default route", func(t T) {
+		_, err := parseDefaultGateway(strings.NewReader("Iface\tDestination\tGateway\n"))
+		assert.ErrorIs(t, err, ErrNoDefaultGateway)
+	})
+}
@@ -0,0 +1,15 @@
+//go:build !linux && !windows && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+
+package mau
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+)
+
+// defaultGateway can't read the routing table of this system, MapPortVia
+// takes the gateway instead
+func defaultGateway() (net.IP, error) {
+	return nil, fmt.Errorf("%w: unsupported on %s, use MapPortVia", ErrNoDefaultGateway, runtime.GOOS)
+}
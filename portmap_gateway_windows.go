@@ -0,0 +1,40 @@
+package mau
+
+import (
+	"fmt"
+	"net"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// defaultGateway returns the IPv4 default gateway of the route with the
+// lowest metric from the IP forward table
+func defaultGateway() (net.IP, error) {
+	var table *windows.MibIpForwardTable2
+	if err := windows.GetIpForwardTable2(windows.AF_INET, &table); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNoDefaultGateway, err)
+	}
+	defer windows.FreeMibTable(unsafe.Pointer(table))
+
+	var gateway net.IP
+	var metric uint32
+	for _, row := range table.Rows() {
+		if row.DestinationPrefix.PrefixLength != 0 {
+			continue
+		}
+
+		hop := (*windows.RawSockaddrInet4)(unsafe.Pointer(&row.NextHop))
+		ip := net.IP(append([]byte{}, hop.Addr[:]...))
+		if ip.IsUnspecified() || (gateway != nil && row.Metric >= metric) {
+			continue
+		}
+		gateway, metric = ip, row.Metric
+	}
+
+	if gateway == nil {
+		return nil, ErrNoDefaultGateway
+	}
+
+	return gateway, nil
+}
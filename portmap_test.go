@@ -0,0 +1,69 @@
+package mau
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakePortMapper struct {
+	mechanism string
+	err       error
+	called    bool
+}
+
+func (f *fakePortMapper) name() string { return f.mechanism }
+
+func (f *fakePortMapper) addPortMapping(ctx context.Context, protocol string, internalPort uint16, lifetime time.Duration) (*PortMapping, error) {
+	f.called = true
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &PortMapping{Protocol: protocol, ExternalIP: net.IPv4(192, 0, 2, 1), ExternalPort: internalPort, InternalPort: internalPort}, nil
+}
+
+func TestMapPort(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Reports the first mechanism that succeeds", func(t T) {
+		upnp := &fakePortMapper{mechanism: "upnp", err: errors.New("no IGD")}
+		pcp := &fakePortMapper{mechanism: "pcp"}
+		natpmp := &fakePortMapper{mechanism: "natpmp"}
+
+		mapping, err := mapPort(ctx, []portMapper{upnp, pcp, natpmp}, "tcp", 80, time.Hour)
+		require.NoError(t, err)
+		assert.Equal(t, "pcp", mapping.Mechanism)
+		assert.Equal(t, "TCP", mapping.Protocol)
+		assert.True(t, upnp.called)
+		assert.False(t, natpmp.called)
+	})
+
+	t.Run("Joins errors when every mechanism fails", func(t T) {
+		upnp := &fakePortMapper{mechanism: "upnp", err: errors.New("no IGD")}
+		natpmp := &fakePortMapper{mechanism: "natpmp", err: errors.New("timeout")}
+
+		_, err := mapPort(ctx, []portMapper{upnp, natpmp}, "UDP", 80, time.Hour)
+		assert.ErrorIs(t, err, ErrNoPortMapper)
+		assert.ErrorContains(t, err, "upnp: no IGD")
+		assert.ErrorContains(t, err, "natpmp: timeout")
+	})
+
+	t.Run("Reports the mechanisms without a gateway", func(t T) {
+		upnp := &fakePortMapper{mechanism: "upnp", err: errors.New("no IGD")}
+		pcp := &unavailablePortMapper{mechanism: "pcp", err: ErrNoDefaultGateway}
+
+		_, err := mapPort(ctx, []portMapper{upnp, pcp}, "TCP", 80, time.Hour)
+		assert.ErrorIs(t, err, ErrNoDefaultGateway)
+		assert.ErrorContains(t, err, "pcp: Can't find default gateway")
+	})
+
+	t.Run("Rejects unknown protocols", func(t T) {
+		_, err := mapPort(ctx, []portMapper{&fakePortMapper{mechanism: "pcp"}}, "SCTP", 80, time.Hour)
+		assert.ErrorIs(t, err, ErrUnsupportedMapProtocol)
+	})
+}
@@ -0,0 +1,125 @@
+package mau
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// QUIC transport: the same router is served over HTTP/3 with the account
+// certificate, peers are authenticated by the same mutual TLS as over TCP.
+// the QUIC port is advertised next to the TCP one in the mDNS TXT record and
+// as a quic:// address in the DHT so peers know they can dial it.
+
+func createQUICServer(router *http.ServeMux, cert tls.Certificate) *http3.Server {
+	return &http3.Server{
+		Handler:   router,
+		TLSConfig: http3.ConfigureTLSConfig(createServerTLSConfig(cert)),
+	}
+}
+
+// ServeQUIC serves the account over HTTP/3 on a UDP connection. it can run
+// alongside Serve, usually on the same port number, so peers can reach the
+// server on quic:// addresses
+func (s *Server) ServeQUIC(conn net.PacketConn) error {
+	transport := &quic.Transport{Conn: conn}
+	listener, err := transport.ListenEarly(s.quicServer.TLSConfig, nil)
+	if err != nil {
+		return err
+	}
+
+	s.quicMutex.Lock()
+	s.quicTransport = transport
+	if addr, ok := conn.LocalAddr().(*net.UDPAddr); ok {
+		s.quicPort = addr.Port
+	}
+	err = s.advertiseQUIC()
+	s.quicMutex.Unlock()
+	if err != nil {
+		_ = listener.Close()
+		return err
+	}
+
+	return s.quicServer.ServeListener(listener)
+}
+
+// advertiseQUIC adds the QUIC port to the mDNS announcement and the DHT
+// addresses if they're already served, quicMutex must be held
+func (s *Server) advertiseQUIC() error {
+	if s.dhtServer != nil {
+		s.dhtServer.advertiseQUIC(s.quicPort)
+	}
+
+	if s.mdnsPort == 0 {
+		return nil
+	}
+
+	return s.announceMDNS()
+}
+
+// servingTransport returns the QUIC transport the server is served on or nil
+// before ServeQUIC is called
+func (s *Server) servingTransport() *quic.Transport {
+	s.quicMutex.Lock()
+	defer s.quicMutex.Unlock()
+
+	return s.quicTransport
+}
+
+// ListenUDP creates a UDP socket for ServeQUIC. like ListenTCP it tries
+// dual-stack first then falls back to IPv4 only.
+func ListenUDP(addr string) (net.PacketConn, error) {
+	conn, err := net.ListenPacket("udp", addr)
+	if err == nil {
+		return conn, nil
+	}
+
+	return net.ListenPacket("udp4", addr)
+}
+
+// peerTransport routes requests to the TCP transport or, for URLs with the
+// quic or webrtc scheme, to the HTTP/3 or WebRTC transport
+type peerTransport struct {
+	tcp    http.RoundTripper
+	quic   http.RoundTripper
+	webrtc http.RoundTripper
+}
+
+func (c *Client) createPeerTransport(tcp http.RoundTripper, cert tls.Certificate) *peerTransport {
+	return &peerTransport{
+		tcp:    tcp,
+		quic:   &http3.Transport{TLSClientConfig: c.createTLSConfig(cert), Dial: c.dialQUIC},
+		webrtc: c.webrtc,
+	}
+}
+
+// dialQUIC punches a hole to the peer for addresses of friends coordinating
+// hole punching to it, other addresses are dialed directly
+func (c *Client) dialQUIC(ctx context.Context, addr string, tlsConf *tls.Config, conf *quic.Config) (*quic.Conn, error) {
+	c.viaMutex.RLock()
+	rendezvous, ok := c.rendezvous[addr]
+	c.viaMutex.RUnlock()
+
+	if ok {
+		return c.account.dialThroughRendezvous(ctx, rendezvous, c.peer, tlsConf, conf)
+	}
+
+	return quic.DialAddrEarly(ctx, addr, tlsConf, conf)
+}
+
+func (t *peerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	switch req.URL.Scheme {
+	case TransportQUIC:
+		req = req.Clone(req.Context())
+		req.URL.Scheme = uriProtocolName
+		return t.quic.RoundTrip(req)
+	case TransportWebRTC:
+		return t.webrtc.RoundTrip(req)
+	default:
+		return t.tcp.RoundTrip(req)
+	}
+}
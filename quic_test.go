@@ -0,0 +1,117 @@
+package mau
+
+import (
+	"context"
+	"net"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQUIC(t *testing.T) {
+	friend, err := NewAccount(t.TempDir(), "Mohamed Mahmoud", "mohamed@example.com", "password")
+	require.NoError(t, err)
+	accountDir := t.TempDir()
+	account, err := NewAccount(accountDir, "Ahmed Mohamed", "ahmed@example.com", "password")
+	require.NoError(t, err)
+
+	aFriend := befriend(t, friend, account)
+	require.NoError(t, account.Follow(befriend(t, account, friend)))
+
+	server, err := friend.Server(nil)
+	require.NoError(t, err)
+	defer server.Close()
+
+	conn, err := ListenUDP("127.0.0.1:0")
+	require.NoError(t, err)
+	go func() { _ = server.ServeQUIC(conn) }()
+	address := QUICAddress(conn.LocalAddr().String())
+
+	_, err = friend.AddFile(strings.NewReader("Hello over QUIC"), "hello.txt", []*Friend{aFriend})
+	require.NoError(t, err)
+
+	t.Run("Downloads files over HTTP/3", func(t T) {
+		client, err := account.Client(friend.Fingerprint(), nil)
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		err = client.DownloadFriend(ctx, friend.Fingerprint(), time.Time{}, []FingerprintResolver{StaticAddress(address)})
+		require.NoError(t, err)
+
+		assert.FileExists(t, path.Join(accountDir, friend.Fingerprint().String(), "hello.txt.pgp"))
+	})
+
+	t.Run("Refuses servers with a wrong fingerprint", func(t T) {
+		client, err := account.Client(account.Fingerprint(), nil)
+		require.NoError(t, err)
+
+		_, err = client.fetchFileList(context.Background(), friend.Fingerprint(), address, time.Time{})
+		assert.ErrorIs(t, err, ErrIncorrectPeerCertificate)
+	})
+}
+
+func TestQUICAdvertised(t *testing.T) {
+	bootstrap, err := NewAccount(t.TempDir(), "Main peer", "main@example.com", "password")
+	require.NoError(t, err)
+	listener, bootstrapAddr := TempListener()
+	bootstrapServer, err := bootstrap.Server(nil)
+	require.NoError(t, err)
+	defer bootstrapServer.Close()
+	go func() { _ = bootstrapServer.Serve(*listener, bootstrapAddr) }()
+
+	peer, err := NewAccount(t.TempDir(), "Peer", "peer@example.com", "password")
+	require.NoError(t, err)
+	server, err := peer.Server([]*Peer{{Fingerprint: bootstrap.Fingerprint(), Address: bootstrapAddr}})
+	require.NoError(t, err)
+	defer server.Close()
+
+	conn, err := ListenUDP("127.0.0.1:0")
+	require.NoError(t, err)
+	go func() { _ = server.ServeQUIC(conn) }()
+	require.Eventually(t, func() bool { return server.servingTransport() != nil }, time.Second, time.Millisecond)
+
+	peerListener, peerAddr := TempListener()
+	go func() { _ = server.Serve(*peerListener, peerAddr) }()
+
+	_, port, err := net.SplitHostPort(conn.LocalAddr().String())
+	require.NoError(t, err)
+	quicAddress := QUICAddress(net.JoinHostPort("0.0.0.0", port))
+
+	dhtServer := func(s *Server) *dhtServer {
+		s.quicMutex.Lock()
+		defer s.quicMutex.Unlock()
+		return s.dhtServer
+	}
+
+	t.Run("Advertises the QUIC address in the certificate", func(t T) {
+		require.Eventually(t, func() bool { return dhtServer(server) != nil }, time.Second, time.Millisecond)
+		assert.Equal(t, []string{peerAddr, quicAddress}, dhtServer(server).advertised())
+	})
+
+	t.Run("Peers learn the QUIC address from the DHT", func(t T) {
+		require.Eventually(t, func() bool {
+			return dhtServer(bootstrapServer) != nil && len(dhtServer(bootstrapServer).nearest(peer.Fingerprint(), 1)) == 1
+		}, 5*time.Second, 10*time.Millisecond)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		addresses := make(chan string, 2)
+		require.NoError(t, InternetFriendAddress(bootstrapServer)(ctx, peer.Fingerprint(), addresses))
+
+		assert.Equal(t, peerAddr, <-addresses)
+		assert.Equal(t, quicAddress, <-addresses)
+	})
+}
+
+func TestListenUDP(t *testing.T) {
+	conn, err := ListenUDP(":0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	assert.Contains(t, conn.LocalAddr().Network(), "udp")
+}
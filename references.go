@@ -0,0 +1,302 @@
+package mau
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path"
+	"slices"
+	"strings"
+)
+
+// References: documents refer to other files with their Mau address, like the
+// parentItem of a comment or the object of a like. an address is resolved to
+// the file in the author directory of the account, followed or hidden, and
+// fetched on demand from the author or peers holding a copy when it's
+// missing. fetched files of authors the account doesn't follow are kept in
+// their hidden directory so they don't show in the feed.
+
+var (
+	ErrInvalidAddress     = errors.New("Invalid Mau address")
+	ErrReferenceNotFound  = errors.New("Referenced file not found")
+	ErrReferenceNotSigned = errors.New("Referenced file isn't signed by its author")
+)
+
+// Address is the Mau address of a file, /p2p/<FPR>/<file>, or of one of its
+// versions, /p2p/<FPR>/<file>.versions/<hash>
+type Address struct {
+	Fingerprint Fingerprint
+	Name        string
+	Version     string // empty for the current version
+}
+
+// ParseAddress parses a Mau address, the .pgp extension of the file name is
+// optional
+func ParseAddress(s string) (*Address, error) {
+	segments := strings.Split(strings.TrimPrefix(s, "/p2p/"), "/")
+	if !strings.HasPrefix(s, "/p2p/") || len(segments) < 2 || len(segments) > 3 {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidAddress, s)
+	}
+
+	fpr, err := FingerprintFromString(segments[0])
+	if err != nil || len(fpr) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidAddress, s)
+	}
+
+	addr := &Address{Fingerprint: fpr, Name: segments[1]}
+	if len(segments) == 3 {
+		addr.Name, addr.Version = strings.TrimSuffix(segments[1], ".versions"), segments[2]
+		if addr.Name == segments[1] || validateFileName(addr.Version) != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidAddress, s)
+		}
+	}
+
+	if validateFileName(addr.Name) != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidAddress, s)
+	}
+	addr.Name = ensurePGPExtension(addr.Name)
+
+	return addr, nil
+}
+
+func (a *Address) String() string {
+	if a.Version != "" {
+		return fileListPath(a.Fingerprint, a.Name+".versions/"+a.Version)
+	}
+
+	return fileListPath(a.Fingerprint, a.Name)
+}
+
+// Address returns the Mau address of the item
+func (i *FeedItem) Address() string {
+	return fileListPath(i.Author.Fingerprint(), i.File.Name())
+}
+
+// Resolve returns the verified document at the address from the files of the
+// account, ErrReferenceNotFound when it doesn't have it
+func (a *Account) Resolve(address string) (*FeedItem, error) {
+	addr, err := ParseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+
+	author, err := a.author(addr.Fingerprint)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := a.referenceFile(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return a.readItem(file, author)
+}
+
+// Fetch resolves the address like Resolve, downloading the file when the
+// account doesn't have it from its author then from peers, friends who may
+// have a copy of it
+func (a *Account) Fetch(ctx context.Context, address string, peers []Fingerprint, resolvers []FingerprintResolver) (*FeedItem, error) {
+	addr, err := ParseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := a.fetchFile(ctx, addr, peers, resolvers); err != nil {
+		return nil, err
+	}
+
+	return a.Resolve(address)
+}
+
+// fetchFile returns the file at addr, downloading it when missing
+func (a *Account) fetchFile(ctx context.Context, addr *Address, peers []Fingerprint, resolvers []FingerprintResolver) (*File, error) {
+	if _, err := a.author(addr.Fingerprint); err != nil {
+		return nil, err
+	}
+
+	file, err := a.referenceFile(addr)
+	if !errors.Is(err, ErrReferenceNotFound) {
+		return file, err
+	}
+
+	for _, peer := range append([]Fingerprint{addr.Fingerprint}, peers...) {
+		if err := a.fetchReference(ctx, addr, peer, resolvers); err != nil {
+			slog.Info("failed to fetch reference", "address", addr, "peer", peer, "error", err)
+			continue
+		}
+
+		return a.referenceFile(addr)
+	}
+
+	return nil, err
+}
+
+// author returns the account or the friend with the fingerprint, the keys
+// files are verified with
+func (a *Account) author(fpr Fingerprint) (*Friend, error) {
+	if fpr.Equal(a.Fingerprint()) {
+		return &Friend{entity: a.entity}, nil
+	}
+
+	keyring, err := a.ListFriends()
+	if err != nil {
+		return nil, err
+	}
+
+	if friend := keyring.FindByFingerprint(fpr); friend != nil {
+		return friend, nil
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrCantFindFriend, fpr)
+}
+
+func (a *Account) referenceFile(addr *Address) (*File, error) {
+	var file *File
+	var err error
+	if addr.Version != "" {
+		file, err = a.GetFileVersion(addr.Fingerprint, addr.Name, addr.Version)
+	} else {
+		file, err = a.GetFile(addr.Fingerprint, addr.Name)
+	}
+
+	if err != nil || !exists(a.storage, file.Path) {
+		return nil, fmt.Errorf("%w: %s", ErrReferenceNotFound, addr)
+	}
+
+	return file, nil
+}
+
+// referencePath is where a fetched file is saved: the author directory, the
+// hidden one if the account doesn't have it
+func (a *Account) referencePath(addr *Address) string {
+	dir, err := a.resolveFriendPath(addr.Fingerprint, "")
+	if err != nil {
+		dir = path.Join(a.path, "."+addr.Fingerprint.String())
+	}
+
+	if addr.Version != "" {
+		return path.Join(dir, addr.Name+".versions", addr.Version)
+	}
+
+	return path.Join(dir, addr.Name)
+}
+
+func (a *Account) fetchReference(ctx context.Context, addr *Address, peer Fingerprint, resolvers []FingerprintResolver) error {
+	ctx, cancel := context.WithTimeout(ctx, httpClientTimeout)
+	defer cancel()
+
+	client, err := a.Client(peer, nil)
+	if err != nil {
+		return err
+	}
+
+	address, err := client.resolveFingerprintAddress(ctx, peer, resolvers)
+	if err != nil {
+		return err
+	}
+
+	data, err := client.downloadPath(ctx, address, addr.String(), referenceMaxSize)
+	if err != nil {
+		return err
+	}
+
+	if addr.Version != "" && addr.Version != fmt.Sprintf("%x", sha256.Sum256(data)) {
+		return ErrVersionNameMismatch
+	}
+
+	return client.saveReference(a.referencePath(addr), addr, data)
+}
+
+// saveReference saves the fetched file at p after checking its author signed
+// it
+func (c *Client) saveReference(p string, addr *Address, data []byte) error {
+	if err := c.account.storage.MkdirAll(path.Dir(p)); err != nil {
+		return err
+	}
+
+	f := &File{Path: p, version: addr.Version != "", storage: c.account.storage}
+	tmpPath, err := c.writeAndVerifyTemp(f, data, addr.Fingerprint)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrReferenceNotSigned, err)
+	}
+
+	if err := c.account.storage.Rename(tmpPath, f.Path); err != nil {
+		_ = c.account.storage.Remove(tmpPath)
+		return err
+	}
+
+	return c.indexDownload(f, data)
+}
+
+// readItem verifies the file was signed by author and decodes it
+func (a *Account) readItem(file *File, author *Friend) (*FeedItem, error) {
+	data, signed, err := a.readSigned(file, author)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrReferenceNotSigned, err)
+	}
+
+	content, err := decodeContent(file.Name(), data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FeedItem{File: file, Author: author, Signed: signed, Content: content}, nil
+}
+
+// Thread is a document and the comments replying to it, each with its own
+// replies, oldest first
+type Thread struct {
+	*FeedItem
+	Replies []*Thread
+}
+
+// Thread returns the document at the address and the comments of the account
+// and its follows replying to it
+func (a *Account) Thread(address string) (*Thread, error) {
+	root, err := a.Resolve(address)
+	if err != nil {
+		return nil, err
+	}
+
+	comments, err := a.Feed(FeedOptions{Types: []string{"Comment"}})
+	if err != nil {
+		return nil, err
+	}
+
+	replies := map[string][]*FeedItem{} // key: address of the parent
+	for _, c := range slices.Backward(comments) {
+		if parent := c.Content.(*Comment).ParentItem; parent != nil {
+			key := canonicalAddress(parent.ID)
+			replies[key] = append(replies[key], c)
+		}
+	}
+
+	return buildThread(root, canonicalAddress(address), replies, map[string]bool{}), nil
+}
+
+func buildThread(item *FeedItem, address string, replies map[string][]*FeedItem, seen map[string]bool) *Thread {
+	thread := &Thread{FeedItem: item, Replies: []*Thread{}}
+	seen[address] = true
+
+	for _, reply := range replies[address] {
+		if !seen[reply.Address()] {
+			thread.Replies = append(thread.Replies, buildThread(reply, reply.Address(), replies, seen))
+		}
+	}
+
+	return thread
+}
+
+// canonicalAddress spells addresses the same way so references to the same
+// file match
+func canonicalAddress(address string) string {
+	addr, err := ParseAddress(address)
+	if err != nil {
+		return address
+	}
+
+	return addr.String()
+}
@@ -0,0 +1,150 @@
+package mau
+
+import (
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAddress(t *testing.T) {
+	fpr := "5d000b2f2c040a1675b49d7f0c7cb7dc36999d56"
+
+	addr, err := ParseAddress("/p2p/" + fpr + "/hello.json.pgp")
+	require.NoError(t, err)
+	assert.Equal(t, fpr, addr.Fingerprint.String())
+	assert.Equal(t, "hello.json.pgp", addr.Name)
+	assert.Empty(t, addr.Version)
+
+	addr, err = ParseAddress("/p2p/" + fpr + "/hello.json")
+	require.NoError(t, err)
+	assert.Equal(t, "/p2p/"+fpr+"/hello.json.pgp", addr.String())
+
+	addr, err = ParseAddress("/p2p/" + fpr + "/hello.json.pgp.versions/abc")
+	require.NoError(t, err)
+	assert.Equal(t, "hello.json.pgp", addr.Name)
+	assert.Equal(t, "abc", addr.Version)
+	assert.Equal(t, "/p2p/"+fpr+"/hello.json.pgp.versions/abc", addr.String())
+
+	for _, invalid := range []string{
+		"",
+		"hello.json",
+		"/p2p/" + fpr,
+		"/p2p/not-hex/hello.json",
+		"/p2p/" + fpr + "/..",
+		"/p2p/" + fpr + "/hello.json/abc",
+		"/p2p/" + fpr + "/a/b/c/d",
+	} {
+		_, err := ParseAddress(invalid)
+		assert.ErrorIs(t, err, ErrInvalidAddress, invalid)
+	}
+}
+
+// serve serves the account on a temporary listener until the test ends
+func serve(t *testing.T, account *Account) FingerprintResolver {
+	server, err := account.Server(nil)
+	require.NoError(t, err)
+
+	listener, address := TempListener()
+	go func() { _ = server.Serve(*listener, "") }()
+	t.Cleanup(func() { _ = server.Close() })
+
+	return StaticAddress(address)
+}
+
+func TestReferences(t *testing.T) {
+	newAccount := func(name string) *Account {
+		account, err := NewAccountWithStorage(NewMemoryStorage(), "/"+name, name, name+"@example.com", "password")
+		require.NoError(t, err)
+		return account
+	}
+	alice, bob, carol := newAccount("alice"), newAccount("bob"), newAccount("carol")
+
+	bobFriend, carolFriend := befriend(t, alice, bob), befriend(t, alice, carol)
+	aliceFriend := befriend(t, bob, alice)
+	befriend(t, bob, carol)
+	befriend(t, carol, alice)
+	befriend(t, carol, bob)
+	require.NoError(t, alice.Follow(bobFriend))
+	require.NoError(t, bob.Follow(aliceFriend))
+
+	atAlice, atBob := serve(t, alice), serve(t, bob)
+
+	post, err := alice.AddContent(&SocialMediaPosting{ArticleBody: "Hello from Alice"}, "hello.json", []*Friend{bobFriend, carolFriend})
+	require.NoError(t, err)
+	postAddress := fileListPath(alice.Fingerprint(), post.Name())
+
+	var commentAddress string
+
+	t.Run("Resolves the account own files", func(t T) {
+		item, err := alice.Resolve(postAddress)
+		require.NoError(t, err)
+		assert.Equal(t, "Hello from Alice", item.Content.(*SocialMediaPosting).ArticleBody)
+		assert.Equal(t, postAddress, item.Address())
+	})
+
+	t.Run("Reports missing files", func(t T) {
+		_, err := bob.Resolve(postAddress)
+		assert.ErrorIs(t, err, ErrReferenceNotFound)
+	})
+
+	t.Run("Fetches files from their author", func(t T) {
+		item, err := bob.Fetch(Timeout(5*time.Second), postAddress, nil, []FingerprintResolver{atAlice})
+		require.NoError(t, err)
+		assert.Equal(t, "Hello from Alice", item.Content.(*SocialMediaPosting).ArticleBody)
+		assert.Equal(t, alice.Fingerprint(), item.Author.Fingerprint())
+		assert.True(t, exists(bob.storage, path.Join(bob.path, alice.Fingerprint().String(), "hello.json.pgp")))
+
+		item, err = bob.Resolve(postAddress)
+		require.NoError(t, err)
+		assert.NotNil(t, item)
+	})
+
+	t.Run("Bob comments on Alice post", func(t T) {
+		comment := &Comment{Text: "Welcome Alice", ParentItem: &Reference{Type: "SocialMediaPosting", ID: postAddress}}
+		file, err := bob.AddContent(comment, "", []*Friend{aliceFriend})
+		require.NoError(t, err)
+		commentAddress = fileListPath(bob.Fingerprint(), file.Name())
+
+		item, err := alice.Fetch(Timeout(5*time.Second), commentAddress, nil, []FingerprintResolver{atBob})
+		require.NoError(t, err)
+		assert.Equal(t, postAddress, item.Content.(*Comment).ParentItem.ID)
+	})
+
+	t.Run("Builds reply threads", func(t T) {
+		// a bare address without .pgp as in hand written documents
+		reply := Document{"@type": "Comment", "text": "Thanks Bob", "parentItem": commentAddress[:len(commentAddress)-len(".pgp")]}
+		_, err := alice.AddContent(&reply, "", []*Friend{bobFriend})
+		require.NoError(t, err)
+
+		thread, err := alice.Thread(postAddress)
+		require.NoError(t, err)
+		assert.Equal(t, "Hello from Alice", thread.Content.(*SocialMediaPosting).ArticleBody)
+		require.Len(t, thread.Replies, 1)
+		assert.Equal(t, "Welcome Alice", thread.Replies[0].Content.(*Comment).Text)
+		assert.Equal(t, bob.Fingerprint(), thread.Replies[0].Author.Fingerprint())
+		require.Len(t, thread.Replies[0].Replies, 1)
+		assert.Equal(t, "Thanks Bob", thread.Replies[0].Replies[0].Content.(*Comment).Text)
+	})
+
+	t.Run("Fetches files from peers holding a copy", func(t T) {
+		// the author address resolves to Bob, whose certificate isn't Alice's
+		item, err := carol.Fetch(Timeout(5*time.Second), postAddress, []Fingerprint{bob.Fingerprint()}, []FingerprintResolver{atBob})
+		require.NoError(t, err)
+		assert.Equal(t, "Hello from Alice", item.Content.(*SocialMediaPosting).ArticleBody)
+
+		// carol doesn't follow Alice, the post is kept out of her feed
+		assert.True(t, exists(carol.storage, path.Join(carol.path, "."+alice.Fingerprint().String(), "hello.json.pgp")))
+		feed, err := carol.Feed(FeedOptions{})
+		require.NoError(t, err)
+		assert.Empty(t, feed)
+	})
+
+	t.Run("Rejects files of unknown authors", func(t T) {
+		stranger := newAccount("stranger")
+		_, err := carol.Fetch(Timeout(5*time.Second), fileListPath(stranger.Fingerprint(), "hello.json.pgp"), nil, []FingerprintResolver{atBob})
+		assert.ErrorIs(t, err, ErrCantFindFriend)
+	})
+}
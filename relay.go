@@ -0,0 +1,367 @@
+package mau
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Relay (circuit) connections: a reachable peer keeps a reverse connection
+// open for a friend behind NAT. other friends ask the relay to open a circuit
+// to that peer and the relay splices both TCP streams together. TLS between
+// the two ends is negotiated inside the circuit so the relay can't read or
+// modify the traffic.
+
+var (
+	ErrNotRelayFriend      = errors.New("Relay is restricted to friends")
+	ErrRelayPeerNotFound   = errors.New("Peer is not connected to the relay")
+	ErrRelayCircuitTimeout = errors.New("Peer didn't accept the relay circuit")
+)
+
+type relayServer struct {
+	mux      *http.ServeMux
+	account  *Account
+	mutex    sync.Mutex
+	peers    map[string]*relayPeer    // key: fingerprint hex string
+	circuits map[string]*relayCircuit // key: circuit ID
+}
+
+// relayPeer is a peer that keeps a reverse connection with the relay
+type relayPeer struct {
+	circuits chan string   // IDs of circuits waiting for the peer to accept
+	done     chan struct{} // closed when the peer registers again
+}
+
+// relayCircuit is a connection waiting for the target peer to accept it
+type relayCircuit struct {
+	target Fingerprint
+	conn   chan net.Conn
+}
+
+func newRelayServer(account *Account) *relayServer {
+	r := &relayServer{
+		mux:      http.NewServeMux(),
+		account:  account,
+		peers:    map[string]*relayPeer{},
+		circuits: map[string]*relayCircuit{},
+	}
+
+	r.mux.HandleFunc("GET /relay/register", r.register)
+	r.mux.HandleFunc("GET /relay/connect/{fpr}", r.connect)
+	r.mux.HandleFunc("GET /relay/accept/{id}", r.accept)
+
+	return r
+}
+
+func (r *relayServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mux.ServeHTTP(w, req)
+}
+
+// authorize returns the fingerprint of the requester if it's one of our friends
+func (r *relayServer) authorize(req *http.Request) (Fingerprint, error) {
+	return authorizeFriend(r.account, req, ErrNotRelayFriend)
+}
+
+// authorizeFriend returns the fingerprint of the requester or notFriend if
+// the requester isn't one of the account friends
+func authorizeFriend(account *Account, req *http.Request, notFriend error) (Fingerprint, error) {
+	fpr, err := requestPeer(req)
+	if err != nil {
+		return nil, err
+	}
+
+	friends, err := account.ListFriends()
+	if err != nil {
+		return nil, err
+	}
+
+	if friends.FindByFingerprint(fpr) == nil {
+		return nil, notFriend
+	}
+
+	return fpr, nil
+}
+
+// register keeps a stream open to the requester and writes the ID of every
+// circuit other peers open to it, one per line
+func (r *relayServer) register(w http.ResponseWriter, req *http.Request) {
+	fpr, err := r.authorize(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{}) // the stream outlives the server write timeout
+
+	peer := r.addPeer(fpr)
+	defer r.removePeer(fpr, peer)
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusOK)
+	_ = rc.Flush()
+
+	streamLines(w, rc, req, peer.done, peer.circuits)
+}
+
+// streamLines writes every line to the response until the request is done or
+// done is closed. empty lines are sent periodically as heartbeats
+func streamLines(w io.Writer, rc *http.ResponseController, req *http.Request, done <-chan struct{}, lines <-chan string) {
+	heartbeat := time.NewTicker(relayHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		var line string
+		select {
+		case <-req.Context().Done():
+			return
+		case <-done:
+			return
+		case <-heartbeat.C:
+			line = "\n" // keeps NAT mappings between the peer and the server alive
+		case l := <-lines:
+			line = l + "\n"
+		}
+
+		if _, err := io.WriteString(w, line); err != nil || rc.Flush() != nil {
+			return
+		}
+	}
+}
+
+func (r *relayServer) addPeer(fpr Fingerprint) *relayPeer {
+	peer := &relayPeer{
+		circuits: make(chan string, relayPendingCircuits),
+		done:     make(chan struct{}),
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if old, ok := r.peers[fpr.String()]; ok {
+		close(old.done)
+	}
+	r.peers[fpr.String()] = peer
+
+	return peer
+}
+
+func (r *relayServer) removePeer(fpr Fingerprint, peer *relayPeer) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.peers[fpr.String()] == peer {
+		delete(r.peers, fpr.String())
+	}
+}
+
+// connect opens a circuit to a registered peer and splices the requester
+// connection with the one the peer accepts the circuit on
+func (r *relayServer) connect(w http.ResponseWriter, req *http.Request) {
+	if _, err := r.authorize(req); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	target, err := FingerprintFromString(req.PathValue("fpr"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	r.relayCircuit(w, req, target)
+}
+
+func (r *relayServer) relayCircuit(w http.ResponseWriter, req *http.Request, target Fingerprint) {
+	id, circuit, err := r.openCircuit(target)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	defer r.closeCircuit(id)
+
+	select {
+	case peerConn := <-circuit.conn:
+		r.spliceRequest(w, peerConn)
+	case <-time.After(relayAcceptTimeout):
+		http.Error(w, ErrRelayCircuitTimeout.Error(), http.StatusGatewayTimeout)
+	case <-req.Context().Done():
+	}
+}
+
+func (r *relayServer) spliceRequest(w http.ResponseWriter, peerConn net.Conn) {
+	conn, err := upgradeRelayRequest(w)
+	if err != nil {
+		_ = peerConn.Close()
+		return
+	}
+
+	splice(conn, peerConn)
+}
+
+// openCircuit registers a new circuit and notifies the target peer about it
+func (r *relayServer) openCircuit(target Fingerprint) (string, *relayCircuit, error) {
+	id, err := newCircuitID()
+	if err != nil {
+		return "", nil, err
+	}
+
+	circuit := &relayCircuit{target: target, conn: make(chan net.Conn, 1)}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if err := r.notifyPeer(target, id); err != nil {
+		return "", nil, err
+	}
+
+	r.circuits[id] = circuit
+	return id, circuit, nil
+}
+
+// notifyPeer queues the circuit ID to be sent to the peer, the mutex must be held
+func (r *relayServer) notifyPeer(target Fingerprint, id string) error {
+	peer, ok := r.peers[target.String()]
+	if !ok {
+		return ErrRelayPeerNotFound
+	}
+
+	select {
+	case peer.circuits <- id:
+		return nil
+	default:
+		return fmt.Errorf("too many pending circuits for %s", target)
+	}
+}
+
+// closeCircuit removes the circuit, closing any connection accepted too late
+func (r *relayServer) closeCircuit(id string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	circuit := r.circuits[id]
+	delete(r.circuits, id)
+
+	select {
+	case conn := <-circuit.conn:
+		_ = conn.Close()
+	default:
+	}
+}
+
+func (r *relayServer) getCircuit(id string, target Fingerprint) *relayCircuit {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	circuit := r.circuits[id]
+	if circuit == nil || !circuit.target.Equal(target) {
+		return nil
+	}
+
+	return circuit
+}
+
+// deliver hands the peer connection to the circuit if it's still open
+func (r *relayServer) deliver(id string, conn net.Conn) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	circuit, ok := r.circuits[id]
+	if !ok {
+		return false
+	}
+
+	select {
+	case circuit.conn <- conn:
+		return true
+	default:
+		return false
+	}
+}
+
+// accept is called by the target peer on a new connection to take a circuit
+func (r *relayServer) accept(w http.ResponseWriter, req *http.Request) {
+	fpr, err := r.authorize(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	id := req.PathValue("id")
+	if r.getCircuit(id, fpr) == nil {
+		http.Error(w, "Circuit not found", http.StatusNotFound)
+		return
+	}
+
+	conn, err := upgradeRelayRequest(w)
+	if err != nil {
+		return
+	}
+
+	if !r.deliver(id, conn) {
+		_ = conn.Close()
+	}
+}
+
+// upgradeRelayRequest takes over the request connection and switches it to a raw stream
+func upgradeRelayRequest(w http.ResponseWriter) (net.Conn, error) {
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	_ = conn.SetDeadline(time.Time{})
+	_, err = fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: %s\r\n\r\n", relayProtocol)
+	if err == nil {
+		err = rw.Flush()
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return &bufferedConn{Conn: conn, reader: rw.Reader}, nil
+}
+
+// splice copies data between both connections until one of them is closed
+func splice(a, b net.Conn) {
+	done := make(chan struct{}, 2)
+	pipe := func(dst, src net.Conn) {
+		_, _ = io.Copy(dst, src)
+		done <- struct{}{}
+	}
+
+	go pipe(a, b)
+	go pipe(b, a)
+
+	<-done
+	_ = a.Close()
+	_ = b.Close()
+	<-done
+}
+
+func newCircuitID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// bufferedConn reads from a buffered reader that may hold bytes already read
+// from the connection while parsing the HTTP upgrade
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
@@ -0,0 +1,192 @@
+package mau
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// dialRelay opens a TLS connection to the relay, authenticating both sides,
+// and sends a request for path over it
+func (a *Account) dialRelay(ctx context.Context, relay *Peer, path string, upgrade bool) (net.Conn, *http.Response, error) {
+	config, err := a.relayTLSConfig(relay.Fingerprint)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dialer := tls.Dialer{Config: config}
+	conn, err := dialer.DialContext(ctx, "tcp", relay.Address)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resp, reader, err := relayHandshake(conn, relay.Address, path, upgrade)
+	if err != nil {
+		_ = conn.Close()
+		return nil, nil, err
+	}
+
+	return &bufferedConn{Conn: conn, reader: reader}, resp, nil
+}
+
+func (a *Account) relayTLSConfig(relay Fingerprint) (*tls.Config, error) {
+	config, err := a.peerTLSConfig(relay)
+	if err != nil {
+		return nil, err
+	}
+
+	config.NextProtos = []string{"http/1.1"} // connections are hijacked by the relay
+	return config, nil
+}
+
+// peerTLSConfig returns the client TLS configuration to connect to peer
+func (a *Account) peerTLSConfig(peer Fingerprint) (*tls.Config, error) {
+	cert, err := a.certificate(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{account: a, peer: peer}
+	return c.createTLSConfig(cert), nil
+}
+
+func relayHandshake(conn net.Conn, host, path string, upgrade bool) (*http.Response, *bufio.Reader, error) {
+	req, err := http.NewRequest(http.MethodGet, uriProtocolName+"://"+host+path, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	expected := http.StatusOK
+	if upgrade {
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", relayProtocol)
+		expected = http.StatusSwitchingProtocols
+	}
+
+	if err := req.Write(conn); err != nil {
+		return nil, nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if resp.StatusCode != expected {
+		return nil, nil, fmt.Errorf("relay responded with status %s", resp.Status)
+	}
+
+	return resp, reader, nil
+}
+
+// dialThroughRelay opens a circuit to peer through relay. the returned
+// connection is a raw stream to peer, TLS is still to be negotiated on it
+func (a *Account) dialThroughRelay(ctx context.Context, relay *Peer, peer Fingerprint) (net.Conn, error) {
+	conn, _, err := a.dialRelay(ctx, relay, "/relay/connect/"+peer.String(), true)
+	return conn, err
+}
+
+// ServeRelay keeps a reverse connection open with a relay peer so friends that
+// can't reach this server directly can connect to it through the relay. it
+// blocks until the context is cancelled or the connection to the relay drops.
+// the relay must have this account in its friends list.
+func (s *Server) ServeRelay(ctx context.Context, relay *Peer) error {
+	conn, resp, err := s.account.dialRelay(ctx, relay, "/relay/register", false)
+	if err != nil {
+		return fmt.Errorf("failed to register with relay %s: %w", relay.Fingerprint, err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+
+	listener := s.relayListener()
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if id := strings.TrimSpace(scanner.Text()); id != "" {
+			go s.acceptRelayCircuit(ctx, relay, id, listener)
+		}
+	}
+
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	return fmt.Errorf("lost connection to relay %s: %w", relay.Fingerprint, scanner.Err())
+}
+
+func (s *Server) acceptRelayCircuit(ctx context.Context, relay *Peer, id string, listener *connListener) {
+	conn, _, err := s.account.dialRelay(ctx, relay, "/relay/accept/"+id, true)
+	if err != nil {
+		slog.Error("failed to accept relay circuit", "relay", relay.Fingerprint, "error", err)
+		return
+	}
+
+	if !listener.push(ctx, conn) {
+		_ = conn.Close()
+	}
+}
+
+// relayListener returns the listener relayed connections are served from,
+// serving it the first time it's needed
+func (s *Server) relayListener() *connListener {
+	s.relayOnce.Do(func() {
+		s.relayConns = newConnListener(&net.TCPAddr{})
+		go func() {
+			_ = s.httpServer.ServeTLS(s.relayConns, "", "")
+		}()
+	})
+
+	return s.relayConns
+}
+
+// connListener is a net.Listener for connections that are established by
+// other means than accepting them on a socket
+type connListener struct {
+	addr  net.Addr
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+func newConnListener(addr net.Addr) *connListener {
+	return &connListener{
+		addr:  addr,
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+}
+
+func (l *connListener) push(ctx context.Context, conn net.Conn) bool {
+	select {
+	case l.conns <- conn:
+		return true
+	case <-l.done:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *connListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr { return l.addr }
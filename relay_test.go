@@ -0,0 +1,101 @@
+package mau
+
+import (
+	"bytes"
+	"context"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func befriend(t *testing.T, account, friend *Account) *Friend {
+	var key bytes.Buffer
+	require.NoError(t, friend.Export(&key))
+
+	f, err := account.AddFriend(&key)
+	require.NoError(t, err)
+	return f
+}
+
+func TestRelay(t *testing.T) {
+	relay, err := NewAccount(t.TempDir(), "Relay", "relay@example.com", "password")
+	require.NoError(t, err)
+	natted, err := NewAccount(t.TempDir(), "Behind NAT", "natted@example.com", "password")
+	require.NoError(t, err)
+	accountDir := t.TempDir()
+	account, err := NewAccount(accountDir, "Ahmed Mohamed", "ahmed@example.com", "password")
+	require.NoError(t, err)
+
+	befriend(t, relay, natted)
+	befriend(t, relay, account)
+	aFriend := befriend(t, natted, account)
+	require.NoError(t, account.Follow(befriend(t, account, natted)))
+
+	relayServer, err := relay.Server(nil)
+	require.NoError(t, err)
+	listener, address := TempListener()
+	go func() { _ = relayServer.Serve(*listener, "") }()
+	defer relayServer.Close()
+	relayPeer := &Peer{Fingerprint: relay.Fingerprint(), Address: address}
+
+	client, err := account.Client(natted.Fingerprint(), nil)
+	require.NoError(t, err)
+
+	t.Run("Fails when the peer isn't connected to the relay", func(t T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		err := client.DownloadFriend(ctx, natted.Fingerprint(), time.Time{}, []FingerprintResolver{ViaRelay(relayPeer)})
+		assert.ErrorContains(t, err, "404")
+	})
+
+	t.Run("Downloads files through the relay", func(t T) {
+		nattedServer, err := natted.Server(nil)
+		require.NoError(t, err)
+		defer nattedServer.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() { _ = nattedServer.ServeRelay(ctx, relayPeer) }()
+
+		_, err = natted.AddFile(strings.NewReader("Hello from behind NAT"), "hello.txt", []*Friend{aFriend})
+		require.NoError(t, err)
+
+		assert.Eventually(t, func() bool {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			err := client.DownloadFriend(ctx, natted.Fingerprint(), time.Time{}, []FingerprintResolver{ViaRelay(relayPeer)})
+			return err == nil
+		}, 10*time.Second, 100*time.Millisecond)
+
+		assert.FileExists(t, path.Join(accountDir, natted.Fingerprint().String(), "hello.txt.pgp"))
+	})
+
+	t.Run("Refuses peers that aren't friends of the relay", func(t T) {
+		stranger, err := NewAccount(t.TempDir(), "Stranger", "stranger@example.com", "password")
+		require.NoError(t, err)
+		strangerServer, err := stranger.Server(nil)
+		require.NoError(t, err)
+		defer strangerServer.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		err = strangerServer.ServeRelay(ctx, relayPeer)
+		assert.ErrorContains(t, err, "403")
+	})
+
+	t.Run("Refuses relays with a wrong fingerprint", func(t T) {
+		nattedServer, err := natted.Server(nil)
+		require.NoError(t, err)
+		defer nattedServer.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		err = nattedServer.ServeRelay(ctx, &Peer{Fingerprint: account.Fingerprint(), Address: address})
+		assert.ErrorIs(t, err, ErrIncorrectPeerCertificate)
+	})
+}
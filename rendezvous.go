@@ -0,0 +1,143 @@
+package mau
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// Rendezvous (UDP hole punching): a friend reachable by two peers behind NAT
+// sees the external UDP endpoint each of them talks to it from. the peer
+// accepting connections keeps a registration open with that friend, when the
+// other peer asks to connect the friend sends each of them the endpoint of the
+// other one. both sides then send packets to each other at the same time so
+// their NATs let the QUIC handshake through.
+
+var (
+	ErrNotRendezvousFriend    = errors.New("Rendezvous is restricted to friends")
+	ErrRendezvousPeerNotFound = errors.New("Peer is not registered for rendezvous")
+	ErrRendezvousRequiresQUIC = errors.New("Rendezvous requests must be sent over QUIC")
+)
+
+type rendezvousServer struct {
+	account *Account
+	mutex   sync.Mutex
+	peers   map[string]*rendezvousPeer // key: fingerprint hex string
+}
+
+// rendezvousPeer is a peer waiting for friends to punch holes to it
+type rendezvousPeer struct {
+	address   string        // external UDP endpoint of the peer
+	endpoints chan string   // endpoints of friends asking to connect
+	done      chan struct{} // closed when the peer registers again
+}
+
+func newRendezvousServer(account *Account) *rendezvousServer {
+	return &rendezvousServer{
+		account: account,
+		peers:   map[string]*rendezvousPeer{},
+	}
+}
+
+// authorize returns the fingerprint of the requester if it's one of our
+// friends talking to us over QUIC, the only transport with an endpoint
+// worth exchanging
+func (r *rendezvousServer) authorize(req *http.Request) (Fingerprint, error) {
+	if req.ProtoMajor != 3 {
+		return nil, ErrRendezvousRequiresQUIC
+	}
+
+	return authorizeFriend(r.account, req, ErrNotRendezvousFriend)
+}
+
+// register keeps a stream open to the requester and writes the endpoint of
+// every friend asking to connect to it, one per line
+func (r *rendezvousServer) register(w http.ResponseWriter, req *http.Request) {
+	fpr, err := r.authorize(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	peer := r.addPeer(fpr, req.RemoteAddr)
+	defer r.removePeer(fpr, peer)
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusOK)
+	_ = rc.Flush()
+
+	streamLines(w, rc, req, peer.done, peer.endpoints)
+}
+
+func (r *rendezvousServer) addPeer(fpr Fingerprint, address string) *rendezvousPeer {
+	peer := &rendezvousPeer{
+		address:   address,
+		endpoints: make(chan string, rendezvousPendingPeers),
+		done:      make(chan struct{}),
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if old, ok := r.peers[fpr.String()]; ok {
+		close(old.done)
+	}
+	r.peers[fpr.String()] = peer
+
+	return peer
+}
+
+func (r *rendezvousServer) removePeer(fpr Fingerprint, peer *rendezvousPeer) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.peers[fpr.String()] == peer {
+		delete(r.peers, fpr.String())
+	}
+}
+
+// connect sends the requester endpoint to the registered peer and responds
+// with the peer endpoint
+func (r *rendezvousServer) connect(w http.ResponseWriter, req *http.Request) {
+	if _, err := r.authorize(req); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	target, err := FingerprintFromString(req.PathValue("fpr"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	address, err := r.notifyPeer(target, req.RemoteAddr)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(&Peer{Fingerprint: target, Address: QUICAddress(address)})
+}
+
+// notifyPeer queues the endpoint to be sent to the target peer and returns
+// the target endpoint
+func (r *rendezvousServer) notifyPeer(target Fingerprint, endpoint string) (string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	peer, ok := r.peers[target.String()]
+	if !ok {
+		return "", ErrRendezvousPeerNotFound
+	}
+
+	select {
+	case peer.endpoints <- endpoint:
+		return peer.address, nil
+	default:
+		return "", fmt.Errorf("too many pending rendezvous for %s", target)
+	}
+}
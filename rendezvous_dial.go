@@ -0,0 +1,182 @@
+package mau
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+var ErrQUICNotServed = errors.New("Server is not served over QUIC")
+
+// quicClient returns an HTTP/3 transport to peer that sends its packets from
+// the UDP socket of transport so the peer observes the socket external endpoint
+func (a *Account) quicClient(peer Fingerprint, transport *quic.Transport) (*http3.Transport, error) {
+	config, err := a.peerTLSConfig(peer)
+	if err != nil {
+		return nil, err
+	}
+
+	dial := func(ctx context.Context, addr string, tlsConf *tls.Config, conf *quic.Config) (*quic.Conn, error) {
+		udpAddr, err := net.ResolveUDPAddr("udp", addr)
+		if err != nil {
+			return nil, err
+		}
+		return transport.DialEarly(ctx, udpAddr, tlsConf, conf)
+	}
+
+	return &http3.Transport{TLSClientConfig: config, Dial: dial}, nil
+}
+
+func rendezvousRequest(ctx context.Context, client http.RoundTripper, rendezvous *Peer, path string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uriProtocolName+"://"+rendezvous.Address+path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("rendezvous responded with status %s", resp.Status)
+	}
+
+	return resp, nil
+}
+
+// dialThroughRendezvous asks the rendezvous peer to exchange endpoints with
+// peer then dials peer from the same UDP socket while it punches a hole to us
+func (a *Account) dialThroughRendezvous(ctx context.Context, rendezvous *Peer, peer Fingerprint, tlsConf *tls.Config, conf *quic.Config) (*quic.Conn, error) {
+	conn, err := ListenUDP(":0")
+	if err != nil {
+		return nil, err
+	}
+
+	transport := &quic.Transport{Conn: conn}
+	qconn, err := a.punchThroughRendezvous(ctx, transport, rendezvous, peer, tlsConf, conf)
+	if err != nil {
+		_ = transport.Close()
+		_ = conn.Close()
+		return nil, err
+	}
+
+	context.AfterFunc(qconn.Context(), func() {
+		_ = transport.Close()
+		_ = conn.Close()
+	})
+
+	return qconn, nil
+}
+
+func (a *Account) punchThroughRendezvous(ctx context.Context, transport *quic.Transport, rendezvous *Peer, peer Fingerprint, tlsConf *tls.Config, conf *quic.Config) (*quic.Conn, error) {
+	endpoint, err := a.rendezvousConnect(ctx, transport, rendezvous, peer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rendezvous with %s through %s: %w", peer, rendezvous.Fingerprint, err)
+	}
+
+	// the first packets may be dropped until the peer punches its NAT, the
+	// handshake retransmits them until they get through
+	return transport.DialEarly(ctx, endpoint, tlsConf, conf)
+}
+
+// rendezvousConnect returns the external endpoint of peer observed by the
+// rendezvous peer
+func (a *Account) rendezvousConnect(ctx context.Context, transport *quic.Transport, rendezvous *Peer, peer Fingerprint) (*net.UDPAddr, error) {
+	client, err := a.quicClient(rendezvous.Fingerprint, transport)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = client.Close() }()
+
+	resp, err := rendezvousRequest(ctx, client, rendezvous, "/kad/rendezvous/connect/"+peer.String())
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	var target Peer
+	if err := json.NewDecoder(resp.Body).Decode(&target); err != nil {
+		return nil, err
+	}
+
+	address, err := parsePeerAddress(target.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	return net.ResolveUDPAddr("udp", address.host)
+}
+
+// ServeRendezvous registers with a rendezvous friend so friends that can't
+// reach this server directly can punch a hole to it. the registration is sent
+// from the socket served by ServeQUIC which must be called first. it blocks
+// until the context is cancelled or the connection to the rendezvous drops.
+func (s *Server) ServeRendezvous(ctx context.Context, rendezvous *Peer) error {
+	transport := s.servingTransport()
+	if transport == nil {
+		return ErrQUICNotServed
+	}
+
+	client, err := s.account.quicClient(rendezvous.Fingerprint, transport)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = client.Close() }()
+
+	resp, err := rendezvousRequest(ctx, client, rendezvous, "/kad/rendezvous/register")
+	if err != nil {
+		return fmt.Errorf("failed to register with rendezvous %s: %w", rendezvous.Fingerprint, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	return s.punchRequested(ctx, transport, rendezvous, resp)
+}
+
+func (s *Server) punchRequested(ctx context.Context, transport *quic.Transport, rendezvous *Peer, resp *http.Response) error {
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if endpoint := strings.TrimSpace(scanner.Text()); endpoint != "" {
+			go punch(ctx, transport, endpoint)
+		}
+	}
+
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	return fmt.Errorf("lost connection to rendezvous %s: %w", rendezvous.Fingerprint, scanner.Err())
+}
+
+// punch sends a few packets to endpoint so our NAT accepts packets from it
+func punch(ctx context.Context, transport *quic.Transport, endpoint string) {
+	addr, err := net.ResolveUDPAddr("udp", endpoint)
+	if err != nil {
+		slog.Error("invalid rendezvous endpoint", "endpoint", endpoint, "error", err)
+		return
+	}
+
+	for range rendezvousPunchAttempts {
+		if _, err := transport.WriteTo([]byte{0}, addr); err != nil {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(rendezvousPunchInterval):
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package mau
+
+import (
+	"context"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRendezvous(t *testing.T) {
+	rendezvous, err := NewAccount(t.TempDir(), "Rendezvous", "rendezvous@example.com", "password")
+	require.NoError(t, err)
+	natted, err := NewAccount(t.TempDir(), "Behind NAT", "natted@example.com", "password")
+	require.NoError(t, err)
+	accountDir := t.TempDir()
+	account, err := NewAccount(accountDir, "Ahmed Mohamed", "ahmed@example.com", "password")
+	require.NoError(t, err)
+
+	befriend(t, rendezvous, natted)
+	befriend(t, rendezvous, account)
+	aFriend := befriend(t, natted, account)
+	require.NoError(t, account.Follow(befriend(t, account, natted)))
+
+	rendezvousServer, err := rendezvous.Server(nil)
+	require.NoError(t, err)
+	defer rendezvousServer.Close()
+	listener, _ := TempListener()
+	go func() { _ = rendezvousServer.Serve(*listener, "") }()
+	rendezvousConn, err := ListenUDP("127.0.0.1:0")
+	require.NoError(t, err)
+	go func() { _ = rendezvousServer.ServeQUIC(rendezvousConn) }()
+	rendezvousPeer := &Peer{Fingerprint: rendezvous.Fingerprint(), Address: rendezvousConn.LocalAddr().String()}
+
+	nattedServer, err := natted.Server(nil)
+	require.NoError(t, err)
+	defer nattedServer.Close()
+
+	client, err := account.Client(natted.Fingerprint(), nil)
+	require.NoError(t, err)
+
+	t.Run("Requires serving QUIC", func(t T) {
+		err := nattedServer.ServeRendezvous(context.Background(), rendezvousPeer)
+		assert.ErrorIs(t, err, ErrQUICNotServed)
+	})
+
+	nattedConn, err := ListenUDP("127.0.0.1:0")
+	require.NoError(t, err)
+	go func() { _ = nattedServer.ServeQUIC(nattedConn) }()
+	require.Eventually(t, func() bool { return nattedServer.servingTransport() != nil }, time.Second, 10*time.Millisecond)
+
+	t.Run("Fails when the peer isn't registered", func(t T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		err := client.DownloadFriend(ctx, natted.Fingerprint(), time.Time{}, []FingerprintResolver{ViaRendezvous(rendezvousPeer)})
+		assert.ErrorContains(t, err, "404")
+	})
+
+	t.Run("Downloads files from a hole punched connection", func(t T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() { _ = nattedServer.ServeRendezvous(ctx, rendezvousPeer) }()
+
+		_, err = natted.AddFile(strings.NewReader("Hello through the hole"), "hello.txt", []*Friend{aFriend})
+		require.NoError(t, err)
+
+		assert.Eventually(t, func() bool {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			err := client.DownloadFriend(ctx, natted.Fingerprint(), time.Time{}, []FingerprintResolver{ViaRendezvous(rendezvousPeer)})
+			return err == nil
+		}, 10*time.Second, 100*time.Millisecond)
+
+		assert.FileExists(t, path.Join(accountDir, natted.Fingerprint().String(), "hello.txt.pgp"))
+	})
+
+	t.Run("Refuses peers that aren't friends of the rendezvous", func(t T) {
+		stranger, err := NewAccount(t.TempDir(), "Stranger", "stranger@example.com", "password")
+		require.NoError(t, err)
+		strangerServer, err := stranger.Server(nil)
+		require.NoError(t, err)
+		defer strangerServer.Close()
+
+		conn, err := ListenUDP("127.0.0.1:0")
+		require.NoError(t, err)
+		go func() { _ = strangerServer.ServeQUIC(conn) }()
+		require.Eventually(t, func() bool { return strangerServer.servingTransport() != nil }, time.Second, 10*time.Millisecond)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		err = strangerServer.ServeRendezvous(ctx, rendezvousPeer)
+		assert.ErrorContains(t, err, "403")
+	})
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net"
+	"strings"
 
 	"github.com/hashicorp/mdns"
 )
@@ -24,6 +26,21 @@ func StaticAddress(address string) FingerprintResolver {
 	}
 }
 
+// ViaRelay resolves any fingerprint to a circuit through the relay peer. the
+// fingerprint owner must keep a reverse connection with the relay using
+// Server.ServeRelay and both must be friends of the relay.
+func ViaRelay(relay *Peer) FingerprintResolver {
+	return StaticAddress(relayAddress(relay))
+}
+
+// ViaRendezvous resolves any fingerprint to a QUIC connection hole punched
+// through the rendezvous peer. the fingerprint owner must serve QUIC and keep
+// a registration with the rendezvous using Server.ServeRendezvous and both
+// must be friends of the rendezvous.
+func ViaRendezvous(rendezvous *Peer) FingerprintResolver {
+	return StaticAddress(rendezvousAddress(rendezvous))
+}
+
 // LocalFriendAddress resolves a fingerprint to the address of the friend if it
 // was found on local network. it uses mDNS-SD to discover other peers on the
 // local area network
@@ -41,6 +58,7 @@ func LocalFriendAddress(ctx context.Context, fingerprint Fingerprint, addresses
 		case entry := <-entriesCh:
 			if entry.Name == name {
 				addresses <- fmt.Sprintf("%s:%d", entry.AddrV4, entry.Port)
+				offerAddresses(addresses, mdnsQUICAddresses(entry))
 				return nil
 			}
 		case <-ctx.Done():
@@ -49,6 +67,30 @@ func LocalFriendAddress(ctx context.Context, fingerprint Fingerprint, addresses
 	}
 }
 
+// mdnsQUICAddresses returns the QUIC address of the mDNS entry if it
+// announces a QUIC port
+func mdnsQUICAddresses(entry *mdns.ServiceEntry) []string {
+	for _, field := range entry.InfoFields {
+		if port, ok := strings.CutPrefix(field, mDNSQUICField+"="); ok {
+			return []string{QUICAddress(net.JoinHostPort(entry.AddrV4.String(), port))}
+		}
+	}
+
+	return nil
+}
+
+// offerAddresses sends the other addresses of a peer after the first one
+// without waiting, a caller reading a single address takes the first
+func offerAddresses(addresses chan<- string, others []string) {
+	for _, address := range others {
+		select {
+		case addresses <- address:
+		default:
+			return
+		}
+	}
+}
+
 var ErrServerDoesNotAllowLookUp = errors.New("Server doesn't allow looking up friends on the internet")
 
 // InternetFriendAddress returns a resolver function that will use the server
@@ -65,6 +107,7 @@ func InternetFriendAddress(server *Server) FingerprintResolver {
 		peer := server.dhtServer.sendFindPeer(ctx, fingerprint)
 		if peer != nil {
 			addresses <- peer.Address
+			offerAddresses(addresses, peer.Addresses)
 		}
 
 		return nil
@@ -0,0 +1,399 @@
+package mau
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Search: an optional index of the words of the documents of the account and
+// the friends it follows, so searching doesn't decrypt every file. it's kept
+// in .mau encrypted to the account, isn't served to peers and can be rebuilt
+// from the files at any time. RebuildSearchIndex enables it and DisableSearch
+// removes it. AddContent and RemoveFile keep it up to date with the account
+// own documents, synced ones are added by UpdateSearchIndex or WatchSearch.
+
+var (
+	ErrSearchDisabled = errors.New("Search index isn't enabled")
+)
+
+// searchSkippedKeys are the document keys whose values aren't searched
+var searchSkippedKeys = map[string]bool{
+	"@context":   true,
+	"@type":      true,
+	"@id":        true,
+	"contentUrl": true,
+	"sha256":     true,
+}
+
+// SearchQuery selects the documents returned by Search
+type SearchQuery struct {
+	Text    string        // words the documents contain, as a prefix of their words
+	Authors []Fingerprint // the account or followed friends, empty for all of them
+	Types   []string      // Schema.org types to include, empty for all
+	After   time.Time     // documents signed after, zero for any
+	Before  time.Time     // documents signed before, zero for any
+	Limit   uint          // documents to return, zero for all
+}
+
+// searchDocument is what the index keeps of a file, documents without a type
+// are the files that aren't JSON-LD so they aren't read again
+type searchDocument struct {
+	Sum    string    `json:"sum"` // of the encrypted file, the document is indexed again when it changes
+	Signed time.Time `json:"signed"`
+	Type   string    `json:"type,omitempty"`
+	Words  []string  `json:"words,omitempty"`
+}
+
+// searchIndex is the index of the account, loaded on first use
+type searchIndex struct {
+	mutex     sync.Mutex
+	loaded    bool
+	documents map[string]*searchDocument // key: file path relative to the account root
+	words     map[string]map[string]bool // documents keys of every word
+}
+
+func searchIndexFile(d string) string { return path.Join(mauDir(d), searchFilename) }
+
+func (s *searchIndex) reset() {
+	s.documents = map[string]*searchDocument{}
+	s.words = map[string]map[string]bool{}
+}
+
+func (s *searchIndex) put(key string, doc *searchDocument) {
+	s.remove(key)
+	s.documents[key] = doc
+
+	for _, w := range doc.Words {
+		if s.words[w] == nil {
+			s.words[w] = map[string]bool{}
+		}
+		s.words[w][key] = true
+	}
+}
+
+func (s *searchIndex) remove(key string) {
+	doc, ok := s.documents[key]
+	if !ok {
+		return
+	}
+
+	for _, w := range doc.Words {
+		delete(s.words[w], key)
+		if len(s.words[w]) == 0 {
+			delete(s.words, w)
+		}
+	}
+	delete(s.documents, key)
+}
+
+// matching returns the keys of the documents with a word starting with every
+// query word
+func (s *searchIndex) matching(query []string) map[string]bool {
+	keys := map[string]bool{}
+	for k, doc := range s.documents {
+		if doc.Type != "" {
+			keys[k] = true
+		}
+	}
+
+	for _, q := range query {
+		found := map[string]bool{}
+		for w, docs := range s.words {
+			if strings.HasPrefix(w, q) {
+				maps.Copy(found, docs)
+			}
+		}
+
+		maps.DeleteFunc(keys, func(k string, _ bool) bool { return !found[k] })
+	}
+
+	return keys
+}
+
+// SearchEnabled is true when the account has a search index
+func (a *Account) SearchEnabled() bool {
+	return exists(a.storage, searchIndexFile(a.path))
+}
+
+// RebuildSearchIndex indexes the documents of the account and its follows
+// from scratch, enabling search if it wasn't
+func (a *Account) RebuildSearchIndex() error {
+	a.search.mutex.Lock()
+	defer a.search.mutex.Unlock()
+
+	a.search.reset()
+	a.search.loaded = true
+
+	if err := a.updateSearch(); err != nil {
+		return err
+	}
+
+	return a.saveSearch()
+}
+
+// DisableSearch removes the search index
+func (a *Account) DisableSearch() error {
+	a.search.mutex.Lock()
+	defer a.search.mutex.Unlock()
+
+	a.search.loaded = false
+	return removeIfExists(a.storage, searchIndexFile(a.path))
+}
+
+// UpdateSearchIndex indexes the documents added or changed since the last
+// update and forgets the removed ones, like files synced from friends
+func (a *Account) UpdateSearchIndex() error {
+	a.search.mutex.Lock()
+	defer a.search.mutex.Unlock()
+
+	if err := a.loadSearch(); err != nil {
+		return err
+	}
+
+	if err := a.updateSearch(); err != nil {
+		return err
+	}
+
+	return a.saveSearch()
+}
+
+// WatchSearch updates the search index as files and follows change until ctx
+// is cancelled
+func (a *Account) WatchSearch(ctx context.Context) {
+	for event := range a.Watch(ctx) {
+		if event.Type == EventFriendAdded || event.Type == EventFriendRemoved {
+			continue
+		}
+
+		if err := a.UpdateSearchIndex(); err != nil {
+			slog.Warn("failed to update search index", "error", err)
+		}
+	}
+}
+
+// Search returns the verified documents matching the query newest first,
+// ErrSearchDisabled when the account has no search index
+func (a *Account) Search(query SearchQuery) ([]*FeedItem, error) {
+	authors, err := a.feedAuthors(query.Authors)
+	if err != nil {
+		return nil, err
+	}
+
+	byFingerprint := map[string]*Friend{}
+	for _, author := range authors {
+		byFingerprint[author.Fingerprint().String()] = author
+	}
+
+	a.search.mutex.Lock()
+	if err := a.loadSearch(); err != nil {
+		a.search.mutex.Unlock()
+		return nil, err
+	}
+
+	opts := FeedOptions{Before: query.Before, Types: query.Types}
+	entries := []*feedEntry{}
+	for k := range a.search.matching(searchWords(query.Text)) {
+		doc := a.search.documents[k]
+		author, ok := byFingerprint[path.Dir(k)]
+		if !ok || !doc.Signed.After(query.After) {
+			continue
+		}
+
+		entry := &feedEntry{file: a.file(path.Join(a.path, k)), author: author, signed: doc.Signed, schemaType: doc.Type}
+		if entry.matches(opts) {
+			entries = append(entries, entry)
+		}
+	}
+	a.search.mutex.Unlock()
+
+	sortFeed(entries)
+	return a.feedItems(feedPage(entries, query.Limit)), nil
+}
+
+// searchFile indexes one of the account own files after AddContent
+func (a *Account) searchFile(file *File) {
+	a.search.mutex.Lock()
+	defer a.search.mutex.Unlock()
+
+	if err := a.loadSearch(); err != nil {
+		return
+	}
+
+	a.indexSearchFile(file, &Friend{entity: a.entity})
+	if err := a.saveSearch(); err != nil {
+		slog.Warn("failed to save search index", "error", err)
+	}
+}
+
+// unsearchFile forgets a file removed by RemoveFile
+func (a *Account) unsearchFile(p string) {
+	a.search.mutex.Lock()
+	defer a.search.mutex.Unlock()
+
+	if err := a.loadSearch(); err != nil {
+		return
+	}
+
+	a.search.remove(a.searchKey(p))
+	if err := a.saveSearch(); err != nil {
+		slog.Warn("failed to save search index", "error", err)
+	}
+}
+
+func (a *Account) searchKey(p string) string { return strings.TrimPrefix(p, a.path+"/") }
+
+// updateSearch indexes the files of the account and its follows that changed
+// and forgets the others
+func (a *Account) updateSearch() error {
+	authors, err := a.feedAuthors(nil)
+	if err != nil {
+		return err
+	}
+	defer a.index.save()
+
+	seen := map[string]bool{}
+	for _, author := range authors {
+		dir := path.Join(a.path, author.Fingerprint().String())
+		entries, _ := a.storage.ReadDir(dir)
+
+		for _, entry := range entries {
+			if !isDocumentFile(entry) {
+				continue
+			}
+
+			file := a.file(path.Join(dir, entry.Name()))
+			seen[a.searchKey(file.Path)] = true
+			a.indexSearchFile(file, author)
+		}
+	}
+
+	for k := range a.search.documents {
+		if !seen[k] {
+			a.search.remove(k)
+		}
+	}
+
+	return nil
+}
+
+// indexSearchFile reads the words of the file unless the index has its
+// current content
+func (a *Account) indexSearchFile(file *File, author *Friend) {
+	meta, err := a.fileMeta(file)
+	if err != nil {
+		return
+	}
+
+	key := a.searchKey(file.Path)
+	if doc, ok := a.search.documents[key]; ok && doc.Sum == meta.Sum {
+		return
+	}
+
+	data, signed, err := a.readSigned(file, author)
+	if err != nil {
+		slog.Warn("failed to verify file to search", "file", file.Path, "error", err)
+		return
+	}
+
+	doc := &searchDocument{Sum: meta.Sum, Signed: signed, Type: documentType(data)}
+	if doc.Type != "" {
+		doc.Words = documentWords(data)
+	}
+
+	a.search.put(key, doc)
+}
+
+// loadSearch reads the index on first use, ErrSearchDisabled when there's
+// none
+func (a *Account) loadSearch() error {
+	if a.search.loaded {
+		return nil
+	}
+
+	encrypted, err := a.storage.ReadFile(searchIndexFile(a.path))
+	if err != nil {
+		return ErrSearchDisabled
+	}
+
+	data, err := a.decryptForAccount(encrypted)
+	if err != nil {
+		return err
+	}
+
+	documents := map[string]*searchDocument{}
+	if err := json.Unmarshal(data, &documents); err != nil {
+		return err
+	}
+
+	a.search.reset()
+	for k, doc := range documents {
+		a.search.put(k, doc)
+	}
+	a.search.loaded = true
+
+	return nil
+}
+
+// saveSearch writes the index encrypted to the account
+func (a *Account) saveSearch() error {
+	data, err := json.Marshal(a.search.documents)
+	if err != nil {
+		return err
+	}
+
+	encrypted, err := a.encryptForAccount(data)
+	if err != nil {
+		return err
+	}
+
+	return a.storage.WriteFile(searchIndexFile(a.path), encrypted)
+}
+
+// searchWords splits text into lower case words
+func searchWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// documentWords returns the distinct words of the string values of a JSON-LD
+// document
+func documentWords(data []byte) []string {
+	var doc any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil
+	}
+
+	words := map[string]bool{}
+	collectWords(doc, words)
+
+	return slices.Sorted(maps.Keys(words))
+}
+
+func collectWords(value any, words map[string]bool) {
+	switch v := value.(type) {
+	case string:
+		for _, w := range searchWords(v) {
+			words[w] = true
+		}
+	case []any:
+		for _, item := range v {
+			collectWords(item, words)
+		}
+	case map[string]any:
+		for k, item := range v {
+			if !searchSkippedKeys[k] {
+				collectWords(item, words)
+			}
+		}
+	}
+}
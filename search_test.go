@@ -0,0 +1,154 @@
+package mau
+
+import (
+	"bytes"
+	"context"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearch(t *testing.T) {
+	created := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	account := accountSince(t, "ahmed", created)
+	friendAccount := accountSince(t, "mohamed", created)
+
+	friend := befriend(t, account, friendAccount)
+	require.NoError(t, account.Follow(friend))
+
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 12, 0, 0, 0, time.UTC) }
+	writeSignedAt(t, account, account, "pasta", &SocialMediaPosting{Headline: "Pasta", ArticleBody: "Tomato sauce with basil"}, day(1))
+	writeSignedAt(t, account, friendAccount, "pizza", &SocialMediaPosting{ArticleBody: "Tomato pizza in Napoli"}, day(2))
+	writeSignedAt(t, account, friendAccount, "reply", &Comment{Text: "Basil from the garden"}, day(3))
+
+	_, err := account.AddFile(bytes.NewBufferString("tomato notes"), "notes.txt", nil)
+	require.NoError(t, err)
+
+	names := func(items []*FeedItem) []string {
+		list := []string{}
+		for _, item := range items {
+			list = append(list, item.File.Name())
+		}
+		return list
+	}
+
+	t.Run("Is disabled until built", func(t T) {
+		assert.False(t, account.SearchEnabled())
+		_, err := account.Search(SearchQuery{Text: "tomato"})
+		assert.ErrorIs(t, err, ErrSearchDisabled)
+
+		require.NoError(t, account.RebuildSearchIndex())
+		assert.True(t, account.SearchEnabled())
+	})
+
+	t.Run("Finds documents by their words", func(t T) {
+		items, err := account.Search(SearchQuery{Text: "tomato"})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"pizza.pgp", "pasta.pgp"}, names(items))
+
+		items, err = account.Search(SearchQuery{Text: "BASIL tom"})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"pasta.pgp"}, names(items))
+
+		items, err = account.Search(SearchQuery{Text: "napoli"})
+		require.NoError(t, err)
+		require.Len(t, items, 1)
+		assert.Equal(t, friendAccount.Fingerprint(), items[0].Author.Fingerprint())
+
+		items, err = account.Search(SearchQuery{Text: "socialmediaposting"})
+		require.NoError(t, err)
+		assert.Empty(t, items)
+	})
+
+	t.Run("Filters by author, type and date", func(t T) {
+		items, err := account.Search(SearchQuery{Authors: []Fingerprint{friendAccount.Fingerprint()}})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"reply.pgp", "pizza.pgp"}, names(items))
+
+		items, err = account.Search(SearchQuery{Text: "basil", Types: []string{"Comment"}})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"reply.pgp"}, names(items))
+
+		items, err = account.Search(SearchQuery{After: day(1), Before: day(3)})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"pizza.pgp"}, names(items))
+
+		items, err = account.Search(SearchQuery{Limit: 1})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"reply.pgp"}, names(items))
+	})
+
+	t.Run("Keeps the index encrypted", func(t T) {
+		data, err := account.storage.ReadFile(searchIndexFile(account.path))
+		require.NoError(t, err)
+		assert.NotContains(t, string(data), "tomato")
+
+		// a friend key is a .pgp file in .mau, the index mustn't look like one
+		_, err = account.ListFriends()
+		assert.NoError(t, err)
+	})
+
+	t.Run("Follows the account own content", func(t T) {
+		file, err := account.AddContent(&SocialMediaPosting{ArticleBody: "Lemon cake"}, "cake.json", nil)
+		require.NoError(t, err)
+
+		items, err := account.Search(SearchQuery{Text: "lemon"})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"cake.json.pgp"}, names(items))
+
+		require.NoError(t, account.RemoveFile(file))
+		items, err = account.Search(SearchQuery{Text: "lemon"})
+		require.NoError(t, err)
+		assert.Empty(t, items)
+	})
+
+	t.Run("Updates with synced files", func(t T) {
+		writeSignedAt(t, account, friendAccount, "soup", &SocialMediaPosting{ArticleBody: "Tomato soup"}, day(4))
+		require.NoError(t, account.storage.Remove(path.Join(account.path, friendAccount.Fingerprint().String(), "pizza.pgp")))
+		require.NoError(t, account.UpdateSearchIndex())
+
+		items, err := account.Search(SearchQuery{Text: "tomato"})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"soup.pgp", "pasta.pgp"}, names(items))
+
+		// a new process reads the saved index
+		reopened := buildAccount(account.storage, account.entity, account.path)
+		items, err = reopened.Search(SearchQuery{Text: "tomato"})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"soup.pgp", "pasta.pgp"}, names(items))
+	})
+
+	t.Run("Watches changes", func(t T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			account.WatchSearch(ctx)
+			close(done)
+		}()
+		time.Sleep(100 * time.Millisecond)
+
+		writeSignedAt(t, account, friendAccount, "salad", &SocialMediaPosting{ArticleBody: "Tomato salad"}, day(5))
+		assert.Eventually(t, func() bool {
+			items, err := account.Search(SearchQuery{Text: "salad"})
+			return err == nil && len(items) == 1
+		}, 5*time.Second, 50*time.Millisecond)
+
+		cancel()
+		<-done
+	})
+
+	t.Run("Rebuilds and disables the index", func(t T) {
+		require.NoError(t, account.RebuildSearchIndex())
+		items, err := account.Search(SearchQuery{Text: "tomato"})
+		require.NoError(t, err)
+		assert.Len(t, items, 3)
+
+		require.NoError(t, account.DisableSearch())
+		assert.False(t, account.SearchEnabled())
+		_, err = account.Search(SearchQuery{})
+		assert.ErrorIs(t, err, ErrSearchDisabled)
+	})
+}
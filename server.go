@@ -3,18 +3,21 @@ package mau
 import (
 	"context"
 	"crypto/tls"
-	"crypto/x509"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io/fs"
 	"log/slog"
 	"net"
 	"net/http"
-	"os"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/hashicorp/mdns"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
 )
 
 type Server struct {
@@ -23,17 +26,45 @@ type Server struct {
 	router *http.ServeMux
 
 	httpServer http.Server
+	quicServer *http3.Server
 	mdnsServer *mdns.Server
 	dhtServer  *dhtServer
 
 	bootstrapNodes []*Peer
 	resultsLimit   uint
+
+	relayOnce  sync.Once
+	relayConns *connListener
+
+	fileEvents *fileEvents
+
+	quicMutex     sync.Mutex      // guards the QUIC socket and what's advertised of it
+	quicTransport *quic.Transport // the UDP socket the server is served on by ServeQUIC
+	quicPort      int             // port of quicTransport, zero before ServeQUIC
+	mdnsPort      int             // TCP port announced over mDNS, zero before Serve
 }
 
 type FileListItem struct {
-	Path string `json:"path"`
-	Size int64  `json:"size"`
-	Sum  string `json:"sum"`
+	Path    string `json:"path"`
+	Size    int64  `json:"size"`
+	Sum     string `json:"sum"`
+	Deleted bool   `json:"deleted,omitempty"` // the item is the tombstone of a deleted file
+}
+
+// fileList is a list response, it also accepts the {"files": [...]} object
+// the TypeScript implementation responds with
+type fileList []FileListItem
+
+func (l *fileList) UnmarshalJSON(data []byte) error {
+	var object struct {
+		Files []FileListItem `json:"files"`
+	}
+	if err := json.Unmarshal(data, &object); err == nil {
+		*l = object.Files
+		return nil
+	}
+
+	return json.Unmarshal(data, (*[]FileListItem)(l))
 }
 
 func (a *Account) Server(knownNodes []*Peer) (*Server, error) {
@@ -50,9 +81,13 @@ func (a *Account) Server(knownNodes []*Peer) (*Server, error) {
 		resultsLimit:   serverResultLimit,
 		bootstrapNodes: knownNodes,
 		httpServer:     createHTTPServer(router, cert),
+		quicServer:     createQUICServer(router, cert),
+		fileEvents:     newFileEvents(a),
 	}
 
 	router.Handle("/p2p/", &s)
+	router.Handle("/relay/", newRelayServer(a))
+	router.Handle("/signaling/", newSignalingServer(a))
 
 	return &s, nil
 }
@@ -88,7 +123,7 @@ func createServerTLSConfig(cert tls.Certificate) *tls.Config {
 var (
 	listReg    = regexp.MustCompile(`^/p2p/[0-9a-f]+$`)
 	getReg     = regexp.MustCompile(`^/p2p/[0-9a-f]+/([^/]+)$`)
-	versionReg = regexp.MustCompile(`^/p2p/[0-9a-f]+/([^/]+).version/([^/]+)$`)
+	versionReg = regexp.MustCompile(`^/p2p/[0-9a-f]+/([^/]+)\.versions?/([^/]+)$`) // .version is kept for older clients
 )
 
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -99,6 +134,10 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	switch {
 	case listReg.MatchString(r.URL.Path):
 		s.list(w, r)
+	case eventsReg.MatchString(r.URL.Path):
+		s.events(w, r)
+	case versionsReg.MatchString(r.URL.Path):
+		s.versions(w, r)
 	case getReg.MatchString(r.URL.Path):
 		s.get(w, r)
 	case versionReg.MatchString(r.URL.Path):
@@ -127,9 +166,29 @@ func (s *Server) Serve(l net.Listener, externalAddress string) error {
 }
 
 func (s *Server) serveMDNS(port int) error {
+	s.quicMutex.Lock()
+	defer s.quicMutex.Unlock()
+
+	s.mdnsPort = port
+	return s.announceMDNS()
+}
+
+// announceMDNS (re)starts the mDNS server announcing the TCP port and, once
+// ServeQUIC is called, the QUIC port in the TXT record. quicMutex must be held
+func (s *Server) announceMDNS() error {
+	if s.mdnsServer != nil {
+		_ = s.mdnsServer.Shutdown()
+		s.mdnsServer = nil
+	}
+
+	txt := []string{}
+	if s.quicPort != 0 {
+		txt = append(txt, fmt.Sprintf("%s=%d", mDNSQUICField, s.quicPort))
+	}
+
 	fingerprint := s.account.Fingerprint().String()
 
-	service, err := mdns.NewMDNSService(fingerprint, mDNSServiceName, "", "", port, nil, []string{})
+	service, err := mdns.NewMDNSService(fingerprint, mDNSServiceName, "", "", s.mdnsPort, nil, txt)
 	if err != nil {
 		return err
 	}
@@ -148,23 +207,39 @@ func (s *Server) serveMDNS(port int) error {
 }
 
 func (s *Server) serveDHT(ctx context.Context, externalAddress string) error {
-	s.dhtServer = newDHTServer(s.account, externalAddress)
-	s.router.Handle("/kad/", s.dhtServer)
-	s.dhtServer.Join(ctx, s.bootstrapNodes)
+	dht := newDHTServer(s.account, externalAddress)
+
+	s.quicMutex.Lock()
+	s.dhtServer = dht
+	if s.quicPort != 0 {
+		dht.advertiseQUIC(s.quicPort)
+	}
+	s.quicMutex.Unlock()
+
+	s.router.Handle("/kad/", dht)
+	dht.Join(ctx, s.bootstrapNodes)
 	return nil
 }
 
 func (s *Server) Close() error {
+	s.quicMutex.Lock()
+	mdnsServer, dhtServer, transport := s.mdnsServer, s.dhtServer, s.quicTransport
+	s.quicMutex.Unlock()
+
 	var mdns_err error
-	if s.mdnsServer != nil {
-		mdns_err = s.mdnsServer.Shutdown()
+	if mdnsServer != nil {
+		mdns_err = mdnsServer.Shutdown()
 	}
 	http_err := s.httpServer.Close()
-	if s.dhtServer != nil {
-		s.dhtServer.Leave()
+	quic_err := s.quicServer.Close()
+	if transport != nil {
+		quic_err = errors.Join(quic_err, transport.Close())
+	}
+	if dhtServer != nil {
+		dhtServer.Leave()
 	}
 
-	return errors.Join(mdns_err, http_err)
+	return errors.Join(mdns_err, http_err, quic_err)
 }
 
 func parseIfModifiedSince(r *http.Request) (time.Time, error) {
@@ -176,41 +251,24 @@ func parseIfModifiedSince(r *http.Request) (time.Time, error) {
 	return http.ParseTime(ifModifiedSince)
 }
 
-func extractFileInfo(item *File) (hash string, size int64, err error) {
-	hash, err = item.Hash()
-	if err != nil {
-		slog.Error("failed to calculate file hash", "file", item.Name(), "error", err)
-		hash = ""
-	}
-
-	size, err = item.Size()
-	if err != nil {
-		slog.Error("failed to calculate file size", "file", item.Name(), "error", err)
-	}
-
-	return hash, size, err
-}
-
-func (s *Server) buildFileListItem(item *File, r *http.Request, fpr Fingerprint) (*FileListItem, bool) {
-	hash, size, err := extractFileInfo(item)
-	if err != nil {
-		slog.Error("failed to calculate file size", "file", item.Name(), "error", err)
-		return nil, false
-	}
-
-	recipients, err := item.Recipients(s.account)
+// buildFileListItem describes the file from the index if the requester is
+// one of its recipients in keyring
+func (s *Server) buildFileListItem(item *File, r *http.Request, fpr Fingerprint, keyring *Keyring) (*FileListItem, bool) {
+	meta, err := s.account.fileMeta(item)
 	if err != nil {
+		slog.Error("failed to read file metadata", "file", item.Name(), "error", err)
 		return nil, false
 	}
 
-	if !isPermitted(r.TLS.PeerCertificates, recipients) {
+	if !isPermitted(r, friendsByKeyIDs(keyring, meta.KeyIDs)) {
 		return nil, false
 	}
 
 	return &FileListItem{
-		Path: "/p2p/" + fpr.String() + "/" + item.Name(),
-		Size: size,
-		Sum:  hash,
+		Path:    fileListPath(fpr, item.Name()),
+		Size:    meta.Size,
+		Sum:     meta.Sum,
+		Deleted: isTombstoneName(item.Name()),
 	}, true
 }
 
@@ -243,10 +301,17 @@ func (s *Server) parseListRequest(r *http.Request) (time.Time, Fingerprint, erro
 
 func (s *Server) buildFileList(r *http.Request, fpr Fingerprint, lastModified time.Time) []FileListItem {
 	page := s.account.ListFiles(fpr, lastModified, s.resultsLimit)
+	defer s.account.index.save()
 
 	list := make([]FileListItem, 0, len(page))
+	keyring, err := s.account.ListFriends()
+	if err != nil {
+		slog.Error("failed to list friends", "error", err)
+		return list
+	}
+
 	for _, item := range page {
-		if fileItem, ok := s.buildFileListItem(item, r, fpr); ok {
+		if fileItem, ok := s.buildFileListItem(item, r, fpr, keyring); ok {
 			list = append(list, *fileItem)
 		}
 	}
@@ -299,6 +364,11 @@ func (s *Server) parseGetRequest(w http.ResponseWriter, r *http.Request) (Finger
 }
 
 func (s *Server) authorizeFileAccess(w http.ResponseWriter, r *http.Request, fpr Fingerprint, filename string) (*File, error) {
+	if isHiddenFileName(filename) {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return nil, fs.ErrNotExist
+	}
+
 	file, err := s.account.GetFile(fpr, filename)
 	if err != nil {
 		http.Error(w, "File not found", http.StatusNotFound)
@@ -311,7 +381,7 @@ func (s *Server) authorizeFileAccess(w http.ResponseWriter, r *http.Request, fpr
 		return nil, err
 	}
 
-	allowed := isPermitted(r.TLS.PeerCertificates, recipients)
+	allowed := isPermitted(r, recipients)
 	if !allowed {
 		http.Error(w, "Error file is not allowed for user", http.StatusUnauthorized)
 		return nil, errors.New("unauthorized")
@@ -321,7 +391,7 @@ func (s *Server) authorizeFileAccess(w http.ResponseWriter, r *http.Request, fpr
 }
 
 func (s *Server) serveFile(w http.ResponseWriter, r *http.Request, file *File) {
-	reader, err := os.Open(file.Path)
+	reader, err := file.store().Open(file.Path)
 	if err != nil {
 		http.Error(w, "Error reading file", http.StatusInternalServerError)
 		return
@@ -360,8 +430,8 @@ func (s *Server) parseVersionRequest(w http.ResponseWriter, r *http.Request) (Fi
 		return Fingerprint{}, "", "", err
 	}
 
-	// Strip .version suffix from filename
-	filename := strings.TrimSuffix(segments[1], ".version")
+	// Strip .versions or .version suffix from filename
+	filename := strings.TrimSuffix(strings.TrimSuffix(segments[1], ".versions"), ".version")
 	return fpr, filename, segments[2], nil
 }
 
@@ -378,7 +448,7 @@ func (s *Server) authorizeVersionAccess(w http.ResponseWriter, r *http.Request,
 		return nil, err
 	}
 
-	allowed := isPermitted(r.TLS.PeerCertificates, recipients)
+	allowed := isPermitted(r, recipients)
 	if !allowed {
 		http.Error(w, "Error file is not allowed for user", http.StatusUnauthorized)
 		return nil, errors.New("unauthorized")
@@ -387,8 +457,25 @@ func (s *Server) authorizeVersionAccess(w http.ResponseWriter, r *http.Request,
 	return file, nil
 }
 
-func isPermitted(certs []*x509.Certificate, recipients []*Friend) bool {
-	fpr, err := FingerprintFromCert(certs)
+type peerContextKey struct{}
+
+// requestPeer returns the fingerprint of the peer that sent the request, from
+// its TLS certificate or from the context for transports authenticating peers
+// by other means
+func requestPeer(r *http.Request) (Fingerprint, error) {
+	if fpr, ok := r.Context().Value(peerContextKey{}).(Fingerprint); ok {
+		return fpr, nil
+	}
+
+	if r.TLS == nil {
+		return nil, ErrIncorrectPeerCertificate
+	}
+
+	return FingerprintFromCert(r.TLS.PeerCertificates)
+}
+
+func isPermitted(r *http.Request, recipients []*Friend) bool {
+	fpr, err := requestPeer(r)
 	if err != nil {
 		return false
 	}
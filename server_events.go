@@ -0,0 +1,71 @@
+package mau
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var eventsReg = regexp.MustCompile(`^/p2p/[0-9a-f]+/events$`)
+
+// events streams the changes of the files of the fingerprint the requester is
+// permitted to read as server-sent events. responses of transports that can't
+// be streamed like WebRTC data channels are refused.
+func (s *Server) events(w http.ResponseWriter, r *http.Request) {
+	fpr, err := FingerprintFromString(strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/p2p/"), "/events"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := w.(http.Flusher); !ok {
+		http.Error(w, "Streaming is not supported over this transport", http.StatusNotImplemented)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{}) // the stream outlives the server write timeout
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	lines := permittedEvents(ctx, r, s.fileEvents.subscribe(ctx, fpr))
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	_ = rc.Flush()
+
+	streamLines(w, rc, r, ctx.Done(), lines)
+}
+
+// permittedEvents formats the changes of files the requester is permitted to
+// read as server-sent events, the empty line ending each event is added by
+// streamLines
+func permittedEvents(ctx context.Context, r *http.Request, changes <-chan *fileChange) <-chan string {
+	lines := make(chan string)
+
+	go func() {
+		for change := range changes {
+			if !isPermitted(r, change.recipients) {
+				continue
+			}
+
+			select {
+			case lines <- formatEvent(&change.event):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return lines
+}
+
+func formatEvent(event *FileEvent) string {
+	data, _ := json.Marshal(event)
+	return fmt.Sprintf("event: %s\ndata: %s\n", event.Type, data)
+}
@@ -7,6 +7,7 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"path"
 	"strings"
 	"testing"
 	"time"
@@ -251,6 +252,30 @@ func TestServer(t *testing.T) {
 				assert.Contains(t, string(body), "hello.txt.pgp", "hello.txt.pgp not found in the response, Response: %s", body)
 			})
 
+			t.Run("With a temporary file", func(t T) {
+				file, err := account.AddFile(strings.NewReader("Hello world"), "hello.txt", []*Friend{friend})
+				assert.NoError(t, err)
+				defer os.Remove(file.Path)
+
+				content, err := os.ReadFile(file.Path)
+				assert.NoError(t, err)
+				tmp := path.Join(path.Dir(file.Path), ".hello.txt.pgp.123.tmp")
+				assert.NoError(t, os.WriteFile(tmp, content, FilePerm))
+				defer os.Remove(tmp)
+
+				resp, err := http.Get(list_account_files_url)
+				assert.NoError(t, err)
+				body, err := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				assert.NoError(t, err)
+				assert.NotContains(t, string(body), ".tmp")
+
+				resp, err = http.Get(list_account_files_url + "/" + path.Base(tmp))
+				assert.NoError(t, err)
+				resp.Body.Close()
+				assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+			})
+
 		})
 	})
 }
@@ -0,0 +1,69 @@
+package mau
+
+import (
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+var versionsReg = regexp.MustCompile(`^/p2p/[0-9a-f]+/([^/]+)\.versions$`)
+
+// versions lists the old versions of a file the requester is permitted to
+// read, oldest first. every version may have different recipients than the
+// current file.
+func (s *Server) versions(w http.ResponseWriter, r *http.Request) {
+	segments := strings.Split(strings.TrimPrefix(r.URL.Path, "/p2p/"), "/")
+	fpr, err := FingerprintFromString(segments[0])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	file, err := s.account.GetFile(fpr, strings.TrimSuffix(segments[1], ".versions"))
+	if err != nil {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+
+	s.writeJSONResponse(w, s.buildVersionList(r, fpr, file))
+}
+
+func (s *Server) buildVersionList(r *http.Request, fpr Fingerprint, file *File) []FileListItem {
+	versions := file.Versions()
+	sortFilesByModificationTime(versions)
+	defer s.account.index.save()
+
+	list := make([]FileListItem, 0, len(versions))
+	keyring, err := s.account.ListFriends()
+	if err != nil {
+		return list
+	}
+
+	for _, version := range versions {
+		if item, ok := s.buildFileListItem(version, r, fpr, keyring); ok {
+			item.Path = versionListPath(fpr, file.Name(), version.Name())
+			list = append(list, *item)
+		}
+	}
+
+	return list
+}
+
+func versionListPath(fpr Fingerprint, name, hash string) string {
+	return fileListPath(fpr, name) + ".versions/" + hash
+}
+
+func sortFilesByModificationTime(files []*File) {
+	modified := make(map[*File]time.Time, len(files))
+	for _, f := range files {
+		if info, err := f.store().Stat(f.Path); err == nil {
+			modified[f] = info.ModTime()
+		}
+	}
+
+	slices.SortStableFunc(files, func(a, b *File) int {
+		return modified[a].Compare(modified[b])
+	})
+}
@@ -0,0 +1,106 @@
+package mau
+
+import (
+	"context"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVersionList(t *testing.T) {
+	accountDir := t.TempDir()
+	account, err := NewAccount(accountDir, "Ahmed Mohamed", "ahmed@example.com", "password")
+	require.NoError(t, err)
+	friend, err := NewAccount(t.TempDir(), "Mohamed Mahmoud", "mohamed@example.com", "password")
+	require.NoError(t, err)
+
+	aFriend := befriend(t, friend, account)
+	require.NoError(t, account.Follow(befriend(t, account, friend)))
+
+	share := func(content string, recipients []*Friend) string {
+		file, err := friend.AddFile(strings.NewReader(content), "contract.txt", recipients)
+		require.NoError(t, err)
+		hash, err := file.Hash()
+		require.NoError(t, err)
+		time.Sleep(10 * time.Millisecond) // a different modification time for every version
+		return hash
+	}
+
+	first := share("First draft", []*Friend{aFriend})
+	share("Private notes", nil)
+	third := share("Second draft", []*Friend{aFriend})
+	share("Signed", []*Friend{aFriend})
+
+	server, err := friend.Server(nil)
+	require.NoError(t, err)
+	listener, address := TempListener()
+	go func() { _ = server.Serve(*listener, "") }()
+	defer server.Close()
+
+	client, err := account.Client(friend.Fingerprint(), nil)
+	require.NoError(t, err)
+
+	t.Run("Lists the permitted versions oldest first", func(t T) {
+		list, err := client.fetchVersionList(context.Background(), friend.Fingerprint(), address, "contract.txt.pgp")
+		require.NoError(t, err)
+		require.Len(t, list, 2)
+
+		assert.Equal(t, versionListPath(friend.Fingerprint(), "contract.txt.pgp", first), list[0].Path)
+		assert.Equal(t, first, list[0].Sum)
+		assert.Equal(t, versionListPath(friend.Fingerprint(), "contract.txt.pgp", third), list[1].Path)
+		assert.Equal(t, third, list[1].Sum)
+	})
+
+	t.Run("Responds with not found for unknown files", func(t T) {
+		_, err := client.fetchVersionList(context.Background(), friend.Fingerprint(), address, "unknown.txt.pgp")
+		assert.ErrorContains(t, err, "404")
+	})
+
+	t.Run("Serves versions at their listed path", func(t T) {
+		data, err := client.downloadPath(context.Background(), address, versionListPath(friend.Fingerprint(), "contract.txt.pgp", first), referenceMaxSize)
+		require.NoError(t, err)
+		assert.NotEmpty(t, data)
+	})
+
+	t.Run("Downloads the missing versions", func(t T) {
+		err := client.DownloadVersions(context.Background(), friend.Fingerprint(), "contract.txt.pgp", []FingerprintResolver{StaticAddress(address)})
+		require.NoError(t, err)
+
+		versionsDir := path.Join(accountDir, friend.Fingerprint().String(), "contract.txt.pgp.versions")
+		entries, err := os.ReadDir(versionsDir)
+		require.NoError(t, err)
+		require.Len(t, entries, 2)
+		assert.FileExists(t, path.Join(versionsDir, first))
+		assert.FileExists(t, path.Join(versionsDir, third))
+
+		version, err := account.GetFileVersion(friend.Fingerprint(), "contract.txt.pgp", first)
+		require.NoError(t, err)
+		assert.NoError(t, version.VerifySignature(account, friend.Fingerprint()))
+	})
+
+	t.Run("Skips versions the download policy refuses", func(t T) {
+		list, err := client.fetchVersionList(context.Background(), friend.Fingerprint(), address, "contract.txt.pgp")
+		require.NoError(t, err)
+
+		client.SetDownloadPolicy(DownloadPolicy{MaxFileSize: 1})
+		defer client.SetDownloadPolicy(DownloadPolicy{})
+
+		dir := t.TempDir()
+		err = client.downloadVersion(context.Background(), address, friend.Fingerprint(), dir, &list[0])
+		var skipped *SkippedFile
+		require.ErrorAs(t, err, &skipped)
+		assert.ErrorIs(t, skipped.Reason, ErrFileTooLarge)
+		assert.NoFileExists(t, path.Join(dir, first))
+	})
+
+	t.Run("Rejects versions not named after their hash", func(t T) {
+		item := &FileListItem{Path: versionListPath(friend.Fingerprint(), "contract.txt.pgp", first), Sum: third}
+		err := client.downloadVersion(context.Background(), address, friend.Fingerprint(), t.TempDir(), item)
+		assert.ErrorIs(t, err, ErrVersionNameMismatch)
+	})
+}
@@ -0,0 +1,105 @@
+package mau
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+)
+
+// Signaling message types, the same as the TypeScript implementation
+const (
+	SignalingOffer        = "offer"
+	SignalingAnswer       = "answer"
+	SignalingICECandidate = "ice-candidate"
+)
+
+// SignalingMessage is exchanged between peers to establish a WebRTC
+// connection. Data holds the session description for offers and answers and
+// the ICE candidate for ice-candidate messages.
+type SignalingMessage struct {
+	From Fingerprint     `json:"from"`
+	To   Fingerprint     `json:"to"`
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// Signaling delivers signaling messages between peers
+type Signaling interface {
+	// Send delivers the message to the peer it's addressed to
+	Send(ctx context.Context, message *SignalingMessage) error
+	// Subscribe returns the messages addressed to us until ctx is cancelled.
+	// every subscriber receives every message.
+	Subscribe(ctx context.Context) <-chan *SignalingMessage
+}
+
+// LocalSignaling delivers signaling messages between peers in the same
+// process, useful for tests and local development
+type LocalSignaling struct {
+	mutex       sync.Mutex
+	subscribers map[string][]chan *SignalingMessage // key: fingerprint hex string
+}
+
+func NewLocalSignaling() *LocalSignaling {
+	return &LocalSignaling{subscribers: map[string][]chan *SignalingMessage{}}
+}
+
+// For returns the signaling of the peer with the fingerprint
+func (l *LocalSignaling) For(fingerprint Fingerprint) Signaling {
+	return &localSignaling{hub: l, fingerprint: fingerprint}
+}
+
+func (l *LocalSignaling) subscribe(ctx context.Context, fingerprint Fingerprint) <-chan *SignalingMessage {
+	messages := make(chan *SignalingMessage, signalingMailboxSize)
+
+	l.mutex.Lock()
+	l.subscribers[fingerprint.String()] = append(l.subscribers[fingerprint.String()], messages)
+	l.mutex.Unlock()
+
+	context.AfterFunc(ctx, func() { l.unsubscribe(fingerprint, messages) })
+
+	return messages
+}
+
+func (l *LocalSignaling) unsubscribe(fingerprint Fingerprint, messages chan *SignalingMessage) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	subscribers := l.subscribers[fingerprint.String()]
+	for i, s := range subscribers {
+		if s == messages {
+			l.subscribers[fingerprint.String()] = append(subscribers[:i], subscribers[i+1:]...)
+			close(messages)
+			return
+		}
+	}
+}
+
+// deliver sends the message to every subscriber of the recipient, messages
+// are dropped for subscribers that don't keep up
+func (l *LocalSignaling) deliver(message *SignalingMessage) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for _, s := range l.subscribers[message.To.String()] {
+		select {
+		case s <- message:
+		default:
+		}
+	}
+}
+
+type localSignaling struct {
+	hub         *LocalSignaling
+	fingerprint Fingerprint
+}
+
+func (s *localSignaling) Send(_ context.Context, message *SignalingMessage) error {
+	m := *message
+	m.From = s.fingerprint
+	s.hub.deliver(&m)
+	return nil
+}
+
+func (s *localSignaling) Subscribe(ctx context.Context) <-chan *SignalingMessage {
+	return s.hub.subscribe(ctx, s.fingerprint)
+}
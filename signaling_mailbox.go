@@ -0,0 +1,133 @@
+package mau
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// signalingMailboxes keeps the signaling messages of every recipient until
+// they are received or expire
+type signalingMailboxes struct {
+	mutex     sync.Mutex
+	mailboxes map[string]*signalingMailbox // key: fingerprint hex string of the recipient
+}
+
+type signalingMailbox struct {
+	messages []*signalingEnvelope
+	arrived  chan struct{} // closed and replaced when a message arrives
+	waiters  int           // recipients waiting for a message, the mailbox is kept while there are some
+}
+
+type signalingEnvelope struct {
+	message *SignalingMessage
+	expires time.Time
+}
+
+func newSignalingMailboxes() *signalingMailboxes {
+	return &signalingMailboxes{mailboxes: map[string]*signalingMailbox{}}
+}
+
+// mailbox returns the mailbox of the recipient, the caller holds the mutex
+func (m *signalingMailboxes) mailbox(recipient Fingerprint) *signalingMailbox {
+	mailbox, ok := m.mailboxes[recipient.String()]
+	if !ok {
+		mailbox = &signalingMailbox{arrived: make(chan struct{})}
+		m.mailboxes[recipient.String()] = mailbox
+	}
+
+	return mailbox
+}
+
+// put adds the message to the mailbox of its recipient, it fails if the
+// recipient has too many messages waiting
+func (m *signalingMailboxes) put(message *SignalingMessage) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	mailbox := m.mailbox(message.To)
+	mailbox.expire(time.Now())
+
+	if len(mailbox.messages) >= signalingMailboxSize {
+		return ErrSignalingMailboxFull
+	}
+
+	mailbox.messages = append(mailbox.messages, &signalingEnvelope{message: message, expires: time.Now().Add(signalingMessageTTL)})
+	close(mailbox.arrived)
+	mailbox.arrived = make(chan struct{})
+
+	return nil
+}
+
+// take removes and returns the messages waiting for the recipient. if there
+// is none and wait is set, the recipient is counted as waiting and a channel
+// closed when the next message arrives is returned
+func (m *signalingMailboxes) take(recipient Fingerprint, wait bool) ([]*SignalingMessage, <-chan struct{}) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	mailbox := m.mailbox(recipient)
+	mailbox.expire(time.Now())
+
+	if len(mailbox.messages) == 0 && wait {
+		mailbox.waiters++
+		return nil, mailbox.arrived
+	}
+
+	messages := make([]*SignalingMessage, 0, len(mailbox.messages))
+	for _, e := range mailbox.messages {
+		messages = append(messages, e.message)
+	}
+	mailbox.messages = nil
+
+	return messages, nil
+}
+
+// wait returns the messages waiting for the recipient, if there is none it
+// waits for one to arrive until ctx is done
+func (m *signalingMailboxes) wait(ctx context.Context, recipient Fingerprint) []*SignalingMessage {
+	messages, arrived := m.take(recipient, true)
+	if arrived == nil {
+		return messages
+	}
+
+	select {
+	case <-arrived:
+	case <-ctx.Done():
+	}
+
+	m.mutex.Lock()
+	m.mailbox(recipient).waiters--
+	m.mutex.Unlock()
+
+	// messages are left in the mailbox if the recipient went away
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	messages, _ = m.take(recipient, false)
+	return messages
+}
+
+// purge removes expired messages and mailboxes nobody waits on
+func (m *signalingMailboxes) purge() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := time.Now()
+	for k, mailbox := range m.mailboxes {
+		if mailbox.expire(now); len(mailbox.messages) == 0 && mailbox.waiters == 0 {
+			delete(m.mailboxes, k)
+		}
+	}
+}
+
+func (b *signalingMailbox) expire(now time.Time) {
+	messages := b.messages[:0]
+	for _, e := range b.messages {
+		if now.Before(e.expires) {
+			messages = append(messages, e)
+		}
+	}
+	b.messages = messages
+}
@@ -0,0 +1,68 @@
+package mau
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignalingMailboxes(t *testing.T) {
+	bob := Fingerprint{2}
+
+	t.Run("Returns the messages once", func(t T) {
+		mailboxes := newSignalingMailboxes()
+		require.NoError(t, mailboxes.put(&SignalingMessage{To: bob, Type: SignalingOffer}))
+
+		messages, _ := mailboxes.take(bob, false)
+		require.Len(t, messages, 1)
+		assert.Equal(t, SignalingOffer, messages[0].Type)
+
+		messages, _ = mailboxes.take(bob, false)
+		assert.Empty(t, messages)
+	})
+
+	t.Run("Refuses messages when the mailbox is full", func(t T) {
+		mailboxes := newSignalingMailboxes()
+		for range signalingMailboxSize {
+			require.NoError(t, mailboxes.put(&SignalingMessage{To: bob}))
+		}
+
+		assert.ErrorIs(t, mailboxes.put(&SignalingMessage{To: bob}), ErrSignalingMailboxFull)
+	})
+
+	t.Run("Drops expired messages", func(t T) {
+		mailboxes := newSignalingMailboxes()
+		require.NoError(t, mailboxes.put(&SignalingMessage{To: bob}))
+		mailboxes.mailboxes[bob.String()].messages[0].expires = time.Now().Add(-time.Second)
+
+		mailboxes.purge()
+		assert.Empty(t, mailboxes.mailboxes)
+	})
+
+	t.Run("Waits for a message to arrive", func(t T) {
+		mailboxes := newSignalingMailboxes()
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			_ = mailboxes.put(&SignalingMessage{To: bob, Type: SignalingAnswer})
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		messages := mailboxes.wait(ctx, bob)
+		require.Len(t, messages, 1)
+		assert.Equal(t, SignalingAnswer, messages[0].Type)
+	})
+
+	t.Run("Stops waiting when the context is done", func(t T) {
+		mailboxes := newSignalingMailboxes()
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		assert.Empty(t, mailboxes.wait(ctx, bob))
+		assert.Equal(t, 0, mailboxes.mailboxes[bob.String()].waiters)
+	})
+}
@@ -0,0 +1,124 @@
+package mau
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RemoteSignaling exchanges signaling messages through the signaling server
+// of node. both peers have to be friends of node.
+func (a *Account) RemoteSignaling(node *Peer) (Signaling, error) {
+	client, err := a.Client(node.Fingerprint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &remoteSignaling{
+		client:      client,
+		node:        node,
+		hub:         NewLocalSignaling(),
+		fingerprint: a.Fingerprint(),
+	}, nil
+}
+
+// remoteSignaling polls the messages addressed to us while there are
+// subscribers and hands every message to all of them
+type remoteSignaling struct {
+	client      *Client
+	node        *Peer
+	hub         *LocalSignaling
+	fingerprint Fingerprint
+
+	mutex       sync.Mutex
+	subscribers int
+	stop        context.CancelFunc
+}
+
+func (r *remoteSignaling) Send(ctx context.Context, message *SignalingMessage) error {
+	resp, err := r.client.client.
+		R().
+		SetContext(ctx).
+		SetBody(message).
+		Post(r.client.peerURL(r.node.Address, "/signaling/signal"))
+	if err != nil {
+		return fmt.Errorf("failed to send signaling message through %s: %w", r.node.Fingerprint, err)
+	}
+
+	if resp.StatusCode() != http.StatusAccepted {
+		return fmt.Errorf("signaling server %s responded with status %s: %s", r.node.Fingerprint, resp.Status(), resp.String())
+	}
+
+	return nil
+}
+
+func (r *remoteSignaling) Subscribe(ctx context.Context) <-chan *SignalingMessage {
+	messages := r.hub.subscribe(ctx, r.fingerprint)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.subscribers++
+	if r.subscribers == 1 {
+		var pollCtx context.Context
+		pollCtx, r.stop = context.WithCancel(context.Background())
+		go r.poll(pollCtx)
+	}
+
+	context.AfterFunc(ctx, r.unsubscribe)
+
+	return messages
+}
+
+func (r *remoteSignaling) unsubscribe() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.subscribers--
+	if r.subscribers == 0 {
+		r.stop()
+	}
+}
+
+// poll long polls the messages addressed to us until ctx is cancelled
+func (r *remoteSignaling) poll(ctx context.Context) {
+	for ctx.Err() == nil {
+		messages, err := r.fetch(ctx)
+		if err != nil && ctx.Err() == nil {
+			slog.Debug("failed to poll signaling messages", "node", r.node.Fingerprint, "error", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(signalingRemotePollWait):
+			}
+		}
+
+		for _, message := range messages {
+			r.hub.deliver(message)
+		}
+	}
+}
+
+func (r *remoteSignaling) fetch(ctx context.Context) ([]*SignalingMessage, error) {
+	var messages []*SignalingMessage
+
+	resp, err := r.client.client.
+		R().
+		SetContext(ctx).
+		SetQueryParam("wait", strconv.Itoa(int(signalingRemotePollWait/time.Second))).
+		SetResult(&messages).
+		ForceContentType("application/json").
+		Get(r.client.peerURL(r.node.Address, "/signaling/poll"))
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode() != http.StatusOK {
+		return nil, fmt.Errorf("signaling server responded with status %s", resp.Status())
+	}
+
+	return messages, nil
+}
@@ -0,0 +1,82 @@
+package mau
+
+import (
+	"context"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoteSignaling(t *testing.T) {
+	node, err := NewAccount(t.TempDir(), "Node", "node@example.com", "password")
+	require.NoError(t, err)
+	friend, err := NewAccount(t.TempDir(), "Mohamed Mahmoud", "mohamed@example.com", "password")
+	require.NoError(t, err)
+	accountDir := t.TempDir()
+	account, err := NewAccount(accountDir, "Ahmed Mohamed", "ahmed@example.com", "password")
+	require.NoError(t, err)
+
+	befriend(t, node, friend)
+	befriend(t, node, account)
+	aFriend := befriend(t, friend, account)
+	require.NoError(t, account.Follow(befriend(t, account, friend)))
+
+	nodeServer, err := node.Server(nil)
+	require.NoError(t, err)
+	listener, address := TempListener()
+	go func() { _ = nodeServer.Serve(*listener, "") }()
+	defer nodeServer.Close()
+	nodePeer := &Peer{Fingerprint: node.Fingerprint(), Address: address}
+
+	friendSignaling, err := friend.RemoteSignaling(nodePeer)
+	require.NoError(t, err)
+	accountSignaling, err := account.RemoteSignaling(nodePeer)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	t.Run("Delivers messages to every subscriber", func(t T) {
+		subCtx, subCancel := context.WithCancel(ctx)
+		defer subCancel()
+		first := friendSignaling.Subscribe(subCtx)
+		second := friendSignaling.Subscribe(subCtx)
+
+		err := accountSignaling.Send(ctx, &SignalingMessage{To: friend.Fingerprint(), Type: SignalingOffer})
+		require.NoError(t, err)
+
+		for _, messages := range []<-chan *SignalingMessage{first, second} {
+			message := <-messages
+			assert.Equal(t, account.Fingerprint(), message.From)
+			assert.Equal(t, SignalingOffer, message.Type)
+		}
+	})
+
+	t.Run("Fails to send to strangers", func(t T) {
+		err := accountSignaling.Send(ctx, &SignalingMessage{To: Fingerprint{1}, Type: SignalingOffer})
+		assert.ErrorContains(t, err, "403")
+	})
+
+	t.Run("Downloads files over WebRTC negotiated through the node", func(t T) {
+		_, err := friend.AddFile(strings.NewReader("Hello through the node"), "hello.txt", []*Friend{aFriend})
+		require.NoError(t, err)
+
+		friendServer, err := friend.Server(nil)
+		require.NoError(t, err)
+		defer friendServer.Close()
+		go func() { _ = friendServer.ServeWebRTC(ctx, WebRTCConfig{Signaling: friendSignaling}) }()
+
+		client, err := account.Client(friend.Fingerprint(), nil)
+		require.NoError(t, err)
+		client.SetWebRTC(WebRTCConfig{Signaling: accountSignaling})
+
+		err = client.DownloadFriend(ctx, friend.Fingerprint(), time.Time{}, []FingerprintResolver{WebRTCFriendAddress})
+		require.NoError(t, err)
+
+		assert.FileExists(t, path.Join(accountDir, friend.Fingerprint().String(), "hello.txt.pgp"))
+	})
+}
@@ -0,0 +1,175 @@
+package mau
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Signaling server: friends of the account exchange signaling messages
+// through it to establish WebRTC connections with each other, browsers
+// included. peers authenticate with their TLS certificate or, when they can't
+// present one like browsers, with a session token obtained by signing a
+// challenge. messages wait in the mailbox of their recipient until it polls
+// them or receives them over a WebSocket, they expire if it doesn't.
+
+var (
+	ErrNotSignalingFriend        = errors.New("Signaling is restricted to friends")
+	ErrSignalingMailboxFull      = errors.New("Signaling mailbox of the peer is full")
+	ErrSignalingChallengeInvalid = errors.New("Signaling challenge is invalid, expired or used")
+	ErrSignalingSessionInvalid   = errors.New("Signaling session is unknown or expired")
+)
+
+type signalingServer struct {
+	mux        *http.ServeMux
+	account    *Account
+	key        []byte // authenticates the challenges the server issued
+	mutex      sync.Mutex
+	challenges map[string]time.Time         // challenges used to open a session, value: expiry
+	sessions   map[string]*signalingSession // key: session token
+	mailboxes  *signalingMailboxes
+}
+
+type signalingSession struct {
+	Token       string      `json:"token"`
+	Fingerprint Fingerprint `json:"fingerprint"`
+	Expires     time.Time   `json:"expires"`
+}
+
+// signalingLogin proves the ownership of the public key by signing a challenge
+type signalingLogin struct {
+	PublicKey string `json:"publicKey"`
+	Challenge string `json:"challenge"`
+	Signature string `json:"signature"`
+}
+
+func newSignalingServer(account *Account) *signalingServer {
+	key := make([]byte, sha256.Size)
+	_, _ = rand.Read(key) // never fails
+
+	s := &signalingServer{
+		mux:        http.NewServeMux(),
+		account:    account,
+		key:        key,
+		challenges: map[string]time.Time{},
+		sessions:   map[string]*signalingSession{},
+		mailboxes:  newSignalingMailboxes(),
+	}
+
+	s.mux.HandleFunc("OPTIONS /signaling/", s.preflight)
+	s.mux.HandleFunc("GET /signaling/challenge", s.challenge)
+	s.mux.HandleFunc("POST /signaling/session", s.session)
+	s.mux.HandleFunc("POST /signaling/signal", s.signal)
+	s.mux.HandleFunc("GET /signaling/poll", s.poll)
+	s.mux.HandleFunc("GET /signaling/ws", s.websocket)
+
+	return s
+}
+
+// ServeHTTP allows requests from browser pages of any origin, requests are
+// authenticated by the token in their headers not by cookies
+func (s *signalingServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	s.mux.ServeHTTP(w, req)
+}
+
+func (s *signalingServer) preflight(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// signal puts the message in the mailbox of its recipient, the sender is
+// always the requester
+func (s *signalingServer) signal(w http.ResponseWriter, req *http.Request) {
+	fpr, err := s.authorize(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	var message SignalingMessage
+	if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, signalingMaxMessageSize)).Decode(&message); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch err := s.deliver(fpr, &message); {
+	case errors.Is(err, ErrSignalingMailboxFull):
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusForbidden)
+	default:
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+func (s *signalingServer) deliver(from Fingerprint, message *SignalingMessage) error {
+	if message.To == nil || !s.isMember(message.To) {
+		return ErrNotSignalingFriend
+	}
+
+	message.From = from
+	s.mailboxes.purge()
+
+	return s.mailboxes.put(message)
+}
+
+// poll responds with the messages waiting for the requester. with the wait
+// parameter it waits up to that many seconds for a message to arrive
+func (s *signalingServer) poll(w http.ResponseWriter, req *http.Request) {
+	fpr, err := s.authorize(req)
+	if err == nil {
+		err = checkPollFingerprint(req, fpr)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), pollWait(req))
+	defer cancel()
+
+	messages := s.mailboxes.wait(ctx, fpr)
+	if messages == nil {
+		messages = []*SignalingMessage{}
+	}
+
+	writeJSON(w, http.StatusOK, messages)
+}
+
+// checkPollFingerprint makes sure the fingerprint parameter TypeScript
+// clients send is the requester's
+func checkPollFingerprint(req *http.Request, fpr Fingerprint) error {
+	param := req.URL.Query().Get("fingerprint")
+	if param == "" {
+		return nil
+	}
+
+	if f, err := FingerprintFromString(param); err != nil || !f.Equal(fpr) {
+		return ErrNotSignalingFriend
+	}
+
+	return nil
+}
+
+func pollWait(req *http.Request) time.Duration {
+	seconds, err := strconv.Atoi(req.URL.Query().Get("wait"))
+	if err != nil || seconds < 0 {
+		return 0
+	}
+
+	return min(time.Duration(seconds)*time.Second, signalingMaxPollWait)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,225 @@
+package mau
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// signalingLoginFor opens a signaling session for account like a browser does
+func signalingLoginFor(t *testing.T, url string, account *Account) (*http.Response, *signalingSession) {
+	resp, err := http.Get(url + "/signaling/challenge")
+	require.NoError(t, err)
+	var challenge map[string]string
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&challenge))
+	_ = resp.Body.Close()
+
+	publicKey, err := account.armoredPublicKey()
+	require.NoError(t, err)
+	signature, err := account.signChallenge([]byte(challenge["challenge"]))
+	require.NoError(t, err)
+
+	body, err := json.Marshal(signalingLogin{PublicKey: publicKey, Challenge: challenge["challenge"], Signature: signature})
+	require.NoError(t, err)
+	resp, err = http.Post(url+"/signaling/session", "application/json", bytes.NewReader(body))
+	require.NoError(t, err)
+	defer func() { _ = resp.Body.Close() }()
+
+	var session signalingSession
+	_ = json.NewDecoder(resp.Body).Decode(&session)
+	return resp, &session
+}
+
+func signalingRequest(t *testing.T, method, url, token string, body any) *http.Response {
+	data, err := json.Marshal(body)
+	require.NoError(t, err)
+	req, err := http.NewRequest(method, url, bytes.NewReader(data))
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = resp.Body.Close() })
+	return resp
+}
+
+func TestSignalingServer(t *testing.T) {
+	node, err := NewAccount(t.TempDir(), "Node", "node@example.com", "password")
+	require.NoError(t, err)
+	alice, err := NewAccount(t.TempDir(), "Alice", "alice@example.com", "password")
+	require.NoError(t, err)
+	bob, err := NewAccount(t.TempDir(), "Bob", "bob@example.com", "password")
+	require.NoError(t, err)
+	befriend(t, node, alice)
+	befriend(t, node, bob)
+
+	server := httptest.NewServer(newSignalingServer(node))
+	defer server.Close()
+
+	resp, aliceSession := signalingLoginFor(t, server.URL, alice)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, bobSession := signalingLoginFor(t, server.URL, bob)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	t.Run("Opens sessions for friends who sign a challenge", func(t T) {
+		assert.Equal(t, alice.Fingerprint(), aliceSession.Fingerprint)
+		assert.NotEmpty(t, aliceSession.Token)
+		assert.True(t, aliceSession.Expires.After(time.Now()))
+	})
+
+	t.Run("Refuses strangers", func(t T) {
+		stranger, err := NewAccount(t.TempDir(), "Stranger", "stranger@example.com", "password")
+		require.NoError(t, err)
+
+		resp, _ := signalingLoginFor(t, server.URL, stranger)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+
+	t.Run("Refuses challenges it didn't issue", func(t T) {
+		publicKey, err := alice.armoredPublicKey()
+		require.NoError(t, err)
+		signature, err := alice.signChallenge([]byte("made up"))
+		require.NoError(t, err)
+
+		fpr, err := newSignalingServer(node).login(&signalingLogin{PublicKey: publicKey, Challenge: "made up", Signature: signature})
+		assert.Nil(t, fpr)
+		assert.ErrorIs(t, err, ErrSignalingChallengeInvalid)
+	})
+
+	t.Run("Keeps no state for the challenges it issues", func(t T) {
+		signaling := newSignalingServer(node)
+		for range 2000 {
+			_, err := signaling.newChallenge()
+			require.NoError(t, err)
+		}
+		assert.Empty(t, signaling.challenges)
+	})
+
+	t.Run("Refuses challenges used or tampered with", func(t T) {
+		signaling := newSignalingServer(node)
+		publicKey, err := alice.armoredPublicKey()
+		require.NoError(t, err)
+		login := func(challenge string) error {
+			signature, err := alice.signChallenge([]byte(challenge))
+			require.NoError(t, err)
+			_, err = signaling.login(&signalingLogin{PublicKey: publicKey, Challenge: challenge, Signature: signature})
+			return err
+		}
+
+		challenge, err := signaling.newChallenge()
+		require.NoError(t, err)
+		require.NoError(t, login(challenge))
+		assert.ErrorIs(t, login(challenge), ErrSignalingChallengeInvalid)
+
+		other, err := signaling.newChallenge()
+		require.NoError(t, err)
+		tampered := []byte(other)
+		tampered[0] ^= 1
+		assert.ErrorIs(t, login(string(tampered)), ErrSignalingChallengeInvalid)
+
+		issued, err := newSignalingServer(node).newChallenge()
+		require.NoError(t, err)
+		assert.ErrorIs(t, login(issued), ErrSignalingChallengeInvalid)
+	})
+
+	t.Run("Refuses requests without a session", func(t T) {
+		resp := signalingRequest(t, http.MethodPost, server.URL+"/signaling/signal", "invalid", &SignalingMessage{To: bob.Fingerprint()})
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+
+	t.Run("Delivers messages with the sender fingerprint", func(t T) {
+		message := &SignalingMessage{From: node.Fingerprint(), To: bob.Fingerprint(), Type: SignalingOffer, Data: json.RawMessage(`{"sdp":"v=0"}`)}
+		resp := signalingRequest(t, http.MethodPost, server.URL+"/signaling/signal", aliceSession.Token, message)
+		require.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+		resp = signalingRequest(t, http.MethodGet, server.URL+"/signaling/poll?fingerprint="+bob.Fingerprint().String(), bobSession.Token, nil)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var messages []*SignalingMessage
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&messages))
+		require.Len(t, messages, 1)
+		assert.Equal(t, alice.Fingerprint(), messages[0].From)
+		assert.JSONEq(t, `{"sdp":"v=0"}`, string(messages[0].Data))
+	})
+
+	t.Run("Refuses polling the mailbox of another peer", func(t T) {
+		resp := signalingRequest(t, http.MethodGet, server.URL+"/signaling/poll?fingerprint="+bob.Fingerprint().String(), aliceSession.Token, nil)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+
+	t.Run("Refuses messages to strangers", func(t T) {
+		resp := signalingRequest(t, http.MethodPost, server.URL+"/signaling/signal", aliceSession.Token, &SignalingMessage{To: Fingerprint{1}})
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+
+	t.Run("Refuses messages when the mailbox is full", func(t T) {
+		for range signalingMailboxSize {
+			resp := signalingRequest(t, http.MethodPost, server.URL+"/signaling/signal", bobSession.Token, &SignalingMessage{To: alice.Fingerprint()})
+			require.Equal(t, http.StatusAccepted, resp.StatusCode)
+		}
+
+		resp := signalingRequest(t, http.MethodPost, server.URL+"/signaling/signal", bobSession.Token, &SignalingMessage{To: alice.Fingerprint()})
+		assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+
+		resp = signalingRequest(t, http.MethodGet, server.URL+"/signaling/poll", aliceSession.Token, nil)
+		var messages []*SignalingMessage
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&messages))
+		assert.Len(t, messages, signalingMailboxSize)
+	})
+
+	t.Run("Exchanges messages over a WebSocket", func(t T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		wsURL := strings.Replace(server.URL, "http", "ws", 1) + "/signaling/ws?token="
+		aliceConn, _, err := websocket.Dial(ctx, wsURL+aliceSession.Token, nil)
+		require.NoError(t, err)
+		defer func() { _ = aliceConn.CloseNow() }()
+		bobConn, _, err := websocket.Dial(ctx, wsURL+bobSession.Token, nil)
+		require.NoError(t, err)
+		defer func() { _ = bobConn.CloseNow() }()
+
+		require.NoError(t, wsjson.Write(ctx, bobConn, map[string]string{"type": "register", "fingerprint": bob.Fingerprint().String()}))
+		require.NoError(t, wsjson.Write(ctx, aliceConn, &SignalingMessage{To: bob.Fingerprint(), Type: SignalingAnswer}))
+
+		var message SignalingMessage
+		require.NoError(t, wsjson.Read(ctx, bobConn, &message))
+		assert.Equal(t, alice.Fingerprint(), message.From)
+		assert.Equal(t, SignalingAnswer, message.Type)
+	})
+
+	t.Run("Allows browser pages of any origin", func(t T) {
+		req, err := http.NewRequest(http.MethodOptions, server.URL+"/signaling/signal", nil)
+		require.NoError(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer func() { _ = resp.Body.Close() }()
+
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+		assert.Equal(t, "*", resp.Header.Get("Access-Control-Allow-Origin"))
+		assert.Contains(t, resp.Header.Get("Access-Control-Allow-Headers"), "Authorization")
+	})
+}
+
+func TestPollWait(t *testing.T) {
+	for query, wait := range map[string]time.Duration{
+		"":         0,
+		"wait=-1":  0,
+		"wait=abc": 0,
+		"wait=3":   3 * time.Second,
+		"wait=999": signalingMaxPollWait,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/signaling/poll?"+query, nil)
+		assert.Equal(t, wait, pollWait(req), query)
+	}
+}
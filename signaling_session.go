@@ -0,0 +1,197 @@
+package mau
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// challenge responds with a challenge the requester signs to open a session
+func (s *signalingServer) challenge(w http.ResponseWriter, _ *http.Request) {
+	challenge, err := s.newChallenge()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"challenge": challenge})
+}
+
+// newChallenge returns a random nonce and its expiry authenticated with the
+// server key. the server checks it when it's signed instead of keeping it, so
+// asking for challenges costs it no memory.
+func (s *signalingServer) newChallenge() (string, error) {
+	challenge := make([]byte, signalingNonceSize)
+	if _, err := rand.Read(challenge); err != nil {
+		return "", err
+	}
+
+	challenge = binary.BigEndian.AppendUint64(challenge, uint64(time.Now().Add(signalingChallengeTTL).Unix()))
+
+	return hex.EncodeToString(append(challenge, s.challengeMAC(challenge)...)), nil
+}
+
+// checkChallenge returns the expiry of a challenge the server issued
+func (s *signalingServer) checkChallenge(challenge string) (time.Time, error) {
+	data, err := hex.DecodeString(challenge)
+	if err != nil || len(data) != signalingNonceSize+8+sha256.Size {
+		return time.Time{}, ErrSignalingChallengeInvalid
+	}
+
+	signed, mac := data[:signalingNonceSize+8], data[signalingNonceSize+8:]
+	if !hmac.Equal(mac, s.challengeMAC(signed)) {
+		return time.Time{}, ErrSignalingChallengeInvalid
+	}
+
+	expires := time.Unix(int64(binary.BigEndian.Uint64(signed[signalingNonceSize:])), 0)
+	if time.Now().After(expires) {
+		return time.Time{}, ErrSignalingChallengeInvalid
+	}
+
+	return expires, nil
+}
+
+func (s *signalingServer) challengeMAC(data []byte) []byte {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// session opens a session for the friend who signed a challenge
+func (s *signalingServer) session(w http.ResponseWriter, req *http.Request) {
+	var login signalingLogin
+	if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, signalingMaxMessageSize)).Decode(&login); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	fpr, err := s.login(&login)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	session, err := s.newSession(fpr)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, session)
+}
+
+// login returns the fingerprint of the friend who signed the challenge, each
+// challenge can be used once
+func (s *signalingServer) login(login *signalingLogin) (Fingerprint, error) {
+	expires, err := s.checkChallenge(login.Challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	fpr, err := verifyChallenge(login.PublicKey, []byte(login.Challenge), login.Signature)
+	if err != nil {
+		return nil, err
+	}
+
+	if !s.isMember(fpr) {
+		return nil, ErrNotSignalingFriend
+	}
+
+	// only the challenges friends signed are kept, until they expire
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.expire(time.Now())
+	if _, used := s.challenges[login.Challenge]; used {
+		return nil, ErrSignalingChallengeInvalid
+	}
+	s.challenges[login.Challenge] = expires
+
+	return fpr, nil
+}
+
+func (s *signalingServer) newSession(fpr Fingerprint) (*signalingSession, error) {
+	token := make([]byte, signalingTokenSize)
+	if _, err := rand.Read(token); err != nil {
+		return nil, err
+	}
+
+	session := &signalingSession{
+		Token:       hex.EncodeToString(token),
+		Fingerprint: fpr,
+		Expires:     time.Now().Add(signalingSessionTTL).UTC(),
+	}
+
+	s.mutex.Lock()
+	s.sessions[session.Token] = session
+	s.mutex.Unlock()
+
+	return session, nil
+}
+
+// expire removes expired used challenges and sessions, the caller holds the mutex
+func (s *signalingServer) expire(now time.Time) {
+	for c, expires := range s.challenges {
+		if now.After(expires) {
+			delete(s.challenges, c)
+		}
+	}
+
+	for token, session := range s.sessions {
+		if now.After(session.Expires) {
+			delete(s.sessions, token)
+		}
+	}
+}
+
+// authorize returns the fingerprint of the requester authenticated by its
+// session token or its TLS certificate if it's one of our friends or us
+func (s *signalingServer) authorize(req *http.Request) (Fingerprint, error) {
+	token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		// browsers can't set headers on WebSocket connections
+		token = req.URL.Query().Get("token")
+	}
+
+	fpr, err := s.sessionPeer(token)
+	if token == "" {
+		fpr, err = requestPeer(req)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !s.isMember(fpr) {
+		return nil, ErrNotSignalingFriend
+	}
+
+	return fpr, nil
+}
+
+func (s *signalingServer) sessionPeer(token string) (Fingerprint, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	session, ok := s.sessions[token]
+	if !ok || time.Now().After(session.Expires) {
+		return nil, ErrSignalingSessionInvalid
+	}
+
+	return session.Fingerprint, nil
+}
+
+// isMember returns true for the account and its friends
+func (s *signalingServer) isMember(fpr Fingerprint) bool {
+	if fpr.Equal(s.account.Fingerprint()) {
+		return true
+	}
+
+	friends, err := s.account.ListFriends()
+	return err == nil && friends.FindByFingerprint(fpr) != nil
+}
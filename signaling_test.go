@@ -0,0 +1,42 @@
+package mau
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalSignaling(t *testing.T) {
+	alice := Fingerprint{1}
+	bob := Fingerprint{2}
+	hub := NewLocalSignaling()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	messages := hub.For(bob).Subscribe(ctx)
+
+	t.Run("Delivers messages to the recipient with the sender fingerprint", func(t T) {
+		err := hub.For(alice).Send(ctx, &SignalingMessage{From: Fingerprint{3}, To: bob, Type: SignalingOffer})
+		require.NoError(t, err)
+
+		message := <-messages
+		assert.Equal(t, alice, message.From)
+		assert.Equal(t, SignalingOffer, message.Type)
+	})
+
+	t.Run("Doesn't deliver messages to other peers", func(t T) {
+		err := hub.For(bob).Send(ctx, &SignalingMessage{To: alice, Type: SignalingAnswer})
+		require.NoError(t, err)
+		assert.Empty(t, messages)
+	})
+
+	t.Run("Closes the subscription when the context is done", func(t T) {
+		cancel()
+		assert.Eventually(t, func() bool {
+			_, ok := <-messages
+			return !ok
+		}, time.Second, 10*time.Millisecond)
+	})
+}
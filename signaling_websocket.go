@@ -0,0 +1,74 @@
+package mau
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+)
+
+// websocket exchanges signaling messages with the requester over a WebSocket,
+// messages it sends are delivered like signal and messages addressed to it
+// are pushed as they arrive
+func (s *signalingServer) websocket(w http.ResponseWriter, req *http.Request) {
+	fpr, err := s.authorize(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	_ = rc.SetReadDeadline(time.Time{}) // the connection outlives the server timeouts
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	// any origin is allowed, the requester is authenticated by its token
+	conn, err := websocket.Accept(w, req, &websocket.AcceptOptions{InsecureSkipVerify: true})
+	if err != nil {
+		return
+	}
+	defer func() { _ = conn.CloseNow() }()
+	conn.SetReadLimit(signalingMaxMessageSize)
+
+	ctx, cancel := context.WithCancel(req.Context())
+	defer cancel()
+
+	go func() {
+		defer cancel()
+		s.receiveSignals(ctx, conn, fpr)
+	}()
+
+	s.pushSignals(ctx, conn, fpr)
+}
+
+// receiveSignals delivers the messages sent by the requester. the register
+// message TypeScript clients start with is ignored, the requester is already
+// known from its token.
+func (s *signalingServer) receiveSignals(ctx context.Context, conn *websocket.Conn, fpr Fingerprint) {
+	for {
+		var message SignalingMessage
+		if err := wsjson.Read(ctx, conn, &message); err != nil {
+			return
+		}
+
+		if message.Type == "register" {
+			continue
+		}
+
+		if err := s.deliver(fpr, &message); err != nil {
+			slog.Warn("failed to deliver signaling message", "from", fpr, "to", message.To, "error", err)
+		}
+	}
+}
+
+func (s *signalingServer) pushSignals(ctx context.Context, conn *websocket.Conn, fpr Fingerprint) {
+	for ctx.Err() == nil {
+		for _, message := range s.mailboxes.wait(ctx, fpr) {
+			if err := wsjson.Write(ctx, conn, message); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package mau
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+)
+
+// Storage keeps the files of an account: its key, friend keys, content
+// files and their versions. paths are the slash separated paths the account
+// joins to its root directory, so the same layout is used by every backend.
+// implementations have to be safe for concurrent use.
+type Storage interface {
+	Open(name string) (io.ReadSeekCloser, error)
+	ReadFile(name string) ([]byte, error)
+	// WriteFile replaces the file content atomically, readers see the old or
+	// the new content. the parent directory has to exist.
+	WriteFile(name string, data []byte) error
+	// Create returns a writer replacing the file content atomically when it's
+	// closed. the parent directory has to exist.
+	Create(name string) (io.WriteCloser, error)
+	Stat(name string) (fs.FileInfo, error)
+	// ReadDir returns the entries of the directory sorted by name
+	ReadDir(name string) ([]fs.DirEntry, error)
+	MkdirAll(name string) error
+	// Rename moves a file or a directory with its content
+	Rename(oldname, newname string) error
+	// Remove removes a file or an empty directory
+	Remove(name string) error
+	RemoveAll(name string) error
+}
+
+// exists is true when the path exists in storage
+func exists(s Storage, name string) bool {
+	_, err := s.Stat(name)
+	return err == nil
+}
+
+// walkStorage calls fn for every entry under dir, directories before their
+// content
+func walkStorage(s Storage, dir string, fn func(name string, entry fs.DirEntry)) {
+	entries, err := s.ReadDir(dir)
+	if err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		name := path.Join(dir, entry.Name())
+		fn(name, entry)
+		if entry.IsDir() {
+			walkStorage(s, name, fn)
+		}
+	}
+}
+
+// dirSize returns the total size of the regular files under dir
+func dirSize(s Storage, dir string) int64 {
+	var size int64
+
+	walkStorage(s, dir, func(_ string, entry fs.DirEntry) {
+		if !entry.Type().IsRegular() {
+			return
+		}
+
+		if info, err := entry.Info(); err == nil {
+			size += info.Size()
+		}
+	})
+
+	return size
+}
+
+// removeIfExists removes a file, it's not an error if it doesn't exist
+func removeIfExists(s Storage, name string) error {
+	err := s.Remove(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,76 @@
+package mau
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path"
+)
+
+// DiskStorage keeps the account files on the local filesystem, paths are
+// filesystem paths
+type DiskStorage struct{}
+
+func (DiskStorage) Open(name string) (io.ReadSeekCloser, error) { return os.Open(name) }
+func (DiskStorage) ReadFile(name string) ([]byte, error)        { return os.ReadFile(name) }
+func (DiskStorage) Stat(name string) (fs.FileInfo, error)       { return os.Stat(name) }
+func (DiskStorage) ReadDir(name string) ([]fs.DirEntry, error)  { return os.ReadDir(name) }
+func (DiskStorage) MkdirAll(name string) error                  { return os.MkdirAll(name, DirPerm) }
+func (DiskStorage) Rename(oldname, newname string) error        { return os.Rename(oldname, newname) }
+func (DiskStorage) Remove(name string) error                    { return os.Remove(name) }
+func (DiskStorage) RemoveAll(name string) error                 { return os.RemoveAll(name) }
+
+func (s DiskStorage) WriteFile(name string, data []byte) error {
+	w, err := s.Create(name)
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(data); err != nil {
+		_ = w.(*diskWriter).abort()
+		return err
+	}
+
+	return w.Close()
+}
+
+// Create writes to a temporary file next to the file and renames it over
+// the file on close
+func (DiskStorage) Create(name string) (io.WriteCloser, error) {
+	tmp, err := os.CreateTemp(path.Dir(name), "."+path.Base(name)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tmp.Chmod(FilePerm); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return nil, err
+	}
+
+	return &diskWriter{File: tmp, name: name}, nil
+}
+
+type diskWriter struct {
+	*os.File
+	name string
+}
+
+func (w *diskWriter) Close() error {
+	if err := w.File.Close(); err != nil {
+		_ = os.Remove(w.File.Name())
+		return err
+	}
+
+	if err := os.Rename(w.File.Name(), w.name); err != nil {
+		_ = os.Remove(w.File.Name())
+		return err
+	}
+
+	return nil
+}
+
+func (w *diskWriter) abort() error {
+	_ = w.File.Close()
+	return os.Remove(w.File.Name())
+}
@@ -0,0 +1,259 @@
+package mau
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStorage keeps the account files in memory, for tests and
+// applications embedding an account without a filesystem
+type MemoryStorage struct {
+	mutex sync.RWMutex
+	files map[string]*memoryFile // key: clean path
+}
+
+type memoryFile struct {
+	data     []byte
+	dir      bool
+	modified time.Time
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{files: map[string]*memoryFile{"/": {dir: true, modified: time.Now()}}}
+}
+
+// clean makes relative paths relative to the root so every path has a parent
+func (s *MemoryStorage) clean(name string) string {
+	return path.Clean("/" + name)
+}
+
+func (s *MemoryStorage) lookup(name string, op string) (*memoryFile, error) {
+	f, ok := s.files[s.clean(name)]
+	if !ok {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+
+	return f, nil
+}
+
+func (s *MemoryStorage) Open(name string) (io.ReadSeekCloser, error) {
+	data, err := s.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return nopSeekCloser{bytes.NewReader(data)}, nil
+}
+
+func (s *MemoryStorage) ReadFile(name string) ([]byte, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	f, err := s.lookup(name, "read")
+	if err != nil {
+		return nil, err
+	}
+	if f.dir {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrInvalid}
+	}
+
+	return bytes.Clone(f.data), nil
+}
+
+func (s *MemoryStorage) WriteFile(name string, data []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	parent, err := s.lookup(path.Dir(s.clean(name)), "write")
+	if err != nil || !parent.dir {
+		return &fs.PathError{Op: "write", Path: name, Err: fs.ErrNotExist}
+	}
+	if f, ok := s.files[s.clean(name)]; ok && f.dir {
+		return &fs.PathError{Op: "write", Path: name, Err: fs.ErrExist}
+	}
+
+	s.files[s.clean(name)] = &memoryFile{data: bytes.Clone(data), modified: time.Now()}
+	return nil
+}
+
+// Create buffers the content and writes it when the writer is closed
+func (s *MemoryStorage) Create(name string) (io.WriteCloser, error) {
+	s.mutex.RLock()
+	parent, err := s.lookup(path.Dir(s.clean(name)), "create")
+	s.mutex.RUnlock()
+	if err != nil || !parent.dir {
+		return nil, &fs.PathError{Op: "create", Path: name, Err: fs.ErrNotExist}
+	}
+
+	return &memoryWriter{storage: s, name: name}, nil
+}
+
+func (s *MemoryStorage) Stat(name string) (fs.FileInfo, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	f, err := s.lookup(name, "stat")
+	if err != nil {
+		return nil, err
+	}
+
+	return f.info(path.Base(s.clean(name))), nil
+}
+
+func (s *MemoryStorage) ReadDir(name string) ([]fs.DirEntry, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	dir, err := s.lookup(name, "readdir")
+	if err != nil {
+		return nil, err
+	}
+	if !dir.dir {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+
+	entries := []fs.DirEntry{}
+	for p, f := range s.files {
+		if p != "/" && path.Dir(p) == s.clean(name) {
+			entries = append(entries, fs.FileInfoToDirEntry(f.info(path.Base(p))))
+		}
+	}
+
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int { return strings.Compare(a.Name(), b.Name()) })
+	return entries, nil
+}
+
+func (s *MemoryStorage) MkdirAll(name string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for p := s.clean(name); ; p = path.Dir(p) {
+		if f, ok := s.files[p]; ok && !f.dir {
+			return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+		} else if !ok {
+			s.files[p] = &memoryFile{dir: true, modified: time.Now()}
+		}
+
+		if p == "/" {
+			return nil
+		}
+	}
+}
+
+// Rename moves the file or the directory with everything under it
+func (s *MemoryStorage) Rename(oldname, newname string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	oldPath, newPath := s.clean(oldname), s.clean(newname)
+	if _, err := s.lookup(oldPath, "rename"); err != nil {
+		return err
+	}
+	if parent, ok := s.files[path.Dir(newPath)]; !ok || !parent.dir {
+		return &fs.PathError{Op: "rename", Path: newname, Err: fs.ErrNotExist}
+	}
+
+	moved := map[string]*memoryFile{}
+	for p, f := range s.files {
+		if p == oldPath || strings.HasPrefix(p, oldPath+"/") {
+			moved[newPath+strings.TrimPrefix(p, oldPath)] = f
+			delete(s.files, p)
+		}
+	}
+	maps.Copy(s.files, moved)
+
+	return nil
+}
+
+func (s *MemoryStorage) Remove(name string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	p := s.clean(name)
+	if _, err := s.lookup(p, "remove"); err != nil {
+		return err
+	}
+
+	for other := range s.files {
+		if strings.HasPrefix(other, p+"/") {
+			return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrExist}
+		}
+	}
+
+	delete(s.files, p)
+	return nil
+}
+
+func (s *MemoryStorage) RemoveAll(name string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	p := s.clean(name)
+	for other := range s.files {
+		if other != "/" && (other == p || strings.HasPrefix(other, p+"/")) {
+			delete(s.files, other)
+		}
+	}
+
+	return nil
+}
+
+// Chtimes sets the modification time of a file
+func (s *MemoryStorage) Chtimes(name string, modified time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	f, err := s.lookup(name, "chtimes")
+	if err != nil {
+		return err
+	}
+
+	f.modified = modified
+	return nil
+}
+
+func (f *memoryFile) info(name string) fs.FileInfo {
+	return &memoryFileInfo{name: name, file: *f}
+}
+
+type memoryFileInfo struct {
+	name string
+	file memoryFile
+}
+
+func (i *memoryFileInfo) Name() string       { return i.name }
+func (i *memoryFileInfo) Size() int64        { return int64(len(i.file.data)) }
+func (i *memoryFileInfo) ModTime() time.Time { return i.file.modified }
+func (i *memoryFileInfo) IsDir() bool        { return i.file.dir }
+func (i *memoryFileInfo) Sys() any           { return nil }
+
+func (i *memoryFileInfo) Mode() fs.FileMode {
+	if i.file.dir {
+		return fs.ModeDir | DirPerm
+	}
+
+	return FilePerm
+}
+
+type memoryWriter struct {
+	bytes.Buffer
+	storage *MemoryStorage
+	name    string
+}
+
+func (w *memoryWriter) Close() error {
+	return w.storage.WriteFile(w.name, w.Bytes())
+}
+
+type nopSeekCloser struct {
+	io.ReadSeeker
+}
+
+func (nopSeekCloser) Close() error { return nil }
@@ -0,0 +1,181 @@
+package mau
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStorage(t *testing.T) {
+	backends := map[string]func(t T) (Storage, string){
+		"Disk":   func(t T) (Storage, string) { return DiskStorage{}, t.TempDir() },
+		"Memory": func(t T) (Storage, string) { return NewMemoryStorage(), "/account" },
+	}
+
+	for name, backend := range backends {
+		t.Run(name, func(t T) {
+			s, root := backend(t)
+			require.NoError(t, s.MkdirAll(path.Join(root, "dir")))
+			file := path.Join(root, "dir", "file.pgp")
+
+			t.Run("Writes and reads files", func(t T) {
+				require.NoError(t, s.WriteFile(file, []byte("hello")))
+
+				data, err := s.ReadFile(file)
+				require.NoError(t, err)
+				assert.Equal(t, "hello", string(data))
+
+				info, err := s.Stat(file)
+				require.NoError(t, err)
+				assert.Equal(t, int64(5), info.Size())
+				assert.True(t, info.Mode().IsRegular())
+			})
+
+			t.Run("Create replaces the content on close", func(t T) {
+				w, err := s.Create(file)
+				require.NoError(t, err)
+				_, err = w.Write([]byte("world"))
+				require.NoError(t, err)
+
+				data, _ := s.ReadFile(file)
+				assert.Equal(t, "hello", string(data))
+
+				require.NoError(t, w.Close())
+				data, _ = s.ReadFile(file)
+				assert.Equal(t, "world", string(data))
+			})
+
+			t.Run("Opens files for seeking", func(t T) {
+				r, err := s.Open(file)
+				require.NoError(t, err)
+				defer r.Close()
+
+				_, err = r.Seek(2, io.SeekStart)
+				require.NoError(t, err)
+				data, err := io.ReadAll(r)
+				require.NoError(t, err)
+				assert.Equal(t, "rld", string(data))
+			})
+
+			t.Run("Writing needs the parent directory", func(t T) {
+				assert.Error(t, s.WriteFile(path.Join(root, "missing", "file.pgp"), []byte{}))
+				_, err := s.Create(path.Join(root, "missing", "file.pgp"))
+				assert.Error(t, err)
+			})
+
+			t.Run("Lists directories sorted by name", func(t T) {
+				require.NoError(t, s.WriteFile(path.Join(root, "dir", "a.pgp"), []byte("a")))
+				require.NoError(t, s.MkdirAll(path.Join(root, "dir", "file.pgp.versions")))
+
+				entries, err := s.ReadDir(path.Join(root, "dir"))
+				require.NoError(t, err)
+				names := []string{}
+				for _, e := range entries {
+					names = append(names, e.Name())
+				}
+				assert.Equal(t, []string{"a.pgp", "file.pgp", "file.pgp.versions"}, names)
+				assert.True(t, entries[2].IsDir())
+			})
+
+			t.Run("Renames directories with their content", func(t T) {
+				require.NoError(t, s.WriteFile(path.Join(root, "dir", "file.pgp.versions", "v1"), []byte("v1")))
+				require.NoError(t, s.Rename(path.Join(root, "dir"), path.Join(root, ".dir")))
+
+				assert.False(t, exists(s, path.Join(root, "dir")))
+				data, err := s.ReadFile(path.Join(root, ".dir", "file.pgp.versions", "v1"))
+				require.NoError(t, err)
+				assert.Equal(t, "v1", string(data))
+				assert.Equal(t, int64(8), dirSize(s, path.Join(root, ".dir")))
+			})
+
+			t.Run("Removes files and empty directories", func(t T) {
+				versions := path.Join(root, ".dir", "file.pgp.versions")
+				assert.Error(t, s.Remove(versions))
+
+				require.NoError(t, s.Remove(path.Join(versions, "v1")))
+				require.NoError(t, s.Remove(versions))
+				assert.False(t, exists(s, versions))
+
+				_, err := s.Stat(versions)
+				assert.ErrorIs(t, err, fs.ErrNotExist)
+				assert.NoError(t, removeIfExists(s, versions))
+			})
+
+			t.Run("Removes directories with their content", func(t T) {
+				require.NoError(t, s.RemoveAll(path.Join(root, ".dir")))
+				assert.False(t, exists(s, path.Join(root, ".dir", "a.pgp")))
+				assert.False(t, exists(s, path.Join(root, ".dir")))
+			})
+		})
+	}
+}
+
+func TestMemoryStorageAccount(t *testing.T) {
+	storage := NewMemoryStorage()
+	account, err := NewAccountWithStorage(storage, "/ahmed", "Ahmed Mohamed", "ahmed@example.com", "password")
+	require.NoError(t, err)
+	friend, err := NewAccountWithStorage(NewMemoryStorage(), "/mohamed", "Mohamed Mahmoud", "mohamed@example.com", "password")
+	require.NoError(t, err)
+
+	t.Run("Reopens the account", func(t T) {
+		opened, err := OpenAccountWithStorage(storage, "/ahmed", "password")
+		require.NoError(t, err)
+		assert.Equal(t, account.Fingerprint(), opened.Fingerprint())
+
+		_, err = OpenAccountWithStorage(storage, "/ahmed", "wrong")
+		assert.Error(t, err)
+	})
+
+	aFriend := befriend(t, friend, account)
+	require.NoError(t, account.Follow(befriend(t, account, friend)))
+
+	t.Run("Keeps files, versions and friends in storage", func(t T) {
+		_, err := account.AddFile(bytes.NewBufferString("first"), "hello.txt", nil)
+		require.NoError(t, err)
+		file, err := account.AddFile(bytes.NewBufferString("second"), "hello.txt", nil)
+		require.NoError(t, err)
+
+		r, err := file.Reader(account)
+		require.NoError(t, err)
+		content, _ := io.ReadAll(r)
+		assert.Equal(t, "second", string(content))
+		assert.Len(t, file.Versions(), 1)
+		assert.Len(t, account.ListFiles(account.Fingerprint(), time.Time{}, 0), 1)
+
+		follows, err := account.ListFollows()
+		require.NoError(t, err)
+		assert.Len(t, follows, 1)
+		assert.True(t, exists(storage, path.Join("/ahmed", friend.Fingerprint().String())))
+	})
+
+	t.Run("Syncs files from a peer", func(t T) {
+		_, err := friend.AddFile(bytes.NewBufferString("shared"), "shared.txt", []*Friend{aFriend})
+		require.NoError(t, err)
+
+		server, err := friend.Server(nil)
+		require.NoError(t, err)
+		listener, address := TempListener()
+		go func() { _ = server.Serve(*listener, "") }()
+		defer server.Close()
+
+		client, err := account.Client(friend.Fingerprint(), nil)
+		require.NoError(t, err)
+		err = client.DownloadFriend(context.Background(), friend.Fingerprint(), time.Time{}, []FingerprintResolver{StaticAddress(address)})
+		require.NoError(t, err)
+
+		file, err := account.GetFile(friend.Fingerprint(), "shared.txt.pgp")
+		require.NoError(t, err)
+		require.NoError(t, file.VerifySignature(account, friend.Fingerprint()))
+		r, err := file.Reader(account)
+		require.NoError(t, err)
+		content, _ := io.ReadAll(r)
+		assert.Equal(t, "shared", string(content))
+	})
+}
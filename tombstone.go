@@ -0,0 +1,153 @@
+package mau
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+)
+
+// Tombstones: removing a shared file leaves a tombstone in its place, a file
+// named after it with the .tombstone suffix, signed by the account and
+// encrypted to the recipients the file had. tombstones are listed and served
+// like any other file so followers who synced the file learn it's gone and
+// remove or archive their copy. they are kept for a retention period then
+// expired by ExpireTombstones.
+
+var (
+	ErrTombstoneMismatch = errors.New("Tombstone doesn't belong to the file it's named after")
+)
+
+// Tombstone records the deletion of a file
+type Tombstone struct {
+	Name    string    `json:"name"` // name of the deleted file
+	Deleted time.Time `json:"deleted"`
+}
+
+func isTombstoneName(name string) bool {
+	return path.Ext(name) == tombstoneSuffix
+}
+
+// isContentFileName is true for the files listed to followers: content files
+// and the tombstones of deleted ones
+func isContentFileName(name string) bool {
+	return path.Ext(name) == ".pgp" || isTombstoneName(name)
+}
+
+// isSharedFile is true for the current version of the account own files
+func (a *Account) isSharedFile(file *File) bool {
+	return !file.version &&
+		!isTombstoneName(file.Name()) &&
+		path.Dir(file.Path) == path.Join(a.path, a.Fingerprint().String())
+}
+
+// writeTombstone writes the tombstone of file for the recipients of file
+func (a *Account) writeTombstone(file *File) error {
+	recipients, err := file.Recipients(a)
+	if err != nil {
+		return fmt.Errorf("failed to read recipients of %s: %w", file.Name(), err)
+	}
+
+	data, err := json.Marshal(Tombstone{Name: file.Name(), Deleted: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+
+	return a.writeEncryptedFile(file.Path+tombstoneSuffix, bytes.NewReader(data), recipients)
+}
+
+// removeTombstone removes the tombstone of the file at filePath if it has one
+func (a *Account) removeTombstone(filePath string) error {
+	return removeIfExists(a.storage, filePath+tombstoneSuffix)
+}
+
+// Tombstone decrypts the tombstone file f and checks it's about the file it's
+// named after. the signature isn't verified.
+func (f *File) Tombstone(account *Account) (*Tombstone, error) {
+	r, err := f.Reader(account)
+	if err != nil {
+		return nil, err
+	}
+
+	var tombstone Tombstone
+	if err := json.NewDecoder(r).Decode(&tombstone); err != nil {
+		return nil, fmt.Errorf("failed to decode tombstone %s: %w", f.Name(), err)
+	}
+
+	if !isTombstoneName(f.Name()) || tombstone.Name != strings.TrimSuffix(f.Name(), tombstoneSuffix) {
+		return nil, ErrTombstoneMismatch
+	}
+
+	return &tombstone, nil
+}
+
+// ExpireTombstones removes the tombstones modified more than retention ago
+// from the account directory and the directories of friends, followed or not.
+// it returns the number of tombstones removed.
+func (a *Account) ExpireTombstones(retention time.Duration) (int, error) {
+	before := time.Now().Add(-retention)
+	expired := 0
+
+	for _, dir := range a.contentDirPaths() {
+		n, err := a.expireDirTombstones(dir, before)
+		expired += n
+		if err != nil {
+			return expired, err
+		}
+	}
+
+	return expired, nil
+}
+
+// contentDirPaths returns the paths of the account directory and the
+// directories of friends
+func (a *Account) contentDirPaths() []string {
+	dirs := []string{path.Join(a.path, a.Fingerprint().String())}
+
+	entries, _ := a.storage.ReadDir(a.path)
+	for _, entry := range entries {
+		fpr, _ := contentDirFingerprint(entry.Name())
+		if entry.IsDir() && fpr != nil && !fpr.Equal(a.Fingerprint()) {
+			dirs = append(dirs, path.Join(a.path, entry.Name()))
+		}
+	}
+
+	return dirs
+}
+
+func (a *Account) expireDirTombstones(dir string, before time.Time) (int, error) {
+	entries, err := a.storage.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	for _, entry := range entries {
+		if !isExpiredTombstone(entry, before) {
+			continue
+		}
+
+		if err := a.storage.Remove(path.Join(dir, entry.Name())); err != nil {
+			return expired, err
+		}
+		expired++
+	}
+
+	return expired, nil
+}
+
+func isExpiredTombstone(entry fs.DirEntry, before time.Time) bool {
+	if !isTombstoneName(entry.Name()) {
+		return false
+	}
+
+	info, err := entry.Info()
+	return err == nil && info.Mode().IsRegular() && info.ModTime().Before(before)
+}
@@ -0,0 +1,172 @@
+package mau
+
+import (
+	"context"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTombstones(t *testing.T) {
+	account, err := NewAccount(t.TempDir(), "Ahmed Mohamed", "ahmed@example.com", "password")
+	require.NoError(t, err)
+	friend, err := NewAccount(t.TempDir(), "Mohamed Mahmoud", "mohamed@example.com", "password")
+	require.NoError(t, err)
+	aFriend := befriend(t, account, friend)
+	befriend(t, friend, account)
+
+	file, err := account.AddFile(strings.NewReader("Hello"), "hello.txt", []*Friend{aFriend})
+	require.NoError(t, err)
+	tombstonePath := file.Path + ".tombstone"
+
+	t.Run("Removing a file leaves a tombstone for its recipients", func(t T) {
+		require.NoError(t, account.RemoveFile(file))
+		assert.NoFileExists(t, file.Path)
+		require.FileExists(t, tombstonePath)
+
+		tombstoneFile := &File{Path: tombstonePath}
+		assert.NoError(t, tombstoneFile.VerifySignature(friend, account.Fingerprint()))
+
+		recipients, err := tombstoneFile.Recipients(account)
+		require.NoError(t, err)
+		require.Len(t, recipients, 1)
+		assert.Equal(t, friend.Fingerprint(), recipients[0].Fingerprint())
+
+		tombstone, err := tombstoneFile.Tombstone(friend)
+		require.NoError(t, err)
+		assert.Equal(t, "hello.txt.pgp", tombstone.Name)
+		assert.WithinDuration(t, time.Now(), tombstone.Deleted, time.Minute)
+	})
+
+	t.Run("Tombstones are listed", func(t T) {
+		files := account.ListFiles(account.Fingerprint(), time.Time{}, 0)
+		require.Len(t, files, 1)
+		assert.Equal(t, "hello.txt.pgp.tombstone", files[0].Name())
+	})
+
+	t.Run("Sharing the file again removes its tombstone", func(t T) {
+		_, err := account.AddFile(strings.NewReader("Hello again"), "hello.txt", []*Friend{aFriend})
+		require.NoError(t, err)
+		assert.NoFileExists(t, tombstonePath)
+	})
+
+	t.Run("Removing a version doesn't leave a tombstone", func(t T) {
+		file, err := account.AddFile(strings.NewReader("Hello once more"), "hello.txt", []*Friend{aFriend})
+		require.NoError(t, err)
+		versions := file.Versions()
+		require.Len(t, versions, 1)
+
+		require.NoError(t, account.RemoveFile(versions[0]))
+		assert.NoFileExists(t, tombstonePath)
+		assert.NoFileExists(t, versions[0].Path+".tombstone")
+	})
+
+	t.Run("A tombstone renamed after another file is rejected", func(t T) {
+		file, err := account.GetFile(account.Fingerprint(), "hello.txt.pgp")
+		require.NoError(t, err)
+		require.NoError(t, account.RemoveFile(file))
+
+		renamed := path.Join(path.Dir(tombstonePath), "other.txt.pgp.tombstone")
+		require.NoError(t, os.Rename(tombstonePath, renamed))
+
+		_, err = (&File{Path: renamed}).Tombstone(friend)
+		assert.ErrorIs(t, err, ErrTombstoneMismatch)
+	})
+}
+
+func TestExpireTombstones(t *testing.T) {
+	account, err := NewAccount(t.TempDir(), "Ahmed Mohamed", "ahmed@example.com", "password")
+	require.NoError(t, err)
+	friend, err := NewAccount(t.TempDir(), "Mohamed Mahmoud", "mohamed@example.com", "password")
+	require.NoError(t, err)
+
+	removeFile := func(name string, age time.Duration) string {
+		file, err := account.AddFile(strings.NewReader("content"), name, nil)
+		require.NoError(t, err)
+		require.NoError(t, account.RemoveFile(file))
+
+		modified := time.Now().Add(-age)
+		require.NoError(t, os.Chtimes(file.Path+".tombstone", modified, modified))
+		return file.Path + ".tombstone"
+	}
+
+	old := removeFile("old.txt", 2*time.Hour)
+	recent := removeFile("recent.txt", 0)
+
+	friendDir := path.Join(account.path, "."+friend.Fingerprint().String())
+	require.NoError(t, os.MkdirAll(friendDir, DirPerm))
+	friendOld := path.Join(friendDir, "post.pgp.tombstone")
+	require.NoError(t, os.WriteFile(friendOld, []byte("tombstone"), FilePerm))
+	modified := time.Now().Add(-2 * time.Hour)
+	require.NoError(t, os.Chtimes(friendOld, modified, modified))
+
+	expired, err := account.ExpireTombstones(time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 2, expired)
+	assert.NoFileExists(t, old)
+	assert.NoFileExists(t, friendOld)
+	assert.FileExists(t, recent)
+}
+
+func TestDownloadTombstones(t *testing.T) {
+	accountDir := t.TempDir()
+	account, err := NewAccount(accountDir, "Ahmed Mohamed", "ahmed@example.com", "password")
+	require.NoError(t, err)
+	friend, err := NewAccount(t.TempDir(), "Mohamed Mahmoud", "mohamed@example.com", "password")
+	require.NoError(t, err)
+
+	aFriend := befriend(t, friend, account)
+	require.NoError(t, account.Follow(befriend(t, account, friend)))
+
+	server, err := friend.Server(nil)
+	require.NoError(t, err)
+	listener, address := TempListener()
+	go func() { _ = server.Serve(*listener, "") }()
+	defer server.Close()
+
+	client, err := account.Client(friend.Fingerprint(), nil)
+	require.NoError(t, err)
+	resolvers := []FingerprintResolver{StaticAddress(address)}
+	localPath := path.Join(accountDir, friend.Fingerprint().String(), "hello.txt.pgp")
+
+	share := func() {
+		_, err := friend.AddFile(strings.NewReader("Hello"), "hello.txt", []*Friend{aFriend})
+		require.NoError(t, err)
+		require.NoError(t, client.DownloadFriend(context.Background(), friend.Fingerprint(), time.Time{}, resolvers))
+		require.FileExists(t, localPath)
+	}
+
+	unshare := func() {
+		file, err := friend.GetFile(friend.Fingerprint(), "hello.txt.pgp")
+		require.NoError(t, err)
+		require.NoError(t, friend.RemoveFile(file))
+		require.NoError(t, client.DownloadFriend(context.Background(), friend.Fingerprint(), time.Time{}, resolvers))
+	}
+
+	t.Run("Tombstones remove the local copy", func(t T) {
+		share()
+		unshare()
+
+		assert.NoFileExists(t, localPath)
+		assert.FileExists(t, localPath+".tombstone")
+	})
+
+	t.Run("Sharing again removes the local tombstone", func(t T) {
+		share()
+		assert.NoFileExists(t, localPath+".tombstone")
+	})
+
+	t.Run("Tombstones archive the local copy when asked to", func(t T) {
+		client.SetArchiveDeleted(true)
+		unshare()
+
+		assert.NoFileExists(t, localPath)
+		versions := (&File{Path: localPath}).Versions()
+		assert.NotEmpty(t, versions)
+	})
+}
@@ -10,6 +10,7 @@ import type { Account } from '../account.js';
 import type { ServerRequest } from '../server.js';
 import { Server } from '../server.js';
 import { getFingerprint, deserializePublicKey } from '../crypto/index.js';
+import { boundChallenge, dtlsFingerprint } from './webrtc.js';
 
 export interface WebRTCServerConfig {
   iceServers?: RTCIceServer[];
@@ -246,11 +247,13 @@ export class WebRTCServer {
 
       connection.fingerprint = peerFingerprint;
 
-      // Sign the challenge
+      // Sign the challenge bound to our DTLS certificate so a peer relaying the
+      // challenge can't reuse the signature on its own connection
       const challenge = new Uint8Array(message.challenge);
       const { sign } = await import('../crypto/index.js');
       const privateKey = await this.account.getPrivateKey();
-      const signature = await sign(challenge, privateKey);
+      const localDTLS = dtlsFingerprint(connection.peer.localDescription?.sdp ?? '');
+      const signature = await sign(boundChallenge(challenge, localDTLS), privateKey);
 
       // Send response
       const response = {
@@ -8,7 +8,7 @@
  */
 
 import { describe, it, expect, beforeAll, afterAll } from '@jest/globals';
-import { WebRTCClient } from './webrtc';
+import { WebRTCClient, boundChallenge, dtlsFingerprint } from './webrtc';
 import { Account } from '../account';
 import { BrowserStorage } from '../storage/browser';
 
@@ -106,3 +106,16 @@ describe('WebRTCClient', () => {
     ).rejects.toThrow('Data channel not ready');
   });
 });
+
+describe('mTLS challenge binding', () => {
+  it('dtlsFingerprint() reads the lower cased fingerprint like Go peers', () => {
+    const sdp = 'v=0\r\no=- 0 0 IN IP4 127.0.0.1\r\na=fingerprint:sha-256 AB:CD:EF\r\na=setup:actpass\r\n';
+    expect(dtlsFingerprint(sdp)).toBe('sha-256 ab:cd:ef');
+    expect(() => dtlsFingerprint('v=0\r\n')).toThrow('DTLS fingerprint not found');
+  });
+
+  it('boundChallenge() appends the fingerprint to the challenge', () => {
+    const bound = boundChallenge(new Uint8Array([1, 2, 3]), 'ab');
+    expect(Array.from(bound)).toEqual([1, 2, 3, 97, 98]);
+  });
+});
@@ -14,6 +14,32 @@ export interface WebRTCConfig {
   timeout?: number;
 }
 
+/**
+ * DTLS certificate fingerprint of a session description, lower cased like the
+ * Go implementation so both sides sign the same bytes
+ */
+export function dtlsFingerprint(sdp: string): string {
+  for (const line of sdp.split('\n')) {
+    const value = line.trim();
+    if (value.startsWith('a=fingerprint:')) {
+      return value.slice('a=fingerprint:'.length).toLowerCase();
+    }
+  }
+  throw new Error('DTLS fingerprint not found in session description');
+}
+
+/**
+ * Challenge followed by the DTLS fingerprint of the signer, the bytes signed in
+ * an mtls_offer
+ */
+export function boundChallenge(challenge: Uint8Array, fingerprint: string): Uint8Array {
+  const suffix = new TextEncoder().encode(fingerprint);
+  const bound = new Uint8Array(challenge.length + suffix.length);
+  bound.set(challenge);
+  bound.set(suffix, challenge.length);
+  return bound;
+}
+
 /**
  * WebRTC-based client for browser P2P
  */
@@ -144,6 +170,12 @@ export class WebRTCClient {
     const publicKey = this.account.getPublicKey();
     const challenge = crypto.getRandomValues(new Uint8Array(32));
 
+    // Sign the challenge bound to our DTLS certificate so the signature can't be
+    // replayed on another connection. Go peers refuse unsigned offers.
+    const { sign } = await import('../crypto/index.js');
+    const localDTLS = dtlsFingerprint(this.connection?.localDescription?.sdp ?? '');
+    const signature = await sign(boundChallenge(challenge, localDTLS), this.account.getPrivateKey());
+
     // Register response handler BEFORE sending offer to eliminate the race condition
     const responsePromise = new Promise<boolean>((resolve, reject): void => {
       const timeout = setTimeout((): void => reject(new Error('mTLS timeout')), 5000);
@@ -162,8 +194,9 @@ export class WebRTCClient {
 
           if (peerFingerprint !== this.peer) { resolve(false); return; }
 
-          const challengeBytes = new Uint8Array(response.challenge);
-          resolve(await verify(challengeBytes, response.signature, peerKey));
+          // The peer signs our challenge bound to its own DTLS certificate
+          const remoteDTLS = dtlsFingerprint(this.connection?.remoteDescription?.sdp ?? '');
+          resolve(await verify(boundChallenge(challenge, remoteDTLS), response.signature, peerKey));
         } catch (err) {
           clearTimeout(timeout);
           this.dataChannel!.removeEventListener('message', handler);
@@ -178,6 +211,7 @@ export class WebRTCClient {
       type: 'mtls_offer',
       publicKey,
       challenge: Array.from(challenge),
+      signature,
     }));
 
     return responsePromise;
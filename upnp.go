@@ -3,11 +3,18 @@ package mau
 import (
 	"context"
 	"errors"
+	"net"
+	"time"
 
 	"github.com/huin/goupnp/dcps/internetgateway1"
 	"github.com/huin/goupnp/dcps/internetgateway2"
 )
 
+const (
+	upnpSSDPAddress        = "239.255.255.250:1900"
+	upnpMappingDescription = "mau"
+)
+
 type upnpClient interface {
 	AddPortMapping(
 		NewRemoteHost string,
@@ -77,3 +84,51 @@ func upnpFactory[T upnpClient](f func() ([]T, []error, error)) func() []upnpClie
 		return cs
 	}
 }
+
+// upnpMapper adapts the UPnP IGD client to the portMapper interface
+type upnpMapper struct {
+	discover   func(context.Context) (upnpClient, error)
+	internalIP func(address string) (net.IP, error) // outboundIP, replaced in tests
+}
+
+func newUPNPMapper() *upnpMapper {
+	return &upnpMapper{discover: newUPNPClient, internalIP: outboundIP}
+}
+
+func (u *upnpMapper) name() string { return "upnp" }
+
+func (u *upnpMapper) addPortMapping(ctx context.Context, protocol string, internalPort uint16, lifetime time.Duration) (*PortMapping, error) {
+	client, err := u.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	externalIP, err := client.GetExternalIPAddress()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := u.forwardPort(client, protocol, internalPort, lifetime); err != nil {
+		return nil, err
+	}
+
+	return &PortMapping{
+		Protocol:     protocol,
+		ExternalIP:   net.ParseIP(externalIP),
+		ExternalPort: internalPort,
+		InternalPort: internalPort,
+		Lifetime:     lifetime,
+	}, nil
+}
+
+func (u *upnpMapper) forwardPort(client upnpClient, protocol string, port uint16, lifetime time.Duration) error {
+	// UPnP doesn't tell us the gateway address, the SSDP multicast route is
+	// the interface the gateway was discovered on
+	internalIP, err := u.internalIP(upnpSSDPAddress)
+	if err != nil {
+		return err
+	}
+
+	seconds := uint32(lifetime / time.Second)
+	return client.AddPortMapping("", port, protocol, port, internalIP.String(), true, upnpMappingDescription, seconds)
+}
@@ -3,10 +3,12 @@ package mau
 import (
 	"context"
 	"errors"
+	"net"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 // mockUPNPClient implements the upnpClient interface for testing
@@ -157,3 +159,35 @@ func TestNewUPNPClient(t *testing.T) {
 
 // Verify mock implements interface at compile time
 var _ upnpClient = (*mockUPNPClient)(nil)
+
+func TestUPNPMapper(t *testing.T) {
+	t.Run("Forwards the internal port and reports the external IP", func(t *testing.T) {
+		var protocol, client string
+		var port uint16
+		mock := &mockUPNPClient{
+			addPortMappingFunc: func(_ string, externalPort uint16, proto string, internalPort uint16, internalClient string, _ bool, _ string, _ uint32) error {
+				protocol, port, client = proto, internalPort, internalClient
+				return nil
+			},
+		}
+		mapper := &upnpMapper{
+			discover:   func(context.Context) (upnpClient, error) { return mock, nil },
+			internalIP: func(string) (net.IP, error) { return net.ParseIP("192.168.1.10"), nil },
+		}
+
+		mapping, err := mapper.addPortMapping(context.Background(), "TCP", 8080, time.Hour)
+		require.NoError(t, err)
+		assert.Equal(t, "TCP", protocol)
+		assert.Equal(t, uint16(8080), port)
+		assert.Equal(t, "192.168.1.10", client)
+		assert.Equal(t, "203.0.113.1", mapping.ExternalIP.String())
+		assert.Equal(t, uint16(8080), mapping.ExternalPort)
+	})
+
+	t.Run("Fails when no gateway is discovered", func(t *testing.T) {
+		mapper := &upnpMapper{discover: func(context.Context) (upnpClient, error) { return nil, errors.New("No services found") }}
+
+		_, err := mapper.addPortMapping(context.Background(), "TCP", 8080, time.Hour)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,318 @@
+package mau
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// VersionRetention decides which old versions of files are kept. a version is
+// pruned unless it's one of the KeepLast most recent versions of its file, it
+// was made less than KeepFor ago or, with KeepReferenced, a file links to it.
+// zero values keep nothing.
+type VersionRetention struct {
+	KeepLast       int           `json:"keep_last,omitempty"`
+	KeepFor        time.Duration `json:"keep_for,omitempty"`
+	KeepReferenced bool          `json:"keep_referenced,omitempty"`
+}
+
+// MarshalJSON writes KeepFor as a duration string like "720h0m0s"
+func (r VersionRetention) MarshalJSON() ([]byte, error) {
+	type plain VersionRetention
+	keepFor := ""
+	if r.KeepFor != 0 {
+		keepFor = r.KeepFor.String()
+	}
+
+	return json.Marshal(struct {
+		plain
+		KeepFor string `json:"keep_for,omitempty"`
+	}{plain(r), keepFor})
+}
+
+// UnmarshalJSON reads KeepFor as a duration string
+func (r *VersionRetention) UnmarshalJSON(data []byte) error {
+	type plain VersionRetention
+	aux := struct {
+		*plain
+		KeepFor string `json:"keep_for,omitempty"`
+	}{plain: (*plain)(r)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	r.KeepFor = 0
+	if aux.KeepFor == "" {
+		return nil
+	}
+
+	d, err := time.ParseDuration(aux.KeepFor)
+	r.KeepFor = d
+	return err
+}
+
+// retentionConfig holds the retention of the account directories. friend
+// directories without their own retention use the default one, versions of
+// directories without any retention are kept forever.
+type retentionConfig struct {
+	Default *VersionRetention            `json:"default,omitempty"`
+	Friends map[string]*VersionRetention `json:"friends,omitempty"` // key: fingerprint hex string, the account own included
+}
+
+// PrunedVersion is a version removed by PruneVersions
+type PrunedVersion struct {
+	Fingerprint Fingerprint `json:"fingerprint"`
+	Name        string      `json:"name"`    // name of the file
+	Version     string      `json:"version"` // hash of the version
+	Size        int64       `json:"size"`
+	Modified    time.Time   `json:"modified"`
+}
+
+// PruneReport lists the versions pruned, or that would be pruned by a dry run
+type PruneReport struct {
+	DryRun bool             `json:"dry_run"`
+	Pruned []*PrunedVersion `json:"pruned"`
+	Kept   int              `json:"kept"`
+	Size   int64            `json:"size"` // total size of the pruned versions
+}
+
+// versionReferenceReg matches links to versions in file contents
+var versionReferenceReg = regexp.MustCompile(`\.versions?/([0-9a-f]{64})`)
+
+func retentionFile(d string) string { return path.Join(mauDir(d), retentionFilename) }
+
+// VersionRetention returns the retention of the fingerprint directory, nil
+// if its versions are kept forever
+func (a *Account) VersionRetention(fpr Fingerprint) *VersionRetention {
+	config, err := a.loadRetentionConfig()
+	if err != nil {
+		return nil
+	}
+
+	return config.forFingerprint(fpr)
+}
+
+// SetVersionRetention sets the retention of the fingerprint directory, or the
+// default retention when fpr is nil. a nil retention removes it.
+func (a *Account) SetVersionRetention(fpr Fingerprint, retention *VersionRetention) error {
+	config, err := a.loadRetentionConfig()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case fpr == nil:
+		config.Default = retention
+	case retention == nil:
+		delete(config.Friends, fpr.String())
+	default:
+		config.Friends[fpr.String()] = retention
+	}
+
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return a.storage.WriteFile(retentionFile(a.path), data)
+}
+
+func (a *Account) loadRetentionConfig() (*retentionConfig, error) {
+	config := &retentionConfig{}
+
+	data, err := a.storage.ReadFile(retentionFile(a.path))
+	if err == nil {
+		err = json.Unmarshal(data, config)
+	} else if errors.Is(err, fs.ErrNotExist) {
+		err = nil
+	}
+
+	if config.Friends == nil {
+		config.Friends = map[string]*VersionRetention{}
+	}
+
+	return config, err
+}
+
+func (c *retentionConfig) forFingerprint(fpr Fingerprint) *VersionRetention {
+	if retention, ok := c.Friends[fpr.String()]; ok {
+		return retention
+	}
+
+	return c.Default
+}
+
+// PruneVersions removes the old versions of files the retention of their
+// directory doesn't keep. a dry run only reports them.
+func (a *Account) PruneVersions(dryRun bool) (*PruneReport, error) {
+	config, err := a.loadRetentionConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	p := &versionPruner{
+		account: a,
+		now:     time.Now(),
+		report:  &PruneReport{DryRun: dryRun, Pruned: []*PrunedVersion{}},
+	}
+
+	for _, dir := range a.contentDirPaths() {
+		fpr, _ := contentDirFingerprint(path.Base(dir))
+		if retention := config.forFingerprint(fpr); retention != nil {
+			if err := p.pruneDir(dir, fpr, retention); err != nil {
+				a.index.save()
+				return p.report, err
+			}
+		}
+	}
+	a.index.save()
+
+	return p.report, nil
+}
+
+type versionPruner struct {
+	account    *Account
+	now        time.Time
+	report     *PruneReport
+	referenced map[string]bool // key: version hash, read on first use
+}
+
+type versionEntry struct {
+	hash     string
+	size     int64
+	modified time.Time
+}
+
+func (p *versionPruner) pruneDir(dir string, fpr Fingerprint, retention *VersionRetention) error {
+	entries, err := p.account.storage.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasSuffix(entry.Name(), ".versions") {
+			continue
+		}
+
+		name := strings.TrimSuffix(entry.Name(), ".versions")
+		if err := p.pruneFile(path.Join(dir, entry.Name()), fpr, name, retention); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// pruneFile prunes the versions of one file, newest versions first
+func (p *versionPruner) pruneFile(versionsDir string, fpr Fingerprint, name string, retention *VersionRetention) error {
+	versions := readVersionEntries(p.account.storage, versionsDir)
+
+	for i, v := range versions {
+		if p.keep(i, v, retention) {
+			p.report.Kept++
+			continue
+		}
+
+		if err := p.prune(versionsDir, fpr, name, v); err != nil {
+			return err
+		}
+	}
+
+	if !p.report.DryRun {
+		_ = p.account.storage.Remove(versionsDir) // only removed once empty
+	}
+
+	return nil
+}
+
+func (p *versionPruner) keep(i int, v *versionEntry, retention *VersionRetention) bool {
+	return i < retention.KeepLast ||
+		p.now.Sub(v.modified) < retention.KeepFor ||
+		(retention.KeepReferenced && p.isReferenced(v.hash))
+}
+
+func (p *versionPruner) prune(versionsDir string, fpr Fingerprint, name string, v *versionEntry) error {
+	if !p.report.DryRun {
+		if err := p.account.storage.Remove(path.Join(versionsDir, v.hash)); err != nil {
+			return err
+		}
+		p.account.index.forget(path.Join(versionsDir, v.hash))
+	}
+
+	p.report.Pruned = append(p.report.Pruned, &PrunedVersion{
+		Fingerprint: fpr,
+		Name:        name,
+		Version:     v.hash,
+		Size:        v.size,
+		Modified:    v.modified,
+	})
+	p.report.Size += v.size
+
+	return nil
+}
+
+// readVersionEntries returns the versions in versionsDir, newest first
+func readVersionEntries(storage Storage, versionsDir string) []*versionEntry {
+	versions := []*versionEntry{}
+
+	entries, _ := storage.ReadDir(versionsDir)
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err == nil && info.Mode().IsRegular() {
+			versions = append(versions, &versionEntry{hash: entry.Name(), size: info.Size(), modified: info.ModTime()})
+		}
+	}
+
+	slices.SortStableFunc(versions, func(a, b *versionEntry) int {
+		return b.modified.Compare(a.modified)
+	})
+
+	return versions
+}
+
+func (p *versionPruner) isReferenced(hash string) bool {
+	if p.referenced == nil {
+		p.referenced = p.account.referencedVersions()
+	}
+
+	return p.referenced[hash]
+}
+
+// referencedVersions returns the hashes of the versions linked to by the
+// current files the account can read
+func (a *Account) referencedVersions() map[string]bool {
+	referenced := map[string]bool{}
+
+	for _, dir := range a.contentDirPaths() {
+		entries, _ := a.storage.ReadDir(dir)
+		for _, entry := range entries {
+			if entry.Type().IsRegular() && path.Ext(entry.Name()) == ".pgp" {
+				a.collectReferences(a.file(path.Join(dir, entry.Name())), referenced)
+			}
+		}
+	}
+
+	return referenced
+}
+
+func (a *Account) collectReferences(file *File, referenced map[string]bool) {
+	r, err := file.Reader(a)
+	if err != nil {
+		return
+	}
+
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return
+	}
+
+	for _, match := range versionReferenceReg.FindAllSubmatch(content, -1) {
+		referenced[string(match[1])] = true
+	}
+}
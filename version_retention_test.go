@@ -0,0 +1,195 @@
+package mau
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVersionRetentionJSON(t *testing.T) {
+	t.Run("Writes KeepFor as a duration", func(t T) {
+		data, err := json.Marshal(&VersionRetention{KeepLast: 2, KeepFor: 150 * time.Minute})
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"keep_last":2,"keep_for":"2h30m0s"}`, string(data))
+	})
+
+	t.Run("Reads durations", func(t T) {
+		var r VersionRetention
+		require.NoError(t, json.Unmarshal([]byte(`{"keep_for":"720h","keep_referenced":true}`), &r))
+		assert.Equal(t, VersionRetention{KeepFor: 30 * 24 * time.Hour, KeepReferenced: true}, r)
+	})
+
+	t.Run("Refuses nanoseconds", func(t T) {
+		var r VersionRetention
+		assert.Error(t, json.Unmarshal([]byte(`{"keep_for":3600000000000}`), &r))
+	})
+
+	t.Run("Refuses invalid durations", func(t T) {
+		var r VersionRetention
+		assert.Error(t, json.Unmarshal([]byte(`{"keep_for":"a month"}`), &r))
+	})
+}
+
+func TestVersionRetention(t *testing.T) {
+	account, err := NewAccount(t.TempDir(), "Ahmed Mohamed", "ahmed@example.com", "password")
+	require.NoError(t, err)
+	friend, err := NewAccount(t.TempDir(), "Mohamed Mahmoud", "mohamed@example.com", "password")
+	require.NoError(t, err)
+
+	t.Run("Directories have no retention by default", func(t T) {
+		assert.Nil(t, account.VersionRetention(account.Fingerprint()))
+		assert.Nil(t, account.VersionRetention(friend.Fingerprint()))
+	})
+
+	t.Run("Directories use the default retention", func(t T) {
+		require.NoError(t, account.SetVersionRetention(nil, &VersionRetention{KeepLast: 3}))
+		assert.Equal(t, &VersionRetention{KeepLast: 3}, account.VersionRetention(friend.Fingerprint()))
+	})
+
+	t.Run("Directories can have their own retention", func(t T) {
+		require.NoError(t, account.SetVersionRetention(friend.Fingerprint(), &VersionRetention{KeepFor: time.Hour}))
+		assert.Equal(t, &VersionRetention{KeepFor: time.Hour}, account.VersionRetention(friend.Fingerprint()))
+		assert.Equal(t, &VersionRetention{KeepLast: 3}, account.VersionRetention(account.Fingerprint()))
+	})
+
+	t.Run("Removing the retention of a directory restores the default", func(t T) {
+		require.NoError(t, account.SetVersionRetention(friend.Fingerprint(), nil))
+		assert.Equal(t, &VersionRetention{KeepLast: 3}, account.VersionRetention(friend.Fingerprint()))
+	})
+}
+
+// addVersions adds a file with count versions, the version i was made i hours ago
+func addVersions(t *testing.T, account *Account, name string, count int) (*File, []string) {
+	var file *File
+	for i := 0; i <= count; i++ {
+		var err error
+		file, err = account.AddFile(strings.NewReader(fmt.Sprintf("%s %d", name, i)), name, nil)
+		require.NoError(t, err)
+	}
+
+	hashes := []string{}
+	for _, version := range file.Versions() {
+		hashes = append(hashes, version.Name())
+	}
+	require.Len(t, hashes, count)
+
+	for i, hash := range hashes {
+		modified := time.Now().Add(-time.Duration(i+1) * time.Hour)
+		require.NoError(t, os.Chtimes(path.Join(file.Path+".versions", hash), modified, modified))
+	}
+
+	return file, hashes
+}
+
+func TestPruneVersions(t *testing.T) {
+	account, err := NewAccount(t.TempDir(), "Ahmed Mohamed", "ahmed@example.com", "password")
+	require.NoError(t, err)
+
+	file, hashes := addVersions(t, account, "post.txt", 4)
+	versionPath := func(i int) string { return path.Join(file.Path+".versions", hashes[i]) }
+
+	t.Run("Versions are kept without retention", func(t T) {
+		report, err := account.PruneVersions(false)
+		require.NoError(t, err)
+		assert.Empty(t, report.Pruned)
+		assert.Len(t, file.Versions(), 4)
+	})
+
+	t.Run("Dry runs only report the versions to prune", func(t T) {
+		require.NoError(t, account.SetVersionRetention(nil, &VersionRetention{KeepLast: 1}))
+
+		report, err := account.PruneVersions(true)
+		require.NoError(t, err)
+		assert.True(t, report.DryRun)
+		assert.Len(t, report.Pruned, 3)
+		assert.Equal(t, 1, report.Kept)
+		assert.Len(t, file.Versions(), 4)
+
+		var size int64
+		for _, v := range report.Pruned {
+			assert.Equal(t, account.Fingerprint(), v.Fingerprint)
+			assert.Equal(t, "post.txt.pgp", v.Name)
+			size += v.Size
+		}
+		assert.Equal(t, size, report.Size)
+	})
+
+	t.Run("Versions made recently are kept", func(t T) {
+		require.NoError(t, account.SetVersionRetention(nil, &VersionRetention{KeepLast: 1, KeepFor: 150 * time.Minute}))
+		_, err := account.index.meta(versionPath(3))
+		require.NoError(t, err)
+		account.index.save()
+
+		report, err := account.PruneVersions(false)
+		require.NoError(t, err)
+		require.Len(t, report.Pruned, 2)
+		assert.ElementsMatch(t, []string{report.Pruned[0].Version, report.Pruned[1].Version}, []string{hashes[2], hashes[3]})
+		assert.FileExists(t, versionPath(0))
+		assert.FileExists(t, versionPath(1))
+		assert.NoFileExists(t, versionPath(2))
+		assert.NoFileExists(t, versionPath(3))
+
+		index, err := os.ReadFile(indexFile(account.path))
+		require.NoError(t, err)
+		assert.NotContains(t, string(index), hashes[2])
+		assert.NotContains(t, string(index), hashes[3])
+		assert.Contains(t, string(index), hashes[1], "the index keeps the versions left")
+	})
+
+	t.Run("Versions referenced by files are kept", func(t T) {
+		link := fmt.Sprintf(`{"isBasedOn": "/p2p/%s/post.txt.pgp.versions/%s"}`, account.Fingerprint(), hashes[1])
+		_, err := account.AddFile(strings.NewReader(link), "reply.json", nil)
+		require.NoError(t, err)
+		require.NoError(t, account.SetVersionRetention(nil, &VersionRetention{KeepReferenced: true}))
+
+		report, err := account.PruneVersions(false)
+		require.NoError(t, err)
+		require.Len(t, report.Pruned, 1)
+		assert.Equal(t, hashes[0], report.Pruned[0].Version)
+		assert.FileExists(t, versionPath(1))
+	})
+
+	t.Run("Empty versions directories are removed", func(t T) {
+		require.NoError(t, account.SetVersionRetention(nil, &VersionRetention{}))
+
+		_, err := account.PruneVersions(false)
+		require.NoError(t, err)
+		assert.NoDirExists(t, file.Path+".versions")
+	})
+}
+
+func TestPruneFriendVersions(t *testing.T) {
+	account, err := NewAccount(t.TempDir(), "Ahmed Mohamed", "ahmed@example.com", "password")
+	require.NoError(t, err)
+	friend, err := NewAccount(t.TempDir(), "Mohamed Mahmoud", "mohamed@example.com", "password")
+	require.NoError(t, err)
+
+	friendDir := path.Join(account.path, friend.Fingerprint().String())
+	versionsDir := path.Join(friendDir, "post.txt.pgp.versions")
+	require.NoError(t, os.MkdirAll(versionsDir, DirPerm))
+	for _, hash := range []string{"a", "b"} {
+		require.NoError(t, os.WriteFile(path.Join(versionsDir, hash), []byte(hash), FilePerm))
+	}
+	_, ownHashes := addVersions(t, account, "post.txt", 2)
+
+	require.NoError(t, account.SetVersionRetention(friend.Fingerprint(), &VersionRetention{}))
+
+	report, err := account.PruneVersions(false)
+	require.NoError(t, err)
+	require.Len(t, report.Pruned, 2)
+	for _, v := range report.Pruned {
+		assert.Equal(t, friend.Fingerprint(), v.Fingerprint)
+	}
+	assert.NoDirExists(t, versionsDir)
+
+	for _, hash := range ownHashes {
+		assert.FileExists(t, path.Join(account.path, account.Fingerprint().String(), "post.txt.pgp.versions", hash))
+	}
+}
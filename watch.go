@@ -0,0 +1,207 @@
+package mau
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Account event types
+const (
+	EventFileAdded     = "file_added"
+	EventFileVersioned = "file_versioned" // the file was replaced by a new version
+	EventFileDeleted   = "file_deleted"
+	EventFriendAdded   = "friend_added"
+	EventFriendRemoved = "friend_removed"
+	EventFollowChanged = "follow_changed"
+)
+
+// AccountEvent is a change of the account content. Fingerprint is the friend
+// for friend and follow events and the owner of the directory for file events.
+type AccountEvent struct {
+	Type        string      `json:"type"`
+	Fingerprint Fingerprint `json:"fingerprint"`
+	Name        string      `json:"name,omitempty"`     // file name of file events
+	Followed    bool        `json:"followed,omitempty"` // whether the friend is followed after follow events
+}
+
+// Watch emits the changes of the account content until ctx is cancelled:
+// files added, replaced by new versions or deleted in every content
+// directory, friends added or removed and friends followed or unfollowed.
+// changes are detected with inotify (or its equivalent on other platforms)
+// and by scanning the account periodically where it isn't available or the
+// account isn't on disk. events are emitted for changes made after Watch
+// returns.
+func (a *Account) Watch(ctx context.Context) <-chan *AccountEvent {
+	if _, ok := a.storage.(DiskStorage); !ok {
+		return a.watch(ctx, nil, accountWatchPollInterval)
+	}
+
+	notify, err := fsnotify.NewWatcher()
+	if err != nil {
+		slog.Warn("failed to watch account, scanning it periodically", "error", err)
+		notify = nil
+	}
+
+	return a.watch(ctx, notify, accountWatchPollInterval)
+}
+
+// watch emits the changes of the account content, detected with notify or by
+// scanning every pollInterval if it's nil
+func (a *Account) watch(ctx context.Context, notify *fsnotify.Watcher, pollInterval time.Duration) <-chan *AccountEvent {
+	w := &accountWatcher{
+		account:      a,
+		state:        newAccountState(),
+		notify:       notify,
+		pollInterval: pollInterval,
+		watched:      map[string]bool{},
+		events:       make(chan *AccountEvent, accountWatchBufferSize),
+	}
+	w.update(ctx, w.allDirty())
+
+	go w.run(ctx)
+
+	return w.events
+}
+
+type accountWatcher struct {
+	account      *Account
+	state        *accountState
+	notify       *fsnotify.Watcher
+	pollInterval time.Duration
+	watched      map[string]bool // directories added to notify
+	events       chan *AccountEvent
+
+	initialized bool
+}
+
+// dirtyParts are the parts of the account to scan again, fingerprint
+// directories are identified by their fingerprint hex string
+type dirtyParts struct {
+	friends bool
+	follows bool
+	dirs    map[string]bool
+}
+
+func (w *accountWatcher) allDirty() *dirtyParts {
+	dirty := &dirtyParts{friends: true, follows: true, dirs: map[string]bool{}}
+	for _, fpr := range w.state.contentDirs(w.account) {
+		dirty.dirs[fpr.String()] = true
+	}
+
+	return dirty
+}
+
+func (w *accountWatcher) run(ctx context.Context) {
+	defer close(w.events)
+	if w.notify != nil {
+		defer func() { _ = w.notify.Close() }()
+	}
+
+	for {
+		dirty := w.wait(ctx)
+		if dirty == nil {
+			return
+		}
+
+		w.update(ctx, dirty)
+	}
+}
+
+// wait returns the parts of the account changed once changes settled for a
+// moment, or at most a while after the first one. it returns nil when ctx is
+// done
+func (w *accountWatcher) wait(ctx context.Context) *dirtyParts {
+	if w.notify == nil {
+		return w.poll(ctx)
+	}
+
+	dirty := &dirtyParts{dirs: map[string]bool{}}
+	var settled, deadline <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event := <-w.notify.Events:
+			w.markDirty(dirty, event.Name)
+		case err := <-w.notify.Errors:
+			slog.Warn("account watcher error, scanning the whole account", "error", err)
+			dirty = w.allDirty()
+		case <-settled:
+			return dirty
+		case <-deadline:
+			return dirty
+		}
+
+		settled = time.After(accountWatchSettleDelay)
+		if deadline == nil {
+			deadline = time.After(accountWatchMaxDelay)
+		}
+	}
+}
+
+// poll returns the whole account as dirty periodically
+func (w *accountWatcher) poll(ctx context.Context) *dirtyParts {
+	select {
+	case <-ctx.Done():
+		return nil
+	case <-time.After(w.pollInterval):
+		return w.allDirty()
+	}
+}
+
+// update scans the dirty parts of the account and emits their changes
+func (w *accountWatcher) update(ctx context.Context, dirty *dirtyParts) {
+	events := []*AccountEvent{}
+
+	if dirty.friends {
+		friends := w.account.scanFriends()
+		events = append(events, diffFriends(w.state.friends, friends)...)
+		w.state.friends = friends
+	}
+
+	if dirty.follows {
+		follows := w.account.scanFollows()
+		events = append(events, diffFollows(w.state.follows, follows)...)
+		markAppearedDirs(dirty, w.state.follows, follows)
+		w.state.follows = follows
+	}
+
+	for k := range dirty.dirs {
+		fpr, _ := FingerprintFromString(k)
+		files := w.account.scanFiles(fpr)
+		events = append(events, diffFiles(k, w.state.files[k], files)...)
+		w.state.files[k] = files
+	}
+
+	w.watchDirs()
+
+	// the first state is recorded without events
+	if w.initialized {
+		w.emit(ctx, events)
+	}
+	w.initialized = true
+}
+
+// markAppearedDirs marks the directories created or removed as dirty
+func markAppearedDirs(dirty *dirtyParts, before, after map[string]bool) {
+	for k := range mergeKeys(before, after) {
+		_, existed := before[k]
+		_, exists := after[k]
+		if existed != exists {
+			dirty.dirs[k] = true
+		}
+	}
+}
+
+func (w *accountWatcher) emit(ctx context.Context, events []*AccountEvent) {
+	for _, event := range events {
+		select {
+		case w.events <- event:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package mau
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// markDirty marks the part of the account p belongs to as dirty
+func (w *accountWatcher) markDirty(dirty *dirtyParts, p string) {
+	rel, err := filepath.Rel(w.account.path, p)
+	if err != nil {
+		return
+	}
+
+	segments := strings.Split(filepath.ToSlash(rel), "/")
+	if segments[0] == mauDirName {
+		dirty.friends = true
+		return
+	}
+
+	fpr, _ := contentDirFingerprint(segments[0])
+	if fpr == nil {
+		return
+	}
+
+	dirty.dirs[fpr.String()] = true
+	if len(segments) == 1 {
+		dirty.follows = true
+	}
+}
+
+// watchDirs makes notify watch the account directories that exist now and
+// forget the ones that don't
+func (w *accountWatcher) watchDirs() {
+	if w.notify == nil {
+		return
+	}
+
+	dirs := w.accountDirs()
+	for dir := range w.watched {
+		if !dirs[dir] {
+			_ = w.notify.Remove(dir)
+			delete(w.watched, dir)
+		}
+	}
+
+	for dir := range dirs {
+		if !w.watched[dir] && w.notify.Add(dir) == nil {
+			w.watched[dir] = true
+		}
+	}
+}
+
+// accountDirs returns the account directory, the keyring directories and the
+// content directories
+func (w *accountWatcher) accountDirs() map[string]bool {
+	dirs := map[string]bool{w.account.path: true}
+
+	_ = filepath.WalkDir(mauDir(w.account.path), func(p string, d os.DirEntry, err error) error {
+		if err == nil && d.IsDir() {
+			dirs[p] = true
+		}
+		return nil
+	})
+
+	for _, fpr := range w.state.contentDirs(w.account) {
+		if dir, err := w.account.resolveFriendPath(fpr, ""); err == nil {
+			dirs[dir] = true
+		}
+	}
+
+	return dirs
+}
@@ -0,0 +1,180 @@
+package mau
+
+import (
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+)
+
+// accountState is what a watcher knows about the account content. friends
+// and follows are read from file and directory names, no key is decrypted.
+type accountState struct {
+	friends map[string]bool                 // key: fingerprint hex string of keyring files
+	follows map[string]bool                 // key: fingerprint hex string, value: followed
+	files   map[string]map[string]fileStamp // key: fingerprint hex string then file name
+}
+
+type fileStamp struct {
+	size     int64
+	modified time.Time
+}
+
+func newAccountState() *accountState {
+	return &accountState{
+		friends: map[string]bool{},
+		follows: map[string]bool{},
+		files:   map[string]map[string]fileStamp{},
+	}
+}
+
+// scanFriends returns the fingerprints of the keyring files, sub keyrings included
+func (a *Account) scanFriends() map[string]bool {
+	friends := map[string]bool{}
+
+	walkStorage(a.storage, mauDir(a.path), func(_ string, d fs.DirEntry) {
+		if d.IsDir() || d.Name() == accountKeyFilename {
+			return
+		}
+
+		if fpr, err := FingerprintFromString(strings.TrimSuffix(d.Name(), ".pgp")); err == nil {
+			friends[fpr.String()] = true
+		}
+	})
+
+	return friends
+}
+
+// scanFollows returns the fingerprints of the content directories, followed
+// or not. the account own directory isn't a follow.
+func (a *Account) scanFollows() map[string]bool {
+	follows := map[string]bool{}
+
+	entries, err := a.storage.ReadDir(a.path)
+	if err != nil {
+		return follows
+	}
+
+	for _, entry := range entries {
+		fpr, followed := contentDirFingerprint(entry.Name())
+		if entry.IsDir() && fpr != nil && !fpr.Equal(a.Fingerprint()) {
+			follows[fpr.String()] = followed || follows[fpr.String()]
+		}
+	}
+
+	return follows
+}
+
+// contentDirFingerprint returns the fingerprint of a followed directory
+// (<fpr>) or an unfollowed one (.<fpr>)
+func contentDirFingerprint(name string) (fpr Fingerprint, followed bool) {
+	fpr, err := FingerprintFromString(strings.TrimPrefix(name, "."))
+	if err != nil {
+		return nil, false
+	}
+
+	return fpr, !strings.HasPrefix(name, ".")
+}
+
+// scanFiles returns the content files of the fingerprint directory
+func (a *Account) scanFiles(fpr Fingerprint) map[string]fileStamp {
+	files := map[string]fileStamp{}
+
+	dir, err := a.resolveFriendPath(fpr, "")
+	if err != nil {
+		return files
+	}
+
+	entries, _ := a.storage.ReadDir(dir)
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err == nil && info.Mode().IsRegular() && path.Ext(entry.Name()) == ".pgp" {
+			files[entry.Name()] = fileStamp{size: info.Size(), modified: info.ModTime()}
+		}
+	}
+
+	return files
+}
+
+// contentDirs returns the fingerprints of every content directory, the
+// account own directory included
+func (s *accountState) contentDirs(account *Account) []Fingerprint {
+	fprs := []Fingerprint{account.Fingerprint()}
+	for k := range s.follows {
+		if fpr, err := FingerprintFromString(k); err == nil {
+			fprs = append(fprs, fpr)
+		}
+	}
+
+	return fprs
+}
+
+func diffFriends(before, after map[string]bool) []*AccountEvent {
+	events := []*AccountEvent{}
+	for k := range after {
+		if !before[k] {
+			events = append(events, newAccountEvent(EventFriendAdded, k))
+		}
+	}
+	for k := range before {
+		if !after[k] {
+			events = append(events, newAccountEvent(EventFriendRemoved, k))
+		}
+	}
+
+	return events
+}
+
+func diffFollows(before, after map[string]bool) []*AccountEvent {
+	events := []*AccountEvent{}
+	for k := range mergeKeys(before, after) {
+		if before[k] != after[k] {
+			event := newAccountEvent(EventFollowChanged, k)
+			event.Followed = after[k]
+			events = append(events, event)
+		}
+	}
+
+	return events
+}
+
+func diffFiles(fpr string, before, after map[string]fileStamp) []*AccountEvent {
+	events := []*AccountEvent{}
+	for name, stamp := range after {
+		if old, ok := before[name]; !ok {
+			events = append(events, newFileEvent(EventFileAdded, fpr, name))
+		} else if old.size != stamp.size || !old.modified.Equal(stamp.modified) {
+			events = append(events, newFileEvent(EventFileVersioned, fpr, name))
+		}
+	}
+	for name := range before {
+		if _, ok := after[name]; !ok {
+			events = append(events, newFileEvent(EventFileDeleted, fpr, name))
+		}
+	}
+
+	return events
+}
+
+func mergeKeys(a, b map[string]bool) map[string]bool {
+	keys := map[string]bool{}
+	for k := range a {
+		keys[k] = true
+	}
+	for k := range b {
+		keys[k] = true
+	}
+
+	return keys
+}
+
+func newAccountEvent(kind, fpr string) *AccountEvent {
+	f, _ := FingerprintFromString(fpr)
+	return &AccountEvent{Type: kind, Fingerprint: f}
+}
+
+func newFileEvent(kind, fpr, name string) *AccountEvent {
+	event := newAccountEvent(kind, fpr)
+	event.Name = name
+	return event
+}
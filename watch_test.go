@@ -0,0 +1,143 @@
+package mau
+
+import (
+	"context"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// expectAccountEvent reads events until one equal to want arrives
+func expectAccountEvent(t *testing.T, events <-chan *AccountEvent, want *AccountEvent) {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event, ok := <-events:
+			require.True(t, ok, "events closed before %v", want)
+			if event.Type == want.Type && event.Fingerprint.Equal(want.Fingerprint) && event.Name == want.Name && event.Followed == want.Followed {
+				return
+			}
+		case <-timeout:
+			require.FailNow(t, "event not received", "%+v", want)
+		}
+	}
+}
+
+func TestAccountWatch(t *testing.T) {
+	for name, notify := range map[string]bool{"inotify": true, "polling": false} {
+		t.Run(name, func(t T) {
+			testAccountWatch(t, notify)
+		})
+	}
+}
+
+func testAccountWatch(t *testing.T, notify bool) {
+	friend, err := NewAccount(t.TempDir(), "Mohamed Mahmoud", "mohamed@example.com", "password")
+	require.NoError(t, err)
+	accountDir := t.TempDir()
+	account, err := NewAccount(accountDir, "Ahmed Mohamed", "ahmed@example.com", "password")
+	require.NoError(t, err)
+	fpr := account.Fingerprint()
+
+	var watcher *fsnotify.Watcher
+	if notify {
+		watcher, err = fsnotify.NewWatcher()
+		require.NoError(t, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := account.watch(ctx, watcher, 50*time.Millisecond)
+
+	var aFriend *Friend
+
+	t.Run("Emits added files", func(t T) {
+		_, err := account.AddFile(strings.NewReader("Hello"), "hello.txt", nil)
+		require.NoError(t, err)
+		expectAccountEvent(t, events, &AccountEvent{Type: EventFileAdded, Fingerprint: fpr, Name: "hello.txt.pgp"})
+	})
+
+	t.Run("Emits new versions", func(t T) {
+		_, err := account.AddFile(strings.NewReader("Hello again"), "hello.txt", nil)
+		require.NoError(t, err)
+		expectAccountEvent(t, events, &AccountEvent{Type: EventFileVersioned, Fingerprint: fpr, Name: "hello.txt.pgp"})
+	})
+
+	t.Run("Emits deleted files", func(t T) {
+		file, err := account.GetFile(fpr, "hello.txt.pgp")
+		require.NoError(t, err)
+		require.NoError(t, account.RemoveFile(file))
+		expectAccountEvent(t, events, &AccountEvent{Type: EventFileDeleted, Fingerprint: fpr, Name: "hello.txt.pgp"})
+	})
+
+	t.Run("Emits added friends", func(t T) {
+		aFriend = befriend(t, account, friend)
+		expectAccountEvent(t, events, &AccountEvent{Type: EventFriendAdded, Fingerprint: friend.Fingerprint()})
+	})
+
+	t.Run("Emits followed friends", func(t T) {
+		require.NoError(t, account.Follow(aFriend))
+		expectAccountEvent(t, events, &AccountEvent{Type: EventFollowChanged, Fingerprint: friend.Fingerprint(), Followed: true})
+	})
+
+	t.Run("Emits files synced from friends", func(t T) {
+		err := os.WriteFile(path.Join(accountDir, friend.Fingerprint().String(), "post.json.pgp"), []byte("post"), FilePerm)
+		require.NoError(t, err)
+		expectAccountEvent(t, events, &AccountEvent{Type: EventFileAdded, Fingerprint: friend.Fingerprint(), Name: "post.json.pgp"})
+	})
+
+	t.Run("Emits unfollowed friends", func(t T) {
+		require.NoError(t, account.Unfollow(aFriend))
+		expectAccountEvent(t, events, &AccountEvent{Type: EventFollowChanged, Fingerprint: friend.Fingerprint(), Followed: false})
+	})
+
+	t.Run("Keeps watching unfollowed directories", func(t T) {
+		err := os.Remove(path.Join(accountDir, "."+friend.Fingerprint().String(), "post.json.pgp"))
+		require.NoError(t, err)
+		expectAccountEvent(t, events, &AccountEvent{Type: EventFileDeleted, Fingerprint: friend.Fingerprint(), Name: "post.json.pgp"})
+	})
+
+	t.Run("Emits removed friends", func(t T) {
+		require.NoError(t, account.RemoveFriend(aFriend))
+		expectAccountEvent(t, events, &AccountEvent{Type: EventFriendRemoved, Fingerprint: friend.Fingerprint()})
+	})
+
+	t.Run("Closes the events when the context is done", func(t T) {
+		cancel()
+		assert.Eventually(t, func() bool {
+			_, ok := <-events
+			return !ok
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+}
+
+func TestDiffFiles(t *testing.T) {
+	now := time.Now()
+	before := map[string]fileStamp{
+		"same.pgp":    {size: 1, modified: now},
+		"changed.pgp": {size: 1, modified: now},
+		"deleted.pgp": {size: 1, modified: now},
+	}
+	after := map[string]fileStamp{
+		"same.pgp":    {size: 1, modified: now},
+		"changed.pgp": {size: 2, modified: now},
+		"added.pgp":   {size: 1, modified: now},
+	}
+
+	events := map[string]string{}
+	for _, event := range diffFiles("abcd", before, after) {
+		events[event.Name] = event.Type
+	}
+
+	assert.Equal(t, map[string]string{
+		"changed.pgp": EventFileVersioned,
+		"deleted.pgp": EventFileDeleted,
+		"added.pgp":   EventFileAdded,
+	}, events)
+}
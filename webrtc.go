@@ -0,0 +1,172 @@
+package mau
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/pion/webrtc/v4"
+)
+
+// WebRTC transport: peers exchange JSON messages over a data channel using the
+// same framing as the TypeScript implementation. the requester proves its
+// identity first with an mtls_offer holding its public key, a challenge and
+// a signature, the other side responds with an mtls_response holding its
+// public key and a signature of the challenge. each side signs the challenge
+// bound to its own DTLS certificate. then every request message is answered
+// with one response message.
+
+var (
+	ErrWebRTCNotConfigured     = errors.New("WebRTC transport is not configured")
+	ErrWebRTCHandshakeFailed   = errors.New("WebRTC handshake failed")
+	ErrDTLSFingerprintNotFound = errors.New("Can't find DTLS fingerprint in session description")
+)
+
+// WebRTCConfig configures the WebRTC transport
+type WebRTCConfig struct {
+	Signaling  Signaling
+	ICEServers []string // STUN/TURN server URLs, host candidates only if empty
+}
+
+func (c *WebRTCConfig) newPeerConnection() (*webrtc.PeerConnection, error) {
+	config := webrtc.Configuration{}
+	if len(c.ICEServers) > 0 {
+		config.ICEServers = []webrtc.ICEServer{{URLs: c.ICEServers}}
+	}
+
+	return webrtc.NewPeerConnection(config)
+}
+
+// channelEnvelope is decoded first to find the message type
+type channelEnvelope struct {
+	Type string `json:"type"`
+}
+
+type mtlsMessage struct {
+	Type      string    `json:"type"` // mtls_offer or mtls_response
+	PublicKey string    `json:"publicKey"`
+	Challenge byteArray `json:"challenge"`
+	Signature string    `json:"signature,omitempty"`
+}
+
+type requestMessage struct {
+	Type    string            `json:"type"`
+	ID      int               `json:"id"`
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Query   map[string]string `json:"query"`
+	Headers map[string]string `json:"headers"`
+}
+
+type responseMessage struct {
+	Type    string            `json:"type"`
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers"`
+	Body    byteArray         `json:"body"`
+}
+
+// byteArray is encoded as an array of numbers like a JavaScript
+// Uint8Array, strings are accepted when decoding
+type byteArray []byte
+
+func (b byteArray) MarshalJSON() ([]byte, error) {
+	numbers := make([]uint16, len(b))
+	for i, v := range b {
+		numbers[i] = uint16(v)
+	}
+	return json.Marshal(numbers)
+}
+
+func (b *byteArray) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*b = []byte(s)
+		return nil
+	}
+
+	var numbers []uint8
+	if err := json.Unmarshal(data, &numbers); err != nil {
+		return err
+	}
+
+	*b = numbers
+	return nil
+}
+
+// dtlsFingerprint returns the DTLS certificate fingerprint of a session
+// description, the certificate the DTLS handshake is verified against
+func dtlsFingerprint(sdp string) (string, error) {
+	for _, line := range strings.Split(sdp, "\n") {
+		if value, ok := strings.CutPrefix(strings.TrimSpace(line), "a=fingerprint:"); ok {
+			return strings.ToLower(value), nil
+		}
+	}
+
+	return "", ErrDTLSFingerprintNotFound
+}
+
+// armoredPublicKey returns the account public key in armored format
+func (a *Account) armoredPublicKey() (string, error) {
+	var key bytes.Buffer
+	if err := a.Export(&key); err != nil {
+		return "", err
+	}
+
+	return key.String(), nil
+}
+
+// signChallenge returns an armored detached signature of the challenge
+func (a *Account) signChallenge(challenge []byte) (string, error) {
+	var signature bytes.Buffer
+	if err := openpgp.ArmoredDetachSign(&signature, a.entity, bytes.NewReader(challenge), nil); err != nil {
+		return "", err
+	}
+
+	return signature.String(), nil
+}
+
+// verifyChallenge returns the fingerprint of the armored public key if the
+// signature of the challenge was made by it
+func verifyChallenge(publicKey string, challenge []byte, signature string) (Fingerprint, error) {
+	keys, err := openpgp.ReadArmoredKeyRing(strings.NewReader(publicKey))
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %w", err)
+	}
+
+	_, err = openpgp.CheckArmoredDetachedSignature(keys, bytes.NewReader(challenge), strings.NewReader(signature), nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrWebRTCHandshakeFailed, err)
+	}
+
+	return Fingerprint(keys[0].PrimaryKey.Fingerprint), nil
+}
+
+// boundChallenge binds the challenge to the DTLS certificate of the signer so
+// the signature can't be replayed on another connection
+func boundChallenge(challenge []byte, dtlsFingerprint string) []byte {
+	return append(append([]byte{}, challenge...), dtlsFingerprint...)
+}
+
+// waitForGathering waits until all ICE candidates are gathered so the local
+// description holds them, no trickle ICE is needed
+func waitForGathering(ctx context.Context, pc *webrtc.PeerConnection) error {
+	select {
+	case <-webrtc.GatheringCompletePromise(pc):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func signalDescription(ctx context.Context, signaling Signaling, to Fingerprint, kind string, description *webrtc.SessionDescription) error {
+	data, err := json.Marshal(description)
+	if err != nil {
+		return err
+	}
+
+	return signaling.Send(ctx, &SignalingMessage{To: to, Type: kind, Data: data})
+}
@@ -0,0 +1,336 @@
+package mau
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// SetWebRTC enables reaching the peer on webrtc addresses, connections are
+// negotiated over the signaling of the config
+func (c *Client) SetWebRTC(config WebRTCConfig) {
+	c.webrtc.setConfig(config)
+}
+
+// WebRTCFriendAddress resolves a fingerprint to a WebRTC connection
+// negotiated over the signaling set with Client.SetWebRTC
+func WebRTCFriendAddress(ctx context.Context, fingerprint Fingerprint, addresses chan<- string) error {
+	return StaticAddress(webrtcAddress(fingerprint))(ctx, fingerprint, addresses)
+}
+
+// webrtcTransport sends requests over a data channel to the client peer. a
+// response carries no request ID so requests are sent one at a time.
+type webrtcTransport struct {
+	account *Account
+	peer    Fingerprint
+	mutex   sync.Mutex
+	config  WebRTCConfig
+	conn    *webrtcConn
+}
+
+func (t *webrtcTransport) setConfig(config WebRTCConfig) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.config = config
+	t.closeConn()
+}
+
+func (t *webrtcTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.config.Signaling == nil {
+		return nil, ErrWebRTCNotConfigured
+	}
+
+	if t.conn == nil {
+		conn, err := t.connect(req.Context())
+		if err != nil {
+			return nil, err
+		}
+		t.conn = conn
+	}
+
+	resp, err := t.conn.roundTrip(req)
+	if err != nil {
+		t.closeConn()
+	}
+
+	return resp, err
+}
+
+func (t *webrtcTransport) closeConn() {
+	if t.conn != nil {
+		_ = t.conn.pc.Close()
+		t.conn = nil
+	}
+}
+
+// webrtcConn is an authenticated data channel to the peer
+type webrtcConn struct {
+	pc       *webrtc.PeerConnection
+	channel  *webrtc.DataChannel
+	messages chan []byte
+}
+
+func (t *webrtcTransport) connect(ctx context.Context) (*webrtcConn, error) {
+	ctx, cancel := context.WithTimeout(ctx, webrtcHandshakeTimeout)
+	defer cancel()
+
+	conn, err := t.offer(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s over WebRTC: %w", t.peer, err)
+	}
+
+	if err := t.authenticate(ctx, conn); err != nil {
+		_ = conn.pc.Close()
+		return nil, fmt.Errorf("failed to authenticate %s over WebRTC: %w", t.peer, err)
+	}
+
+	return conn, nil
+}
+
+// offer negotiates a peer connection with a data channel over the signaling
+func (t *webrtcTransport) offer(ctx context.Context) (*webrtcConn, error) {
+	pc, err := t.config.newPeerConnection()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, opened, err := newWebRTCConn(pc)
+	if err == nil {
+		err = t.negotiate(ctx, pc)
+	}
+	if err == nil {
+		err = waitFor(ctx, opened)
+	}
+
+	if err != nil {
+		_ = pc.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+func newWebRTCConn(pc *webrtc.PeerConnection) (*webrtcConn, <-chan struct{}, error) {
+	ordered := true
+	channel, err := pc.CreateDataChannel(webrtcChannelLabel, &webrtc.DataChannelInit{Ordered: &ordered})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	conn := &webrtcConn{pc: pc, channel: channel, messages: make(chan []byte, signalingMailboxSize)}
+	opened := make(chan struct{})
+	channel.OnOpen(func() { close(opened) })
+	channel.OnMessage(func(msg webrtc.DataChannelMessage) { conn.messages <- msg.Data })
+	channel.OnClose(func() { close(conn.messages) })
+
+	return conn, opened, nil
+}
+
+// negotiate sends the offer with all ICE candidates and applies the answer
+func (t *webrtcTransport) negotiate(ctx context.Context, pc *webrtc.PeerConnection) error {
+	signals := t.config.Signaling.Subscribe(ctx)
+
+	offer, err := pc.CreateOffer(nil)
+	if err == nil {
+		err = pc.SetLocalDescription(offer)
+	}
+	if err == nil {
+		err = waitForGathering(ctx, pc)
+	}
+	if err == nil {
+		err = signalDescription(ctx, t.config.Signaling, t.peer, SignalingOffer, pc.LocalDescription())
+	}
+	if err != nil {
+		return err
+	}
+
+	return t.applyAnswer(ctx, pc, signals)
+}
+
+func (t *webrtcTransport) applyAnswer(ctx context.Context, pc *webrtc.PeerConnection, signals <-chan *SignalingMessage) error {
+	for message := range signals {
+		if !message.From.Equal(t.peer) || message.Type != SignalingAnswer {
+			continue
+		}
+
+		var answer webrtc.SessionDescription
+		if err := json.Unmarshal(message.Data, &answer); err != nil {
+			return err
+		}
+
+		return pc.SetRemoteDescription(answer)
+	}
+
+	return ctx.Err()
+}
+
+// authenticate proves our identity to the peer with a signature of a random
+// challenge bound to our DTLS certificate and verifies the peer signed it back
+// bound to its own
+func (t *webrtcTransport) authenticate(ctx context.Context, conn *webrtcConn) error {
+	challenge := make([]byte, webrtcChallengeSize)
+	if _, err := rand.Read(challenge); err != nil {
+		return err
+	}
+
+	offer, err := t.mtlsOffer(conn, challenge)
+	if err != nil {
+		return err
+	}
+
+	if err := conn.send(offer); err != nil {
+		return err
+	}
+
+	var response mtlsMessage
+	if err := conn.receive(ctx, &response); err != nil {
+		return err
+	}
+
+	return t.verifyResponse(conn, &response, challenge)
+}
+
+func (t *webrtcTransport) mtlsOffer(conn *webrtcConn, challenge []byte) (*mtlsMessage, error) {
+	localDTLS, err := dtlsFingerprint(conn.pc.LocalDescription().SDP)
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey, err := t.account.armoredPublicKey()
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := t.account.signChallenge(boundChallenge(challenge, localDTLS))
+	if err != nil {
+		return nil, err
+	}
+
+	return &mtlsMessage{Type: "mtls_offer", PublicKey: publicKey, Challenge: challenge, Signature: signature}, nil
+}
+
+func (t *webrtcTransport) verifyResponse(conn *webrtcConn, response *mtlsMessage, challenge []byte) error {
+	if response.Type != "mtls_response" {
+		return ErrWebRTCHandshakeFailed
+	}
+
+	remoteDTLS, err := dtlsFingerprint(conn.pc.RemoteDescription().SDP)
+	if err != nil {
+		return err
+	}
+
+	fpr, err := verifyChallenge(response.PublicKey, boundChallenge(challenge, remoteDTLS), response.Signature)
+	if err != nil {
+		return err
+	}
+
+	if !fpr.Equal(t.peer) {
+		return ErrIncorrectPeerCertificate
+	}
+
+	return nil
+}
+
+func (c *webrtcConn) send(message any) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	return c.channel.SendText(string(data))
+}
+
+// receive decodes the next message, responses of failed handshakes are
+// reported as errors
+func (c *webrtcConn) receive(ctx context.Context, message any) error {
+	select {
+	case data, ok := <-c.messages:
+		if !ok {
+			return io.ErrUnexpectedEOF
+		}
+		return decodeChannelMessage(data, message)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func decodeChannelMessage(data []byte, message any) error {
+	var envelope responseMessage
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return err
+	}
+
+	if _, isResponse := message.(*responseMessage); envelope.Type == "response" && !isResponse {
+		return fmt.Errorf("%w: peer responded with status %d %s", ErrWebRTCHandshakeFailed, envelope.Status, envelope.Body)
+	}
+
+	return json.Unmarshal(data, message)
+}
+
+func (c *webrtcConn) roundTrip(req *http.Request) (*http.Response, error) {
+	if err := c.send(newRequestMessage(req)); err != nil {
+		return nil, err
+	}
+
+	var response responseMessage
+	if err := c.receive(req.Context(), &response); err != nil {
+		return nil, err
+	}
+
+	return response.httpResponse(req), nil
+}
+
+func newRequestMessage(req *http.Request) *requestMessage {
+	query := map[string]string{}
+	for k, v := range req.URL.Query() {
+		query[k] = v[0]
+	}
+
+	// header names are sent in lower case like HTTP/2 and the TypeScript client
+	headers := map[string]string{}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.Join(v, ", ")
+	}
+
+	return &requestMessage{Type: "request", Method: req.Method, Path: req.URL.Path, Query: query, Headers: headers}
+}
+
+func (m *responseMessage) httpResponse(req *http.Request) *http.Response {
+	header := http.Header{}
+	for k, v := range m.Headers {
+		header.Set(k, v)
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", m.Status, http.StatusText(m.Status)),
+		StatusCode:    m.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(m.Body)),
+		ContentLength: int64(len(m.Body)),
+		Request:       req,
+	}
+}
+
+func waitFor(ctx context.Context, done <-chan struct{}) error {
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
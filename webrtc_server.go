@@ -0,0 +1,331 @@
+package mau
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// ServeWebRTC accepts WebRTC connections offered over the signaling and
+// serves the same requests as Serve over their data channels. it blocks until
+// the context is cancelled.
+func (s *Server) ServeWebRTC(ctx context.Context, config WebRTCConfig) error {
+	if config.Signaling == nil {
+		return ErrWebRTCNotConfigured
+	}
+
+	w := &webrtcServer{server: s, config: config, peers: map[string]*webrtc.PeerConnection{}}
+	defer w.close()
+
+	for message := range config.Signaling.Subscribe(ctx) {
+		w.handleSignal(ctx, message)
+	}
+
+	return nil
+}
+
+type webrtcServer struct {
+	server *Server
+	config WebRTCConfig
+	mutex  sync.Mutex
+	peers  map[string]*webrtc.PeerConnection // key: fingerprint hex string of the offering peer
+}
+
+func (w *webrtcServer) handleSignal(ctx context.Context, message *SignalingMessage) {
+	var err error
+	switch message.Type {
+	case SignalingOffer:
+		err = w.accept(ctx, message)
+	case SignalingICECandidate:
+		err = w.addICECandidate(message)
+	}
+
+	if err != nil {
+		slog.Error("failed to handle signaling message", "from", message.From, "type", message.Type, "error", err)
+	}
+}
+
+// accept answers an offer, the answer is sent once ICE gathering is done
+func (w *webrtcServer) accept(ctx context.Context, message *SignalingMessage) error {
+	var offer webrtc.SessionDescription
+	if err := json.Unmarshal(message.Data, &offer); err != nil {
+		return err
+	}
+
+	remoteDTLS, err := dtlsFingerprint(offer.SDP)
+	if err != nil {
+		return err
+	}
+
+	pc, err := w.config.newPeerConnection()
+	if err != nil {
+		return err
+	}
+	w.addPeer(message.From, pc)
+
+	pc.OnDataChannel(func(dc *webrtc.DataChannel) {
+		conn := &webrtcServerConn{server: w.server, pc: pc, channel: dc, remoteDTLS: remoteDTLS}
+		dc.OnMessage(func(msg webrtc.DataChannelMessage) { conn.handle(msg.Data) })
+	})
+
+	if err := pc.SetRemoteDescription(offer); err != nil {
+		return err
+	}
+
+	go w.answer(ctx, message.From, pc)
+	return nil
+}
+
+func (w *webrtcServer) answer(ctx context.Context, to Fingerprint, pc *webrtc.PeerConnection) {
+	answer, err := pc.CreateAnswer(nil)
+	if err == nil {
+		err = pc.SetLocalDescription(answer)
+	}
+	if err == nil {
+		err = waitForGathering(ctx, pc)
+	}
+	if err == nil {
+		err = signalDescription(ctx, w.config.Signaling, to, SignalingAnswer, pc.LocalDescription())
+	}
+
+	if err != nil {
+		slog.Error("failed to answer WebRTC offer", "peer", to, "error", err)
+		w.removePeer(to, pc)
+	}
+}
+
+func (w *webrtcServer) addICECandidate(message *SignalingMessage) error {
+	var candidate webrtc.ICECandidateInit
+	if err := json.Unmarshal(message.Data, &candidate); err != nil {
+		return err
+	}
+
+	w.mutex.Lock()
+	pc, ok := w.peers[message.From.String()]
+	w.mutex.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	return pc.AddICECandidate(candidate)
+}
+
+// addPeer keeps the latest connection offered by each peer, closing older ones
+func (w *webrtcServer) addPeer(fpr Fingerprint, pc *webrtc.PeerConnection) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if old, ok := w.peers[fpr.String()]; ok {
+		_ = old.Close()
+	}
+	w.peers[fpr.String()] = pc
+
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		if state == webrtc.PeerConnectionStateFailed || state == webrtc.PeerConnectionStateClosed {
+			w.removePeer(fpr, pc)
+		}
+	})
+}
+
+func (w *webrtcServer) removePeer(fpr Fingerprint, pc *webrtc.PeerConnection) {
+	w.mutex.Lock()
+	if w.peers[fpr.String()] == pc {
+		delete(w.peers, fpr.String())
+	}
+	w.mutex.Unlock()
+
+	_ = pc.Close()
+}
+
+func (w *webrtcServer) close() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	for _, pc := range w.peers {
+		_ = pc.Close()
+	}
+	w.peers = map[string]*webrtc.PeerConnection{}
+}
+
+// webrtcServerConn serves the messages of one data channel. pion delivers
+// them one at a time so responses are sent in the order of the requests.
+type webrtcServerConn struct {
+	server     *Server
+	pc         *webrtc.PeerConnection
+	channel    *webrtc.DataChannel
+	remoteDTLS string      // DTLS fingerprint the remote peer signs its challenge with
+	peer       Fingerprint // set once the peer is authenticated
+}
+
+func (c *webrtcServerConn) handle(data []byte) {
+	var envelope channelEnvelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		c.sendError(http.StatusBadRequest, "Bad Request")
+		return
+	}
+
+	switch {
+	case envelope.Type == "mtls_offer":
+		c.authenticate(data)
+	case c.peer == nil:
+		c.sendError(http.StatusUnauthorized, "Unauthorized - mTLS required")
+	case envelope.Type == "request":
+		c.serveRequest(data)
+	}
+}
+
+// authenticate verifies the peer signed the challenge bound to its DTLS
+// certificate and responds with our signature of the challenge bound to ours
+func (c *webrtcServerConn) authenticate(data []byte) {
+	var offer mtlsMessage
+	if err := json.Unmarshal(data, &offer); err != nil {
+		c.sendError(http.StatusBadRequest, "Bad Request")
+		return
+	}
+
+	fpr, err := verifyChallenge(offer.PublicKey, boundChallenge(offer.Challenge, c.remoteDTLS), offer.Signature)
+	if err != nil {
+		c.sendError(http.StatusForbidden, "mTLS authentication failed")
+		_ = c.channel.Close()
+		return
+	}
+
+	response, err := c.mtlsResponse(offer.Challenge)
+	if err != nil {
+		c.sendError(http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	c.peer = fpr
+	c.send(response)
+}
+
+func (c *webrtcServerConn) mtlsResponse(challenge []byte) (*mtlsMessage, error) {
+	account := c.server.account
+
+	localDTLS, err := dtlsFingerprint(c.pc.LocalDescription().SDP)
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey, err := account.armoredPublicKey()
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := account.signChallenge(boundChallenge(challenge, localDTLS))
+	if err != nil {
+		return nil, err
+	}
+
+	return &mtlsMessage{Type: "mtls_response", PublicKey: publicKey, Challenge: challenge, Signature: signature}, nil
+}
+
+// serveRequest serves the request with the server router as if it was
+// received over HTTPS from the authenticated peer
+func (c *webrtcServerConn) serveRequest(data []byte) {
+	var request requestMessage
+	if err := json.Unmarshal(data, &request); err != nil {
+		c.sendError(http.StatusBadRequest, "Bad Request")
+		return
+	}
+
+	ctx := context.WithValue(context.Background(), peerContextKey{}, c.peer)
+	req, err := request.httpRequest(ctx)
+	if err != nil {
+		c.sendError(http.StatusBadRequest, "Bad Request")
+		return
+	}
+
+	w := newResponseBuffer()
+	c.server.router.ServeHTTP(w, req)
+	c.send(w.message())
+}
+
+func (c *webrtcServerConn) sendError(status int, message string) {
+	c.send(&responseMessage{
+		Type:    "response",
+		Status:  status,
+		Headers: map[string]string{"Content-Type": "text/plain"},
+		Body:    byteArray(message),
+	})
+}
+
+func (c *webrtcServerConn) send(message any) {
+	data, err := json.Marshal(message)
+	if err == nil {
+		err = c.channel.SendText(string(data))
+	}
+
+	if err != nil {
+		slog.Error("failed to send WebRTC message", "peer", c.peer, "error", err)
+	}
+}
+
+func (m *requestMessage) httpRequest(ctx context.Context) (*http.Request, error) {
+	method := m.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	query := url.Values{}
+	for k, v := range m.Query {
+		query.Set(k, v)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, (&url.URL{Path: m.Path, RawQuery: query.Encode()}).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range m.Headers {
+		req.Header.Set(k, v)
+	}
+	setIfModifiedSinceFromQuery(req, m.Query)
+
+	return req, nil
+}
+
+// setIfModifiedSinceFromQuery maps the after query parameter TypeScript
+// clients send for incremental listing to the If-Modified-Since header
+func setIfModifiedSinceFromQuery(req *http.Request, query map[string]string) {
+	after, err := time.Parse(time.RFC3339, query["after"])
+	if err != nil || req.Header.Get("If-Modified-Since") != "" {
+		return
+	}
+
+	req.Header.Set("If-Modified-Since", after.UTC().Format(http.TimeFormat))
+}
+
+// responseBuffer is an http.ResponseWriter keeping the response in memory
+type responseBuffer struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newResponseBuffer() *responseBuffer {
+	return &responseBuffer{header: http.Header{}, status: http.StatusOK}
+}
+
+func (r *responseBuffer) Header() http.Header         { return r.header }
+func (r *responseBuffer) Write(b []byte) (int, error) { return r.body.Write(b) }
+func (r *responseBuffer) WriteHeader(status int)      { r.status = status }
+
+func (r *responseBuffer) message() *responseMessage {
+	headers := map[string]string{}
+	for k, v := range r.header {
+		headers[k] = strings.Join(v, ", ")
+	}
+
+	return &responseMessage{Type: "response", Status: r.status, Headers: headers, Body: r.body.Bytes()}
+}
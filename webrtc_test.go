@@ -0,0 +1,210 @@
+package mau
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebRTC(t *testing.T) {
+	friend, err := NewAccount(t.TempDir(), "Mohamed Mahmoud", "mohamed@example.com", "password")
+	require.NoError(t, err)
+	accountDir := t.TempDir()
+	account, err := NewAccount(accountDir, "Ahmed Mohamed", "ahmed@example.com", "password")
+	require.NoError(t, err)
+
+	aFriend := befriend(t, friend, account)
+	require.NoError(t, account.Follow(befriend(t, account, friend)))
+
+	_, err = friend.AddFile(strings.NewReader("Hello over WebRTC"), "hello.txt", []*Friend{aFriend})
+	require.NoError(t, err)
+
+	signaling := NewLocalSignaling()
+	server, err := friend.Server(nil)
+	require.NoError(t, err)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = server.ServeWebRTC(ctx, WebRTCConfig{Signaling: signaling.For(friend.Fingerprint())}) }()
+
+	t.Run("Fails without WebRTC config", func(t T) {
+		client, err := account.Client(friend.Fingerprint(), nil)
+		require.NoError(t, err)
+
+		err = client.DownloadFriend(ctx, friend.Fingerprint(), time.Time{}, []FingerprintResolver{WebRTCFriendAddress})
+		assert.ErrorIs(t, err, ErrWebRTCNotConfigured)
+	})
+
+	t.Run("Downloads files over a data channel", func(t T) {
+		client, err := account.Client(friend.Fingerprint(), nil)
+		require.NoError(t, err)
+		client.SetWebRTC(WebRTCConfig{Signaling: signaling.For(account.Fingerprint())})
+
+		err = client.DownloadFriend(ctx, friend.Fingerprint(), time.Time{}, []FingerprintResolver{WebRTCFriendAddress})
+		require.NoError(t, err)
+
+		assert.FileExists(t, path.Join(accountDir, friend.Fingerprint().String(), "hello.txt.pgp"))
+	})
+
+	t.Run("Lists nothing for strangers", func(t T) {
+		stranger, err := NewAccount(t.TempDir(), "Stranger", "stranger@example.com", "password")
+		require.NoError(t, err)
+		client, err := stranger.Client(friend.Fingerprint(), nil)
+		require.NoError(t, err)
+		client.SetWebRTC(WebRTCConfig{Signaling: signaling.For(stranger.Fingerprint())})
+
+		list, err := client.fetchFileList(ctx, friend.Fingerprint(), webrtcAddress(friend.Fingerprint()), time.Time{})
+		require.NoError(t, err)
+		assert.Empty(t, list)
+	})
+}
+
+// TestWebRTCTypeScriptHandshake replays the messages the TypeScript client
+// sends over the data channel
+func TestWebRTCTypeScriptHandshake(t *testing.T) {
+	friend, err := NewAccount(t.TempDir(), "Mohamed Mahmoud", "mohamed@example.com", "password")
+	require.NoError(t, err)
+	account, err := NewAccount(t.TempDir(), "Ahmed Mohamed", "ahmed@example.com", "password")
+	require.NoError(t, err)
+	befriend(t, friend, account)
+
+	signaling := NewLocalSignaling()
+	server, err := friend.Server(nil)
+	require.NoError(t, err)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = server.ServeWebRTC(ctx, WebRTCConfig{Signaling: signaling.For(friend.Fingerprint())}) }()
+
+	publicKey, err := account.armoredPublicKey()
+	require.NoError(t, err)
+	challenge := []int{}
+	for i := range webrtcChallengeSize {
+		challenge = append(challenge, i)
+	}
+
+	// connect opens a data channel without the Go handshake
+	connect := func(t T) *webrtcConn {
+		transport := &webrtcTransport{account: account, peer: friend.Fingerprint(), config: WebRTCConfig{Signaling: signaling.For(account.Fingerprint())}}
+		conn, err := transport.offer(ctx)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = conn.pc.Close() })
+		return conn
+	}
+
+	// send sends a message shaped like JSON.stringify of the TypeScript client
+	send := func(t T, conn *webrtcConn, message string) {
+		require.NoError(t, conn.channel.SendText(message))
+	}
+
+	t.Run("Accepts the signed offer of TypeScript clients", func(t T) {
+		conn := connect(t)
+		localDTLS, err := dtlsFingerprint(conn.pc.LocalDescription().SDP)
+		require.NoError(t, err)
+
+		bound := []byte{}
+		for _, b := range challenge {
+			bound = append(bound, byte(b))
+		}
+		signature, err := account.signChallenge(boundChallenge(bound, localDTLS))
+		require.NoError(t, err)
+
+		key, _ := json.Marshal(publicKey)
+		sig, _ := json.Marshal(signature)
+		numbers, _ := json.Marshal(challenge)
+		send(t, conn, `{"type":"mtls_offer","publicKey":`+string(key)+`,"challenge":`+string(numbers)+`,"signature":`+string(sig)+`}`)
+
+		var response mtlsMessage
+		require.NoError(t, conn.receive(ctx, &response))
+		assert.Equal(t, "mtls_response", response.Type)
+		remoteDTLS, err := dtlsFingerprint(conn.pc.RemoteDescription().SDP)
+		require.NoError(t, err)
+		fpr, err := verifyChallenge(response.PublicKey, boundChallenge(bound, remoteDTLS), response.Signature)
+		require.NoError(t, err)
+		assert.Equal(t, friend.Fingerprint(), fpr)
+
+		_, err = verifyChallenge(response.PublicKey, bound, response.Signature)
+		assert.Error(t, err, "the response isn't valid without the certificate of the signer")
+
+		send(t, conn, `{"type":"request","method":"GET","path":"/p2p/`+friend.Fingerprint().String()+`","query":{},"headers":{}}`)
+		var list responseMessage
+		require.NoError(t, conn.receive(ctx, &list))
+		assert.Equal(t, http.StatusOK, list.Status)
+	})
+
+	t.Run("Refuses unsigned offers", func(t T) {
+		conn := connect(t)
+
+		key, _ := json.Marshal(publicKey)
+		numbers, _ := json.Marshal(challenge)
+		send(t, conn, `{"type":"mtls_offer","publicKey":`+string(key)+`,"challenge":`+string(numbers)+`}`)
+
+		var response responseMessage
+		require.NoError(t, conn.receive(ctx, &response))
+		assert.Equal(t, http.StatusForbidden, response.Status)
+	})
+}
+
+func TestWebRTCChallenge(t *testing.T) {
+	account, err := NewAccount(t.TempDir(), "Ahmed Mohamed", "ahmed@example.com", "password")
+	require.NoError(t, err)
+	publicKey, err := account.armoredPublicKey()
+	require.NoError(t, err)
+
+	challenge := boundChallenge([]byte{1, 2, 3}, "sha-256 ab:cd")
+	signature, err := account.signChallenge(challenge)
+	require.NoError(t, err)
+
+	t.Run("Verifies the signer", func(t T) {
+		fpr, err := verifyChallenge(publicKey, challenge, signature)
+		require.NoError(t, err)
+		assert.Equal(t, account.Fingerprint(), fpr)
+	})
+
+	t.Run("Rejects signatures bound to another certificate", func(t T) {
+		_, err := verifyChallenge(publicKey, boundChallenge([]byte{1, 2, 3}, "sha-256 ef:01"), signature)
+		assert.ErrorIs(t, err, ErrWebRTCHandshakeFailed)
+	})
+}
+
+func TestDTLSFingerprint(t *testing.T) {
+	sdp := "v=0\r\no=- 0 0 IN IP4 127.0.0.1\r\na=fingerprint:sha-256 AB:CD:EF\r\na=setup:actpass\r\n"
+
+	fpr, err := dtlsFingerprint(sdp)
+	require.NoError(t, err)
+	assert.Equal(t, "sha-256 ab:cd:ef", fpr)
+
+	_, err = dtlsFingerprint("v=0\r\n")
+	assert.ErrorIs(t, err, ErrDTLSFingerprintNotFound)
+}
+
+func TestByteArray(t *testing.T) {
+	data, err := json.Marshal(byteArray("hi"))
+	require.NoError(t, err)
+	assert.Equal(t, "[104,105]", string(data))
+
+	var b byteArray
+	require.NoError(t, json.Unmarshal([]byte("[104,105]"), &b))
+	assert.Equal(t, "hi", string(b))
+
+	require.NoError(t, json.Unmarshal([]byte(`"hello"`), &b))
+	assert.Equal(t, "hello", string(b))
+}
+
+func TestFileList(t *testing.T) {
+	var list fileList
+	require.NoError(t, json.Unmarshal([]byte(`[{"path":"/p2p/abc/a.pgp","size":1,"sum":"x"}]`), &list))
+	assert.Equal(t, fileList{{Path: "/p2p/abc/a.pgp", Size: 1, Sum: "x"}}, list)
+
+	require.NoError(t, json.Unmarshal([]byte(`{"files":[{"path":"b.pgp","size":2,"sum":"y"}]}`), &list))
+	assert.Equal(t, fileList{{Path: "b.pgp", Size: 2, Sum: "y"}}, list)
+}